@@ -5,30 +5,66 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/alexchebotarsky/heatpump-api/bus"
 	"github.com/alexchebotarsky/heatpump-api/client/database"
+	"github.com/alexchebotarsky/heatpump-api/client/notify"
 	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
 	"github.com/alexchebotarsky/heatpump-api/env"
+	"github.com/alexchebotarsky/heatpump-api/health"
+	"github.com/alexchebotarsky/heatpump-api/model/clock"
+	"github.com/alexchebotarsky/heatpump-api/model/energy"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/recording"
+	"github.com/alexchebotarsky/heatpump-api/model/weather"
 	"github.com/alexchebotarsky/heatpump-api/processor"
+	"github.com/alexchebotarsky/heatpump-api/processor/middleware"
 	"github.com/alexchebotarsky/heatpump-api/server"
+	"github.com/alexchebotarsky/heatpump-api/service"
 )
 
 type App struct {
-	Services []Service
+	Services []ManagedService
 	Clients  *Clients
+	Health   *health.Checker
+
+	// env is the config the app runs with, it's updated by Reload.
+	env             *env.Config
+	reloadMu        sync.Mutex
+	shutdownTimeout time.Duration
+	restartBackoff  time.Duration
+	maxBackoff      time.Duration
 }
 
 func New(ctx context.Context, env *env.Config) (*App, error) {
-	var app App
-	var err error
-
-	app.Clients, err = setupClients(ctx, env)
+	clients, err := setupClients(ctx, env)
 	if err != nil {
 		return nil, fmt.Errorf("error setting up clients: %v", err)
 	}
 
-	app.Services, err = setupServices(env, app.Clients)
+	return NewWithClients(env, clients)
+}
+
+// NewWithClients creates the app with clients set up by the caller, e.g. with
+// a fake pubsub to replay recorded traffic. Clients are closed on shutdown.
+func NewWithClients(env *env.Config, clients *Clients) (*App, error) {
+	var app App
+	var err error
+
+	app.shutdownTimeout = env.ShutdownTimeout
+	app.restartBackoff = env.ServiceRestartBackoff
+	app.maxBackoff = env.ServiceMaxRestartBackoff
+	app.Clients = clients
+	app.env = env
+
+	app.Health = health.New(env.HealthCacheTTL, env.HealthCheckTimeout)
+	app.Health.Register(app.Clients.Database.HealthChecks(env.HealthSensorMaxAge, env.HealthIRTransmitterAckTimeout)...)
+	app.Health.Register(app.Clients.PubSub.HealthChecks()...)
+
+	app.Services, err = setupServices(env, app.Clients, app.Health)
 	if err != nil {
 		return nil, fmt.Errorf("error setting up services: %v", err)
 	}
@@ -36,96 +72,427 @@ func New(ctx context.Context, env *env.Config) (*App, error) {
 	return &app, nil
 }
 
-func (app *App) Launch(ctx context.Context) {
-	errc := make(chan error, 1)
+func setupServices(env *env.Config, clients *Clients, healthChecker *health.Checker) ([]ManagedService, error) {
+	var services []ManagedService
 
-	for _, service := range app.Services {
-		go service.Start(ctx, errc)
+	// Events are published by the processor and services for the automations
+	events := bus.New()
+
+	compensation, err := weather.ParseCompensationCurve(env.WeatherCompensationCurve)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing weather compensation curve: %v", err)
 	}
+	heatpumpService := service.NewHeatpump(clients.Database, clients.PubSub, events, compensation, env.OutdoorTemperatureMaxAge)
 
-	select {
-	case <-ctx.Done():
-		slog.Debug("Context is cancelled")
-	case err := <-errc:
-		slog.Error(fmt.Sprintf("Critical service error: %v", err))
+	energyConfig, err := energy.LoadConfig(env.EnergyConfigFilename)
+	if err != nil {
+		return nil, fmt.Errorf("error loading energy config: %v", err)
 	}
+	energyService := service.NewEnergy(clients.Database, *energyConfig, env.EnergyMeterInterval, env.OutdoorTemperatureMaxAge)
 
-	var errs []error
+	humidityConfig, err := newHumidityAutomationConfig(env)
+	if err != nil {
+		return nil, err
+	}
+	humidityService := service.NewHumidityAutomation(clients.Database, heatpumpService, humidityConfig)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	suspendState, err := service.NewSuspendState(env.OpenWindowSuspendMode, env.OpenWindowFrostProtectTemperature)
+	if err != nil {
+		return nil, fmt.Errorf("error creating open window suspend state: %v", err)
+	}
+	openWindowConfig := service.OpenWindowConfig{
+		Enabled:      env.OpenWindowDetectionEnabled,
+		DropSlope:    env.OpenWindowDropSlope,
+		Window:       env.OpenWindowSlopeWindow,
+		StableSlope:  env.OpenWindowStableSlope,
+		MinDuration:  env.OpenWindowMinDuration,
+		MaxDuration:  env.OpenWindowMaxDuration,
+		SuspendState: suspendState,
+	}
+	err = openWindowConfig.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("error validating open window config: %v", err)
+	}
+	openWindowService := service.NewOpenWindow(clients.Database, heatpumpService, clients.PubSub, events, openWindowConfig)
+
+	presenceConfig := service.PresenceConfig{
+		AwayDebounce:       env.PresenceAwayDebounce,
+		MotionTimeout:      env.PresenceMotionTimeout,
+		ComfortTemperature: env.PresenceComfortTemperature,
+		EcoTemperature:     env.PresenceEcoTemperature,
+		ControlEnabled:     env.PresenceControlEnabled,
+	}
+	err = presenceConfig.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("error validating presence config: %v", err)
+	}
+	presenceService := service.NewPresence(clients.Database, heatpumpService, presenceConfig)
 
-	for _, service := range app.Services {
-		err := service.Stop(ctx)
+	channels, err := setupNotificationChannels(env)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up notification channels: %v", err)
+	}
+	notificationsConfig := service.NotificationsConfig{
+		Cooldown:            env.NotifyCooldown,
+		HealthCheckInterval: env.NotifyHealthCheckInterval,
+	}
+	if env.NotifyQuietHours != "" {
+		quietHours, err := clock.ParseWindow(env.NotifyQuietHours)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("error stopping a service: %v", err))
+			return nil, fmt.Errorf("error parsing notification quiet hours: %v", err)
 		}
+		notificationsConfig.QuietHours = &quietHours
 	}
+	err = notificationsConfig.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("error validating notifications config: %v", err)
+	}
+	notificationsService := service.NewNotifications(clients.Database, events, healthChecker, channels, notificationsConfig)
 
-	err := app.Clients.Close()
+	webhooksConfig := service.WebhooksConfig{
+		MaxAttempts:     env.WebhookMaxAttempts,
+		RetryBackoff:    env.WebhookRetryBackoff,
+		MaxRetryBackoff: env.WebhookMaxRetryBackoff,
+		Timeout:         env.WebhookTimeout,
+		SensorMaxAge:    env.HealthSensorMaxAge,
+	}
+	err = webhooksConfig.Validate()
 	if err != nil {
-		errs = append(errs, fmt.Errorf("error closing app clients: %v", err))
+		return nil, fmt.Errorf("error validating webhooks config: %v", err)
 	}
+	webhooksService := service.NewWebhooks(clients.Database, events, webhooksConfig)
 
-	if len(errs) > 0 {
-		slog.Error(fmt.Sprintf("Error gracefully shutting down: %v", errors.Join(errs...)))
-	} else {
-		slog.Debug("App has been gracefully shut down")
+	backupConfig := service.BackupConfig{
+		SnapshotDir:      env.BackupSnapshotDir,
+		SnapshotInterval: env.BackupSnapshotInterval,
+		SnapshotKeep:     env.BackupSnapshotKeep,
 	}
-}
+	err = backupConfig.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("error validating backup config: %v", err)
+	}
+	backupService := service.NewBackup(clients.Database, backupConfig)
 
-type Service interface {
-	Start(context.Context, chan<- error)
-	Stop(context.Context) error
-}
+	p := processor.New(processor.Config{
+		StateSetTopic:           env.PubSubStateSetTopic,
+		CommandTopic:            env.PubSubCommandTopic,
+		IRTransmitterAckTopic:   env.PubSubIRTransmitterAckTopic,
+		SmartPlugTopic:          env.PubSubSmartPlugTopic,
+		OutdoorTemperatureTopic: env.PubSubOutdoorTemperatureTopic,
+		WindowContactTopic:      env.PubSubWindowContactTopic,
+		PresenceTopics:          env.PubSubPresenceTopics,
+		MotionTopics:            env.PubSubMotionTopics,
+		RetryAttempts:           env.ProcessorRetryAttempts,
+		RetryBackoff:            env.ProcessorRetryBackoff,
+		Workers:                 env.ProcessorWorkers,
+		QueueSize:               env.ProcessorQueueSize,
+	}, processor.Clients{
+		PubSub:   clients.PubSub,
+		Database: clients.Database,
+		Heatpump: heatpumpService,
+		Events:   events,
+		Presence: presenceService,
+		Recorder: clients.recorder(),
+	})
+	services = append(services, ManagedService{
+		Name:    "processor",
+		Service: p,
+	})
 
-func setupServices(env *env.Config, clients *Clients) ([]Service, error) {
-	var services []Service
+	services = append(services, ManagedService{
+		Name:    "energy",
+		Service: energyService,
+	})
 
-	s := server.New(env.Host, env.Port, server.Clients{
-		Database: clients.Database,
-		PubSub:   clients.PubSub,
+	services = append(services, ManagedService{
+		Name:    "presence",
+		Service: presenceService,
 	})
-	services = append(services, s)
 
-	p := processor.New(processor.Clients{
-		PubSub:   clients.PubSub,
+	rulesService := service.NewRules(clients.Database, heatpumpService, clients.PubSub, events, presenceService, reservedTopics(env))
+	services = append(services, ManagedService{
+		Name:    "rules",
+		Service: rulesService,
+	})
+
+	services = append(services, ManagedService{
+		Name:    "notifications",
+		Service: notificationsService,
+	})
+
+	services = append(services, ManagedService{
+		Name:    "webhooks",
+		Service: webhooksService,
+	})
+
+	if openWindowConfig.Enabled {
+		services = append(services, ManagedService{
+			Name:    "open-window",
+			Service: openWindowService,
+		})
+	}
+
+	if humidityConfig.Enabled {
+		services = append(services, ManagedService{
+			Name:    "humidity-automation",
+			Service: humidityService,
+		})
+	}
+
+	// Exports and imports work without snapshots, they only need the service
+	// for the schedule
+	if backupConfig.SnapshotDir != "" {
+		services = append(services, ManagedService{
+			Name:    "backup",
+			Service: backupService,
+		})
+	}
+
+	if env.OutdoorTemperatureSource != "" {
+		services = append(services, ManagedService{
+			Name:    "outdoor-temperature",
+			Service: service.NewOutdoorTemperaturePoller(env.OutdoorTemperatureSource, env.OutdoorTemperaturePollInterval, heatpumpService),
+		})
+	}
+
+	// Server is started after the processor is ready, so that HTTP traffic is
+	// only accepted once MQTT subscriptions are in place
+	s, err := server.New(env.Host, env.Port, env.AdminAPIKey, server.Clients{
 		Database: clients.Database,
+		PubSub:   clients.PubSub,
+		Heatpump: heatpumpService,
+		Health:   healthChecker,
+		Energy:   energyService,
+		Humidity: humidityService,
+		Rules:    rulesService,
+		Window:   openWindowService,
+		Presence: presenceService,
+		Webhooks: webhooksService,
+		Alerts:   notificationsService,
+		Backup:   backupService,
+		Events:   events,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating server: %v", err)
+	}
+	services = append(services, ManagedService{
+		Name:     "server",
+		Service:  s,
+		Critical: true,
 	})
-	services = append(services, p)
 
 	return services, nil
 }
 
+// reservedTopics are the topics the API consumes or produces itself, which
+// rules must not publish to.
+func reservedTopics(env *env.Config) []string {
+	topics := []string{
+		pubsub.IRTransmitterTopic,
+		processor.TemperatureSensorTopic,
+		env.PubSubStateSetTopic,
+		env.PubSubCommandTopic,
+		env.PubSubIRTransmitterAckTopic,
+		env.PubSubSmartPlugTopic,
+		env.PubSubOutdoorTemperatureTopic,
+		env.PubSubWindowContactTopic,
+		env.PubSubStatusTopic,
+		env.PubSubStateTopic,
+		env.PubSubTemperatureAndHumidityTopic,
+		env.PubSubDeadLetterTopic,
+		env.PubSubOpenWindowTopic,
+	}
+	for _, topic := range env.PubSubPresenceTopics {
+		topics = append(topics, topic)
+	}
+	for _, topic := range env.PubSubMotionTopics {
+		topics = append(topics, topic)
+	}
+
+	// Optional topics are empty when they aren't configured
+	return slices.DeleteFunc(topics, func(topic string) bool {
+		return topic == ""
+	})
+}
+
+// newHumidityAutomationConfig is shared by the setup and Reload.
+func newHumidityAutomationConfig(env *env.Config) (service.HumidityAutomationConfig, error) {
+	config := service.HumidityAutomationConfig{
+		Enabled:           env.HumidityAutomationEnabled,
+		HighThreshold:     env.HumidityHighThreshold,
+		LowThreshold:      env.HumidityLowThreshold,
+		SustainFor:        env.HumiditySustainDuration,
+		Mode:              heatpump.Mode(env.HumidityAutomationMode),
+		FanSpeed:          env.HumidityAutomationFanSpeed,
+		ManualOverrideFor: env.HumidityManualOverrideDuration,
+		SensorMaxAge:      env.HealthSensorMaxAge,
+		CheckInterval:     env.HumidityAutomationCheckInterval,
+	}
+	if env.HumidityQuietHours != "" {
+		quietHours, err := clock.ParseWindow(env.HumidityQuietHours)
+		if err != nil {
+			return service.HumidityAutomationConfig{}, fmt.Errorf("error parsing humidity quiet hours: %v", err)
+		}
+		config.QuietHours = &quietHours
+	}
+
+	err := config.Validate()
+	if err != nil {
+		return service.HumidityAutomationConfig{}, fmt.Errorf("error validating humidity automation config: %v", err)
+	}
+
+	return config, nil
+}
+
+// setupNotificationChannels returns the channels that are configured.
+func setupNotificationChannels(env *env.Config) ([]service.NotificationChannel, error) {
+	var channels []service.NotificationChannel
+
+	if env.NotifyHTTPURL != "" {
+		channels = append(channels, notify.NewHTTP(env.NotifyHTTPURL, env.NotifyHTTPToken, env.NotifyTimeout))
+	}
+
+	if env.NotifySMTPHost != "" {
+		if env.NotifySMTPFrom == "" || len(env.NotifySMTPTo) == 0 {
+			return nil, errors.New("smtp sender and recipients must be set")
+		}
+		channels = append(channels, notify.NewSMTP(env.NotifySMTPHost, env.NotifySMTPPort, env.NotifySMTPUsername, env.NotifySMTPPassword, env.NotifySMTPFrom, env.NotifySMTPTo, env.NotifyTimeout))
+	}
+
+	if env.NotifyTelegramBotToken != "" {
+		if env.NotifyTelegramChatID == "" {
+			return nil, errors.New("telegram chat id must be set")
+		}
+		channels = append(channels, notify.NewTelegram(env.NotifyTelegramAPIURL, env.NotifyTelegramBotToken, env.NotifyTelegramChatID, env.NotifyTimeout))
+	}
+
+	return channels, nil
+}
+
 type Clients struct {
 	Database *database.Database
-	PubSub   *pubsub.PubSub
+	PubSub   PubSub
+	// Recorder is optional, MQTT traffic is recorded if it's set.
+	Recorder *recording.Recorder
+}
+
+// PubSub is implemented by the pubsub client, and by pubsubtest.PubSub to run
+// the app without a broker.
+type PubSub interface {
+	processor.PubSubClient
+	server.PubSub
+	service.PubSub
+	service.RulesPubSub
+	service.OpenWindowPublisher
+	HealthChecks() []health.Check
+	Close(ctx context.Context) error
+}
+
+// recorder returns the recorder as an interface that is nil if it's not set,
+// so that consumers can check for it.
+func (c *Clients) recorder() middleware.MessageRecorder {
+	if c.Recorder == nil {
+		return nil
+	}
+	return c.Recorder
 }
 
 func setupClients(ctx context.Context, env *env.Config) (*Clients, error) {
 	var c Clients
 	var err error
 
-	c.Database, err = database.New(env.DatabaseFilename, map[string]string{
-		database.ModeKey:              env.DefaultMode,
-		database.TargetTemperatureKey: fmt.Sprintf("%d", env.DefaultTargetTemperature),
-		database.FanSpeedKey:          fmt.Sprintf("%d", env.DefaultFanSpeed),
-	})
+	c.Database, err = NewDatabase(env)
 	if err != nil {
-		return nil, fmt.Errorf("error creating new database client: %v", err)
+		return nil, err
+	}
+
+	var pubsubRecorder pubsub.Recorder
+	if env.RecordingFilename != "" {
+		c.Recorder, err = recording.Create(env.RecordingFilename)
+		if err != nil {
+			closeErr := c.Database.Close()
+			if closeErr != nil {
+				slog.Error(fmt.Sprintf("Error closing database client: %v", closeErr))
+			}
+			return nil, fmt.Errorf("error creating recorder: %v", err)
+		}
+		pubsubRecorder = c.Recorder
 	}
 
-	c.PubSub, err = pubsub.New(ctx, env.PubSubHost, env.PubSubPort, env.PubSubClientID, env.PubSubQoS)
+	c.PubSub, err = pubsub.New(ctx, pubsub.Config{
+		URLs:      env.PubSubURLs,
+		Host:      env.PubSubHost,
+		Port:      env.PubSubPort,
+		ClientID:  env.PubSubClientID,
+		QoS:       env.PubSubQoS,
+		KeepAlive: env.PubSubKeepAlive,
+		Username:  env.PubSubUsername,
+		Password:  env.PubSubPassword,
+		CAFile:    env.PubSubCAFile,
+		CertFile:  env.PubSubCertFile,
+		KeyFile:   env.PubSubKeyFile,
+
+		StatusTopic:                 env.PubSubStatusTopic,
+		StateTopic:                  env.PubSubStateTopic,
+		TemperatureAndHumidityTopic: env.PubSubTemperatureAndHumidityTopic,
+		DeadLetterTopic:             env.PubSubDeadLetterTopic,
+		OpenWindowTopic:             env.PubSubOpenWindowTopic,
+
+		Recorder: pubsubRecorder,
+	})
 	if err != nil {
+		// Don't leave files open if we fail half way through
+		c.PubSub = nil
+		closeErr := c.Close(ctx)
+		if closeErr != nil {
+			slog.Error(fmt.Sprintf("Error closing clients: %v", closeErr))
+		}
 		return nil, fmt.Errorf("error creating new pubsub client: %v", err)
 	}
 
 	return &c, nil
 }
 
-func (c *Clients) Close() error {
+// NewDatabase opens the database file of the config, with the default state
+// for a new file.
+func NewDatabase(env *env.Config) (*database.Database, error) {
+	db, err := database.New(env.DatabaseFilename, map[string]string{
+		database.ModeKey:              env.DefaultMode,
+		database.TargetTemperatureKey: fmt.Sprintf("%d", env.DefaultTargetTemperature),
+		database.FanSpeedKey:          fmt.Sprintf("%d", env.DefaultFanSpeed),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating new database client: %v", err)
+	}
+
+	return db, nil
+}
+
+// Close closes the clients in reverse order of their creation.
+func (c *Clients) Close(ctx context.Context) error {
 	var errs []error
 
+	if c.PubSub != nil {
+		err := c.PubSub.Close(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error closing pubsub client: %v", err))
+		}
+	}
+
+	if c.Recorder != nil {
+		err := c.Recorder.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error closing recorder: %v", err))
+		}
+	}
+
+	if c.Database != nil {
+		err := c.Database.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error closing database client: %v", err))
+		}
+	}
+
 	if len(errs) > 0 {
 		return errors.Join(errs...)
 	}
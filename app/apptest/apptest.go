@@ -0,0 +1,452 @@
+// Package apptest runs the whole app against an in-process broker and a
+// temporary database file, so that full flows, from an HTTP request to the IR
+// frame on the broker or from a sensor reading to an automation, can be tested
+// with go test.
+package apptest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/app"
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub/pubsubtest"
+	"github.com/alexchebotarsky/heatpump-api/env"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	envconfig "github.com/sethvargo/go-envconfig"
+)
+
+const (
+	// startTimeout is how long the app has to become ready.
+	startTimeout = 10 * time.Second
+	// stopTimeout is how long the app has to shut down, on top of its own
+	// shutdown timeout.
+	stopTimeout = 5 * time.Second
+)
+
+type Harness struct {
+	// URL is the base URL of the HTTP server, without a trailing slash.
+	URL    string
+	Config *env.Config
+	App    *app.App
+	// Broker is set by Start, the app is connected to it.
+	Broker *mqtt.Server
+	// PubSub is set by StartWithFake, the app uses it instead of a broker, so
+	// that publish failures can be injected.
+	PubSub *pubsubtest.PubSub
+
+	tb       testing.TB
+	mu       sync.Mutex
+	messages []pubsubtest.Message
+	// messagesc is closed and replaced on every message, to wake up waiters.
+	messagesc chan struct{}
+}
+
+// Start runs the app until the end of the test. Vars override the env
+// variables the app would be configured with, the host, ports and database
+// file are always set by the harness. The environment of the test process
+// isn't used.
+func Start(tb testing.TB, vars map[string]string) *Harness {
+	tb.Helper()
+
+	var h Harness
+	h.tb = tb
+	h.messagesc = make(chan struct{})
+
+	brokerAddress := freeAddress(tb)
+	h.Broker = startBroker(tb, brokerAddress)
+
+	// Every message on the broker is recorded, so that tests can assert on
+	// what the app published
+	err := h.Broker.Subscribe("#", 1, h.recordMessage)
+	if err != nil {
+		tb.Fatalf("error subscribing to broker messages: %v", err)
+	}
+
+	h.Config = loadConfig(tb, vars)
+	brokerHost, brokerPort := splitAddress(tb, brokerAddress)
+	h.Config.PubSubHost = brokerHost
+	h.Config.PubSubPort = parsePort(tb, brokerPort)
+
+	h.launch(func(ctx context.Context) (*app.App, error) {
+		return app.New(ctx, h.Config)
+	})
+
+	return &h
+}
+
+// StartWithFake is Start with the app using a pubsubtest.PubSub instead of a
+// broker. Messages are injected and inspected through h.PubSub, the broker
+// helpers of the harness can't be used.
+func StartWithFake(tb testing.TB, vars map[string]string) *Harness {
+	tb.Helper()
+
+	var h Harness
+	h.tb = tb
+
+	h.Config = loadConfig(tb, vars)
+	h.PubSub = pubsubtest.New(pubsub.Config{
+		QoS:                         h.Config.PubSubQoS,
+		StateTopic:                  h.Config.PubSubStateTopic,
+		TemperatureAndHumidityTopic: h.Config.PubSubTemperatureAndHumidityTopic,
+		DeadLetterTopic:             h.Config.PubSubDeadLetterTopic,
+		OpenWindowTopic:             h.Config.PubSubOpenWindowTopic,
+	})
+
+	h.launch(func(ctx context.Context) (*app.App, error) {
+		clients := &app.Clients{PubSub: h.PubSub}
+
+		var err error
+		clients.Database, err = app.NewDatabase(h.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		a, err := app.NewWithClients(h.Config, clients)
+		if err != nil {
+			closeErr := clients.Close(ctx)
+			if closeErr != nil {
+				h.tb.Errorf("error closing clients: %v", closeErr)
+			}
+			return nil, err
+		}
+
+		return a, nil
+	})
+
+	return &h
+}
+
+// loadConfig processes the vars on top of the harness defaults, with a free
+// server address and a temporary database file.
+func loadConfig(tb testing.TB, vars map[string]string) *env.Config {
+	tb.Helper()
+
+	lookup := map[string]string{
+		"LOG_LEVEL":        "error",
+		"SHUTDOWN_TIMEOUT": "2s",
+		"ADMIN_API_KEY":    "apptest",
+	}
+	for key, value := range vars {
+		lookup[key] = value
+	}
+
+	host, port := splitAddress(tb, freeAddress(tb))
+	lookup["HOST"] = host
+	lookup["PORT"] = port
+	delete(lookup, "PUBSUB_URLS")
+
+	lookup["DATABASE_FILENAME"] = filepath.Join(tb.TempDir(), "database.json")
+
+	var config env.Config
+	err := envconfig.ProcessWith(context.Background(), &envconfig.Config{
+		Target:   &config,
+		Lookuper: envconfig.MapLookuper(lookup),
+	})
+	if err != nil {
+		tb.Fatalf("error processing env variables: %v", err)
+	}
+	slog.SetLogLoggerLevel(config.LogLevel)
+
+	return &config
+}
+
+// launch creates the app with newApp and runs it until the end of the test.
+func (h *Harness) launch(newApp func(ctx context.Context) (*app.App, error)) {
+	h.tb.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var err error
+	h.App, err = newApp(ctx)
+	if err != nil {
+		cancel()
+		h.tb.Fatalf("error creating app: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.App.Launch(ctx)
+		close(done)
+	}()
+
+	h.tb.Cleanup(func() {
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(h.Config.ShutdownTimeout + stopTimeout):
+			h.tb.Errorf("app didn't shut down in time")
+		}
+	})
+
+	h.URL = fmt.Sprintf("http://%s", net.JoinHostPort(h.Config.Host, strconv.Itoa(int(h.Config.Port))))
+	h.waitUntilReady(done)
+}
+
+func (h *Harness) waitUntilReady(done <-chan struct{}) {
+	h.tb.Helper()
+
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-done:
+			h.tb.Fatalf("app stopped while starting")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		res, err := http.Get(h.URL + "/_healthz/ready")
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusOK {
+			return
+		}
+	}
+
+	h.tb.Fatalf("app isn't ready after %s", startTimeout)
+}
+
+// Do sends a request to the app and decodes the JSON response into out, if
+// it's not nil. Body is encoded as JSON, unless it's already []byte. Requests
+// are authorized with the admin API key, if it's set. It returns the status
+// code, and fails the test if the request can't be made.
+func (h *Harness) Do(method, path string, body any, out any) int {
+	h.tb.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		payload, err := json.Marshal(b)
+		if err != nil {
+			h.tb.Fatalf("error marshalling request body: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, h.URL+path, reader)
+	if err != nil {
+		h.tb.Fatalf("error creating request: %v", err)
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if h.Config.AdminAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Config.AdminAPIKey)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.tb.Fatalf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if out != nil && res.StatusCode < http.StatusBadRequest {
+		err = json.NewDecoder(res.Body).Decode(out)
+		if err != nil {
+			h.tb.Fatalf("error decoding response body: %v", err)
+		}
+	}
+
+	return res.StatusCode
+}
+
+// Publish publishes a message on the broker, as a device would.
+func (h *Harness) Publish(topic string, payload []byte, retain bool) {
+	h.tb.Helper()
+	h.requireBroker()
+
+	err := h.Broker.Publish(topic, payload, retain, 1)
+	if err != nil {
+		h.tb.Fatalf("error publishing to %s: %v", topic, err)
+	}
+}
+
+// PublishJSON is Publish with the value encoded as JSON.
+func (h *Harness) PublishJSON(topic string, value any) {
+	h.tb.Helper()
+
+	payload, err := json.Marshal(value)
+	if err != nil {
+		h.tb.Fatalf("error marshalling payload: %v", err)
+	}
+
+	h.Publish(topic, payload, false)
+}
+
+// Messages returns the messages on topics matching the filter, published by
+// the app or anyone else, in order.
+func (h *Harness) Messages(filter string) []pubsubtest.Message {
+	h.tb.Helper()
+	h.requireBroker()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var messages []pubsubtest.Message
+	for _, message := range h.messages {
+		if pubsub.MatchTopic(filter, message.Topic) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// ClearMessages forgets the recorded messages, so that the next
+// WaitForMessage only sees new ones.
+func (h *Harness) ClearMessages() {
+	h.tb.Helper()
+	h.requireBroker()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.messages = nil
+}
+
+// WaitForMessage returns the first recorded message matching the filter,
+// waiting for it up to the timeout. It fails the test on timeout.
+func (h *Harness) WaitForMessage(filter string, timeout time.Duration) pubsubtest.Message {
+	h.tb.Helper()
+	h.requireBroker()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		h.mu.Lock()
+		for _, message := range h.messages {
+			if pubsub.MatchTopic(filter, message.Topic) {
+				h.mu.Unlock()
+				return message
+			}
+		}
+		messagesc := h.messagesc
+		h.mu.Unlock()
+
+		select {
+		case <-timer.C:
+			h.tb.Fatalf("no message on %s after %s", filter, timeout)
+			return pubsubtest.Message{}
+		case <-messagesc:
+		}
+	}
+}
+
+func (h *Harness) requireBroker() {
+	h.tb.Helper()
+
+	if h.Broker == nil {
+		h.tb.Fatalf("harness has no broker, use h.PubSub instead")
+	}
+}
+
+func (h *Harness) recordMessage(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
+	message := pubsubtest.Message{
+		Topic:   pk.TopicName,
+		Payload: pk.Payload,
+		QoS:     pk.FixedHeader.Qos,
+		Retain:  pk.FixedHeader.Retain,
+		Time:    time.Now(),
+	}
+	if pk.Properties.ResponseTopic != "" {
+		message.ResponseTopic = pk.Properties.ResponseTopic
+		message.CorrelationData = pk.Properties.CorrelationData
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.messages = append(h.messages, message)
+	close(h.messagesc)
+	h.messagesc = make(chan struct{})
+}
+
+func startBroker(tb testing.TB, address string) *mqtt.Server {
+	tb.Helper()
+
+	broker := mqtt.New(&mqtt.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.DiscardHandler),
+	})
+
+	err := broker.AddHook(new(auth.AllowHook), nil)
+	if err != nil {
+		tb.Fatalf("error adding broker auth hook: %v", err)
+	}
+
+	err = broker.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: address}))
+	if err != nil {
+		tb.Fatalf("error adding broker listener: %v", err)
+	}
+
+	err = broker.Serve()
+	if err != nil {
+		tb.Fatalf("error serving broker: %v", err)
+	}
+
+	// Broker is closed after the app, as cleanups run in reverse order
+	tb.Cleanup(func() {
+		err := broker.Close()
+		if err != nil {
+			tb.Errorf("error closing broker: %v", err)
+		}
+	})
+
+	return broker
+}
+
+// freeAddress returns a local address with a port that is free at the time of
+// the call.
+func freeAddress(tb testing.TB) string {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("error finding a free port: %v", err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func parsePort(tb testing.TB, port string) uint16 {
+	tb.Helper()
+
+	value, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		tb.Fatalf("error parsing port %s: %v", port, err)
+	}
+
+	return uint16(value)
+}
+
+func splitAddress(tb testing.TB, address string) (host, port string) {
+	tb.Helper()
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		tb.Fatalf("error splitting address %s: %v", address, err)
+	}
+
+	return host, port
+}
@@ -0,0 +1,381 @@
+package apptest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/app/apptest"
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub/pubsubtest"
+	"github.com/alexchebotarsky/heatpump-api/model/deadletter"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/rule"
+	"github.com/alexchebotarsky/heatpump-api/model/webhook"
+)
+
+const messageTimeout = 5 * time.Second
+
+const temperatureSensorTopic = "heatpump/temperature-sensor"
+
+func TestUpdateStateTransmitsIRFrame(t *testing.T) {
+	h := apptest.Start(t, nil)
+
+	mode := heatpump.HeatMode
+	targetTemperature := 24
+	fanSpeed := 40
+	state := heatpump.State{Mode: &mode, TargetTemperature: &targetTemperature, FanSpeed: &fanSpeed}
+
+	var updated heatpump.State
+	status := h.Do(http.MethodPost, "/api/v1/state", &state, &updated)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, status)
+	}
+	if *updated.Mode != mode || *updated.TargetTemperature != targetTemperature || *updated.FanSpeed != fanSpeed {
+		t.Fatalf("unexpected updated state: %+v", updated)
+	}
+
+	data := readDatabase(t, h.Config.DatabaseFilename)
+	if data["mode"] != "HEAT" || data["targetTemperature"] != "24" || data["fanSpeed"] != "40" {
+		t.Errorf("state isn't persisted, database has mode %q, targetTemperature %q, fanSpeed %q", data["mode"], data["targetTemperature"], data["fanSpeed"])
+	}
+
+	expectedSignal, err := state.ToBinary()
+	if err != nil {
+		t.Fatalf("error converting state to binary: %v", err)
+	}
+
+	message := h.WaitForMessage(pubsubtest.IRTransmitterTopic, messageTimeout)
+	var signal pubsub.IRSignal
+	err = json.Unmarshal(message.Payload, &signal)
+	if err != nil {
+		t.Fatalf("error unmarshalling ir signal: %v", err)
+	}
+	if signal.Signal != expectedSignal {
+		t.Errorf("expected ir signal %s, got: %s", expectedSignal, signal.Signal)
+	}
+
+	message = h.WaitForMessage(h.Config.PubSubStateTopic, messageTimeout)
+	if !message.Retain {
+		t.Errorf("expected state to be published retained")
+	}
+}
+
+func TestTemperatureReadingIsStoredAndPublished(t *testing.T) {
+	h := apptest.Start(t, nil)
+
+	h.PublishJSON(temperatureSensorTopic, heatpump.TemperatureReading{Temperature: 21.5, Humidity: 48})
+
+	message := h.WaitForMessage(h.Config.PubSubTemperatureAndHumidityTopic, messageTimeout)
+	if !message.Retain {
+		t.Errorf("expected reading to be published retained")
+	}
+
+	var published heatpump.TemperatureReading
+	err := json.Unmarshal(message.Payload, &published)
+	if err != nil {
+		t.Fatalf("error unmarshalling published reading: %v", err)
+	}
+	if published.Temperature != 21.5 || published.Humidity != 48 {
+		t.Errorf("unexpected published reading: %+v", published)
+	}
+
+	// Reading is stored before it's published
+	data := readDatabase(t, h.Config.DatabaseFilename)
+	temperature, _ := strconv.ParseFloat(data["currentTemperature"], 64)
+	humidity, _ := strconv.ParseFloat(data["currentHumidity"], 64)
+	if temperature != 21.5 || humidity != 48 {
+		t.Errorf("reading isn't persisted, database has temperature %q, humidity %q", data["currentTemperature"], data["currentHumidity"])
+	}
+
+	var reading struct {
+		Temperature float64 `json:"temperature"`
+		Humidity    float64 `json:"humidity"`
+	}
+	status := h.Do(http.MethodGet, "/api/v1/temperature-and-humidity", nil, &reading)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, status)
+	}
+	if reading.Temperature != 21.5 || reading.Humidity != 48 {
+		t.Errorf("unexpected reading: %+v", reading)
+	}
+}
+
+func TestUpdateStateFailsWhenTransmissionFails(t *testing.T) {
+	h := apptest.StartWithFake(t, nil)
+
+	h.PubSub.Reset()
+	h.PubSub.FailPublish(pubsubtest.IRTransmitterTopic, errors.New("broker is gone"), 0)
+
+	mode := heatpump.CoolMode
+	status := h.Do(http.MethodPost, "/api/v1/state", &heatpump.State{Mode: &mode}, nil)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got: %d", http.StatusInternalServerError, status)
+	}
+
+	// State isn't announced if it didn't reach the heatpump
+	if published := h.PubSub.Published(h.Config.PubSubStateTopic); len(published) > 0 {
+		t.Errorf("expected no state to be published, got: %d messages", len(published))
+	}
+}
+
+func TestTemperatureReadingIsRetriedAfterPublishFailure(t *testing.T) {
+	h := apptest.StartWithFake(t, map[string]string{
+		"PROCESSOR_RETRY_ATTEMPTS": "3",
+		"PROCESSOR_RETRY_BACKOFF":  "10ms",
+	})
+
+	h.PubSub.Reset()
+	h.PubSub.FailPublish(h.Config.PubSubTemperatureAndHumidityTopic, errors.New("broker is gone"), 1)
+
+	err := h.PubSub.Inject(context.Background(), temperatureSensorTopic, []byte(`{"temperature":19,"humidity":60}`))
+	if err != nil {
+		t.Fatalf("error injecting reading: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	message, err := h.PubSub.WaitForMessage(ctx, h.Config.PubSubTemperatureAndHumidityTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !message.Retain {
+		t.Errorf("expected reading to be published retained")
+	}
+
+	if published := h.PubSub.Published(h.Config.PubSubDeadLetterTopic); len(published) > 0 {
+		t.Errorf("expected no dead letters after a successful retry, got: %d", len(published))
+	}
+}
+
+func TestTemperatureReadingIsDeadLetteredAfterRetries(t *testing.T) {
+	h := apptest.StartWithFake(t, map[string]string{
+		"PROCESSOR_RETRY_ATTEMPTS": "2",
+		"PROCESSOR_RETRY_BACKOFF":  "10ms",
+	})
+
+	h.PubSub.Reset()
+	h.PubSub.FailPublish(h.Config.PubSubTemperatureAndHumidityTopic, errors.New("broker is gone"), 0)
+
+	payload := `{"temperature":19,"humidity":60}`
+	err := h.PubSub.Inject(context.Background(), temperatureSensorTopic, []byte(payload))
+	if err != nil {
+		t.Fatalf("error injecting reading: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	message, err := h.PubSub.WaitForMessage(ctx, h.Config.PubSubDeadLetterTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deadLetter deadletter.Message
+	err = json.Unmarshal(message.Payload, &deadLetter)
+	if err != nil {
+		t.Fatalf("error unmarshalling dead letter: %v", err)
+	}
+	if deadLetter.Topic != temperatureSensorTopic || deadLetter.Payload != payload {
+		t.Errorf("unexpected dead letter: %+v", deadLetter)
+	}
+
+	var stored []deadletter.Message
+	status := h.Do(http.MethodGet, "/api/v1/admin/dead-letters", nil, &stored)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, status)
+	}
+	if len(stored) != 1 || stored[0].ID != deadLetter.ID {
+		t.Errorf("expected the dead letter to be stored, got: %+v", stored)
+	}
+}
+
+func TestWeatherCompensationOnlyAppliesInHeatMode(t *testing.T) {
+	h := apptest.StartWithFake(t, map[string]string{
+		"PUBSUB_OUTDOOR_TEMPERATURE_TOPIC": "weather/outdoor-temperature",
+		"WEATHER_COMPENSATION_CURVE":       "-10:2,10:0",
+	})
+
+	err := h.PubSub.Inject(context.Background(), "weather/outdoor-temperature", []byte(`{"temperature":-10}`))
+	if err != nil {
+		t.Fatalf("error injecting outdoor temperature: %v", err)
+	}
+
+	tests := []struct {
+		mode   heatpump.Mode
+		offset int
+	}{
+		{heatpump.CoolMode, 0},
+		{heatpump.HeatMode, 2},
+		{heatpump.AutoMode, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			h.PubSub.Reset()
+
+			mode := test.mode
+			targetTemperature := 22
+			var updated heatpump.State
+			status := h.Do(http.MethodPost, "/api/v1/state", &heatpump.State{Mode: &mode, TargetTemperature: &targetTemperature}, &updated)
+			if status != http.StatusOK {
+				t.Fatalf("expected status %d, got: %d", http.StatusOK, status)
+			}
+
+			transmitted := updated
+			compensatedTemperature := targetTemperature + test.offset
+			transmitted.TargetTemperature = &compensatedTemperature
+			expectedSignal, err := transmitted.ToBinary()
+			if err != nil {
+				t.Fatalf("error converting state to binary: %v", err)
+			}
+
+			published := h.PubSub.Published(pubsubtest.IRTransmitterTopic)
+			if len(published) != 1 {
+				t.Fatalf("expected 1 ir signal, got: %d", len(published))
+			}
+			var signal pubsub.IRSignal
+			err = json.Unmarshal(published[0].Payload, &signal)
+			if err != nil {
+				t.Fatalf("error unmarshalling ir signal: %v", err)
+			}
+			if signal.Signal != expectedSignal {
+				t.Errorf("expected target temperature %d to be transmitted", compensatedTemperature)
+			}
+
+			var outdoor struct {
+				CompensationOffset int `json:"compensationOffset"`
+			}
+			status = h.Do(http.MethodGet, "/api/v1/outdoor-temperature", nil, &outdoor)
+			if status != http.StatusOK {
+				t.Fatalf("expected status %d, got: %d", http.StatusOK, status)
+			}
+			if outdoor.CompensationOffset != test.offset {
+				t.Errorf("expected compensation offset %d, got: %d", test.offset, outdoor.CompensationOffset)
+			}
+		})
+	}
+}
+
+func TestWebhookIsDeliveredWhileAnotherHangs(t *testing.T) {
+	h := apptest.StartWithFake(t, map[string]string{"WEBHOOK_TIMEOUT": "10s"})
+
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(hanging.Close)
+	t.Cleanup(func() { close(release) })
+
+	delivered := make(chan string, 1)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.Header.Get(webhook.EventHeader)
+	}))
+	t.Cleanup(healthy.Close)
+
+	// Hanging webhook is created first, so its delivery is queued first
+	for _, url := range []string{hanging.URL, healthy.URL} {
+		wh := webhook.Webhook{URL: url, Enabled: true, EventTypes: []webhook.EventType{webhook.StateChangedEvent}}
+		status := h.Do(http.MethodPost, "/api/v1/webhooks", &wh, nil)
+		if status != http.StatusCreated {
+			t.Fatalf("expected status %d, got: %d", http.StatusCreated, status)
+		}
+	}
+
+	mode := heatpump.CoolMode
+	status := h.Do(http.MethodPost, "/api/v1/state", &heatpump.State{Mode: &mode}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, status)
+	}
+
+	select {
+	case eventType := <-delivered:
+		if eventType != string(webhook.StateChangedEvent) {
+			t.Errorf("expected %s event, got: %s", webhook.StateChangedEvent, eventType)
+		}
+	case <-time.After(messageTimeout):
+		t.Fatal("healthy webhook didn't get the delivery while the other one hangs")
+	}
+}
+
+func TestRulesDontTriggerEachOtherInALoop(t *testing.T) {
+	h := apptest.StartWithFake(t, nil)
+
+	// Each rule publishes to the trigger topic of the other one
+	for _, topics := range [][2]string{{"rules/a", "rules/b"}, {"rules/b", "rules/a"}} {
+		r := rule.Rule{
+			Name:    topics[0],
+			Enabled: true,
+			Trigger: rule.Trigger{Type: rule.MQTTTrigger, Topic: topics[0]},
+			Actions: []rule.Action{{Type: rule.PublishAction, Topic: topics[1], Payload: "ping"}},
+		}
+		status := h.Do(http.MethodPost, "/api/v1/rules", &r, nil)
+		if status != http.StatusCreated {
+			t.Fatalf("expected status %d, got: %d", http.StatusCreated, status)
+		}
+	}
+
+	err := h.PubSub.Inject(context.Background(), "rules/a", []byte("ping"))
+	if err != nil {
+		t.Fatalf("error injecting message: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	_, err = h.PubSub.WaitForMessage(ctx, "rules/b")
+	if err != nil {
+		t.Fatalf("error waiting for the first rule to publish: %v", err)
+	}
+
+	// Give a loop the time to show up
+	time.Sleep(200 * time.Millisecond)
+
+	if published := len(h.PubSub.Published("rules/b")); published != 1 {
+		t.Errorf("expected 1 message published to rules/b, got: %d", published)
+	}
+	if published := len(h.PubSub.Published("rules/a")); published != 0 {
+		t.Errorf("expected no messages published back to rules/a, got: %d", published)
+	}
+}
+
+func TestRulesCantPublishToReservedTopics(t *testing.T) {
+	h := apptest.StartWithFake(t, nil)
+
+	for _, topic := range []string{pubsubtest.IRTransmitterTopic, h.Config.PubSubStateSetTopic} {
+		r := rule.Rule{
+			Name:    "spoof",
+			Enabled: true,
+			Trigger: rule.Trigger{Type: rule.MQTTTrigger, Topic: "rules/a"},
+			Actions: []rule.Action{{Type: rule.PublishAction, Topic: topic, Payload: "{}"}},
+		}
+		status := h.Do(http.MethodPost, "/api/v1/rules", &r, nil)
+		if status != http.StatusBadRequest {
+			t.Errorf("expected publishing to %s to be %d, got: %d", topic, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func readDatabase(t *testing.T, filename string) map[string]string {
+	t.Helper()
+
+	file, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("error reading database file: %v", err)
+	}
+
+	var data map[string]string
+	err = json.Unmarshal(file, &data)
+	if err != nil {
+		t.Fatalf("error unmarshalling database file: %v", err)
+	}
+
+	return data
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type Service interface {
+	Start(context.Context, chan<- error)
+	Stop(context.Context) error
+}
+
+// ReadyService is implemented by services that need time to become ready
+// after Start, services listed after it are not started until it's ready.
+type ReadyService interface {
+	Ready() <-chan struct{}
+}
+
+type ManagedService struct {
+	Name    string
+	Service Service
+	// Critical service errors shut down the whole app, other services are
+	// restarted with exponential backoff.
+	Critical bool
+}
+
+// Launch starts the services in order and blocks until the context is
+// cancelled or a critical service fails, then it shuts down services in
+// reverse order and closes the clients.
+func (app *App) Launch(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fatalc := make(chan error, len(app.Services))
+
+	started := 0
+startup:
+	for _, managed := range app.Services {
+		readyc := app.supervise(ctx, managed, fatalc)
+		started++
+
+		select {
+		case <-readyc:
+			slog.Debug(fmt.Sprintf("Service %s is ready", managed.Name))
+		case <-ctx.Done():
+			break startup
+		case err := <-fatalc:
+			// Put the error back for the select below
+			fatalc <- err
+			break startup
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		slog.Debug("Context is cancelled")
+	case err := <-fatalc:
+		slog.Error(fmt.Sprintf("Critical service error: %v", err))
+	}
+	cancel()
+
+	app.shutdown(app.Services[:started])
+}
+
+// supervise starts the service and keeps it running until the context is
+// cancelled. Returned channel is closed once the service is ready.
+func (app *App) supervise(ctx context.Context, managed ManagedService, fatalc chan<- error) <-chan struct{} {
+	readyc := make(chan struct{})
+
+	readyService, ok := managed.Service.(ReadyService)
+	if ok {
+		go func() {
+			select {
+			case <-readyService.Ready():
+				close(readyc)
+			case <-ctx.Done():
+			}
+		}()
+	} else {
+		close(readyc)
+	}
+
+	go func() {
+		backoff := app.restartBackoff
+
+		for {
+			errc := make(chan error, 1)
+			go managed.Service.Start(ctx, errc)
+
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case err = <-errc:
+			}
+
+			if managed.Critical {
+				fatalc <- fmt.Errorf("error running %s service: %v", managed.Name, err)
+				return
+			}
+
+			slog.Error(fmt.Sprintf("Error running %s service, restarting in %s: %v", managed.Name, backoff, err))
+
+			stopCtx, cancel := context.WithTimeout(ctx, app.shutdownTimeout)
+			err = managed.Service.Stop(stopCtx)
+			cancel()
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error stopping %s service before restart: %v", managed.Name, err))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, app.maxBackoff)
+		}
+	}()
+
+	return readyc
+}
+
+func (app *App) shutdown(services []ManagedService) {
+	var errs []error
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout)
+	defer cancel()
+
+	for i := len(services) - 1; i >= 0; i-- {
+		err := services[i].Service.Stop(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error stopping %s service: %v", services[i].Name, err))
+		}
+	}
+
+	err := app.Clients.Close(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error closing app clients: %v", err))
+	}
+
+	if len(errs) > 0 {
+		slog.Error(fmt.Sprintf("Error gracefully shutting down: %v", errors.Join(errs...)))
+	} else {
+		slog.Debug("App has been gracefully shut down")
+	}
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/env"
+	"github.com/alexchebotarsky/heatpump-api/logger"
+	"github.com/alexchebotarsky/heatpump-api/service"
+)
+
+// reloadableSettings are applied by Reload to the running app, other settings
+// need a restart.
+var reloadableSettings = []string{
+	"LOG_LEVEL",
+	"HUMIDITY_HIGH_THRESHOLD",
+	"HUMIDITY_LOW_THRESHOLD",
+	"HUMIDITY_SUSTAIN_DURATION",
+	"HUMIDITY_AUTOMATION_MODE",
+	"HUMIDITY_AUTOMATION_FAN_SPEED",
+	"HUMIDITY_QUIET_HOURS",
+	"HUMIDITY_MANUAL_OVERRIDE_DURATION",
+}
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 5 * time.Second
+
+// Reload applies the reloadable settings that changed, and warns about the
+// others. Nothing is applied if the new config is invalid.
+func (app *App) Reload(config *env.Config) error {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	changed := env.Changed(app.env, config)
+	if len(changed) == 0 {
+		slog.Info("Config is unchanged")
+		return nil
+	}
+
+	var applied, restart []string
+	for _, name := range changed {
+		if slices.Contains(reloadableSettings, name) {
+			applied = append(applied, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+
+	humidityChanged := slices.ContainsFunc(applied, func(name string) bool {
+		return strings.HasPrefix(name, "HUMIDITY_")
+	})
+	if humidityChanged {
+		humidityConfig, err := newHumidityAutomationConfig(config)
+		if err != nil {
+			return err
+		}
+
+		// It's only a service while enabled, its config doesn't matter
+		// otherwise
+		humidityService, ok := app.service("humidity-automation").(*service.HumidityAutomation)
+		if ok {
+			err = humidityService.UpdateConfig(humidityConfig)
+			if err != nil {
+				return fmt.Errorf("error updating humidity automation config: %v", err)
+			}
+		}
+	}
+
+	app.env = config
+
+	if len(applied) > 0 {
+		slog.Info(fmt.Sprintf("Config is reloaded, applied: %s", strings.Join(applied, ", ")))
+	}
+	if len(restart) > 0 {
+		slog.Warn(fmt.Sprintf("Config changes need a restart to apply: %s", strings.Join(restart, ", ")))
+	}
+
+	// Level is changed last, so that the reload is logged at the old level
+	if slices.Contains(applied, "LOG_LEVEL") {
+		logger.SetLevel(config.LogLevel)
+	}
+
+	return nil
+}
+
+// WatchConfig reloads the config on SIGHUP and when the config file changes,
+// until ctx is done. Filename is optional, only SIGHUP triggers a reload
+// without it.
+func (app *App) WatchConfig(ctx context.Context, filename string, load func(ctx context.Context) (*env.Config, error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	modTime := fileModTime(filename)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading config")
+		case <-ticker.C:
+			if filename == "" {
+				continue
+			}
+
+			t := fileModTime(filename)
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+
+			slog.Info(fmt.Sprintf("Config file %s changed, reloading config", filename))
+		}
+
+		config, err := load(ctx)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error reloading config, keeping the current one: %v", err))
+			continue
+		}
+
+		err = app.Reload(config)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error reloading config, keeping the current one: %v", err))
+		}
+	}
+}
+
+// fileModTime returns the zero time if the file can't be read, so that it
+// being removed and restored counts as a change.
+func fileModTime(filename string) time.Time {
+	if filename == "" {
+		return time.Time{}
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+func (app *App) service(name string) Service {
+	for _, managed := range app.Services {
+		if managed.Name == name {
+			return managed.Service
+		}
+	}
+	return nil
+}
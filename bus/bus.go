@@ -0,0 +1,120 @@
+package bus
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+type Type string
+
+const (
+	// ReadingEvent carries Reading data.
+	ReadingEvent Type = "reading"
+	// StateChangeEvent carries StateChange data.
+	StateChangeEvent Type = "stateChange"
+	// WindowContactEvent carries WindowContact data.
+	WindowContactEvent Type = "windowContact"
+	// TransmissionFailedEvent carries TransmissionFailed data.
+	TransmissionFailedEvent Type = "transmissionFailed"
+)
+
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+type Reading struct {
+	Sensor heatpump.Sensor `json:"sensor"`
+	Value  float64         `json:"value"`
+}
+
+type StateChange struct {
+	heatpump.StateChange
+	// Fields that have changed, see heatpump.State json names.
+	Fields []string `json:"fields"`
+}
+
+type WindowContact struct {
+	Open bool `json:"open"`
+}
+
+type TransmissionFailed struct {
+	// State is the state as it was meant to be transmitted.
+	State heatpump.State `json:"state"`
+	Error string         `json:"error"`
+}
+
+// Bus delivers events to in-process subscribers. Publishing never blocks,
+// events are dropped for subscribers that can't keep up.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	events chan Event
+	types  map[Type]bool
+}
+
+func New() *Bus {
+	var b Bus
+
+	b.subscribers = make(map[*subscriber]struct{})
+
+	return &b
+}
+
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subscribers {
+		if len(s.types) > 0 && !s.types[event.Type] {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			slog.Warn("Event bus subscriber is full, dropping event", "type", event.Type)
+			metrics.AddBusEventDropped(string(event.Type))
+		}
+	}
+}
+
+// Subscribe returns a channel receiving events of the given types, or all
+// events if no types are given. Unsubscribe closes the channel.
+func (b *Bus) Subscribe(bufferSize int, types ...Type) (events <-chan Event, unsubscribe func()) {
+	s := &subscriber{
+		events: make(chan Event, bufferSize),
+		types:  make(map[Type]bool, len(types)),
+	}
+	for _, t := range types {
+		s.types[t] = true
+	}
+
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe = func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, s)
+			b.mu.Unlock()
+			close(s.events)
+		})
+	}
+
+	return s.events, unsubscribe
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/alert"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const (
+	AlertsKey              = "alerts"
+	AlertStatesKey         = "alertStates"
+	NotificationHistoryKey = "notificationHistory"
+)
+
+// maxNotificationHistory limits how many notifications are kept, oldest are
+// dropped first.
+const maxNotificationHistory = 100
+
+func (d *Database) FetchAlerts(ctx context.Context) (_ []alert.Rule, err error) {
+	_, span := tracing.Start(ctx, "database.FetchAlerts")
+	defer func() { tracing.End(span, err) }()
+
+	alerts := []alert.Rule{}
+
+	err = d.GetJSON(AlertsKey, &alerts)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", AlertsKey, err)
+	}
+
+	return alerts, nil
+}
+
+func (d *Database) FetchAlert(ctx context.Context, id string) (*alert.Rule, error) {
+	alerts, err := d.FetchAlerts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range alerts {
+		if a.ID == id {
+			return &a, nil
+		}
+	}
+
+	return nil, &client.ErrNotFound{Err: fmt.Errorf("alert %q not found in database", id)}
+}
+
+func (d *Database) AddAlert(ctx context.Context, a *alert.Rule) (err error) {
+	_, span := tracing.Start(ctx, "database.AddAlert")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, AlertsKey, func(alerts []alert.Rule) ([]alert.Rule, error) {
+		return append(alerts, *a), nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", AlertsKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) UpdateAlert(ctx context.Context, a *alert.Rule) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateAlert")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, AlertsKey, func(alerts []alert.Rule) ([]alert.Rule, error) {
+		for i := range alerts {
+			if alerts[i].ID == a.ID {
+				alerts[i] = *a
+				return alerts, nil
+			}
+		}
+
+		return nil, &client.ErrNotFound{Err: fmt.Errorf("alert %q not found in database", a.ID)}
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return err
+		}
+		return fmt.Errorf("error updating %s in database: %v", AlertsKey, err)
+	}
+
+	return nil
+}
+
+// DeleteAlert deletes the alert along with its state.
+func (d *Database) DeleteAlert(ctx context.Context, id string) (err error) {
+	_, span := tracing.Start(ctx, "database.DeleteAlert")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, AlertsKey, func(alerts []alert.Rule) ([]alert.Rule, error) {
+		for i := range alerts {
+			if alerts[i].ID == id {
+				return append(alerts[:i], alerts[i+1:]...), nil
+			}
+		}
+
+		return nil, &client.ErrNotFound{Err: fmt.Errorf("alert %q not found in database", id)}
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return err
+		}
+		return fmt.Errorf("error updating %s in database: %v", AlertsKey, err)
+	}
+
+	err = d.UpdateJSON(AlertStatesKey, func(value string) (any, error) {
+		states, err := decodeAlertStates(value)
+		if err != nil {
+			return nil, err
+		}
+
+		delete(states, id)
+
+		return states, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", AlertStatesKey, err)
+	}
+
+	return nil
+}
+
+// FetchAlertState returns the state of the alert, zero state if it has never
+// been evaluated.
+func (d *Database) FetchAlertState(ctx context.Context, id string) (_ *alert.State, err error) {
+	_, span := tracing.Start(ctx, "database.FetchAlertState")
+	defer func() { tracing.End(span, err) }()
+
+	var states map[string]alert.State
+	err = d.GetJSON(AlertStatesKey, &states)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", AlertStatesKey, err)
+	}
+
+	state := states[id]
+
+	return &state, nil
+}
+
+func (d *Database) UpdateAlertState(ctx context.Context, id string, state *alert.State) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateAlertState")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.UpdateJSON(AlertStatesKey, func(value string) (any, error) {
+		states, err := decodeAlertStates(value)
+		if err != nil {
+			return nil, err
+		}
+
+		states[id] = *state
+
+		return states, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", AlertStatesKey, err)
+	}
+
+	return nil
+}
+
+func decodeAlertStates(value string) (map[string]alert.State, error) {
+	states := make(map[string]alert.State)
+	if value != "" {
+		err := json.Unmarshal([]byte(value), &states)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding alert states: %v", err)
+		}
+	}
+
+	return states, nil
+}
+
+// FetchNotificationHistory returns the sent and suppressed notifications,
+// latest first.
+func (d *Database) FetchNotificationHistory(ctx context.Context) (_ []alert.Record, err error) {
+	_, span := tracing.Start(ctx, "database.FetchNotificationHistory")
+	defer func() { tracing.End(span, err) }()
+
+	var history []alert.Record
+	err = d.GetJSON(NotificationHistoryKey, &history)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", NotificationHistoryKey, err)
+	}
+
+	records := []alert.Record{}
+	for i := len(history) - 1; i >= 0; i-- {
+		records = append(records, history[i])
+	}
+
+	return records, nil
+}
+
+func (d *Database) AddNotificationRecord(ctx context.Context, record *alert.Record) (err error) {
+	_, span := tracing.Start(ctx, "database.AddNotificationRecord")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, NotificationHistoryKey, func(history []alert.Record) ([]alert.Record, error) {
+		history = append(history, *record)
+		if len(history) > maxNotificationHistory {
+			history = history[len(history)-maxNotificationHistory:]
+		}
+		return history, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", NotificationHistoryKey, err)
+	}
+
+	return nil
+}
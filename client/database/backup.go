@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+// ExportData returns a copy of all stored data.
+func (d *Database) ExportData(ctx context.Context) (_ map[string]string, err error) {
+	_, span := tracing.Start(ctx, "database.ExportData")
+	defer func() { tracing.End(span, err) }()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return maps.Clone(d.data), nil
+}
+
+// ReplaceData atomically replaces all stored data with the result of fn, which
+// gets a copy of the current data. Nil result leaves the data unchanged, e.g.
+// for a dry run.
+func (d *Database) ReplaceData(ctx context.Context, fn func(data map[string]string) (map[string]string, error)) (err error) {
+	_, span := tracing.Start(ctx, "database.ReplaceData")
+	defer func() { tracing.End(span, err) }()
+
+	replaced, err := d.replaceData(fn)
+	if err != nil {
+		return err
+	}
+
+	// Metrics are only updated on writes otherwise, so they have to catch up
+	// with the replaced state
+	if replaced {
+		err = d.prepareHeatpumpStatements()
+		if err != nil {
+			return fmt.Errorf("error preparing heatpump statements: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Database) replaceData(fn func(data map[string]string) (map[string]string, error)) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := fn(maps.Clone(d.data))
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		return false, nil
+	}
+
+	err = checkData(data)
+	if err != nil {
+		return false, &client.ErrInvalid{Err: err}
+	}
+
+	previous := d.data
+	d.data = data
+
+	err = d.updateFile()
+	if err != nil {
+		// Keep memory in line with what's most likely still in the file
+		d.data = previous
+		return false, fmt.Errorf("error updating database file: %v", err)
+	}
+
+	return true, nil
+}
+
+// checkData makes sure that the data has a valid heatpump state, which is
+// required for the database to work, unlike the other keys.
+func checkData(data map[string]string) error {
+	var state heatpump.State
+
+	for _, key := range []string{ModeKey, TargetTemperatureKey, FanSpeedKey} {
+		_, ok := data[key]
+		if !ok {
+			return fmt.Errorf("data must have the %s key", key)
+		}
+	}
+
+	mode := heatpump.Mode(data[ModeKey])
+	state.Mode = &mode
+
+	targetTemperature, err := strconv.Atoi(data[TargetTemperatureKey])
+	if err != nil {
+		return fmt.Errorf("error converting %s value %q to int: %v", TargetTemperatureKey, data[TargetTemperatureKey], err)
+	}
+	state.TargetTemperature = &targetTemperature
+
+	fanSpeed, err := strconv.Atoi(data[FanSpeedKey])
+	if err != nil {
+		return fmt.Errorf("error converting %s value %q to int: %v", FanSpeedKey, data[FanSpeedKey], err)
+	}
+	state.FanSpeed = &fanSpeed
+
+	err = state.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid heatpump state: %v", err)
+	}
+
+	return nil
+}
@@ -2,15 +2,19 @@ package database
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/alexchebotarsky/heatpump-api/client"
 )
 
 type Database struct {
+	mu   sync.RWMutex
 	file *os.File
 	data map[string]string
 }
@@ -73,6 +77,9 @@ func (d *Database) updateFile() error {
 }
 
 func (d *Database) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	err := d.file.Close()
 	if err != nil {
 		return fmt.Errorf("error closing database file: %v", err)
@@ -82,6 +89,9 @@ func (d *Database) Close() error {
 }
 
 func (d *Database) GetStr(key string) (string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	value, ok := d.data[key]
 	if !ok {
 		return "", &client.ErrNotFound{Err: fmt.Errorf("key %q not found in database", key)}
@@ -91,6 +101,9 @@ func (d *Database) GetStr(key string) (string, error) {
 }
 
 func (d *Database) GetInt(key string) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	value, ok := d.data[key]
 	if !ok {
 		return 0, &client.ErrNotFound{Err: fmt.Errorf("key %q not found in database", key)}
@@ -105,6 +118,9 @@ func (d *Database) GetInt(key string) (int, error) {
 }
 
 func (d *Database) GetFloat(key string) (float64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	value, ok := d.data[key]
 	if !ok {
 		return 0, &client.ErrNotFound{Err: fmt.Errorf("key %q not found in database", key)}
@@ -118,7 +134,31 @@ func (d *Database) GetFloat(key string) (float64, error) {
 	return floatValue, nil
 }
 
+func (d *Database) GetTime(key string) (time.Time, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	value, ok := d.data[key]
+	if !ok {
+		return time.Time{}, &client.ErrNotFound{Err: fmt.Errorf("key %q not found in database", key)}
+	}
+
+	timeValue, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error converting value %q to time: %v", value, err)
+	}
+
+	return timeValue, nil
+}
+
+func (d *Database) SetTime(key string, value time.Time) error {
+	return d.Set(key, value.UTC().Format(time.RFC3339Nano))
+}
+
 func (d *Database) Set(key, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	d.data[key] = value
 
 	err := d.updateFile()
@@ -130,6 +170,9 @@ func (d *Database) Set(key, value string) error {
 }
 
 func (d *Database) Delete(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	delete(d.data, key)
 
 	err := d.updateFile()
@@ -139,3 +182,56 @@ func (d *Database) Delete(key string) error {
 
 	return nil
 }
+
+func (d *Database) GetJSON(key string, v any) error {
+	value, err := d.GetStr(key)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal([]byte(value), v)
+	if err != nil {
+		return fmt.Errorf("error decoding json value of %q: %v", key, err)
+	}
+
+	return nil
+}
+
+func (d *Database) SetJSON(key string, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding json value of %q: %v", key, err)
+	}
+
+	return d.Set(key, string(value))
+}
+
+// UpdateJSON atomically replaces the value of the key with the json encoded
+// result of fn. Empty string is passed to fn if the key doesn't exist yet.
+func (d *Database) UpdateJSON(key string, fn func(value string) (any, error)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	v, err := fn(d.data[key])
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding json value of %q: %v", key, err)
+	}
+	d.data[key] = string(value)
+
+	err = d.updateFile()
+	if err != nil {
+		return fmt.Errorf("error updating database file: %v", err)
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var errNotFound *client.ErrNotFound
+	return errors.As(err, &errNotFound)
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/deadletter"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const DeadLettersKey = "deadLetters"
+
+// maxDeadLetters limits how many failed messages are kept, oldest are dropped first.
+const maxDeadLetters = 100
+
+func (d *Database) FetchDeadLetters(ctx context.Context) (_ []deadletter.Message, err error) {
+	_, span := tracing.Start(ctx, "database.FetchDeadLetters")
+	defer func() { tracing.End(span, err) }()
+
+	messages := []deadletter.Message{}
+
+	err = d.GetJSON(DeadLettersKey, &messages)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", DeadLettersKey, err)
+	}
+
+	return messages, nil
+}
+
+func (d *Database) FetchDeadLetter(ctx context.Context, id string) (*deadletter.Message, error) {
+	messages, err := d.FetchDeadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range messages {
+		if message.ID == id {
+			return &message, nil
+		}
+	}
+
+	return nil, &client.ErrNotFound{Err: fmt.Errorf("dead letter %q not found in database", id)}
+}
+
+func (d *Database) AddDeadLetter(ctx context.Context, message *deadletter.Message) (err error) {
+	_, span := tracing.Start(ctx, "database.AddDeadLetter")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.UpdateJSON(DeadLettersKey, func(value string) (any, error) {
+		var messages []deadletter.Message
+		if value != "" {
+			err := json.Unmarshal([]byte(value), &messages)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding dead letters: %v", err)
+			}
+		}
+
+		messages = append(messages, *message)
+		if len(messages) > maxDeadLetters {
+			messages = messages[len(messages)-maxDeadLetters:]
+		}
+
+		return messages, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", DeadLettersKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) DeleteDeadLetter(ctx context.Context, id string) (err error) {
+	_, span := tracing.Start(ctx, "database.DeleteDeadLetter")
+	defer func() { tracing.End(span, err) }()
+
+	var found bool
+
+	err = d.UpdateJSON(DeadLettersKey, func(value string) (any, error) {
+		var messages []deadletter.Message
+		if value != "" {
+			err := json.Unmarshal([]byte(value), &messages)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding dead letters: %v", err)
+			}
+		}
+
+		remaining := make([]deadletter.Message, 0, len(messages))
+		for _, message := range messages {
+			if message.ID == id {
+				found = true
+				continue
+			}
+			remaining = append(remaining, message)
+		}
+
+		return remaining, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", DeadLettersKey, err)
+	}
+
+	if !found {
+		return &client.ErrNotFound{Err: fmt.Errorf("dead letter %q not found in database", id)}
+	}
+
+	return nil
+}
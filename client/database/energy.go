@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const (
+	MeasuredPowerKey           = "measuredPower"
+	MeasuredPowerAtKey         = "measuredPowerAt"
+	EnergyCalibrationFactorKey = "energyCalibrationFactor"
+)
+
+func (d *Database) FetchMeasuredPower(ctx context.Context) (power float64, measuredAt time.Time, err error) {
+	_, span := tracing.Start(ctx, "database.FetchMeasuredPower")
+	defer func() { tracing.End(span, err) }()
+
+	power, err = d.GetFloat(MeasuredPowerKey)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	measuredAt, err = d.GetTime(MeasuredPowerAtKey)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return power, measuredAt, nil
+}
+
+func (d *Database) UpdateMeasuredPower(ctx context.Context, power float64, measuredAt time.Time) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateMeasuredPower")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.Set(MeasuredPowerKey, fmt.Sprintf("%.1f", power))
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", MeasuredPowerKey, err)
+	}
+
+	err = d.SetTime(MeasuredPowerAtKey, measuredAt)
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", MeasuredPowerAtKey, err)
+	}
+
+	return nil
+}
+
+// FetchEnergyCalibrationFactor returns 1 if the estimate hasn't been
+// calibrated yet.
+func (d *Database) FetchEnergyCalibrationFactor(ctx context.Context) (_ float64, err error) {
+	_, span := tracing.Start(ctx, "database.FetchEnergyCalibrationFactor")
+	defer func() { tracing.End(span, err) }()
+
+	factor, err := d.GetFloat(EnergyCalibrationFactorKey)
+	if err != nil {
+		if isNotFound(err) {
+			return 1, nil
+		}
+		return 0, fmt.Errorf("error getting %s from database: %v", EnergyCalibrationFactorKey, err)
+	}
+
+	return factor, nil
+}
+
+func (d *Database) UpdateEnergyCalibrationFactor(ctx context.Context, factor float64) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateEnergyCalibrationFactor")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.Set(EnergyCalibrationFactorKey, fmt.Sprintf("%.4f", factor))
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", EnergyCalibrationFactorKey, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/health"
+)
+
+func (d *Database) HealthChecks(sensorMaxAge, irTransmitterAckTimeout time.Duration) []health.Check {
+	return []health.Check{
+		{
+			Name:     "database",
+			Critical: true,
+			Func:     d.checkWritable,
+		},
+		{
+			Name: "temperatureSensor",
+			Func: func(ctx context.Context) error {
+				return d.checkSensorFreshness(sensorMaxAge)
+			},
+		},
+		{
+			Name: "irTransmitter",
+			Func: func(ctx context.Context) error {
+				return d.checkIRTransmitterAck(irTransmitterAckTimeout)
+			},
+		},
+	}
+}
+
+// checkWritable writes and syncs a probe file next to the database file, which
+// fails if the disk is not writable anymore. The database file itself is left
+// alone, so probes don't add writes to it. File operations can't be cancelled,
+// so a hanging disk is reported when the context is done.
+func (d *Database) checkWritable(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- d.writeProbe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("error writing probe file: %v", ctx.Err())
+	}
+}
+
+func (d *Database) writeProbe() error {
+	probe, err := os.CreateTemp(filepath.Dir(d.file.Name()), ".health-*")
+	if err != nil {
+		return fmt.Errorf("error creating probe file: %v", err)
+	}
+	defer os.Remove(probe.Name())
+	defer probe.Close()
+
+	_, err = probe.Write([]byte(time.Now().UTC().Format(time.RFC3339)))
+	if err != nil {
+		return fmt.Errorf("error writing probe file: %v", err)
+	}
+
+	err = probe.Sync()
+	if err != nil {
+		return fmt.Errorf("error syncing probe file: %v", err)
+	}
+
+	return nil
+}
+
+func (d *Database) checkSensorFreshness(maxAge time.Duration) error {
+	updatedAt, err := d.GetTime(TemperatureAndHumidityUpdatedAtKey)
+	if err != nil {
+		return fmt.Errorf("error getting last sensor reading time: %v", err)
+	}
+
+	age := time.Since(updatedAt)
+	if age > maxAge {
+		return fmt.Errorf("last sensor reading is %s old, max age is %s", age.Round(time.Second), maxAge)
+	}
+
+	return nil
+}
+
+func (d *Database) checkIRTransmitterAck(ackTimeout time.Duration) error {
+	transmittedAt, err := d.GetTime(IRTransmittedAtKey)
+	if err != nil {
+		if isNotFound(err) {
+			// Nothing has been transmitted yet
+			return nil
+		}
+		return fmt.Errorf("error getting last transmission time: %v", err)
+	}
+
+	ackedAt, err := d.GetTime(IRTransmitterAckedAtKey)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("error getting last transmitter ack time: %v", err)
+	}
+
+	if ackedAt.Before(transmittedAt) && time.Since(transmittedAt) > ackTimeout {
+		return fmt.Errorf("transmitter has not acknowledged signal sent at %s", transmittedAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckWritableLeavesDatabaseAlone(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "database.json")
+
+	d, err := New(filename, map[string]string{
+		ModeKey:              "HEAT",
+		TargetTemperatureKey: "22",
+		FanSpeedKey:          "0",
+	})
+	if err != nil {
+		t.Fatalf("error creating database: %v", err)
+	}
+	defer d.Close()
+
+	before, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.checkWritable(context.Background())
+	if err != nil {
+		t.Fatalf("expected database to be writable, got: %v", err)
+	}
+
+	after, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("expected database file to be left alone")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected probe file to be removed, got: %d files", len(entries))
+	}
+}
@@ -1,10 +1,12 @@
 package database
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/alexchebotarsky/heatpump-api/metrics"
 	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
 )
 
 const (
@@ -35,9 +37,11 @@ func (d *Database) prepareHeatpumpStatements() error {
 	return nil
 }
 
-func (d *Database) FetchHeatpumpState() (*heatpump.State, error) {
+func (d *Database) FetchHeatpumpState(ctx context.Context) (_ *heatpump.State, err error) {
+	_, span := tracing.Start(ctx, "database.FetchHeatpumpState")
+	defer func() { tracing.End(span, err) }()
+
 	var s heatpump.State
-	var err error
 
 	modeValue, err := d.GetStr(ModeKey)
 	if err != nil {
@@ -61,10 +65,13 @@ func (d *Database) FetchHeatpumpState() (*heatpump.State, error) {
 	return &s, nil
 }
 
-func (d *Database) UpdateHeatpumpState(state *heatpump.State) (*heatpump.State, error) {
+func (d *Database) UpdateHeatpumpState(ctx context.Context, state *heatpump.State) (_ *heatpump.State, err error) {
+	_, span := tracing.Start(ctx, "database.UpdateHeatpumpState")
+	defer func() { tracing.End(span, err) }()
+
 	if state.Mode != nil {
 		mode := *state.Mode
-		err := d.Set(ModeKey, string(mode))
+		err = d.Set(ModeKey, string(mode))
 		if err != nil {
 			return nil, fmt.Errorf("error setting %s in database: %v", ModeKey, err)
 		}
@@ -73,7 +80,7 @@ func (d *Database) UpdateHeatpumpState(state *heatpump.State) (*heatpump.State,
 
 	if state.TargetTemperature != nil {
 		temperature := *state.TargetTemperature
-		err := d.Set(TargetTemperatureKey, fmt.Sprintf("%d", temperature))
+		err = d.Set(TargetTemperatureKey, fmt.Sprintf("%d", temperature))
 		if err != nil {
 			return nil, fmt.Errorf("error setting %s in database: %v", TargetTemperatureKey, err)
 		}
@@ -82,14 +89,14 @@ func (d *Database) UpdateHeatpumpState(state *heatpump.State) (*heatpump.State,
 
 	if state.FanSpeed != nil {
 		fanSpeed := snapToNearest(*state.FanSpeed, 20)
-		err := d.Set(FanSpeedKey, fmt.Sprintf("%d", fanSpeed))
+		err = d.Set(FanSpeedKey, fmt.Sprintf("%d", fanSpeed))
 		if err != nil {
 			return nil, fmt.Errorf("error setting %s in database: %v", FanSpeedKey, err)
 		}
 		metrics.SetHeatpumpFanSpeed(fanSpeed)
 	}
 
-	return d.FetchHeatpumpState()
+	return d.FetchHeatpumpState(ctx)
 }
 
 func snapToNearest(number, snap int) int {
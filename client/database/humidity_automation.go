@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/model/humidity"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const (
+	HumidityAutomationKey          = "humidityAutomation"
+	HumidityAutomationDecisionsKey = "humidityAutomationDecisions"
+)
+
+// maxHumidityAutomationDecisions limits the decision history, oldest are dropped first.
+const maxHumidityAutomationDecisions = 200
+
+func (d *Database) FetchHumidityAutomation(ctx context.Context) (_ *humidity.Automation, err error) {
+	_, span := tracing.Start(ctx, "database.FetchHumidityAutomation")
+	defer func() { tracing.End(span, err) }()
+
+	var automation humidity.Automation
+	err = d.GetJSON(HumidityAutomationKey, &automation)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", HumidityAutomationKey, err)
+	}
+
+	return &automation, nil
+}
+
+func (d *Database) UpdateHumidityAutomation(ctx context.Context, automation *humidity.Automation) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateHumidityAutomation")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.SetJSON(HumidityAutomationKey, automation)
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", HumidityAutomationKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) FetchHumidityAutomationDecisions(ctx context.Context) (_ []humidity.Decision, err error) {
+	_, span := tracing.Start(ctx, "database.FetchHumidityAutomationDecisions")
+	defer func() { tracing.End(span, err) }()
+
+	decisions := []humidity.Decision{}
+
+	err = d.GetJSON(HumidityAutomationDecisionsKey, &decisions)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", HumidityAutomationDecisionsKey, err)
+	}
+
+	return decisions, nil
+}
+
+func (d *Database) AddHumidityAutomationDecision(ctx context.Context, decision *humidity.Decision) (err error) {
+	_, span := tracing.Start(ctx, "database.AddHumidityAutomationDecision")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.UpdateJSON(HumidityAutomationDecisionsKey, func(value string) (any, error) {
+		var decisions []humidity.Decision
+		if value != "" {
+			err := json.Unmarshal([]byte(value), &decisions)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding humidity automation decisions: %v", err)
+			}
+		}
+
+		decisions = append(decisions, *decision)
+		if len(decisions) > maxHumidityAutomationDecisions {
+			decisions = decisions[len(decisions)-maxHumidityAutomationDecisions:]
+		}
+
+		return decisions, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", HumidityAutomationDecisionsKey, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const (
+	IRTransmittedAtKey      = "irTransmittedAt"
+	IRTransmitterAckedAtKey = "irTransmitterAckedAt"
+)
+
+func (d *Database) UpdateIRTransmittedAt(ctx context.Context, transmittedAt time.Time) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateIRTransmittedAt")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.SetTime(IRTransmittedAtKey, transmittedAt)
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", IRTransmittedAtKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) UpdateIRTransmitterAckedAt(ctx context.Context, ackedAt time.Time) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateIRTransmitterAckedAt")
+	defer func() { tracing.End(span, err) }()
+
+	transmittedAt, transmittedErr := d.GetTime(IRTransmittedAtKey)
+	previousAckedAt, previousAckedErr := d.GetTime(IRTransmitterAckedAtKey)
+	// Only the first ack after a transmission is counted
+	if transmittedErr == nil && (previousAckedErr != nil || previousAckedAt.Before(transmittedAt)) {
+		metrics.ObserveIRTransmissionLatency(ackedAt.Sub(transmittedAt))
+	}
+
+	err = d.SetTime(IRTransmitterAckedAtKey, ackedAt)
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", IRTransmitterAckedAtKey, err)
+	}
+
+	return nil
+}
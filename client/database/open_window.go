@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/model/window"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const OpenWindowKey = "openWindow"
+
+func (d *Database) FetchOpenWindow(ctx context.Context) (_ *window.Suspension, err error) {
+	_, span := tracing.Start(ctx, "database.FetchOpenWindow")
+	defer func() { tracing.End(span, err) }()
+
+	var suspension window.Suspension
+	err = d.GetJSON(OpenWindowKey, &suspension)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", OpenWindowKey, err)
+	}
+
+	return &suspension, nil
+}
+
+func (d *Database) UpdateOpenWindow(ctx context.Context, suspension *window.Suspension) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateOpenWindow")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.SetJSON(OpenWindowKey, suspension)
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", OpenWindowKey, err)
+	}
+
+	return nil
+}
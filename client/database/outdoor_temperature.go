@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const (
+	OutdoorTemperatureKey          = "outdoorTemperature"
+	OutdoorTemperatureUpdatedAtKey = "outdoorTemperatureUpdatedAt"
+)
+
+func (d *Database) FetchOutdoorTemperature(ctx context.Context) (temperature float64, updatedAt time.Time, err error) {
+	_, span := tracing.Start(ctx, "database.FetchOutdoorTemperature")
+	defer func() { tracing.End(span, err) }()
+
+	temperature, err = d.GetFloat(OutdoorTemperatureKey)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	updatedAt, err = d.GetTime(OutdoorTemperatureUpdatedAtKey)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return temperature, updatedAt, nil
+}
+
+func (d *Database) UpdateOutdoorTemperature(ctx context.Context, temperature float64) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateOutdoorTemperature")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.Set(OutdoorTemperatureKey, fmt.Sprintf("%.1f", temperature))
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", OutdoorTemperatureKey, err)
+	}
+
+	metrics.SetOutdoorTemperature(temperature)
+
+	err = d.SetTime(OutdoorTemperatureUpdatedAtKey, time.Now())
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", OutdoorTemperatureUpdatedAtKey, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/model/presence"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const PresenceKey = "presence"
+
+func (d *Database) FetchPresence(ctx context.Context) (_ *presence.Status, err error) {
+	_, span := tracing.Start(ctx, "database.FetchPresence")
+	defer func() { tracing.End(span, err) }()
+
+	status := presence.Status{
+		// Assume someone's home until told otherwise
+		AnyoneHome: true,
+	}
+
+	err = d.GetJSON(PresenceKey, &status)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", PresenceKey, err)
+	}
+
+	if status.People == nil {
+		status.People = make(map[string]presence.Person)
+	}
+	if status.MotionSensors == nil {
+		status.MotionSensors = make(map[string]presence.MotionSensor)
+	}
+
+	return &status, nil
+}
+
+func (d *Database) UpdatePresence(ctx context.Context, status *presence.Status) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdatePresence")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.SetJSON(PresenceKey, status)
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", PresenceKey, err)
+	}
+
+	return nil
+}
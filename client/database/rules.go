@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/rule"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const RulesKey = "rules"
+
+func (d *Database) FetchRules(ctx context.Context) (_ []rule.Rule, err error) {
+	_, span := tracing.Start(ctx, "database.FetchRules")
+	defer func() { tracing.End(span, err) }()
+
+	rules := []rule.Rule{}
+
+	err = d.GetJSON(RulesKey, &rules)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", RulesKey, err)
+	}
+
+	return rules, nil
+}
+
+func (d *Database) FetchRule(ctx context.Context, id string) (*rule.Rule, error) {
+	rules, err := d.FetchRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		if r.ID == id {
+			return &r, nil
+		}
+	}
+
+	return nil, &client.ErrNotFound{Err: fmt.Errorf("rule %q not found in database", id)}
+}
+
+func (d *Database) AddRule(ctx context.Context, r *rule.Rule) (err error) {
+	_, span := tracing.Start(ctx, "database.AddRule")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.updateRules(func(rules []rule.Rule) ([]rule.Rule, error) {
+		return append(rules, *r), nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", RulesKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) UpdateRule(ctx context.Context, r *rule.Rule) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateRule")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.updateRules(func(rules []rule.Rule) ([]rule.Rule, error) {
+		for i := range rules {
+			if rules[i].ID == r.ID {
+				rules[i] = *r
+				return rules, nil
+			}
+		}
+
+		return nil, &client.ErrNotFound{Err: fmt.Errorf("rule %q not found in database", r.ID)}
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return err
+		}
+		return fmt.Errorf("error updating %s in database: %v", RulesKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) UpdateRuleTriggeredAt(ctx context.Context, id string, triggeredAt time.Time) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateRuleTriggeredAt")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.updateRules(func(rules []rule.Rule) ([]rule.Rule, error) {
+		for i := range rules {
+			if rules[i].ID == id {
+				rules[i].LastTriggeredAt = &triggeredAt
+				return rules, nil
+			}
+		}
+
+		return nil, &client.ErrNotFound{Err: fmt.Errorf("rule %q not found in database", id)}
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return err
+		}
+		return fmt.Errorf("error updating %s in database: %v", RulesKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) DeleteRule(ctx context.Context, id string) (err error) {
+	_, span := tracing.Start(ctx, "database.DeleteRule")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.updateRules(func(rules []rule.Rule) ([]rule.Rule, error) {
+		for i := range rules {
+			if rules[i].ID == id {
+				return append(rules[:i], rules[i+1:]...), nil
+			}
+		}
+
+		return nil, &client.ErrNotFound{Err: fmt.Errorf("rule %q not found in database", id)}
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return err
+		}
+		return fmt.Errorf("error updating %s in database: %v", RulesKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) updateRules(fn func(rules []rule.Rule) ([]rule.Rule, error)) error {
+	return d.UpdateJSON(RulesKey, func(value string) (any, error) {
+		rules := []rule.Rule{}
+		if value != "" {
+			err := json.Unmarshal([]byte(value), &rules)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding rules: %v", err)
+			}
+		}
+
+		return fn(rules)
+	})
+}
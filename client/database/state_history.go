@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const StateHistoryKey = "stateHistory"
+
+// stateHistoryRetention is long enough to report on the previous month.
+const stateHistoryRetention = 62 * 24 * time.Hour
+
+func (d *Database) FetchStateHistory(ctx context.Context, from time.Time) (_ []heatpump.StateChange, err error) {
+	_, span := tracing.Start(ctx, "database.FetchStateHistory")
+	defer func() { tracing.End(span, err) }()
+
+	var history []heatpump.StateChange
+	err = d.GetJSON(StateHistoryKey, &history)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", StateHistoryKey, err)
+	}
+
+	// Include the last change before the start, as it defines the state at the start
+	start := 0
+	for i, change := range history {
+		if change.Time.After(from) {
+			break
+		}
+		start = i
+	}
+
+	return history[start:], nil
+}
+
+func (d *Database) AddStateChange(ctx context.Context, change *heatpump.StateChange) (err error) {
+	_, span := tracing.Start(ctx, "database.AddStateChange")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.UpdateJSON(StateHistoryKey, func(value string) (any, error) {
+		var history []heatpump.StateChange
+		if value != "" {
+			err := json.Unmarshal([]byte(value), &history)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding state history: %v", err)
+			}
+		}
+
+		history = append(history, *change)
+
+		cutoff := time.Now().Add(-stateHistoryRetention)
+		for len(history) > 1 && history[1].Time.Before(cutoff) {
+			history = history[1:]
+		}
+
+		return history, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", StateHistoryKey, err)
+	}
+
+	return nil
+}
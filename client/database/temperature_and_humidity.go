@@ -1,17 +1,27 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
 )
 
 const (
 	CurrentTemperatureKey = "currentTemperature"
 	CurrentHumidityKey    = "currentHumidity"
+
+	TemperatureAndHumidityUpdatedAtKey = "temperatureAndHumidityUpdatedAt"
 )
 
-func (d *Database) FetchTemperatureAndHumidity() (temperature float64, humidity float64, err error) {
+func (d *Database) FetchTemperatureAndHumidity(ctx context.Context) (temperature float64, humidity float64, err error) {
+	_, span := tracing.Start(ctx, "database.FetchTemperatureAndHumidity")
+	defer func() { tracing.End(span, err) }()
+
 	temperature, err = d.GetFloat(CurrentTemperatureKey)
 	if err != nil {
 		return 0, 0, err
@@ -25,8 +35,11 @@ func (d *Database) FetchTemperatureAndHumidity() (temperature float64, humidity
 	return temperature, humidity, nil
 }
 
-func (d *Database) UpdateTemperatureAndHumidity(temperature float64, humidity float64) error {
-	err := d.Set(CurrentTemperatureKey, fmt.Sprintf("%.1f", temperature))
+func (d *Database) UpdateTemperatureAndHumidity(ctx context.Context, temperature float64, humidity float64) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateTemperatureAndHumidity")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.Set(CurrentTemperatureKey, fmt.Sprintf("%.1f", temperature))
 	if err != nil {
 		return fmt.Errorf("error setting %s in database: %v", CurrentTemperatureKey, err)
 	}
@@ -40,5 +53,88 @@ func (d *Database) UpdateTemperatureAndHumidity(temperature float64, humidity fl
 
 	metrics.SetHeatpumpCurrentHumidity(humidity)
 
+	now := time.Now()
+	err = d.SetTime(TemperatureAndHumidityUpdatedAtKey, now)
+	if err != nil {
+		return fmt.Errorf("error setting %s in database: %v", TemperatureAndHumidityUpdatedAtKey, err)
+	}
+
+	err = d.addReading(now, temperature, humidity)
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", ReadingHistoryKey, err)
+	}
+
 	return nil
 }
+
+func (d *Database) FetchTemperatureAndHumidityUpdatedAt(ctx context.Context) (_ time.Time, err error) {
+	_, span := tracing.Start(ctx, "database.FetchTemperatureAndHumidityUpdatedAt")
+	defer func() { tracing.End(span, err) }()
+
+	updatedAt, err := d.GetTime(TemperatureAndHumidityUpdatedAtKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return updatedAt, nil
+}
+
+const ReadingHistoryKey = "readingHistory"
+
+const (
+	// readingHistoryInterval is the minimum time between history entries,
+	// readings in between are only kept as the current values.
+	readingHistoryInterval  = 5 * time.Minute
+	readingHistoryRetention = 7 * 24 * time.Hour
+)
+
+func (d *Database) FetchReadingHistory(ctx context.Context, from time.Time) (_ []heatpump.Reading, err error) {
+	_, span := tracing.Start(ctx, "database.FetchReadingHistory")
+	defer func() { tracing.End(span, err) }()
+
+	var history []heatpump.Reading
+	err = d.GetJSON(ReadingHistoryKey, &history)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", ReadingHistoryKey, err)
+	}
+
+	readings := []heatpump.Reading{}
+	for _, reading := range history {
+		if !reading.Time.Before(from) {
+			readings = append(readings, reading)
+		}
+	}
+
+	return readings, nil
+}
+
+func (d *Database) addReading(now time.Time, temperature float64, humidity float64) error {
+	return d.UpdateJSON(ReadingHistoryKey, func(value string) (any, error) {
+		var history []heatpump.Reading
+		if value != "" {
+			err := json.Unmarshal([]byte(value), &history)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding reading history: %v", err)
+			}
+		}
+
+		if len(history) > 0 && now.Sub(history[len(history)-1].Time) < readingHistoryInterval {
+			return history, nil
+		}
+
+		history = append(history, heatpump.Reading{
+			Time: now.UTC(),
+			TemperatureReading: heatpump.TemperatureReading{
+				Temperature: temperature,
+				Humidity:    humidity,
+			},
+		})
+
+		cutoff := now.Add(-readingHistoryRetention)
+		for len(history) > 0 && history[0].Time.Before(cutoff) {
+			history = history[1:]
+		}
+
+		return history, nil
+	})
+}
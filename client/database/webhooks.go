@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/webhook"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+const (
+	WebhooksKey          = "webhooks"
+	WebhookQueueKey      = "webhookQueue"
+	WebhookDeliveriesKey = "webhookDeliveries"
+)
+
+// maxWebhookAttempts limits how many delivery log entries are kept per
+// webhook, oldest are dropped first.
+const maxWebhookAttempts = 50
+
+func (d *Database) FetchWebhooks(ctx context.Context) (_ []webhook.Webhook, err error) {
+	_, span := tracing.Start(ctx, "database.FetchWebhooks")
+	defer func() { tracing.End(span, err) }()
+
+	webhooks := []webhook.Webhook{}
+
+	err = d.GetJSON(WebhooksKey, &webhooks)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", WebhooksKey, err)
+	}
+
+	return webhooks, nil
+}
+
+func (d *Database) FetchWebhook(ctx context.Context, id string) (*webhook.Webhook, error) {
+	webhooks, err := d.FetchWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range webhooks {
+		if w.ID == id {
+			return &w, nil
+		}
+	}
+
+	return nil, &client.ErrNotFound{Err: fmt.Errorf("webhook %q not found in database", id)}
+}
+
+func (d *Database) AddWebhook(ctx context.Context, w *webhook.Webhook) (err error) {
+	_, span := tracing.Start(ctx, "database.AddWebhook")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, WebhooksKey, func(webhooks []webhook.Webhook) ([]webhook.Webhook, error) {
+		return append(webhooks, *w), nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", WebhooksKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) UpdateWebhook(ctx context.Context, w *webhook.Webhook) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateWebhook")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, WebhooksKey, func(webhooks []webhook.Webhook) ([]webhook.Webhook, error) {
+		for i := range webhooks {
+			if webhooks[i].ID == w.ID {
+				webhooks[i] = *w
+				return webhooks, nil
+			}
+		}
+
+		return nil, &client.ErrNotFound{Err: fmt.Errorf("webhook %q not found in database", w.ID)}
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return err
+		}
+		return fmt.Errorf("error updating %s in database: %v", WebhooksKey, err)
+	}
+
+	return nil
+}
+
+// DeleteWebhook deletes the webhook along with its queued deliveries and
+// delivery log.
+func (d *Database) DeleteWebhook(ctx context.Context, id string) (err error) {
+	_, span := tracing.Start(ctx, "database.DeleteWebhook")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, WebhooksKey, func(webhooks []webhook.Webhook) ([]webhook.Webhook, error) {
+		for i := range webhooks {
+			if webhooks[i].ID == id {
+				return append(webhooks[:i], webhooks[i+1:]...), nil
+			}
+		}
+
+		return nil, &client.ErrNotFound{Err: fmt.Errorf("webhook %q not found in database", id)}
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return err
+		}
+		return fmt.Errorf("error updating %s in database: %v", WebhooksKey, err)
+	}
+
+	err = updateList(d, WebhookQueueKey, func(queue []webhook.Delivery) ([]webhook.Delivery, error) {
+		kept := []webhook.Delivery{}
+		for _, delivery := range queue {
+			if delivery.WebhookID != id {
+				kept = append(kept, delivery)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", WebhookQueueKey, err)
+	}
+
+	err = d.UpdateJSON(WebhookDeliveriesKey, func(value string) (any, error) {
+		deliveries, err := decodeWebhookDeliveries(value)
+		if err != nil {
+			return nil, err
+		}
+
+		delete(deliveries, id)
+
+		return deliveries, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", WebhookDeliveriesKey, err)
+	}
+
+	return nil
+}
+
+// FetchWebhookQueue returns the pending deliveries in the order they were
+// enqueued.
+func (d *Database) FetchWebhookQueue(ctx context.Context) (_ []webhook.Delivery, err error) {
+	_, span := tracing.Start(ctx, "database.FetchWebhookQueue")
+	defer func() { tracing.End(span, err) }()
+
+	queue := []webhook.Delivery{}
+
+	err = d.GetJSON(WebhookQueueKey, &queue)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", WebhookQueueKey, err)
+	}
+
+	return queue, nil
+}
+
+func (d *Database) EnqueueWebhookDeliveries(ctx context.Context, deliveries []webhook.Delivery) (err error) {
+	_, span := tracing.Start(ctx, "database.EnqueueWebhookDeliveries")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, WebhookQueueKey, func(queue []webhook.Delivery) ([]webhook.Delivery, error) {
+		return append(queue, deliveries...), nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", WebhookQueueKey, err)
+	}
+
+	return nil
+}
+
+// UpdateWebhookDelivery replaces the queued delivery, it does nothing if the
+// delivery is not queued anymore.
+func (d *Database) UpdateWebhookDelivery(ctx context.Context, delivery *webhook.Delivery) (err error) {
+	_, span := tracing.Start(ctx, "database.UpdateWebhookDelivery")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, WebhookQueueKey, func(queue []webhook.Delivery) ([]webhook.Delivery, error) {
+		for i := range queue {
+			if queue[i].ID == delivery.ID {
+				queue[i] = *delivery
+				break
+			}
+		}
+		return queue, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", WebhookQueueKey, err)
+	}
+
+	return nil
+}
+
+func (d *Database) RemoveWebhookDelivery(ctx context.Context, id string) (err error) {
+	_, span := tracing.Start(ctx, "database.RemoveWebhookDelivery")
+	defer func() { tracing.End(span, err) }()
+
+	err = updateList(d, WebhookQueueKey, func(queue []webhook.Delivery) ([]webhook.Delivery, error) {
+		for i := range queue {
+			if queue[i].ID == id {
+				return append(queue[:i], queue[i+1:]...), nil
+			}
+		}
+		return queue, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", WebhookQueueKey, err)
+	}
+
+	return nil
+}
+
+// FetchWebhookDeliveries returns the delivery log of the webhook, latest
+// attempts first.
+func (d *Database) FetchWebhookDeliveries(ctx context.Context, webhookID string) (_ []webhook.Attempt, err error) {
+	_, span := tracing.Start(ctx, "database.FetchWebhookDeliveries")
+	defer func() { tracing.End(span, err) }()
+
+	var deliveries map[string][]webhook.Attempt
+	err = d.GetJSON(WebhookDeliveriesKey, &deliveries)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("error getting %s from database: %v", WebhookDeliveriesKey, err)
+	}
+
+	attempts := []webhook.Attempt{}
+	for i := len(deliveries[webhookID]) - 1; i >= 0; i-- {
+		attempts = append(attempts, deliveries[webhookID][i])
+	}
+
+	return attempts, nil
+}
+
+func (d *Database) AddWebhookAttempt(ctx context.Context, webhookID string, attempt *webhook.Attempt) (err error) {
+	_, span := tracing.Start(ctx, "database.AddWebhookAttempt")
+	defer func() { tracing.End(span, err) }()
+
+	err = d.UpdateJSON(WebhookDeliveriesKey, func(value string) (any, error) {
+		deliveries, err := decodeWebhookDeliveries(value)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts := append(deliveries[webhookID], *attempt)
+		if len(attempts) > maxWebhookAttempts {
+			attempts = attempts[len(attempts)-maxWebhookAttempts:]
+		}
+		deliveries[webhookID] = attempts
+
+		return deliveries, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating %s in database: %v", WebhookDeliveriesKey, err)
+	}
+
+	return nil
+}
+
+func decodeWebhookDeliveries(value string) (map[string][]webhook.Attempt, error) {
+	deliveries := make(map[string][]webhook.Attempt)
+	if value != "" {
+		err := json.Unmarshal([]byte(value), &deliveries)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding webhook deliveries: %v", err)
+		}
+	}
+
+	return deliveries, nil
+}
+
+// updateList decodes the JSON list stored under the key, applies fn and
+// stores the result.
+func updateList[T any](d *Database, key string, fn func(list []T) ([]T, error)) error {
+	return d.UpdateJSON(key, func(value string) (any, error) {
+		list := []T{}
+		if value != "" {
+			err := json.Unmarshal([]byte(value), &list)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding %s: %v", key, err)
+			}
+		}
+
+		return fn(list)
+	})
+}
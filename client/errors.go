@@ -11,3 +11,17 @@ func (e *ErrNotFound) Error() string {
 func (e *ErrNotFound) Unwrap() error {
 	return e.Err
 }
+
+// ErrInvalid means the data passed to the client was rejected, e.g. an import
+// that would leave required keys missing.
+type ErrInvalid struct {
+	Err error
+}
+
+func (e *ErrInvalid) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ErrInvalid) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/alert"
+)
+
+// HTTP posts the message as plain text with the title and priority in
+// headers, the way ntfy expects it. Any endpoint accepting that works too.
+type HTTP struct {
+	URL   string
+	Token string
+
+	httpClient *http.Client
+}
+
+func NewHTTP(url, token string, timeout time.Duration) *HTTP {
+	var h HTTP
+
+	h.URL = url
+	h.Token = token
+	h.httpClient = &http.Client{Timeout: timeout}
+
+	return &h
+}
+
+func (h *HTTP) Name() string {
+	return "http"
+}
+
+func (h *HTTP) Send(ctx context.Context, n *alert.Notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, strings.NewReader(n.Message))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Title", n.Title)
+	req.Header.Set("Priority", priority(n))
+	req.Header.Set("Tags", tags(n))
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+		return fmt.Errorf("unexpected status code: %d, body: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// maxErrorBodySize is how much of an error response is included in the error.
+const maxErrorBodySize = 512
+
+// priority maps severity to ntfy priority, from 1 (min) to 5 (urgent).
+func priority(n *alert.Notification) string {
+	if n.Resolved {
+		return "3"
+	}
+
+	switch n.Severity {
+	case alert.CriticalSeverity:
+		return "5"
+	case alert.WarningSeverity:
+		return "4"
+	default:
+		return "3"
+	}
+}
+
+func tags(n *alert.Notification) string {
+	if n.Resolved {
+		return "white_check_mark"
+	}
+
+	switch n.Severity {
+	case alert.CriticalSeverity:
+		return "rotating_light"
+	case alert.WarningSeverity:
+		return "warning"
+	default:
+		return "information_source"
+	}
+}
@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/alert"
+)
+
+// SMTP sends plain text emails. Port 465 uses implicit TLS, other ports
+// upgrade with STARTTLS if the server supports it.
+type SMTP struct {
+	Host     string
+	Port     uint16
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	timeout time.Duration
+}
+
+func NewSMTP(host string, port uint16, username, password, from string, to []string, timeout time.Duration) *SMTP {
+	var s SMTP
+
+	s.Host = host
+	s.Port = port
+	s.Username = username
+	s.Password = password
+	s.From = from
+	s.To = to
+	s.timeout = timeout
+
+	return &s
+}
+
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+const implicitTLSPort = 465
+
+func (s *SMTP) Send(ctx context.Context, n *alert.Notification) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(int(s.Port)))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %v", addr, err)
+	}
+
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error setting connection deadline: %v", err)
+	}
+
+	if s.Port == implicitTLSPort {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error creating smtp client: %v", err)
+	}
+	defer c.Close()
+
+	if s.Port != implicitTLSPort {
+		ok, _ := c.Extension("STARTTLS")
+		if ok {
+			err = c.StartTLS(tlsConfig)
+			if err != nil {
+				return fmt.Errorf("error starting tls: %v", err)
+			}
+		}
+	}
+
+	if s.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host))
+		if err != nil {
+			return fmt.Errorf("error authenticating: %v", err)
+		}
+	}
+
+	err = c.Mail(s.From)
+	if err != nil {
+		return fmt.Errorf("error setting sender: %v", err)
+	}
+
+	for _, to := range s.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return fmt.Errorf("error adding recipient %s: %v", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("error starting message: %v", err)
+	}
+
+	_, err = w.Write(s.message(n))
+	if err != nil {
+		return fmt.Errorf("error writing message: %v", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("error sending message: %v", err)
+	}
+
+	return c.Quit()
+}
+
+func (s *SMTP) message(n *alert.Notification) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(n.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(n.Message, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
+
+// headerValue prevents header injection through line breaks and encodes
+// non-ASCII characters, e.g. the degree sign.
+func headerValue(value string) string {
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	return mime.QEncoding.Encode("utf-8", value)
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/alert"
+)
+
+// Telegram sends messages with the Bot API sendMessage method. APIURL can
+// point to any compatible server.
+type Telegram struct {
+	APIURL string
+	Token  string
+	ChatID string
+
+	httpClient *http.Client
+}
+
+func NewTelegram(apiURL, token, chatID string, timeout time.Duration) *Telegram {
+	var t Telegram
+
+	t.APIURL = strings.TrimSuffix(apiURL, "/")
+	t.Token = token
+	t.ChatID = chatID
+	t.httpClient = &http.Client{Timeout: timeout}
+
+	return &t
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+type telegramMessage struct {
+	ChatID string `json:"chat_id"`
+	Text   string `json:"text"`
+	// DisableNotification delivers the message silently.
+	DisableNotification bool `json:"disable_notification"`
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+func (t *Telegram) Send(ctx context.Context, n *alert.Notification) error {
+	body, err := json.Marshal(telegramMessage{
+		ChatID:              t.ChatID,
+		Text:                fmt.Sprintf("%s\n\n%s", n.Title, n.Message),
+		DisableNotification: n.Resolved || n.Severity == alert.InfoSeverity,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding message: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", t.APIURL, t.Token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		// The URL contains the token, so the error isn't included
+		return fmt.Errorf("error creating request to %s", t.APIURL)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request to %s", t.APIURL)
+	}
+	defer res.Body.Close()
+
+	var response telegramResponse
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return fmt.Errorf("error decoding response with status code %d: %v", res.StatusCode, err)
+	}
+
+	if !response.OK {
+		return fmt.Errorf("error response with status code %d: %s", res.StatusCode, response.Description)
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/model/deadletter"
+)
+
+func (p *PubSub) PublishDeadLetter(ctx context.Context, message *deadletter.Message) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error marshalling dead letter: %v", err)
+	}
+
+	err = p.Publish(ctx, p.deadLetterTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing dead letter: %v", err)
+	}
+
+	return nil
+}
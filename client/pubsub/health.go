@@ -0,0 +1,26 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexchebotarsky/heatpump-api/health"
+)
+
+func (p *PubSub) HealthChecks() []health.Check {
+	return []health.Check{
+		{
+			Name:     "pubsub",
+			Critical: true,
+			Func:     p.checkConnection,
+		},
+	}
+}
+
+func (p *PubSub) checkConnection(ctx context.Context) error {
+	if !p.connected.Load() {
+		return errors.New("pubsub broker is not connected")
+	}
+
+	return nil
+}
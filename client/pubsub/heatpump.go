@@ -0,0 +1,37 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+func (p *PubSub) PublishHeatpumpState(ctx context.Context, state *heatpump.State) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error marshalling heatpump state: %v", err)
+	}
+
+	err = p.PublishRetained(ctx, p.stateTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing heatpump state: %v", err)
+	}
+
+	return nil
+}
+
+func (p *PubSub) PublishTemperatureAndHumidity(ctx context.Context, reading *heatpump.TemperatureReading) error {
+	payload, err := json.Marshal(reading)
+	if err != nil {
+		return fmt.Errorf("error marshalling temperature reading: %v", err)
+	}
+
+	err = p.PublishRetained(ctx, p.temperatureAndHumidityTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing temperature reading: %v", err)
+	}
+
+	return nil
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 )
 
+// IRTransmitterTopic is where the IR transmitter listens for frames.
+const IRTransmitterTopic = "heatpump/ir-transmitter"
+
 type IRSignal struct {
 	Signal string `json:"signal"`
 }
@@ -19,7 +22,7 @@ func (p *PubSub) TransmitIRSignal(ctx context.Context, binaryString string) erro
 		return fmt.Errorf("error marshalling ir signal: %v", err)
 	}
 
-	err = p.Publish(ctx, "heatpump/ir-transmitter", payload)
+	err = p.Publish(ctx, IRTransmitterTopic, payload)
 	if err != nil {
 		return fmt.Errorf("error publishing heatpump binary state: %v", err)
 	}
@@ -0,0 +1,23 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/model/window"
+)
+
+func (p *PubSub) PublishOpenWindowEvent(ctx context.Context, event *window.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshalling open window event: %v", err)
+	}
+
+	err = p.Publish(ctx, p.openWindowTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing open window event: %v", err)
+	}
+
+	return nil
+}
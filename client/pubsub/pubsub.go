@@ -2,48 +2,134 @@ package pubsub
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/alexchebotarsky/heatpump-api/model/recording"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
 	"github.com/eclipse/paho.golang/autopaho"
 	"github.com/eclipse/paho.golang/paho"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PubSub struct {
 	clientID      string
 	qos           byte
+	mu            sync.RWMutex
 	subscriptions map[string]func(ctx context.Context, payload []byte) error
+	watchers      map[string]func(ctx context.Context, topic string, payload []byte) error
+
+	statusTopic                 string
+	stateTopic                  string
+	temperatureAndHumidityTopic string
+	deadLetterTopic             string
+	openWindowTopic             string
+
+	recorder Recorder
 
 	connManager *autopaho.ConnectionManager
+	connected   atomic.Bool
+}
+
+type Config struct {
+	// URLs of the brokers to connect to, tried in order. If empty, a plain
+	// mqtt:// URL is built from Host and Port.
+	URLs []string
+	Host string
+	Port uint16
+
+	ClientID  string
+	QoS       byte
+	KeepAlive time.Duration
+
+	Username string
+	Password string
+
+	CAFile   string
+	CertFile string
+	KeyFile  string
+
+	// StatusTopic receives retained "online" message on every connection and
+	// "offline" as the Last Will when the connection is lost.
+	StatusTopic                 string
+	StateTopic                  string
+	TemperatureAndHumidityTopic string
+	DeadLetterTopic             string
+	OpenWindowTopic             string
+
+	// Recorder is optional, published messages are recorded if it's set.
+	Recorder Recorder
+}
+
+type Recorder interface {
+	Record(direction recording.Direction, topic string, payload []byte) error
 }
 
-func New(ctx context.Context, host string, port uint16, clientID string, qos byte) (*PubSub, error) {
+const (
+	onlineStatus  = "online"
+	offlineStatus = "offline"
+)
+
+func New(ctx context.Context, config Config) (*PubSub, error) {
 	var p PubSub
 	var err error
 
-	p.clientID = clientID
-	p.qos = qos
+	p.clientID = config.ClientID
+	p.qos = config.QoS
 	p.subscriptions = make(map[string]func(ctx context.Context, payload []byte) error)
+	p.watchers = make(map[string]func(ctx context.Context, topic string, payload []byte) error)
+	p.statusTopic = config.StatusTopic
+	p.stateTopic = config.StateTopic
+	p.temperatureAndHumidityTopic = config.TemperatureAndHumidityTopic
+	p.deadLetterTopic = config.DeadLetterTopic
+	p.openWindowTopic = config.OpenWindowTopic
+	p.recorder = config.Recorder
+
+	serverURLs, err := parseServerURLs(config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing broker URLs: %v", err)
+	}
 
-	brokerURL, err := url.Parse(fmt.Sprintf("mqtt://%s:%d", host, port))
+	tlsConfig, err := newTLSConfig(config.CAFile, config.CertFile, config.KeyFile)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing broker URL: %v", err)
+		return nil, fmt.Errorf("error creating tls config: %v", err)
 	}
 
 	cfg := autopaho.ClientConfig{
-		ServerUrls:            []*url.URL{brokerURL},
+		ServerUrls:            serverURLs,
+		TlsCfg:                tlsConfig,
+		KeepAlive:             uint16(config.KeepAlive.Seconds()),
 		SessionExpiryInterval: 10 * 60, // 10 minutes for reconnection
+		OnConnectionUp:        p.handleConnectionUp,
 		OnConnectError:        p.handleConnectError,
+		ConnectUsername:       config.Username,
+		ConnectPassword:       []byte(config.Password),
+		WillMessage: &paho.WillMessage{
+			Topic:   p.statusTopic,
+			Payload: []byte(offlineStatus),
+			QoS:     p.qos,
+			Retain:  true,
+		},
 		ClientConfig: paho.ClientConfig{
-			ClientID: p.clientID,
+			ClientID:           p.clientID,
+			OnClientError:      p.handleClientError,
+			OnServerDisconnect: p.handleServerDisconnect,
 			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
 				p.handleMessage,
 			},
 		},
 	}
 
-	p.connManager, err = autopaho.NewConnection(ctx, cfg)
+	// Connection outlives the setup context, it's terminated by Close
+	p.connManager, err = autopaho.NewConnection(context.WithoutCancel(ctx), cfg)
 	if err != nil {
 		return nil, fmt.Errorf("error creating pubsub connection: %v", err)
 	}
@@ -56,8 +142,77 @@ func New(ctx context.Context, host string, port uint16, clientID string, qos byt
 	return &p, nil
 }
 
+func parseServerURLs(config Config) ([]*url.URL, error) {
+	rawURLs := config.URLs
+	if len(rawURLs) == 0 {
+		rawURLs = []string{fmt.Sprintf("mqtt://%s:%d", config.Host, config.Port)}
+	}
+
+	serverURLs := make([]*url.URL, 0, len(rawURLs))
+	for _, rawURL := range rawURLs {
+		serverURL, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing broker URL %q: %v", rawURL, err)
+		}
+
+		switch serverURL.Scheme {
+		case "mqtt", "tcp", "mqtts", "ssl", "tls", "ws", "wss":
+			// Valid
+		default:
+			return nil, fmt.Errorf("broker URL scheme must be one of: [mqtt, tcp, mqtts, ssl, tls, ws, wss], got: %s", serverURL.Scheme)
+		}
+
+		serverURLs = append(serverURLs, serverURL)
+	}
+
+	return serverURLs, nil
+}
+
+func newTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		// Use system defaults for mqtts:// and wss:// URLs
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		caBundle, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ca file: %v", err)
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, errors.New("error parsing ca file: no valid PEM certificates found")
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("both client cert file and key file must be set for mutual tls")
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
 func (p *PubSub) Close(ctx context.Context) error {
-	err := p.connManager.Disconnect(ctx)
+	// Graceful disconnect doesn't trigger the Last Will, so we announce it ourselves
+	err := p.publish(ctx, p.statusTopic, []byte(offlineStatus), true)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error publishing offline status: %v", err))
+	}
+
+	err = p.connManager.Disconnect(ctx)
 	if err != nil {
 		return fmt.Errorf("error disconnecting: %v", err)
 	}
@@ -66,20 +221,51 @@ func (p *PubSub) Close(ctx context.Context) error {
 }
 
 func (p *PubSub) Publish(ctx context.Context, topic string, payload []byte) error {
-	_, err := p.connManager.Publish(ctx, &paho.Publish{
-		Topic:   topic,
-		Payload: payload,
-		QoS:     p.qos,
+	return p.publish(ctx, topic, payload, false)
+}
+
+func (p *PubSub) PublishRetained(ctx context.Context, topic string, payload []byte) error {
+	return p.publish(ctx, topic, payload, true)
+}
+
+func (p *PubSub) publish(ctx context.Context, topic string, payload []byte, retain bool) (err error) {
+	ctx, span := tracing.Start(ctx, "pubsub.Publish", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(tracing.Attr("messaging.destination.name", topic)))
+	defer func() { tracing.End(span, err) }()
+
+	properties := &paho.PublishProperties{}
+	injectTraceContext(ctx, properties)
+
+	_, err = p.connManager.Publish(ctx, &paho.Publish{
+		Topic:      topic,
+		Payload:    payload,
+		QoS:        p.qos,
+		Retain:     retain,
+		Properties: properties,
 	})
 	if err != nil {
 		return fmt.Errorf("error publishing message: %v", err)
 	}
 
+	p.record(topic, payload)
+
 	return nil
 }
 
+func (p *PubSub) record(topic string, payload []byte) {
+	if p.recorder == nil {
+		return
+	}
+
+	err := p.recorder.Record(recording.PublishedDirection, topic, payload)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error recording message on topic %s: %v", topic, err))
+	}
+}
+
 func (p *PubSub) Subscribe(ctx context.Context, topic string, handler func(ctx context.Context, payload []byte) error) error {
+	p.mu.Lock()
 	p.subscriptions[topic] = handler
+	p.mu.Unlock()
 
 	_, err := p.connManager.Subscribe(ctx, &paho.Subscribe{
 		Subscriptions: []paho.SubscribeOptions{
@@ -94,18 +280,73 @@ func (p *PubSub) Subscribe(ctx context.Context, topic string, handler func(ctx c
 }
 
 func (p *PubSub) handleMessage(message paho.PublishReceived) (bool, error) {
-	for topic, handler := range p.subscriptions {
-		if message.Packet.Topic == topic {
-			err := handler(context.Background(), message.Packet.Payload)
-			if err != nil {
-				return true, fmt.Errorf("error handling message: %v", err)
-			}
+	topic := message.Packet.Topic
+
+	var handlers []func(ctx context.Context, payload []byte) error
+	p.mu.RLock()
+	for filter, handler := range p.subscriptions {
+		if MatchTopic(filter, topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	for filter, watcher := range p.watchers {
+		if MatchTopic(filter, topic) {
+			handlers = append(handlers, func(ctx context.Context, payload []byte) error {
+				return watcher(ctx, topic, payload)
+			})
+		}
+	}
+	p.mu.RUnlock()
+
+	if len(handlers) == 0 {
+		return true, nil
+	}
+
+	ctx := extractTraceContext(context.Background(), message.Packet.Properties)
+	ctx = withResponse(ctx, message.Packet.Properties)
+
+	var errs []error
+	for _, handler := range handlers {
+		ctx, span := tracing.Start(ctx, "pubsub.handleMessage", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(tracing.Attr("messaging.destination.name", topic)))
+		err := handler(ctx, message.Packet.Payload)
+		tracing.End(span, err)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error handling message on topic %s: %v", topic, err))
+			errs = append(errs, err)
 		}
 	}
 
+	if len(errs) > 0 {
+		return true, fmt.Errorf("error handling message: %v", errors.Join(errs...))
+	}
+
 	return true, nil
 }
 
+func (p *PubSub) handleConnectionUp(connManager *autopaho.ConnectionManager, connack *paho.Connack) {
+	p.connected.Store(true)
+
+	_, err := connManager.Publish(context.Background(), &paho.Publish{
+		Topic:   p.statusTopic,
+		Payload: []byte(onlineStatus),
+		QoS:     p.qos,
+		Retain:  true,
+	})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error publishing online status: %v", err))
+	}
+}
+
 func (p *PubSub) handleConnectError(err error) {
 	slog.Error(fmt.Sprintf("error with pubsub connection: %s", err))
 }
+
+func (p *PubSub) handleClientError(err error) {
+	p.connected.Store(false)
+	slog.Error(fmt.Sprintf("Pubsub connection lost: %v", err))
+}
+
+func (p *PubSub) handleServerDisconnect(disconnect *paho.Disconnect) {
+	p.connected.Store(false)
+	slog.Error(fmt.Sprintf("Pubsub broker disconnected with reason code %d", disconnect.ReasonCode))
+}
@@ -0,0 +1,239 @@
+package pubsub
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseServerURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "host and port without urls",
+			config: Config{Host: "broker.local", Port: 1883},
+			want:   []string{"mqtt://broker.local:1883"},
+		},
+		{
+			name:   "urls take precedence over host and port",
+			config: Config{Host: "broker.local", Port: 1883, URLs: []string{"mqtts://broker.local:8883"}},
+			want:   []string{"mqtts://broker.local:8883"},
+		},
+		{
+			name:   "multiple urls keep their order",
+			config: Config{URLs: []string{"tcp://a:1883", "ssl://b:8883", "tls://c:8883", "ws://d/mqtt", "wss://e/mqtt"}},
+			want:   []string{"tcp://a:1883", "ssl://b:8883", "tls://c:8883", "ws://d/mqtt", "wss://e/mqtt"},
+		},
+		{
+			name:    "unsupported scheme",
+			config:  Config{URLs: []string{"http://broker.local"}},
+			wantErr: "broker URL scheme must be one of",
+		},
+		{
+			name:    "url without scheme",
+			config:  Config{URLs: []string{"broker.local:1883"}},
+			wantErr: "broker URL scheme must be one of",
+		},
+		{
+			name:    "invalid url",
+			config:  Config{URLs: []string{"mqtt://broker local:1883"}},
+			wantErr: "error parsing broker URL",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			urls, err := parseServerURLs(test.config)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+
+			if len(urls) != len(test.want) {
+				t.Fatalf("expected %d urls, got: %d", len(test.want), len(urls))
+			}
+			for i, want := range test.want {
+				if urls[i].String() != want {
+					t.Errorf("expected url %d to be %s, got: %s", i, want, urls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, caKey := newCertificate(t, "test ca", nil, nil, true)
+	serverCert, serverKey := newCertificate(t, "localhost", ca, caKey, false)
+	clientCert, clientKey := newCertificate(t, "heatpump-api", ca, caKey, false)
+
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Raw)
+	certFile := writePEM(t, dir, "client.pem", "CERTIFICATE", clientCert.Raw)
+	keyFile := writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", marshalKey(t, clientKey))
+
+	t.Run("system defaults without files", func(t *testing.T) {
+		cfg, err := newTLSConfig("", "", "")
+		if err != nil || cfg != nil {
+			t.Errorf("expected nil config and no error, got: %v, %v", cfg, err)
+		}
+	})
+
+	t.Run("cert without key", func(t *testing.T) {
+		_, err := newTLSConfig(caFile, certFile, "")
+		if err == nil || !strings.Contains(err.Error(), "both client cert file and key file must be set") {
+			t.Errorf("expected missing key error, got: %v", err)
+		}
+	})
+
+	t.Run("invalid ca file", func(t *testing.T) {
+		_, err := newTLSConfig(keyFile, "", "")
+		if err == nil || !strings.Contains(err.Error(), "no valid PEM certificates found") {
+			t.Errorf("expected invalid ca error, got: %v", err)
+		}
+	})
+
+	t.Run("mutual tls handshake", func(t *testing.T) {
+		cfg, err := newTLSConfig(caFile, certFile, keyFile)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if len(cfg.Certificates) != 1 {
+			t.Fatalf("expected client certificate to be loaded")
+		}
+
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(ca)
+
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+		})
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		defer listener.Close()
+
+		peerName := make(chan string, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				peerName <- ""
+				return
+			}
+			defer conn.Close()
+
+			tlsConn := conn.(*tls.Conn)
+			err = tlsConn.Handshake()
+			if err != nil || len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+				peerName <- ""
+				return
+			}
+			peerName <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		}()
+
+		cfg.ServerName = "localhost"
+		conn, err := tls.Dial("tcp", listener.Addr().String(), cfg)
+		if err != nil {
+			t.Fatalf("error connecting with mutual tls: %v", err)
+		}
+		defer conn.Close()
+
+		select {
+		case name := <-peerName:
+			if name != "heatpump-api" {
+				t.Errorf("expected server to verify client certificate, got peer: %q", name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the handshake")
+		}
+	})
+}
+
+// newCertificate creates a certificate signed by the parent, or a self-signed
+// one if the parent is nil.
+func newCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{commonName}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+
+	return cert, key
+}
+
+func marshalKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshalling key: %v", err)
+	}
+
+	return der
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+
+	filename := filepath.Join(dir, name)
+	err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("error writing %s: %v", name, err)
+	}
+
+	return filename
+}
@@ -0,0 +1,92 @@
+package pubsubtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+)
+
+// Inject delivers the message to the matching subscriptions as if it was
+// received from the broker, with the QoS of the fake. Unlike Publish, it
+// isn't recorded, and handler errors are returned.
+func (p *PubSub) Inject(ctx context.Context, topic string, payload []byte) error {
+	return p.InjectMessage(ctx, Message{Topic: topic, Payload: payload, QoS: p.qos})
+}
+
+// InjectMessage is Inject with full control over the message, e.g. to set a
+// response topic for request/response handlers.
+func (p *PubSub) InjectMessage(ctx context.Context, message Message) error {
+	return p.deliver(ctx, message)
+}
+
+// deliver calls the handlers matching the topic. The QoS the message is
+// delivered with is the lower of the message and the subscription QoS, like a
+// broker does:
+//   - QoS 0 is at most once, so it's lost on a dropped topic.
+//   - QoS 1 is at least once, so it's delivered twice on a duplicated topic.
+//   - QoS 2 is exactly once, so it's neither dropped nor duplicated.
+func (p *PubSub) deliver(ctx context.Context, message Message) error {
+	qos := min(message.QoS, p.qos)
+
+	p.mu.Lock()
+	dropped := qos == 0 && matchAny(p.drops, message.Topic)
+	duplicated := qos == 1 && matchAny(p.duplicates, message.Topic)
+
+	var handlers []func(ctx context.Context, payload []byte) error
+	for filter, handler := range p.subscriptions {
+		if pubsub.MatchTopic(filter, message.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	for filter, watcher := range p.watchers {
+		if pubsub.MatchTopic(filter, message.Topic) {
+			handlers = append(handlers, func(ctx context.Context, payload []byte) error {
+				return watcher(ctx, message.Topic, payload)
+			})
+		}
+	}
+	p.mu.Unlock()
+
+	if dropped || len(handlers) == 0 {
+		return nil
+	}
+
+	deliveries := []Message{message}
+	if duplicated {
+		duplicate := message
+		duplicate.Duplicate = true
+		deliveries = append(deliveries, duplicate)
+	}
+
+	var errs []error
+	for _, delivery := range deliveries {
+		ctx := ctx
+		if delivery.ResponseTopic != "" {
+			ctx = context.WithValue(ctx, responseKey{}, delivery)
+		}
+
+		for _, handler := range handlers {
+			err := handler(ctx, delivery.Payload)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error handling message: %v", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func matchAny(filters []string, topic string) bool {
+	for _, filter := range filters {
+		if pubsub.MatchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
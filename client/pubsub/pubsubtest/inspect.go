@@ -0,0 +1,125 @@
+package pubsubtest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+)
+
+// Published returns the messages published to topics matching the filter, in
+// order. Use "#" for all of them.
+func (p *PubSub) Published(filter string) []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var messages []Message
+	for _, message := range p.published {
+		if pubsub.MatchTopic(filter, message.Topic) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Retained returns the retained message of the topic, if any.
+func (p *PubSub) Retained(topic string) (Message, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	message, ok := p.retained[topic]
+	return message, ok
+}
+
+// WaitForMessage returns the first recorded message matching the filter,
+// waiting for it to be published if there is none yet. Call Reset before the
+// action under test to ignore earlier messages.
+func (p *PubSub) WaitForMessage(ctx context.Context, filter string) (Message, error) {
+	for {
+		p.mu.Lock()
+		i := slices.IndexFunc(p.published, func(m Message) bool {
+			return pubsub.MatchTopic(filter, m.Topic)
+		})
+		if i >= 0 {
+			message := p.published[i]
+			p.mu.Unlock()
+			return message, nil
+		}
+		publishedc := p.publishedc
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Message{}, fmt.Errorf("error waiting for message on %s: %v", filter, ctx.Err())
+		case <-publishedc:
+		}
+	}
+}
+
+// Reset forgets the recorded messages and injected failures. Subscriptions
+// and retained messages are kept.
+func (p *PubSub) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.published = nil
+	p.publishFailures = nil
+	p.subscribeFailures = nil
+	p.drops = nil
+	p.duplicates = nil
+}
+
+// FailPublish makes publishing to topics matching the filter return err,
+// until Reset. With times > 0, it only fails that many times.
+func (p *PubSub) FailPublish(filter string, err error, times int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.publishFailures = append(p.publishFailures, failure{filter: filter, err: err, times: times})
+}
+
+// FailSubscribe makes subscribing to the filter return err, until Reset. With
+// times > 0, it only fails that many times.
+func (p *PubSub) FailSubscribe(filter string, err error, times int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subscribeFailures = append(p.subscribeFailures, failure{filter: filter, err: err, times: times})
+}
+
+// Drop loses messages delivered with QoS 0 on topics matching the filter.
+func (p *PubSub) Drop(filter string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.drops = append(p.drops, filter)
+}
+
+// Duplicate delivers messages delivered with QoS 1 on topics matching the
+// filter twice, the second time with Duplicate set.
+func (p *PubSub) Duplicate(filter string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.duplicates = append(p.duplicates, filter)
+}
+
+// takeFailure returns the error of the first failure matching the topic, and
+// uses up one of its times. It must be called with the lock held.
+func takeFailure(failures *[]failure, topic string) error {
+	for i, f := range *failures {
+		if !pubsub.MatchTopic(f.filter, topic) {
+			continue
+		}
+
+		if f.times > 0 {
+			(*failures)[i].times--
+			if (*failures)[i].times == 0 {
+				*failures = slices.Delete(*failures, i, i+1)
+			}
+		}
+		return f.err
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+// Package pubsubtest provides an in-process stand-in for the pubsub client, so
+// that the processor, services and handlers can be tested without a broker.
+// Messages are delivered synchronously, publishes are recorded, and failures
+// can be injected per topic filter.
+package pubsubtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/health"
+	"github.com/alexchebotarsky/heatpump-api/model/deadletter"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/recording"
+	"github.com/alexchebotarsky/heatpump-api/model/window"
+	"github.com/alexchebotarsky/heatpump-api/processor"
+	"github.com/alexchebotarsky/heatpump-api/server"
+	"github.com/alexchebotarsky/heatpump-api/service"
+)
+
+var (
+	_ processor.PubSubClient      = (*PubSub)(nil)
+	_ server.PubSub               = (*PubSub)(nil)
+	_ service.PubSub              = (*PubSub)(nil)
+	_ service.RulesPubSub         = (*PubSub)(nil)
+	_ service.OpenWindowPublisher = (*PubSub)(nil)
+)
+
+// IRTransmitterTopic is where TransmitIRSignal publishes, like the real client.
+const IRTransmitterTopic = pubsub.IRTransmitterTopic
+
+// Message is a message published through, or injected into, the fake.
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+	// ResponseTopic and CorrelationData are the MQTT 5 request/response
+	// properties, PublishResponse replies to them.
+	ResponseTopic   string
+	CorrelationData []byte
+	// Duplicate is set on the second delivery of a duplicated message.
+	Duplicate bool
+	Time      time.Time
+}
+
+type failure struct {
+	filter string
+	err    error
+	// times is how many more times the failure applies, 0 for until Reset.
+	times int
+}
+
+type PubSub struct {
+	qos byte
+
+	stateTopic                  string
+	temperatureAndHumidityTopic string
+	deadLetterTopic             string
+	openWindowTopic             string
+
+	recorder pubsub.Recorder
+
+	mu            sync.Mutex
+	subscriptions map[string]func(ctx context.Context, payload []byte) error
+	watchers      map[string]func(ctx context.Context, topic string, payload []byte) error
+	retained      map[string]Message
+	published     []Message
+	// publishedc is closed and replaced on every publish, to wake up waiters.
+	publishedc chan struct{}
+	closed     bool
+
+	publishFailures   []failure
+	subscribeFailures []failure
+	drops             []string
+	duplicates        []string
+}
+
+// New creates the fake with the QoS, topics and recorder of the config, the
+// connection settings are ignored.
+func New(config pubsub.Config) *PubSub {
+	var p PubSub
+
+	p.qos = config.QoS
+	p.stateTopic = config.StateTopic
+	p.temperatureAndHumidityTopic = config.TemperatureAndHumidityTopic
+	p.deadLetterTopic = config.DeadLetterTopic
+	p.openWindowTopic = config.OpenWindowTopic
+	p.recorder = config.Recorder
+	p.subscriptions = make(map[string]func(ctx context.Context, payload []byte) error)
+	p.watchers = make(map[string]func(ctx context.Context, topic string, payload []byte) error)
+	p.retained = make(map[string]Message)
+	p.publishedc = make(chan struct{})
+
+	return &p
+}
+
+func (p *PubSub) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	return nil
+}
+
+func (p *PubSub) HealthChecks() []health.Check {
+	return []health.Check{
+		{
+			Name:     "pubsub",
+			Critical: true,
+			Func: func(ctx context.Context) error {
+				p.mu.Lock()
+				defer p.mu.Unlock()
+
+				if p.closed {
+					return errors.New("pubsub broker is not connected")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (p *PubSub) Publish(ctx context.Context, topic string, payload []byte) error {
+	return p.publish(ctx, Message{Topic: topic, Payload: payload, QoS: p.qos})
+}
+
+func (p *PubSub) PublishRetained(ctx context.Context, topic string, payload []byte) error {
+	return p.publish(ctx, Message{Topic: topic, Payload: payload, QoS: p.qos, Retain: true})
+}
+
+// publish records the message and delivers it to the matching subscriptions,
+// including the publisher's own like a broker does. Handler errors aren't
+// returned, as the publisher doesn't see them with a real broker either.
+func (p *PubSub) publish(ctx context.Context, message Message) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errors.New("error publishing message: pubsub is closed")
+	}
+
+	err := takeFailure(&p.publishFailures, message.Topic)
+	if err != nil {
+		p.mu.Unlock()
+		return fmt.Errorf("error publishing message: %v", err)
+	}
+
+	message.Time = time.Now()
+	p.published = append(p.published, message)
+	close(p.publishedc)
+	p.publishedc = make(chan struct{})
+
+	if message.Retain {
+		// Empty retained payload clears the retained message
+		if len(message.Payload) == 0 {
+			delete(p.retained, message.Topic)
+		} else {
+			p.retained[message.Topic] = message
+		}
+	}
+	p.mu.Unlock()
+
+	if p.recorder != nil {
+		err = p.recorder.Record(recording.PublishedDirection, message.Topic, message.Payload)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error recording message on topic %s: %v", message.Topic, err))
+		}
+	}
+
+	err = p.deliver(context.WithoutCancel(ctx), message)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error handling message on topic %s: %v", message.Topic, err))
+	}
+
+	return nil
+}
+
+func (p *PubSub) Subscribe(ctx context.Context, topic string, handler func(ctx context.Context, payload []byte) error) error {
+	p.mu.Lock()
+	err := takeFailure(&p.subscribeFailures, topic)
+	if err != nil {
+		p.mu.Unlock()
+		return fmt.Errorf("error subscribing to topic: %v", err)
+	}
+	p.subscriptions[topic] = handler
+	retained := p.retainedMatching(topic)
+	p.mu.Unlock()
+
+	for _, message := range retained {
+		err := handler(ctx, message.Payload)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error handling message on topic %s: %v", message.Topic, err))
+		}
+	}
+
+	return nil
+}
+
+// Watch subscribes on behalf of an observer, see pubsub.PubSub.Watch.
+func (p *PubSub) Watch(ctx context.Context, filter string, handler func(ctx context.Context, topic string, payload []byte) error) error {
+	p.mu.Lock()
+	err := takeFailure(&p.subscribeFailures, filter)
+	if err != nil {
+		p.mu.Unlock()
+		return fmt.Errorf("error subscribing to topic: %v", err)
+	}
+	p.watchers[filter] = handler
+	retained := p.retainedMatching(filter)
+	p.mu.Unlock()
+
+	for _, message := range retained {
+		err := handler(ctx, message.Topic, message.Payload)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error handling message on topic %s: %v", message.Topic, err))
+		}
+	}
+
+	return nil
+}
+
+func (p *PubSub) Unwatch(ctx context.Context, filter string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.watchers, filter)
+	return nil
+}
+
+// Unsubscribe removes the subscription to the topic.
+func (p *PubSub) Unsubscribe(topic string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.subscriptions, topic)
+}
+
+func (p *PubSub) retainedMatching(filter string) []Message {
+	var messages []Message
+	for topic, message := range p.retained {
+		if pubsub.MatchTopic(filter, topic) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func (p *PubSub) PublishHeatpumpState(ctx context.Context, state *heatpump.State) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error marshalling heatpump state: %v", err)
+	}
+
+	err = p.PublishRetained(ctx, p.stateTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing heatpump state: %v", err)
+	}
+
+	return nil
+}
+
+func (p *PubSub) PublishTemperatureAndHumidity(ctx context.Context, reading *heatpump.TemperatureReading) error {
+	payload, err := json.Marshal(reading)
+	if err != nil {
+		return fmt.Errorf("error marshalling temperature reading: %v", err)
+	}
+
+	err = p.PublishRetained(ctx, p.temperatureAndHumidityTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing temperature reading: %v", err)
+	}
+
+	return nil
+}
+
+func (p *PubSub) PublishDeadLetter(ctx context.Context, message *deadletter.Message) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error marshalling dead letter: %v", err)
+	}
+
+	err = p.Publish(ctx, p.deadLetterTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing dead letter: %v", err)
+	}
+
+	return nil
+}
+
+func (p *PubSub) PublishOpenWindowEvent(ctx context.Context, event *window.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshalling open window event: %v", err)
+	}
+
+	err = p.Publish(ctx, p.openWindowTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing open window event: %v", err)
+	}
+
+	return nil
+}
+
+func (p *PubSub) TransmitIRSignal(ctx context.Context, binaryString string) error {
+	payload, err := json.Marshal(&pubsub.IRSignal{Signal: binaryString})
+	if err != nil {
+		return fmt.Errorf("error marshalling ir signal: %v", err)
+	}
+
+	err = p.Publish(ctx, IRTransmitterTopic, payload)
+	if err != nil {
+		return fmt.Errorf("error publishing heatpump binary state: %v", err)
+	}
+
+	return nil
+}
+
+type responseKey struct{}
+
+// PublishResponse publishes the payload to the response topic of the message
+// being handled. It's a no-op if the message didn't request a response.
+func (p *PubSub) PublishResponse(ctx context.Context, payload []byte) error {
+	request, ok := ctx.Value(responseKey{}).(Message)
+	if !ok {
+		return nil
+	}
+
+	err := p.publish(ctx, Message{
+		Topic:           request.ResponseTopic,
+		Payload:         payload,
+		QoS:             p.qos,
+		CorrelationData: request.CorrelationData,
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing response: %v", err)
+	}
+
+	return nil
+}
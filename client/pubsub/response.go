@@ -0,0 +1,59 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+type responseKey struct{}
+
+type response struct {
+	topic           string
+	correlationData []byte
+}
+
+// withResponse stores MQTT 5 request/response properties of the received
+// message in the context, so that handlers can reply to it.
+func withResponse(ctx context.Context, properties *paho.PublishProperties) context.Context {
+	if properties == nil || properties.ResponseTopic == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, responseKey{}, response{
+		topic:           properties.ResponseTopic,
+		correlationData: properties.CorrelationData,
+	})
+}
+
+// PublishResponse publishes the payload to the response topic of the message
+// being handled. It's a no-op if the message didn't request a response.
+func (p *PubSub) PublishResponse(ctx context.Context, payload []byte) error {
+	resp, ok := ctx.Value(responseKey{}).(response)
+	if !ok {
+		slog.Debug("Message has no response topic, skipping response")
+		return nil
+	}
+
+	properties := &paho.PublishProperties{
+		CorrelationData: resp.correlationData,
+		ContentType:     "application/json",
+	}
+	injectTraceContext(ctx, properties)
+
+	_, err := p.connManager.Publish(ctx, &paho.Publish{
+		Topic:      resp.topic,
+		Payload:    payload,
+		QoS:        p.qos,
+		Properties: properties,
+	})
+	if err != nil {
+		return fmt.Errorf("error publishing response: %v", err)
+	}
+
+	p.record(resp.topic, payload)
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package pubsub
+
+import "strings"
+
+// MatchTopic reports whether the topic matches the MQTT topic filter, with +
+// matching a single level and # matching any remaining levels.
+func MatchTopic(filter, topic string) bool {
+	if filter == topic {
+		return true
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(topicLevels) {
+			return false
+		}
+
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,47 @@
+package pubsub
+
+import (
+	"context"
+
+	"github.com/eclipse/paho.golang/paho"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// userPropertiesCarrier propagates W3C trace context in MQTT 5 user
+// properties, so that subscribers (e.g. transmitter firmware) can continue
+// the trace.
+type userPropertiesCarrier struct {
+	properties *paho.UserProperties
+}
+
+var _ propagation.TextMapCarrier = userPropertiesCarrier{}
+
+func (c userPropertiesCarrier) Get(key string) string {
+	return c.properties.Get(key)
+}
+
+func (c userPropertiesCarrier) Set(key, value string) {
+	c.properties.Add(key, value)
+}
+
+func (c userPropertiesCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.properties))
+	for _, property := range *c.properties {
+		keys = append(keys, property.Key)
+	}
+
+	return keys
+}
+
+func injectTraceContext(ctx context.Context, properties *paho.PublishProperties) {
+	otel.GetTextMapPropagator().Inject(ctx, userPropertiesCarrier{properties: &properties.User})
+}
+
+func extractTraceContext(ctx context.Context, properties *paho.PublishProperties) context.Context {
+	if properties == nil {
+		return ctx
+	}
+
+	return otel.GetTextMapPropagator().Extract(ctx, userPropertiesCarrier{properties: &properties.User})
+}
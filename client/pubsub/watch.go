@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// Watch subscribes to the topic filter on behalf of an observer, e.g. the rule
+// engine. Unlike Subscribe, the handler receives the actual topic, and it
+// doesn't replace a handler subscribed to the same filter.
+func (p *PubSub) Watch(ctx context.Context, filter string, handler func(ctx context.Context, topic string, payload []byte) error) error {
+	p.mu.Lock()
+	p.watchers[filter] = handler
+	p.mu.Unlock()
+
+	_, err := p.connManager.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{
+			{Topic: filter, QoS: p.qos},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error subscribing to topic: %v", err)
+	}
+
+	return nil
+}
+
+// Unwatch removes the watcher, the broker subscription is kept if the filter
+// is also subscribed to with Subscribe.
+func (p *PubSub) Unwatch(ctx context.Context, filter string) error {
+	p.mu.Lock()
+	delete(p.watchers, filter)
+	_, subscribed := p.subscriptions[filter]
+	p.mu.Unlock()
+
+	if subscribed {
+		return nil
+	}
+
+	_, err := p.connManager.Unsubscribe(ctx, &paho.Unsubscribe{
+		Topics: []string{filter},
+	})
+	if err != nil {
+		return fmt.Errorf("error unsubscribing from topic: %v", err)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
@@ -11,18 +12,38 @@ import (
 	"github.com/alexchebotarsky/heatpump-api/env"
 	"github.com/alexchebotarsky/heatpump-api/logger"
 	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
 )
 
 func main() {
+	var configFile string
+	var printConfig bool
+	flag.StringVar(&configFile, "config", "", "YAML config file, env variables override its settings. Defaults to CONFIG_FILE")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the config with secrets redacted and exit")
+	flag.Parse()
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
-	env, err := env.LoadConfig(ctx)
+	loadConfig := func(ctx context.Context) (*env.Config, error) {
+		return env.LoadConfig(ctx, configFile)
+	}
+
+	env, err := loadConfig(ctx)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Error loading env config: %v", err))
 		os.Exit(1)
 	}
 
+	if printConfig {
+		err := env.Print(os.Stdout)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error printing config: %v", err))
+			os.Exit(1)
+		}
+		return
+	}
+
 	logger.Init(env.LogLevel, env.LogFormat)
 
 	err = metrics.Init()
@@ -30,11 +51,29 @@ func main() {
 		slog.Error(fmt.Sprintf("Error initializing metrics: %v", err))
 	}
 
+	shutdownTracing, err := tracing.Init(ctx, env.TracingExporter, env.TracingServiceName)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error initializing tracing: %v", err))
+		os.Exit(1)
+	}
+	defer func() {
+		err := shutdownTracing(context.Background())
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error shutting down tracing: %v", err))
+		}
+	}()
+
 	app, err := app.New(ctx, env)
 	if err != nil {
 		slog.Error(fmt.Sprintf("Error creating app: %v", err))
 		os.Exit(1)
 	}
 
+	// CONFIG_FILE may come from .env, which is only loaded with the config
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	go app.WatchConfig(ctx, configFile, loadConfig)
+
 	app.Launch(ctx)
 }
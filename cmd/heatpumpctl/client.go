@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/model/apierror"
+)
+
+const (
+	apiPath        = "/api/v1"
+	requestTimeout = 10 * time.Second
+)
+
+// Client calls the heatpump HTTP API.
+type Client struct {
+	BaseURL string
+	APIKey  string
+	HTTP    *http.Client
+}
+
+func NewClient(baseURL, apiKey string) *Client {
+	var c Client
+
+	c.BaseURL = strings.TrimSuffix(baseURL, "/") + apiPath
+	c.APIKey = apiKey
+	c.HTTP = &http.Client{}
+
+	return &c
+}
+
+// Do sends the request body as JSON, if any, and decodes the response into
+// result, if any.
+func (c *Client) Do(ctx context.Context, method, path string, body, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding request body: %v", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	res, err := c.send(ctx, method, path, reqBody)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(res.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+
+	return nil
+}
+
+// Stream calls handle with every event of the event stream, until the stream
+// ends or ctx is done.
+func (c *Client) Stream(ctx context.Context, handle func(e *bus.Event, raw []byte) error) error {
+	res, err := c.send(ctx, http.MethodGet, "/events", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			// Comments, event names and blank separator lines
+			continue
+		}
+		raw := []byte(strings.TrimSpace(data))
+
+		var e bus.Event
+		err := json.Unmarshal(raw, &e)
+		if err != nil {
+			return fmt.Errorf("error decoding event: %v", err)
+		}
+
+		err = handle(&e, raw)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if scanner.Err() != nil {
+		return fmt.Errorf("error reading event stream: %v", scanner.Err())
+	}
+
+	return errors.New("event stream closed by the server")
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+
+	if res.StatusCode >= 400 {
+		defer res.Body.Close()
+
+		var apiErr apierror.Response
+		err := json.NewDecoder(res.Body).Decode(&apiErr)
+		if err != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("%s %s: %s", method, path, res.Status)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, apiErr.Error)
+	}
+
+	return res, nil
+}
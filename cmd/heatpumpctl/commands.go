@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/rule"
+)
+
+type Status struct {
+	State   heatpump.State              `json:"state"`
+	Reading heatpump.TemperatureReading `json:"reading"`
+}
+
+func runStatus(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	err := parseCommandFlags(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	client := NewClient(cfg.URL, cfg.APIKey)
+
+	var status Status
+	err = client.Do(ctx, http.MethodGet, "/state", nil, &status.State)
+	if err != nil {
+		return fmt.Errorf("error fetching state: %v", err)
+	}
+
+	err = client.Do(ctx, http.MethodGet, "/temperature-and-humidity", nil, &status.Reading)
+	if err != nil {
+		return fmt.Errorf("error fetching temperature and humidity: %v", err)
+	}
+
+	if cfg.Output == JSONOutput {
+		return printJSON(status)
+	}
+
+	t := newTable(os.Stdout)
+	t.Row("MODE", formatMode(status.State.Mode))
+	t.Row("TARGET", formatTarget(status.State.TargetTemperature))
+	t.Row("FAN", formatFan(status.State.FanSpeed))
+	t.Row("TEMPERATURE", fmt.Sprintf("%.1f°C", status.Reading.Temperature))
+	t.Row("HUMIDITY", fmt.Sprintf("%.0f%%", status.Reading.Humidity))
+	return t.Flush()
+}
+
+// stateFlags adds the flags to change the heatpump state. Only the flags that
+// are given are set in the returned state.
+func stateFlags(flags *flag.FlagSet) func() *heatpump.State {
+	mode := flags.String("mode", "", fmt.Sprintf("Mode, one of: %v", heatpump.Modes))
+	temp := flags.Int("temp", 0, fmt.Sprintf("Target temperature, %d to %d", heatpump.MinTargetTemperature, heatpump.MaxTargetTemperature))
+	fan := flags.Int("fan", 0, "Fan speed, 0 for auto, or 20 to 100 in steps of 20")
+
+	return func() *heatpump.State {
+		var state heatpump.State
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "mode":
+				m := heatpump.Mode(strings.ToUpper(*mode))
+				state.Mode = &m
+			case "temp":
+				state.TargetTemperature = temp
+			case "fan":
+				state.FanSpeed = fan
+			}
+		})
+		return &state
+	}
+}
+
+func runSet(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	state := stateFlags(flags)
+	err := parseCommandFlags(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	newState := state()
+	if newState.Mode == nil && newState.TargetTemperature == nil && newState.FanSpeed == nil {
+		flags.Usage()
+		return &usageError{"at least one of --mode, --temp and --fan is required"}
+	}
+
+	client := NewClient(cfg.URL, cfg.APIKey)
+
+	var updated heatpump.State
+	err = client.Do(ctx, http.MethodPost, "/state", newState, &updated)
+	if err != nil {
+		return fmt.Errorf("error updating state: %v", err)
+	}
+
+	return printState(cfg, &updated)
+}
+
+func runWatch(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	err := parseCommandFlags(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	client := NewClient(cfg.URL, cfg.APIKey)
+
+	return client.Stream(ctx, func(e *bus.Event, raw []byte) error {
+		// JSON output is one event per line, so that it can be piped
+		if cfg.Output == JSONOutput {
+			_, err := fmt.Println(string(raw))
+			return err
+		}
+
+		var details string
+		switch data := e.Data.(type) {
+		case map[string]any:
+			switch e.Type {
+			case bus.ReadingEvent:
+				details = fmt.Sprintf("%v %v", data["sensor"], data["value"])
+			case bus.StateChangeEvent:
+				details = fmt.Sprintf("mode=%v target=%v fan=%v source=%v", data["mode"], data["targetTemperature"], data["fanSpeed"], data["source"])
+			}
+		}
+
+		_, err := fmt.Printf("%s  %-12s %s\n", e.Time.Local().Format(timeFormat), e.Type, details)
+		return err
+	})
+}
+
+func runHistory(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	since := flags.Duration("since", 24*time.Hour, "How far back to list readings")
+	from := flags.String("from", "", "Earliest reading to list as RFC 3339 time, overrides --since")
+	err := parseCommandFlags(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now().Add(-*since)
+	if *from != "" {
+		start, err = time.Parse(time.RFC3339, *from)
+		if err != nil {
+			return &usageError{fmt.Sprintf("invalid --from: %v", err)}
+		}
+	}
+
+	client := NewClient(cfg.URL, cfg.APIKey)
+
+	var readings []heatpump.Reading
+	err = client.Do(ctx, http.MethodGet, "/temperature-and-humidity/history?from="+url.QueryEscape(start.Format(time.RFC3339)), nil, &readings)
+	if err != nil {
+		return fmt.Errorf("error fetching history: %v", err)
+	}
+
+	if cfg.Output == JSONOutput {
+		return printJSON(readings)
+	}
+
+	t := newTable(os.Stdout)
+	t.Row("TIME", "TEMPERATURE", "HUMIDITY")
+	for _, reading := range readings {
+		t.Row(reading.Time.Local().Format(timeFormat), fmt.Sprintf("%.1f°C", reading.Temperature), fmt.Sprintf("%.0f%%", reading.Humidity))
+	}
+	return t.Flush()
+}
+
+// runSchedules manages schedules, which are rules with a time trigger and a
+// set state action.
+func runSchedules(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		flags.Usage()
+		return &usageError{"schedules expects list or add"}
+	}
+
+	switch args[0] {
+	case "list":
+		return listSchedules(ctx, cfg, flags, args[1:])
+	case "add":
+		return addSchedule(ctx, cfg, flags, args[1:])
+	default:
+		flags.Usage()
+		return &usageError{fmt.Sprintf("unknown schedules command: %s", args[0])}
+	}
+}
+
+func listSchedules(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	err := parseCommandFlags(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	client := NewClient(cfg.URL, cfg.APIKey)
+
+	var rules []rule.Rule
+	err = client.Do(ctx, http.MethodGet, "/rules", nil, &rules)
+	if err != nil {
+		return fmt.Errorf("error fetching rules: %v", err)
+	}
+
+	schedules := []rule.Rule{}
+	for _, r := range rules {
+		if r.Trigger.Type == rule.TimeTrigger {
+			schedules = append(schedules, r)
+		}
+	}
+
+	if cfg.Output == JSONOutput {
+		return printJSON(schedules)
+	}
+
+	t := newTable(os.Stdout)
+	t.Row("ID", "NAME", "AT", "ENABLED", "ACTIONS")
+	for _, s := range schedules {
+		var actions []string
+		for _, action := range s.Actions {
+			if action.Type == rule.SetStateAction {
+				actions = append(actions, formatState(action.State))
+			} else {
+				actions = append(actions, string(action.Type))
+			}
+		}
+		t.Row(s.ID, s.Name, s.Trigger.At, s.Enabled, strings.Join(actions, ", "))
+	}
+	return t.Flush()
+}
+
+func addSchedule(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	at := flags.String("at", "", "Time of day as HH:MM")
+	name := flags.String("name", "", "Schedule name, defaults to \"Schedule HH:MM\"")
+	disabled := flags.Bool("disabled", false, "Create the schedule disabled")
+	state := stateFlags(flags)
+	err := parseCommandFlags(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	if *at == "" {
+		flags.Usage()
+		return &usageError{"--at is required"}
+	}
+
+	newState := state()
+	if newState.Mode == nil && newState.TargetTemperature == nil && newState.FanSpeed == nil {
+		flags.Usage()
+		return &usageError{"at least one of --mode, --temp and --fan is required"}
+	}
+
+	if *name == "" {
+		*name = "Schedule " + *at
+	}
+
+	newRule := rule.Rule{
+		Name:    *name,
+		Enabled: !*disabled,
+		Trigger: rule.Trigger{Type: rule.TimeTrigger, At: *at},
+		Actions: []rule.Action{{Type: rule.SetStateAction, State: newState}},
+	}
+
+	client := NewClient(cfg.URL, cfg.APIKey)
+
+	var created rule.Rule
+	err = client.Do(ctx, http.MethodPost, "/rules", &newRule, &created)
+	if err != nil {
+		return fmt.Errorf("error creating schedule: %v", err)
+	}
+
+	if cfg.Output == JSONOutput {
+		return printJSON(created)
+	}
+
+	_, err = fmt.Println(created.ID)
+	return err
+}
+
+func runEncode(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	state := stateFlags(flags)
+	err := parseCommandFlags(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	s := state()
+	if s.Mode == nil || s.TargetTemperature == nil || s.FanSpeed == nil {
+		flags.Usage()
+		return &usageError{"--mode, --temp and --fan are required"}
+	}
+
+	err = s.Validate()
+	if err != nil {
+		return fmt.Errorf("error validating state: %v", err)
+	}
+
+	binary, err := s.ToBinary()
+	if err != nil {
+		return fmt.Errorf("error encoding state: %v", err)
+	}
+
+	if cfg.Output == JSONOutput {
+		return printJSON(map[string]string{"binary": binary})
+	}
+
+	_, err = fmt.Println(binary)
+	return err
+}
+
+func runDecode(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error {
+	err := parseCommandFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	// Frames are often copied from logs with spaces between the parts
+	binary := strings.Join(strings.Fields(flags.Arg(0)), "")
+	if strings.Trim(binary, "01") != "" {
+		return errors.New("binary must only contain 0 and 1")
+	}
+
+	state, err := heatpump.NewStateFromBinary(binary)
+	if err != nil {
+		return fmt.Errorf("error decoding binary: %v", err)
+	}
+
+	return printState(cfg, state)
+}
+
+func printState(cfg *Config, state *heatpump.State) error {
+	if cfg.Output == JSONOutput {
+		return printJSON(state)
+	}
+
+	t := newTable(os.Stdout)
+	t.Row("MODE", formatMode(state.Mode))
+	t.Row("TARGET", formatTarget(state.TargetTemperature))
+	t.Row("FAN", formatFan(state.FanSpeed))
+	return t.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	urlKey    = "HEATPUMPCTL_URL"
+	apiKeyKey = "HEATPUMPCTL_API_KEY"
+	configKey = "HEATPUMPCTL_CONFIG"
+
+	defaultURL = "http://localhost:8000"
+)
+
+type Config struct {
+	URL    string
+	APIKey string
+	Output Output
+}
+
+// loadConfig reads the config file, then the environment, each overriding the
+// former. The file has the same KEY=VALUE format as .env files.
+func loadConfig(path string) (*Config, error) {
+	var c Config
+	c.URL = defaultURL
+	c.Output = TableOutput
+
+	if path == "" {
+		path = os.Getenv(configKey)
+	}
+	explicit := path != ""
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err == nil {
+			path = filepath.Join(dir, "heatpumpctl", "config")
+		}
+	}
+
+	if path != "" {
+		values, err := godotenv.Read(path)
+		switch {
+		case err == nil:
+			c.apply(values)
+		case errors.Is(err, fs.ErrNotExist) && !explicit:
+			// The default config file is optional
+		default:
+			return nil, fmt.Errorf("error reading config file %s: %v", path, err)
+		}
+	}
+
+	c.apply(map[string]string{
+		urlKey:    os.Getenv(urlKey),
+		apiKeyKey: os.Getenv(apiKeyKey),
+	})
+
+	return &c, nil
+}
+
+func (c *Config) apply(values map[string]string) {
+	if values[urlKey] != "" {
+		c.URL = values[urlKey]
+	}
+	if values[apiKeyKey] != "" {
+		c.APIKey = values[apiKeyKey]
+	}
+}
@@ -0,0 +1,158 @@
+// Command heatpumpctl controls the heatpump through the HTTP API, and encodes
+// and decodes IR frames offline for debugging.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type command struct {
+	Name    string
+	Usage   string
+	Summary string
+	// Run gets the flag set with the common flags, to add its own and parse
+	// the args.
+	Run func(ctx context.Context, cfg *Config, flags *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{Name: "status", Usage: "status", Summary: "Show the heatpump state and the latest reading", Run: runStatus},
+	{Name: "set", Usage: "set [--mode MODE] [--temp TEMP] [--fan FAN]", Summary: "Change the heatpump state", Run: runSet},
+	{Name: "watch", Usage: "watch", Summary: "Stream readings and state changes", Run: runWatch},
+	{Name: "history", Usage: "history [--since DURATION | --from TIME]", Summary: "List stored readings", Run: runHistory},
+	{Name: "schedules", Usage: "schedules list | schedules add --at HH:MM [--name NAME] [--mode MODE] [--temp TEMP] [--fan FAN] [--disabled]", Summary: "Manage state changes at a time of day", Run: runSchedules},
+	{Name: "encode", Usage: "encode --mode MODE --temp TEMP --fan FAN", Summary: "Encode a state into an IR frame, offline", Run: runEncode},
+	{Name: "decode", Usage: "decode BINARY", Summary: "Decode an IR frame into a state, offline", Run: runDecode},
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	err := run(ctx, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "heatpumpctl: %v\n", err)
+
+		var errUsage *usageError
+		if errors.As(err, &errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	var configPath, url, apiKey string
+	var output Output
+
+	flags := flag.NewFlagSet("heatpumpctl", flag.ContinueOnError)
+	flags.Usage = func() { printUsage(flags) }
+	flags.StringVar(&configPath, "config", "", "Config file, defaults to $"+configKey+" or heatpumpctl/config in the user config directory")
+	flags.StringVar(&url, "url", "", "API base URL, defaults to $"+urlKey+" or "+defaultURL)
+	flags.StringVar(&apiKey, "api-key", "", "API key sent as a bearer token, defaults to $"+apiKeyKey)
+	flags.Var(&output, "o", "Output format: table or json")
+	flags.Var(&output, "output", "Output format: table or json")
+
+	err := flags.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{err.Error()}
+	}
+
+	if flags.NArg() == 0 {
+		printUsage(flags)
+		return &usageError{"command is required"}
+	}
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %v", err)
+	}
+	if url != "" {
+		cfg.URL = url
+	}
+	if apiKey != "" {
+		cfg.APIKey = apiKey
+	}
+	if output != "" {
+		cfg.Output = output
+	}
+
+	name := flags.Arg(0)
+	for _, c := range commands {
+		if c.Name == name {
+			return c.Run(ctx, cfg, newCommandFlags(&c, cfg), flags.Args()[1:])
+		}
+	}
+
+	printUsage(flags)
+	return &usageError{fmt.Sprintf("unknown command: %s", name)}
+}
+
+func printUsage(flags *flag.FlagSet) {
+	w := flags.Output()
+	fmt.Fprintf(w, "Usage: heatpumpctl [flags] COMMAND [command flags]\n\nCommands:\n")
+
+	t := newTable(w)
+	for _, c := range commands {
+		t.Row("  "+c.Name, c.Summary)
+	}
+	_ = t.Flush()
+
+	fmt.Fprintf(w, "\nFlags:\n")
+	flags.PrintDefaults()
+}
+
+// usageError is an error in the command line, as opposed to an error of the
+// command itself.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// newCommandFlags returns the flag set of the command. Output format can be
+// given after the command as well.
+func newCommandFlags(c *command, cfg *Config) *flag.FlagSet {
+	flags := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: heatpumpctl %s\n\n%s\n\nFlags:\n", c.Usage, c.Summary)
+		flags.PrintDefaults()
+	}
+	flags.Var(&cfg.Output, "o", "Output format: table or json")
+	flags.Var(&cfg.Output, "output", "Output format: table or json")
+
+	return flags
+}
+
+// parseCommandFlags parses the flags and checks the number of positional
+// arguments left.
+func parseCommandFlags(flags *flag.FlagSet, args []string, positional int) error {
+	err := flags.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{err.Error()}
+	}
+
+	if flags.NArg() != positional {
+		flags.Usage()
+		return &usageError{fmt.Sprintf("%s expects %d argument(s), got: %d", flags.Name(), positional, flags.NArg())}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+type Output string
+
+const (
+	TableOutput Output = "table"
+	JSONOutput  Output = "json"
+)
+
+func (o *Output) String() string {
+	return string(*o)
+}
+
+func (o *Output) Set(value string) error {
+	switch Output(value) {
+	case TableOutput, JSONOutput:
+		*o = Output(value)
+		return nil
+	default:
+		return fmt.Errorf("output must be one of: [%s, %s], got: %s", TableOutput, JSONOutput, value)
+	}
+}
+
+// table writes rows of tab separated cells as aligned columns.
+type table struct {
+	w *tabwriter.Writer
+}
+
+func newTable(w io.Writer) *table {
+	return &table{w: tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)}
+}
+
+func (t *table) Row(cells ...any) {
+	values := make([]string, len(cells))
+	for i, cell := range cells {
+		values[i] = fmt.Sprint(cell)
+	}
+	fmt.Fprintln(t.w, strings.Join(values, "\t"))
+}
+
+func (t *table) Flush() error {
+	return t.w.Flush()
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func formatMode(mode *heatpump.Mode) string {
+	if mode == nil {
+		return "-"
+	}
+	return string(*mode)
+}
+
+func formatTarget(temperature *int) string {
+	if temperature == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d°C", *temperature)
+}
+
+func formatFan(speed *int) string {
+	switch {
+	case speed == nil:
+		return "-"
+	case *speed == 0:
+		return "auto"
+	default:
+		return fmt.Sprint(*speed)
+	}
+}
+
+// formatState summarises the fields that are set, e.g. for schedule actions.
+func formatState(s *heatpump.State) string {
+	if s == nil {
+		return "-"
+	}
+
+	var fields []string
+	if s.Mode != nil {
+		fields = append(fields, "mode="+formatMode(s.Mode))
+	}
+	if s.TargetTemperature != nil {
+		fields = append(fields, "target="+formatTarget(s.TargetTemperature))
+	}
+	if s.FanSpeed != nil {
+		fields = append(fields, "fan="+formatFan(s.FanSpeed))
+	}
+	if len(fields) == 0 {
+		return "-"
+	}
+
+	return strings.Join(fields, " ")
+}
+
+const timeFormat = "2006-01-02 15:04:05"
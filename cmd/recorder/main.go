@@ -0,0 +1,102 @@
+// Command recorder captures MQTT traffic on a broker to a JSONL file, see
+// package recording. Unlike RECORDING_FILENAME of the API, it records all the
+// topics it subscribes to regardless of who publishes them, so every message
+// is recorded as received.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/logger"
+	"github.com/alexchebotarsky/heatpump-api/model/recording"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	err := run(ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context) error {
+	var brokerURL, topics, output, username, password string
+	var logLevel slog.Level
+
+	flag.StringVar(&brokerURL, "broker", "mqtt://localhost:1883", "Broker URL to connect to")
+	flag.StringVar(&topics, "topics", "#", "Comma separated topic filters to record")
+	flag.StringVar(&output, "output", "recording.jsonl", "File to append the recording to")
+	flag.StringVar(&username, "username", "", "Broker username")
+	flag.StringVar(&password, "password", "", "Broker password")
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "Log level")
+	flag.Parse()
+
+	logger.Init(logLevel, "text")
+
+	recorder, err := recording.Create(output)
+	if err != nil {
+		return fmt.Errorf("error creating recorder: %v", err)
+	}
+	defer func() {
+		err := recorder.Close()
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error closing recorder: %v", err))
+		}
+	}()
+
+	ps, err := pubsub.New(ctx, pubsub.Config{
+		URLs:        []string{brokerURL},
+		ClientID:    "heatpump-recorder",
+		QoS:         1,
+		KeepAlive:   30 * time.Second,
+		Username:    username,
+		Password:    password,
+		StatusTopic: "heatpump/recorder/status",
+	})
+	if err != nil {
+		return fmt.Errorf("error connecting to broker: %v", err)
+	}
+	defer func() {
+		err := ps.Close(context.Background())
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error closing pubsub: %v", err))
+		}
+	}()
+
+	var recorded atomic.Int64
+	for _, filter := range strings.Split(topics, ",") {
+		filter = strings.TrimSpace(filter)
+		if filter == "" {
+			continue
+		}
+
+		err := ps.Watch(ctx, filter, func(ctx context.Context, topic string, payload []byte) error {
+			recorded.Add(1)
+			return recorder.Record(recording.ReceivedDirection, topic, payload)
+		})
+		if err != nil {
+			return fmt.Errorf("error subscribing to %s: %v", filter, err)
+		}
+	}
+
+	slog.Info(fmt.Sprintf("Recording %s to %s", topics, output))
+
+	<-ctx.Done()
+
+	slog.Info(fmt.Sprintf("Recorded %d messages", recorded.Load()))
+
+	return nil
+}
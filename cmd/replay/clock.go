@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// replayClock tells the time of the recording while replaying, so that the new
+// recording lines up with the original one.
+type replayClock struct {
+	speed float64
+
+	mu sync.Mutex
+	// at is the recorded time of the last injected message, and setAt is when
+	// it was injected.
+	at    time.Time
+	setAt time.Time
+}
+
+func newReplayClock(start time.Time, speed float64) *replayClock {
+	var c replayClock
+
+	c.speed = speed
+	c.at = start
+	c.setAt = time.Now()
+
+	return &c
+}
+
+func (c *replayClock) Set(at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.at = at
+	c.setAt = time.Now()
+}
+
+// Now advances from the last injected message at the replay speed. Without
+// delays, everything in response to a message is at the time of the message.
+func (c *replayClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.speed == 0 {
+		return c.at
+	}
+
+	return c.at.Add(time.Duration(float64(time.Since(c.setAt)) * c.speed))
+}
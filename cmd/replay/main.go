@@ -0,0 +1,251 @@
+// Command replay re-injects the received messages of a recording into the
+// processor, with the rest of the app running against a fake pubsub and a
+// scratch database, and records everything the app receives and publishes in
+// response to a new recording. Comparing it with the original recording makes
+// a regression test for sensor handling and automations out of real-world
+// captures.
+//
+// Only the message timing is replayed, automations waiting for wall clock time,
+// like sustain durations and scheduled rules, aren't accelerated with it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/app"
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub/pubsubtest"
+	"github.com/alexchebotarsky/heatpump-api/env"
+	"github.com/alexchebotarsky/heatpump-api/logger"
+	"github.com/alexchebotarsky/heatpump-api/model/recording"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	err := run(ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// readyTimeout is how long the app has to subscribe to its topics.
+const readyTimeout = 10 * time.Second
+
+func run(ctx context.Context) error {
+	var speed float64
+	var configFile, databaseFilename, output string
+	var settle time.Duration
+	var logLevel slog.Level
+
+	flag.Float64Var(&speed, "speed", 1, "How many times faster than recorded to replay, 0 replays without delays")
+	flag.StringVar(&configFile, "config", "", "YAML config file of the API, env variables override its settings. Defaults to CONFIG_FILE")
+	flag.StringVar(&databaseFilename, "database", "", "Database file to start from, it's copied and left unchanged. Empty starts from defaults")
+	flag.StringVar(&output, "output", "replay.jsonl", "File to write the new recording to, it's overwritten")
+	flag.DurationVar(&settle, "settle", 2*time.Second, "How long to keep the app running after the last message")
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "Log level")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <recording.jsonl>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logger.Init(logLevel, "text")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return errors.New("expected a recording file")
+	}
+
+	if speed < 0 {
+		return fmt.Errorf("speed must not be negative, got: %v", speed)
+	}
+
+	entries, err := recording.ReadFile(flag.Arg(0))
+	if err != nil {
+		return fmt.Errorf("error reading recording: %v", err)
+	}
+
+	var received []recording.Entry
+	for _, entry := range entries {
+		if entry.Direction == recording.ReceivedDirection {
+			received = append(received, entry)
+		}
+	}
+	if len(received) == 0 {
+		return errors.New("recording has no received messages")
+	}
+
+	// Services are configured like the API, apart from the clients
+	cfg, err := env.LoadConfig(ctx, configFile)
+	if err != nil {
+		return fmt.Errorf("error loading env config: %v", err)
+	}
+	cfg.Host = "localhost"
+	cfg.Port = 0
+	cfg.RecordingFilename = ""
+
+	dir, err := os.MkdirTemp("", "heatpump-replay")
+	if err != nil {
+		return fmt.Errorf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg.DatabaseFilename = filepath.Join(dir, "database.json")
+	if databaseFilename != "" {
+		data, err := os.ReadFile(databaseFilename)
+		if err != nil {
+			return fmt.Errorf("error reading database file: %v", err)
+		}
+
+		err = os.WriteFile(cfg.DatabaseFilename, data, 0644)
+		if err != nil {
+			return fmt.Errorf("error copying database file: %v", err)
+		}
+	}
+
+	err = os.Remove(output)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing previous output: %v", err)
+	}
+
+	clock := newReplayClock(received[0].Time, speed)
+
+	recorder, err := recording.Create(output)
+	if err != nil {
+		return fmt.Errorf("error creating recorder: %v", err)
+	}
+	recorder.Clock = clock.Now
+
+	fake := pubsubtest.New(pubsub.Config{
+		QoS:                         cfg.PubSubQoS,
+		StateTopic:                  cfg.PubSubStateTopic,
+		TemperatureAndHumidityTopic: cfg.PubSubTemperatureAndHumidityTopic,
+		DeadLetterTopic:             cfg.PubSubDeadLetterTopic,
+		OpenWindowTopic:             cfg.PubSubOpenWindowTopic,
+		Recorder:                    recorder,
+	})
+
+	clients := &app.Clients{PubSub: fake, Recorder: recorder}
+	clients.Database, err = app.NewDatabase(cfg)
+	if err != nil {
+		closeErr := clients.Close(ctx)
+		if closeErr != nil {
+			slog.Error(fmt.Sprintf("Error closing clients: %v", closeErr))
+		}
+		return err
+	}
+
+	a, err := app.NewWithClients(cfg, clients)
+	if err != nil {
+		closeErr := clients.Close(ctx)
+		if closeErr != nil {
+			slog.Error(fmt.Sprintf("Error closing clients: %v", closeErr))
+		}
+		return fmt.Errorf("error creating app: %v", err)
+	}
+
+	// Clients, including the recorder, are closed by the app on shutdown
+	appCtx, stopApp := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		a.Launch(appCtx)
+		close(done)
+	}()
+	defer func() {
+		stopApp()
+		<-done
+	}()
+
+	err = waitForProcessor(ctx, a, done)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Replaying %d messages from %s at %gx speed", len(received), received[0].Time.Local().Format(time.DateTime), speed))
+
+	replayed := replay(ctx, fake, clock, received)
+
+	// Give the workers and automations time to react to the last messages
+	select {
+	case <-ctx.Done():
+	case <-done:
+	case <-time.After(settle):
+	}
+	stopApp()
+	<-done
+
+	slog.Info(fmt.Sprintf("Replayed %d messages, app published %d, recorded to %s", replayed, len(fake.Published("#")), output))
+
+	return nil
+}
+
+// replay injects the entries with the recorded delays between them, and
+// returns how many were injected before ctx was done.
+func replay(ctx context.Context, fake *pubsubtest.PubSub, clock *replayClock, entries []recording.Entry) int {
+	previous := entries[0].Time
+	for i, entry := range entries {
+		delay := entry.Time.Sub(previous)
+		previous = entry.Time
+
+		if clock.speed > 0 && delay > 0 {
+			select {
+			case <-ctx.Done():
+				return i
+			case <-time.After(time.Duration(float64(delay) / clock.speed)):
+			}
+		}
+
+		if ctx.Err() != nil {
+			return i
+		}
+
+		clock.Set(entry.Time)
+
+		// Handler errors are dead-lettered by the processor already, an
+		// error here is only about the delivery
+		err := fake.Inject(ctx, entry.Topic, []byte(entry.Payload))
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error injecting message on topic %s: %v", entry.Topic, err))
+		}
+	}
+
+	return len(entries)
+}
+
+func waitForProcessor(ctx context.Context, a *app.App, done <-chan struct{}) error {
+	for _, managed := range a.Services {
+		if managed.Name != "processor" {
+			continue
+		}
+
+		readyService, ok := managed.Service.(app.ReadyService)
+		if !ok {
+			return nil
+		}
+
+		select {
+		case <-readyService.Ready():
+			return nil
+		case <-done:
+			return errors.New("app stopped before the processor was ready")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(readyTimeout):
+			return fmt.Errorf("processor isn't ready after %s", readyTimeout)
+		}
+	}
+
+	return errors.New("app has no processor")
+}
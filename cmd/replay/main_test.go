@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/app/apptest"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/recording"
+)
+
+const settleTimeout = 5 * time.Second
+
+func TestReplayCapture(t *testing.T) {
+	entries, err := recording.ReadFile("testdata/capture.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []recording.Entry
+	for _, entry := range entries {
+		if entry.Direction == recording.ReceivedDirection {
+			received = append(received, entry)
+		}
+	}
+
+	h := apptest.StartWithFake(t, map[string]string{
+		"DEFAULT_MODE":               "OFF",
+		"DEFAULT_TARGET_TEMPERATURE": "20",
+		"DEFAULT_FAN_SPEED":          "0",
+	})
+
+	replayed := replay(context.Background(), h.PubSub, newReplayClock(received[0].Time, 0), received)
+	if replayed != len(received) {
+		t.Fatalf("expected %d messages to be replayed, got: %d", len(received), replayed)
+	}
+
+	// Events are handled by the workers after they are injected
+	waitFor(t, "readings and dead letter to be published", func() bool {
+		return len(h.PubSub.Published(h.Config.PubSubTemperatureAndHumidityTopic)) == 3 &&
+			len(h.PubSub.Published(h.Config.PubSubDeadLetterTopic)) == 1
+	})
+
+	var state heatpump.State
+	waitFor(t, "state to be updated", func() bool {
+		status := h.Do(http.MethodGet, "/api/v1/state", nil, &state)
+		return status == http.StatusOK && *state.FanSpeed == 60
+	})
+	if *state.Mode != heatpump.HeatMode || *state.TargetTemperature != 23 {
+		t.Errorf("expected both state updates to be applied, got mode %s, targetTemperature %d", *state.Mode, *state.TargetTemperature)
+	}
+
+	var reading heatpump.TemperatureReading
+	status := h.Do(http.MethodGet, "/api/v1/temperature-and-humidity", nil, &reading)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got: %d", http.StatusOK, status)
+	}
+	if reading.Temperature != 20.1 || reading.Humidity != 48 {
+		t.Errorf("expected the last reading, got: %+v", reading)
+	}
+
+	// Readings are published in the order they were recorded
+	published := h.PubSub.Published(h.Config.PubSubTemperatureAndHumidityTopic)
+	for i, temperature := range []float64{18.5, 19.2, 20.1} {
+		var reading heatpump.TemperatureReading
+		err := json.Unmarshal(published[i].Payload, &reading)
+		if err != nil {
+			t.Fatalf("error unmarshalling published reading: %v", err)
+		}
+		if reading.Temperature != temperature {
+			t.Errorf("expected reading %d to be %v, got: %v", i, temperature, reading.Temperature)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(settleTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// startBroker runs an MQTT broker allowing anonymous clients, it's only meant
+// for local testing.
+func startBroker(address string) (*mqtt.Server, error) {
+	server := mqtt.New(&mqtt.Options{
+		Logger: slog.Default().With("component", "broker"),
+	})
+
+	err := server.AddHook(new(auth.AllowHook), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error adding auth hook: %v", err)
+	}
+
+	err = server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: address}))
+	if err != nil {
+		return nil, fmt.Errorf("error adding listener: %v", err)
+	}
+
+	err = server.Serve()
+	if err != nil {
+		return nil, fmt.Errorf("error serving: %v", err)
+	}
+
+	return server, nil
+}
+
+// brokerURLFor returns the URL to connect to a broker listening at address.
+func brokerURLFor(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("mqtt://%s", net.JoinHostPort(host, port))
+}
@@ -0,0 +1,110 @@
+// Command simulator stands in for the heatpump and the room, see package
+// simulator. It connects to a local broker, or runs one in-process that the
+// API can connect to as well.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/logger"
+	"github.com/alexchebotarsky/heatpump-api/simulator"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	err := run(ctx)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context) error {
+	var config simulator.Config
+	var brokerURL, embeddedBroker, start string
+	var logLevel slog.Level
+
+	flag.StringVar(&brokerURL, "broker", "mqtt://localhost:1883", "Broker URL to connect to")
+	flag.StringVar(&embeddedBroker, "embedded-broker", "", "Run an in-process broker on the address, e.g. :1883, and connect to it")
+	flag.Float64Var(&config.Speed, "speed", 1, "How many times faster than real time to run, e.g. 8640 for a day in 10 seconds")
+	flag.StringVar(&start, "start", "", "Simulated start time as RFC 3339, defaults to now")
+	flag.DurationVar(&config.ReadingInterval, "reading-interval", time.Minute, "Simulated time between sensor readings")
+	flag.DurationVar(&config.StatusInterval, "status-interval", time.Hour, "Simulated time between status logs")
+	flag.Float64Var(&config.Temperature, "temperature", 19, "Initial room temperature in °C")
+	flag.Float64Var(&config.Humidity, "humidity", 50, "Initial relative humidity in %")
+	flag.Float64Var(&config.HeatCapacity, "heat-capacity", 5e6, "Heat capacity of the room in J/K")
+	flag.Float64Var(&config.HeatLoss, "heat-loss", 60, "Heat loss to the outdoors in W/K")
+	flag.Float64Var(&config.Outdoor.Mean, "outdoor-mean", 5, "Daily mean outdoor temperature in °C")
+	flag.Float64Var(&config.Outdoor.Amplitude, "outdoor-amplitude", 4, "Daily outdoor temperature swing around the mean in °C")
+	flag.StringVar(&config.IRTransmitterTopic, "ir-transmitter-topic", "heatpump/ir-transmitter", "Topic to receive IR frames on")
+	flag.StringVar(&config.TemperatureSensorTopic, "temperature-sensor-topic", "heatpump/temperature-sensor", "Topic to publish indoor readings to")
+	flag.StringVar(&config.AckTopic, "ack-topic", "heatpump/ir-transmitter/ack", "Topic to acknowledge IR frames on, empty disables acks")
+	flag.DurationVar(&config.AckDelay, "ack-delay", 200*time.Millisecond, "Delay before acknowledging an IR frame")
+	flag.Float64Var(&config.AckDropRate, "ack-drop-rate", 0, "Probability of an IR frame being lost, 0 to 1")
+	flag.StringVar(&config.OutdoorTemperatureTopic, "outdoor-temperature-topic", "", "Topic to publish outdoor readings to, empty disables them")
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "Log level")
+	flag.Parse()
+
+	logger.Init(logLevel, "text")
+
+	config.Start = time.Now()
+	if start != "" {
+		var err error
+		config.Start, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return fmt.Errorf("error parsing start time: %v", err)
+		}
+	}
+
+	err := config.Validate()
+	if err != nil {
+		return fmt.Errorf("error validating config: %v", err)
+	}
+
+	if embeddedBroker != "" {
+		broker, err := startBroker(embeddedBroker)
+		if err != nil {
+			return fmt.Errorf("error starting embedded broker: %v", err)
+		}
+		defer func() {
+			err := broker.Close()
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error closing embedded broker: %v", err))
+			}
+		}()
+
+		brokerURL = brokerURLFor(embeddedBroker)
+		slog.Info(fmt.Sprintf("Embedded broker is listening at %s", embeddedBroker))
+	}
+
+	ps, err := pubsub.New(ctx, pubsub.Config{
+		URLs:        []string{brokerURL},
+		ClientID:    "heatpump-simulator",
+		QoS:         1,
+		KeepAlive:   30 * time.Second,
+		StatusTopic: "heatpump/simulator/status",
+	})
+	if err != nil {
+		return fmt.Errorf("error connecting to broker: %v", err)
+	}
+	defer func() {
+		err := ps.Close(context.Background())
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error closing pubsub: %v", err))
+		}
+	}()
+
+	slog.Info(fmt.Sprintf("Simulating from %s at %gx speed", config.Start.Format(time.DateTime), config.Speed))
+
+	return simulator.New(config, ps).Run(ctx)
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	envconfig "github.com/sethvargo/go-envconfig"
@@ -13,22 +17,126 @@ type Config struct {
 	LogLevel  slog.Level `env:"LOG_LEVEL,default=debug"`
 	LogFormat string     `env:"LOG_FORMAT,default=text"`
 
+	TracingExporter    string `env:"TRACING_EXPORTER,default=none"`
+	TracingServiceName string `env:"TRACING_SERVICE_NAME,default=heatpump-api"`
+
 	Host string `env:"HOST,default=localhost"`
 	Port uint16 `env:"PORT,default=8000"`
 
+	AdminAPIKey string `env:"ADMIN_API_KEY" secret:"true"`
+
 	DatabaseFilename string `env:"DATABASE_FILENAME,default=./database.json"`
 
+	BackupSnapshotDir      string        `env:"BACKUP_SNAPSHOT_DIR"`
+	BackupSnapshotInterval time.Duration `env:"BACKUP_SNAPSHOT_INTERVAL,default=24h"`
+	BackupSnapshotKeep     int           `env:"BACKUP_SNAPSHOT_KEEP,default=7"`
+
 	DefaultMode              string `env:"DEFAULT_MODE,default=OFF"`
 	DefaultTargetTemperature int    `env:"DEFAULT_TARGET_TEMPERATURE,default=22"`
 	DefaultFanSpeed          int    `env:"DEFAULT_FAN_SPEED,default=0"`
 
-	PubSubHost     string `env:"PUBSUB_HOST,default=localhost"`
-	PubSubPort     uint16 `env:"PUBSUB_PORT,default=1883"`
-	PubSubClientID string `env:"PUBSUB_CLIENT_ID,default=heatpump-api"`
-	PubSubQoS      byte   `env:"PUBSUB_QOS,default=1"`
+	PubSubURLs         []string      `env:"PUBSUB_URLS" secret:"url"`
+	PubSubHost         string        `env:"PUBSUB_HOST,default=localhost"`
+	PubSubPort         uint16        `env:"PUBSUB_PORT,default=1883"`
+	PubSubClientID     string        `env:"PUBSUB_CLIENT_ID,default=heatpump-api"`
+	PubSubQoS          byte          `env:"PUBSUB_QOS,default=1"`
+	PubSubKeepAlive    time.Duration `env:"PUBSUB_KEEP_ALIVE,default=20s"`
+	PubSubUsername     string        `env:"PUBSUB_USERNAME"`
+	PubSubPassword     string        `env:"PUBSUB_PASSWORD" secret:"true"`
+	PubSubPasswordFile string        `env:"PUBSUB_PASSWORD_FILE"`
+	PubSubCAFile       string        `env:"PUBSUB_CA_FILE"`
+	PubSubCertFile     string        `env:"PUBSUB_CERT_FILE"`
+	PubSubKeyFile      string        `env:"PUBSUB_KEY_FILE"`
+
+	PubSubStatusTopic                 string `env:"PUBSUB_STATUS_TOPIC,default=heatpump-api/status"`
+	PubSubStateTopic                  string `env:"PUBSUB_STATE_TOPIC,default=heatpump-api/state"`
+	PubSubStateSetTopic               string `env:"PUBSUB_STATE_SET_TOPIC,default=heatpump-api/state/set"`
+	PubSubTemperatureAndHumidityTopic string `env:"PUBSUB_TEMPERATURE_AND_HUMIDITY_TOPIC,default=heatpump-api/temperature-and-humidity"`
+	PubSubCommandTopic                string `env:"PUBSUB_COMMAND_TOPIC,default=heatpump-api/command"`
+	PubSubDeadLetterTopic             string `env:"PUBSUB_DEAD_LETTER_TOPIC,default=heatpump-api/dead-letter"`
+	PubSubIRTransmitterAckTopic       string `env:"PUBSUB_IR_TRANSMITTER_ACK_TOPIC,default=heatpump/ir-transmitter/ack"`
+	PubSubSmartPlugTopic              string `env:"PUBSUB_SMART_PLUG_TOPIC"`
+	PubSubOutdoorTemperatureTopic     string `env:"PUBSUB_OUTDOOR_TEMPERATURE_TOPIC"`
+	PubSubWindowContactTopic          string `env:"PUBSUB_WINDOW_CONTACT_TOPIC"`
+	PubSubOpenWindowTopic             string `env:"PUBSUB_OPEN_WINDOW_TOPIC,default=heatpump-api/open-window"`
+	// Presence and motion topics are "name:topic" pairs separated by commas
+	PubSubPresenceTopics map[string]string `env:"PUBSUB_PRESENCE_TOPICS"`
+	PubSubMotionTopics   map[string]string `env:"PUBSUB_MOTION_TOPICS"`
+
+	ProcessorRetryAttempts int           `env:"PROCESSOR_RETRY_ATTEMPTS,default=3"`
+	ProcessorRetryBackoff  time.Duration `env:"PROCESSOR_RETRY_BACKOFF,default=500ms"`
+	ProcessorWorkers       int           `env:"PROCESSOR_WORKERS,default=4"`
+	ProcessorQueueSize     int           `env:"PROCESSOR_QUEUE_SIZE,default=100"`
+
+	RecordingFilename string `env:"RECORDING_FILENAME"`
+
+	EnergyConfigFilename string        `env:"ENERGY_CONFIG_FILENAME"`
+	EnergyMeterInterval  time.Duration `env:"ENERGY_METER_INTERVAL,default=1m"`
+
+	OutdoorTemperatureSource       string        `env:"OUTDOOR_TEMPERATURE_SOURCE"`
+	OutdoorTemperaturePollInterval time.Duration `env:"OUTDOOR_TEMPERATURE_POLL_INTERVAL,default=5m"`
+	OutdoorTemperatureMaxAge       time.Duration `env:"OUTDOOR_TEMPERATURE_MAX_AGE,default=1h"`
+	WeatherCompensationCurve       string        `env:"WEATHER_COMPENSATION_CURVE"`
+
+	HumidityAutomationEnabled       bool          `env:"HUMIDITY_AUTOMATION_ENABLED,default=false"`
+	HumidityHighThreshold           float64       `env:"HUMIDITY_HIGH_THRESHOLD,default=65"`
+	HumidityLowThreshold            float64       `env:"HUMIDITY_LOW_THRESHOLD,default=55"`
+	HumiditySustainDuration         time.Duration `env:"HUMIDITY_SUSTAIN_DURATION,default=15m"`
+	HumidityAutomationMode          string        `env:"HUMIDITY_AUTOMATION_MODE,default=DRY"`
+	HumidityAutomationFanSpeed      int           `env:"HUMIDITY_AUTOMATION_FAN_SPEED,default=20"`
+	HumidityQuietHours              string        `env:"HUMIDITY_QUIET_HOURS"`
+	HumidityManualOverrideDuration  time.Duration `env:"HUMIDITY_MANUAL_OVERRIDE_DURATION,default=2h"`
+	HumidityAutomationCheckInterval time.Duration `env:"HUMIDITY_AUTOMATION_CHECK_INTERVAL,default=1m"`
+
+	OpenWindowDetectionEnabled        bool          `env:"OPEN_WINDOW_DETECTION_ENABLED,default=false"`
+	OpenWindowDropSlope               float64       `env:"OPEN_WINDOW_DROP_SLOPE,default=0.3"`
+	OpenWindowSlopeWindow             time.Duration `env:"OPEN_WINDOW_SLOPE_WINDOW,default=5m"`
+	OpenWindowStableSlope             float64       `env:"OPEN_WINDOW_STABLE_SLOPE,default=0.05"`
+	OpenWindowMinDuration             time.Duration `env:"OPEN_WINDOW_MIN_DURATION,default=5m"`
+	OpenWindowMaxDuration             time.Duration `env:"OPEN_WINDOW_MAX_DURATION,default=30m"`
+	OpenWindowSuspendMode             string        `env:"OPEN_WINDOW_SUSPEND_MODE,default=OFF"`
+	OpenWindowFrostProtectTemperature int           `env:"OPEN_WINDOW_FROST_PROTECT_TEMPERATURE,default=17"`
+
+	PresenceAwayDebounce       time.Duration `env:"PRESENCE_AWAY_DEBOUNCE,default=10m"`
+	PresenceMotionTimeout      time.Duration `env:"PRESENCE_MOTION_TIMEOUT,default=30m"`
+	PresenceComfortTemperature int           `env:"PRESENCE_COMFORT_TEMPERATURE,default=22"`
+	PresenceEcoTemperature     int           `env:"PRESENCE_ECO_TEMPERATURE,default=18"`
+	PresenceControlEnabled     bool          `env:"PRESENCE_CONTROL_ENABLED,default=false"`
+
+	NotifyHTTPURL             string        `env:"NOTIFY_HTTP_URL" secret:"url"`
+	NotifyHTTPToken           string        `env:"NOTIFY_HTTP_TOKEN" secret:"true"`
+	NotifySMTPHost            string        `env:"NOTIFY_SMTP_HOST"`
+	NotifySMTPPort            uint16        `env:"NOTIFY_SMTP_PORT,default=587"`
+	NotifySMTPUsername        string        `env:"NOTIFY_SMTP_USERNAME"`
+	NotifySMTPPassword        string        `env:"NOTIFY_SMTP_PASSWORD" secret:"true"`
+	NotifySMTPFrom            string        `env:"NOTIFY_SMTP_FROM"`
+	NotifySMTPTo              []string      `env:"NOTIFY_SMTP_TO"`
+	NotifyTelegramAPIURL      string        `env:"NOTIFY_TELEGRAM_API_URL,default=https://api.telegram.org"`
+	NotifyTelegramBotToken    string        `env:"NOTIFY_TELEGRAM_BOT_TOKEN" secret:"true"`
+	NotifyTelegramChatID      string        `env:"NOTIFY_TELEGRAM_CHAT_ID"`
+	NotifyTimeout             time.Duration `env:"NOTIFY_TIMEOUT,default=10s"`
+	NotifyCooldown            time.Duration `env:"NOTIFY_COOLDOWN,default=30m"`
+	NotifyQuietHours          string        `env:"NOTIFY_QUIET_HOURS"`
+	NotifyHealthCheckInterval time.Duration `env:"NOTIFY_HEALTH_CHECK_INTERVAL,default=1m"`
+
+	WebhookMaxAttempts     int           `env:"WEBHOOK_MAX_ATTEMPTS,default=8"`
+	WebhookRetryBackoff    time.Duration `env:"WEBHOOK_RETRY_BACKOFF,default=5s"`
+	WebhookMaxRetryBackoff time.Duration `env:"WEBHOOK_MAX_RETRY_BACKOFF,default=30m"`
+	WebhookTimeout         time.Duration `env:"WEBHOOK_TIMEOUT,default=10s"`
+
+	ShutdownTimeout          time.Duration `env:"SHUTDOWN_TIMEOUT,default=5s"`
+	ServiceRestartBackoff    time.Duration `env:"SERVICE_RESTART_BACKOFF,default=1s"`
+	ServiceMaxRestartBackoff time.Duration `env:"SERVICE_MAX_RESTART_BACKOFF,default=1m"`
+
+	HealthCacheTTL                time.Duration `env:"HEALTH_CACHE_TTL,default=5s"`
+	HealthCheckTimeout            time.Duration `env:"HEALTH_CHECK_TIMEOUT,default=2s"`
+	HealthSensorMaxAge            time.Duration `env:"HEALTH_SENSOR_MAX_AGE,default=10m"`
+	HealthIRTransmitterAckTimeout time.Duration `env:"HEALTH_IR_TRANSMITTER_ACK_TIMEOUT,default=30s"`
 }
 
-func LoadConfig(ctx context.Context) (*Config, error) {
+// LoadConfig reads the settings from env variables, which override the
+// settings of the config file, if any. The file defaults to CONFIG_FILE.
+func LoadConfig(ctx context.Context, filename string) (*Config, error) {
 	var c Config
 
 	// We are loading env variables from .env file only for local development
@@ -37,10 +145,60 @@ func LoadConfig(ctx context.Context) (*Config, error) {
 		slog.Debug(fmt.Sprintf("error loading .env file: %v", err))
 	}
 
-	err = envconfig.Process(ctx, &c)
+	if filename == "" {
+		filename = os.Getenv("CONFIG_FILE")
+	}
+
+	lookuper := envconfig.OsLookuper()
+	if filename != "" {
+		fileVars, err := loadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("error loading config file %s: %v", filename, err)
+		}
+		lookuper = envconfig.MultiLookuper(envconfig.OsLookuper(), envconfig.MapLookuper(fileVars))
+	}
+
+	err = envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &c,
+		Lookuper: lookuper,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("error processing environment variables: %v", err)
 	}
 
+	// Password file takes precedence, so that it can be mounted as a Docker secret
+	if c.PubSubPasswordFile != "" {
+		password, err := os.ReadFile(c.PubSubPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pubsub password file: %v", err)
+		}
+		c.PubSubPassword = strings.TrimSpace(string(password))
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config:\n%v", err)
+	}
+
 	return &c, nil
 }
+
+// Changed returns the names of the settings that differ between the configs.
+func Changed(a, b *Config) []string {
+	var names []string
+
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := va.Type()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("env"), ",")
+		if name == "" {
+			continue
+		}
+
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
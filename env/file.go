@@ -0,0 +1,212 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config file is YAML with the same settings as the env variables. Nested keys
+// are joined, and camelCase keys are split into words, so the following are
+// all PUBSUB_STATE_SET_TOPIC:
+//
+//	pubsub:
+//	  stateSetTopic: heatpump-api/state/set
+//	pubsub:
+//	  state_set_topic: heatpump-api/state/set
+//	pubsubStateSetTopic: heatpump-api/state/set
+//
+// Lists are used for list settings and mappings for map settings, e.g.
+// PUBSUB_PRESENCE_TOPICS.
+
+// loadFile reads the config file as env variables, so that env variables can
+// override it key by key.
+func loadFile(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var root yaml.Node
+	err = yaml.Unmarshal(data, &root)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	vars := make(map[string]string)
+
+	// Empty file has no document
+	if len(root.Content) == 0 {
+		return vars, nil
+	}
+
+	err = flatten(root.Content[0], nil, nil, settingKinds(), vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// flatten adds the settings of the mapping node to vars. Path is the keys as
+// written in the file for error messages, and words the env variable name so
+// far.
+func flatten(node *yaml.Node, path, words []string, kinds map[string]reflect.Kind, vars map[string]string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: %s must be a mapping", node.Line, keyPath(path))
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		path := append(slices.Clone(path), key.Value)
+		words := append(slices.Clone(words), splitWords(key.Value)...)
+		name := strings.Join(words, "_")
+
+		kind, known := kinds[name]
+
+		if value.Kind == yaml.MappingNode && kind != reflect.Map {
+			if !isPrefix(name, kinds) {
+				return fmt.Errorf("line %d: unknown key %s", key.Line, keyPath(path))
+			}
+
+			err := flatten(value, path, words, kinds, vars)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !known {
+			return fmt.Errorf("line %d: unknown key %s (%s)", key.Line, keyPath(path), name)
+		}
+
+		_, duplicate := vars[name]
+		if duplicate {
+			return fmt.Errorf("line %d: %s is set more than once", key.Line, name)
+		}
+
+		// Null leaves the setting to env variables and defaults
+		if value.Tag == "!!null" {
+			continue
+		}
+
+		str, err := flattenValue(value, kind)
+		if err != nil {
+			return fmt.Errorf("line %d: %s %v", value.Line, keyPath(path), err)
+		}
+
+		vars[name] = str
+	}
+
+	return nil
+}
+
+// flattenValue formats the value the way env variables are parsed.
+func flattenValue(node *yaml.Node, kind reflect.Kind) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.SequenceNode:
+		if kind != reflect.Slice {
+			return "", errors.New("must not be a list")
+		}
+
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("items must be plain values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	case yaml.MappingNode:
+		if kind != reflect.Map {
+			return "", errors.New("must not be a mapping")
+		}
+
+		pairs := make([]string, 0, len(node.Content)/2)
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind != yaml.ScalarNode {
+				return "", errors.New("values must be plain values")
+			}
+			pairs = append(pairs, key.Value+":"+value.Value)
+		}
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", errors.New("has unsupported value")
+	}
+}
+
+// settingKinds maps the env variable names of the config to the kinds of their
+// fields.
+func settingKinds() map[string]reflect.Kind {
+	kinds := make(map[string]reflect.Kind)
+
+	t := reflect.TypeFor[Config]()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("env"), ",")
+		if name == "" {
+			continue
+		}
+		kinds[name] = field.Type.Kind()
+	}
+
+	return kinds
+}
+
+func isPrefix(prefix string, kinds map[string]reflect.Kind) bool {
+	for name := range kinds {
+		if strings.HasPrefix(name, prefix+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitWords splits camelCase, snake_case and kebab-case keys into upper case
+// words, e.g. "irTransmitterAckTopic" into IR, TRANSMITTER, ACK and TOPIC.
+func splitWords(key string) []string {
+	var words []string
+	var word []rune
+
+	runes := []rune(key)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-':
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+			continue
+		case unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])):
+			// Start of a new word, acronyms like URL stay together
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+		}
+		word = append(word, unicode.ToUpper(r))
+	}
+
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	return words
+}
+
+func keyPath(path []string) string {
+	if len(path) == 0 {
+		return "config"
+	}
+	return strings.Join(path, ".")
+}
@@ -0,0 +1,97 @@
+package env
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadFileFlattensKeys(t *testing.T) {
+	filename := writeConfigFile(t, `
+port: 9000
+pubsub:
+  stateSetTopic: home/state/set
+  urls:
+    - mqtts://a:8883
+    - mqtts://b:8883
+  presence_topics:
+    alex: home/alex
+    sam: home/sam
+  password: null
+health:
+  irTransmitterAckTimeout: 30s
+`)
+
+	vars, err := loadFile(filename)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	want := map[string]string{
+		"PORT":                              "9000",
+		"PUBSUB_STATE_SET_TOPIC":            "home/state/set",
+		"PUBSUB_URLS":                       "mqtts://a:8883,mqtts://b:8883",
+		"PUBSUB_PRESENCE_TOPICS":            "alex:home/alex,sam:home/sam",
+		"HEALTH_IR_TRANSMITTER_ACK_TIMEOUT": "30s",
+	}
+	if !maps.Equal(vars, want) {
+		t.Errorf("expected vars %v, got: %v", want, vars)
+	}
+}
+
+func TestLoadFileRejectsInvalidKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"unknown key", "prot: 9000", "line 1: unknown key prot (PROT)"},
+		{"unknown section", "mqtt:\n  host: broker", "line 1: unknown key mqtt"},
+		{"unknown nested key", "pubsub:\n  hots: broker", "line 2: unknown key pubsub.hots (PUBSUB_HOTS)"},
+		{"set twice", "pubsubHost: a\npubsub:\n  host: b", "line 3: PUBSUB_HOST is set more than once"},
+		{"list for a plain setting", "port: [1, 2]", "port must not be a list"},
+		{"mapping for a plain setting", "pubsub:\n  host:\n    name: broker", "unknown key pubsub.host"},
+		{"not a mapping", "- port", "config must be a mapping"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadFile(writeConfigFile(t, test.config))
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := map[string]string{
+		"stateSetTopic":         "STATE_SET_TOPIC",
+		"state_set_topic":       "STATE_SET_TOPIC",
+		"state-set-topic":       "STATE_SET_TOPIC",
+		"irTransmitterAckTopic": "IR_TRANSMITTER_ACK_TOPIC",
+		"notifyHttpUrl":         "NOTIFY_HTTP_URL",
+		"pubsubCaFile":          "PUBSUB_CA_FILE",
+	}
+
+	for key, want := range tests {
+		got := strings.Join(splitWords(key), "_")
+		if got != want {
+			t.Errorf("expected %s to be %s, got: %s", key, want, got)
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(filename, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+
+	return filename
+}
@@ -0,0 +1,127 @@
+package env
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// Print writes the config as a config file, grouped by the first word of the
+// setting. Fields tagged secret:"true" are redacted, and so are passwords in
+// URLs of fields tagged secret:"url".
+func (c *Config) Print(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	groups := make(map[string]*yaml.Node)
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("env"), ",")
+		if name == "" {
+			continue
+		}
+
+		value := printValue(v.Field(i), field.Tag.Get("secret"))
+
+		words := strings.Split(name, "_")
+		if len(words) == 1 {
+			root.Content = append(root.Content, keyNode(words), value)
+			continue
+		}
+
+		group, ok := groups[words[0]]
+		if !ok {
+			group = &yaml.Node{Kind: yaml.MappingNode}
+			groups[words[0]] = group
+			root.Content = append(root.Content, keyNode(words[:1]), group)
+		}
+		group.Content = append(group.Content, keyNode(words[1:]), value)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+
+	err := encoder.Encode(root)
+	if err != nil {
+		return fmt.Errorf("error encoding config: %v", err)
+	}
+
+	err = encoder.Close()
+	if err != nil {
+		return fmt.Errorf("error closing config encoder: %v", err)
+	}
+
+	return nil
+}
+
+// keyNode joins the words of a setting as a camelCase key, which the config
+// file splits back into the same words.
+func keyNode(words []string) *yaml.Node {
+	var key strings.Builder
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if i > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		key.WriteString(word)
+	}
+
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: key.String()}
+}
+
+func printValue(v reflect.Value, secret string) *yaml.Node {
+	switch v.Kind() {
+	case reflect.Slice:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for i := range v.Len() {
+			node.Content = append(node.Content, printValue(v.Index(i), secret))
+		}
+		return node
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		for _, key := range keys {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key.String()}, printValue(v.MapIndex(key), secret))
+		}
+		return node
+	case reflect.String:
+		value := v.String()
+		switch {
+		case secret == "true" && value != "":
+			value = redacted
+		case secret == "url":
+			value = redactURL(value)
+		}
+		// Strings are tagged, so that e.g. an empty topic isn't printed as
+		// null, which would fall back to the default
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(v.Interface())}
+	}
+}
+
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+
+	_, hasPassword := u.User.Password()
+	if !hasPassword {
+		return value
+	}
+
+	u.User = url.UserPassword(u.User.Username(), redacted)
+	return u.String()
+}
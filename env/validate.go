@@ -0,0 +1,97 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+var (
+	logFormats       = []string{"text", "json"}
+	tracingExporters = []string{"none", "", "stdout", "otlp"}
+)
+
+// Validate checks the settings that are used as is, so that mistakes are
+// reported at startup. Settings that are parsed further, like quiet hours,
+// are validated by the services using them.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if !slices.Contains(logFormats, c.LogFormat) {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be one of: %v, got: %s", logFormats, c.LogFormat))
+	}
+
+	if !slices.Contains(tracingExporters, c.TracingExporter) {
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER must be one of: %v, got: %s", tracingExporters, c.TracingExporter))
+	}
+
+	if c.DatabaseFilename == "" {
+		errs = append(errs, errors.New("DATABASE_FILENAME must be set"))
+	}
+
+	// Defaults are persisted to a new database as is, so they have to be a
+	// valid state
+	mode := heatpump.Mode(c.DefaultMode)
+	if !slices.Contains(heatpump.Modes, mode) {
+		errs = append(errs, fmt.Errorf("DEFAULT_MODE must be one of: %v, got: %s", heatpump.Modes, c.DefaultMode))
+	}
+
+	if c.DefaultTargetTemperature < heatpump.MinTargetTemperature || c.DefaultTargetTemperature > heatpump.MaxTargetTemperature {
+		errs = append(errs, fmt.Errorf("DEFAULT_TARGET_TEMPERATURE must be in range [%d,%d], got: %d", heatpump.MinTargetTemperature, heatpump.MaxTargetTemperature, c.DefaultTargetTemperature))
+	}
+
+	if c.DefaultFanSpeed < 0 || c.DefaultFanSpeed > 100 {
+		errs = append(errs, fmt.Errorf("DEFAULT_FAN_SPEED must be in range [0,100], got: %d", c.DefaultFanSpeed))
+	}
+
+	if c.PubSubQoS > 2 {
+		errs = append(errs, fmt.Errorf("PUBSUB_QOS must be 0, 1 or 2, got: %d", c.PubSubQoS))
+	}
+
+	if c.PubSubClientID == "" {
+		errs = append(errs, errors.New("PUBSUB_CLIENT_ID must be set"))
+	}
+
+	if c.ProcessorRetryAttempts < 1 {
+		errs = append(errs, fmt.Errorf("PROCESSOR_RETRY_ATTEMPTS must be at least 1, got: %d", c.ProcessorRetryAttempts))
+	}
+
+	if c.ProcessorWorkers < 1 || c.ProcessorQueueSize < 1 {
+		errs = append(errs, fmt.Errorf("PROCESSOR_WORKERS and PROCESSOR_QUEUE_SIZE must be at least 1, got: %d and %d", c.ProcessorWorkers, c.ProcessorQueueSize))
+	}
+
+	if c.HumidityHighThreshold < 0 || c.HumidityHighThreshold > 100 || c.HumidityLowThreshold < 0 || c.HumidityLowThreshold > 100 {
+		errs = append(errs, fmt.Errorf("HUMIDITY_HIGH_THRESHOLD and HUMIDITY_LOW_THRESHOLD must be in range [0,100], got: %g and %g", c.HumidityHighThreshold, c.HumidityLowThreshold))
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"HEALTH_CHECK_TIMEOUT", c.HealthCheckTimeout},
+		{"ENERGY_METER_INTERVAL", c.EnergyMeterInterval},
+		{"PROCESSOR_RETRY_BACKOFF", c.ProcessorRetryBackoff},
+		{"OUTDOOR_TEMPERATURE_POLL_INTERVAL", c.OutdoorTemperaturePollInterval},
+		{"SERVICE_RESTART_BACKOFF", c.ServiceRestartBackoff},
+		{"SERVICE_MAX_RESTART_BACKOFF", c.ServiceMaxRestartBackoff},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got: %s", d.name, d.value))
+		}
+	}
+
+	if c.ServiceMaxRestartBackoff < c.ServiceRestartBackoff {
+		errs = append(errs, fmt.Errorf("SERVICE_MAX_RESTART_BACKOFF must not be less than SERVICE_RESTART_BACKOFF, got: %s and %s", c.ServiceMaxRestartBackoff, c.ServiceRestartBackoff))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
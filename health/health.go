@@ -0,0 +1,196 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+)
+
+type Check struct {
+	Name string
+	// Critical checks make the service not ready when failing, others are
+	// only reported.
+	Critical bool
+	Func     func(ctx context.Context) error
+}
+
+type Status string
+
+const (
+	OKStatus       Status = "OK"
+	DegradedStatus Status = "DEGRADED"
+	ErrStatus      Status = "ERR"
+)
+
+type Result struct {
+	Name      string    `json:"name"`
+	Status    Status    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs float64   `json:"latencyMs"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker runs registered checks and caches their results, so that frequent
+// probes don't hammer the dependencies.
+type Checker struct {
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	checks  []Check
+	results map[string]Result
+}
+
+func New(cacheTTL, timeout time.Duration) *Checker {
+	var c Checker
+
+	c.cacheTTL = cacheTTL
+	c.timeout = timeout
+	c.results = make(map[string]Result)
+
+	return &c
+}
+
+func (c *Checker) Register(checks ...Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, checks...)
+}
+
+// Names returns the names of the registered checks.
+func (c *Checker) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make([]string, len(c.checks))
+	for i, check := range c.checks {
+		names[i] = check.Name
+	}
+
+	return names
+}
+
+// Run runs the checks whose cached results are stale. Checks that don't return
+// within the timeout are reported as failed, without waiting for them.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := slices.Clone(c.checks)
+	results := make([]Result, len(checks))
+	var due []int
+	for i, check := range checks {
+		cached, ok := c.results[check.Name]
+		if ok && time.Since(cached.CheckedAt) < c.cacheTTL {
+			results[i] = cached
+			continue
+		}
+		due = append(due, i)
+	}
+	c.mu.Unlock()
+
+	type checkResult struct {
+		index  int
+		result Result
+	}
+
+	// Buffered, so that checks finishing after the timeout don't block
+	resultc := make(chan checkResult, len(due))
+	start := time.Now()
+	for _, i := range due {
+		go func() {
+			resultc <- checkResult{index: i, result: c.run(ctx, checks[i])}
+		}()
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	pending := make(map[int]bool, len(due))
+	for _, i := range due {
+		pending[i] = true
+	}
+
+	for len(pending) > 0 {
+		select {
+		case r := <-resultc:
+			results[r.index] = r.result
+			delete(pending, r.index)
+		case <-timeoutCtx.Done():
+			for i := range pending {
+				results[i] = Result{
+					Name:      checks[i].Name,
+					Status:    ErrStatus,
+					Critical:  checks[i].Critical,
+					Error:     fmt.Sprintf("check timed out after %s", c.timeout),
+					LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+					CheckedAt: start,
+				}
+				metrics.SetHealthCheckStatus(checks[i].Name, false)
+			}
+			clear(pending)
+		}
+	}
+
+	report := Report{
+		Status: OKStatus,
+		Checks: results,
+	}
+
+	// Results of a cancelled run say nothing about the dependencies
+	if ctx.Err() == nil {
+		c.mu.Lock()
+		for _, i := range due {
+			c.results[results[i].Name] = results[i]
+		}
+		c.mu.Unlock()
+	}
+
+	for _, result := range results {
+		if result.Status == OKStatus {
+			continue
+		}
+
+		if result.Critical {
+			report.Status = ErrStatus
+		} else if report.Status == OKStatus {
+			report.Status = DegradedStatus
+		}
+	}
+
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Func(ctx)
+	latency := time.Since(start)
+
+	result := Result{
+		Name:      check.Name,
+		Status:    OKStatus,
+		Critical:  check.Critical,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Status = ErrStatus
+		result.Error = err.Error()
+	}
+
+	metrics.SetHealthCheckStatus(check.Name, err == nil)
+
+	return result
+}
@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunReportsSlowChecksAsTimedOut(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	c := New(time.Minute, 50*time.Millisecond)
+	c.Register(
+		Check{Name: "fast", Critical: true, Func: func(ctx context.Context) error { return nil }},
+		// Ignores the context, like a hanging disk
+		Check{Name: "hanging", Func: func(ctx context.Context) error {
+			<-release
+			return nil
+		}},
+	)
+
+	start := time.Now()
+	report := c.Run(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected run to return after the timeout, took: %s", elapsed)
+	}
+
+	if report.Status != DegradedStatus {
+		t.Errorf("expected status %s, got: %s", DegradedStatus, report.Status)
+	}
+	if report.Checks[0].Status != OKStatus {
+		t.Errorf("expected fast check to be %s, got: %s", OKStatus, report.Checks[0].Status)
+	}
+	if report.Checks[1].Status != ErrStatus || report.Checks[1].Error == "" {
+		t.Errorf("expected hanging check to be timed out, got: %+v", report.Checks[1])
+	}
+}
+
+func TestRunDoesntBlockOtherCalls(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	c := New(time.Minute, time.Minute)
+	c.Register(Check{Name: "hanging", Func: func(ctx context.Context) error {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Run(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.Names()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected other calls not to wait for a running check")
+	}
+}
+
+func TestRunCachesResults(t *testing.T) {
+	var calls int
+	c := New(time.Minute, time.Second)
+	c.Register(Check{Name: "failing", Critical: true, Func: func(ctx context.Context) error {
+		calls++
+		return errors.New("down")
+	}})
+
+	for range 3 {
+		report := c.Run(context.Background())
+		if report.Status != ErrStatus {
+			t.Errorf("expected status %s, got: %s", ErrStatus, report.Status)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected check to run once within the cache ttl, got: %d", calls)
+	}
+}
@@ -6,9 +6,15 @@ import (
 	"log/slog"
 )
 
-func Init(level slog.Level, format string) {
+// level is shared by the handlers, so that it can be changed without
+// replacing the logger.
+var level slog.LevelVar
+
+func Init(l slog.Level, format string) {
+	level.Set(l)
+
 	opts := &slog.HandlerOptions{
-		Level: level,
+		Level: &level,
 	}
 
 	var handler slog.Handler
@@ -25,3 +31,8 @@ func Init(level slog.Level, format string) {
 
 	slog.SetDefault(logger)
 }
+
+// SetLevel changes the level of the logger set up by Init.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
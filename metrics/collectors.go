@@ -2,6 +2,8 @@ package metrics
 
 import (
 	"math"
+	"runtime"
+	"runtime/debug"
 	"strconv"
 	"time"
 
@@ -16,11 +18,13 @@ var (
 	},
 		[]string{"route_name", "status_code"},
 	))
-	requestsDuration = newCollector(prometheus.NewHistogram(prometheus.HistogramOpts{
+	requestsDuration = newCollector(prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "requests_duration",
 		Help:    "Time spent processing requests",
 		Buckets: []float64{.005, .01, .025, .05, .075, .1, .25, .5, .75, 1.0, 2.5, 5.0, 7.5, 10.0, math.Inf(1)},
-	}))
+	},
+		[]string{"method", "route"},
+	))
 
 	eventsProcessed = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "events_processed",
@@ -35,11 +39,40 @@ var (
 	},
 		[]string{"event_name"},
 	))
+	eventsQueueDepth = newCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "events_queue_depth",
+		Help: "Number of events waiting in the queue to be processed",
+	},
+		[]string{"event_name"},
+	))
+	eventsDropped = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_dropped",
+		Help: "Events dropped because the queue was full or the processor was stopped",
+	},
+		[]string{"event_name"},
+	))
+
+	healthCheckStatus = newCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "health_check_status",
+		Help: "Status of the health check, 1 if passing and 0 if failing",
+	},
+		[]string{"check"},
+	))
 
 	heatpumpMode = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "heatpump_mode",
 		Help: "Mode of the heatpump",
 	}))
+	heatpumpModeInfo = newCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heatpump_mode_info",
+		Help: "Mode of the heatpump as a state set, current mode is 1 and others are 0",
+	},
+		[]string{"mode"},
+	))
+	heatpumpModeRuntime = newCollector(newModeRuntimeCollector(
+		"heatpump_mode_seconds_total",
+		"Estimated time the heatpump has spent in each mode since the service start",
+	))
 	heatpumpTargetTemperature = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "heatpump_target_temperature",
 		Help: "Target temperature of the heatpump",
@@ -57,14 +90,137 @@ var (
 		Name: "heatpump_current_humidity",
 		Help: "Current humidity reading of the heatpump",
 	}))
+
+	outdoorTemperature = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "outdoor_temperature",
+		Help: "Current outdoor temperature reading",
+	}))
+	weatherCompensationOffset = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "weather_compensation_offset",
+		Help: "Offset applied to the target temperature by weather compensation",
+	}))
+
+	humidityAutomationActive = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "humidity_automation_active",
+		Help: "Whether humidity automation has taken over the heatpump, 1 if active and 0 otherwise",
+	}))
+	humidityAutomationDecisions = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "humidity_automation_decisions_total",
+		Help: "Number of humidity automation decisions by action",
+	},
+		[]string{"action"},
+	))
+
+	openWindowActive = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "open_window_active",
+		Help: "Whether heating is suspended because of an open window, 1 if suspended and 0 otherwise",
+	}))
+	openWindowDetections = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "open_window_detections_total",
+		Help: "Number of open window detections by reason",
+	},
+		[]string{"reason"},
+	))
+
+	presenceAnyoneHome = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "presence_anyone_home",
+		Help: "Debounced presence aggregate, 1 if anyone is home and 0 otherwise",
+	}))
+	presencePersonHome = newCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "presence_person_home",
+		Help: "Presence of the person, 1 if home and 0 otherwise",
+	},
+		[]string{"person"},
+	))
+
+	busEventsDropped = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bus_events_dropped_total",
+		Help: "Number of internal events dropped because a subscriber couldn't keep up",
+	},
+		[]string{"type"},
+	))
+
+	notificationsSent = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifications_sent_total",
+		Help: "Number of notifications sent by channel and result",
+	},
+		[]string{"channel", "result"},
+	))
+	notificationsSuppressed = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notifications_suppressed_total",
+		Help: "Number of alert notifications not sent by reason",
+	},
+		[]string{"reason"},
+	))
+
+	webhookDeliveries = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_deliveries_total",
+		Help: "Number of webhook delivery attempts by status",
+	},
+		[]string{"status"},
+	))
+	webhookQueueSize = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "webhook_queue_size",
+		Help: "Number of webhook deliveries waiting to be sent",
+	}))
+
+	ruleExecutions = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rule_executions_total",
+		Help: "Number of rule executions by rule and result",
+	},
+		[]string{"rule", "result"},
+	))
+
+	stateChanges = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "state_changes_total",
+		Help: "Changes of the heatpump state fields by the source of the change",
+	},
+		[]string{"source", "field"},
+	))
+
+	irTransmissions = newCollector(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ir_transmissions_total",
+		Help: "IR signal transmissions by result",
+	},
+		[]string{"result"},
+	))
+	irTransmissionLatency = newCollector(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ir_transmission_latency_seconds",
+		Help:    "Time between IR signal transmission and the transmitter acknowledgement",
+		Buckets: []float64{.05, .1, .25, .5, .75, 1.0, 2.5, 5.0, 10.0, 30.0, math.Inf(1)},
+	}))
+
+	energyEstimated = newCollector(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "energy_estimated_kwh_total",
+		Help: "Estimated energy consumption of the heatpump in kWh",
+	}))
+	energyEstimatedCost = newCollector(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "energy_estimated_cost_total",
+		Help: "Estimated cost of the consumed energy in tariff currency",
+	}))
+	energyEstimatedPower = newCollector(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "energy_estimated_power_watts",
+		Help: "Estimated current power draw of the heatpump",
+	}))
+	energyMeasured = newCollector(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "energy_measured_kwh_total",
+		Help: "Energy consumption of the heatpump in kWh measured by the smart plug",
+	}))
+
+	buildInfo = newCollector(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "build_info",
+		Help: "Build information of the running binary, value is always 1",
+	},
+		[]string{"version", "revision", "goversion"},
+	))
 )
 
 func AddRequestHandled(routeName string, statusCode int) {
 	requestsHandled.WithLabelValues(routeName, strconv.Itoa(statusCode)).Inc()
 }
 
-func ObserveRequestDuration(duration time.Duration) {
-	requestsDuration.Observe(duration.Seconds())
+func ObserveRequestDuration(method, route string, duration time.Duration) {
+	requestsDuration.WithLabelValues(method, route).Observe(duration.Seconds())
 }
 
 func AddEventProcessed(eventName, status string) {
@@ -75,6 +231,27 @@ func ObserveEventDuration(eventName string, duration time.Duration) {
 	eventsDuration.WithLabelValues(eventName).Observe(duration.Seconds())
 }
 
+func IncEventQueueDepth(eventName string) {
+	eventsQueueDepth.WithLabelValues(eventName).Inc()
+}
+
+func DecEventQueueDepth(eventName string) {
+	eventsQueueDepth.WithLabelValues(eventName).Dec()
+}
+
+func AddEventDropped(eventName string) {
+	eventsDropped.WithLabelValues(eventName).Inc()
+}
+
+func SetHealthCheckStatus(check string, ok bool) {
+	var value float64
+	if ok {
+		value = 1
+	}
+
+	healthCheckStatus.WithLabelValues(check).Set(value)
+}
+
 func SetHeatpumpMode(mode heatpump.Mode) {
 	var modeValue float64
 	switch mode {
@@ -86,11 +263,23 @@ func SetHeatpumpMode(mode heatpump.Mode) {
 		modeValue = 2
 	case heatpump.AutoMode:
 		modeValue = 3
+	case heatpump.DryMode:
+		modeValue = 4
 	default:
 		modeValue = -1
 	}
 
 	heatpumpMode.Set(modeValue)
+
+	for _, m := range heatpump.Modes {
+		var value float64
+		if m == mode {
+			value = 1
+		}
+		heatpumpModeInfo.WithLabelValues(string(m)).Set(value)
+	}
+
+	heatpumpModeRuntime.setMode(mode)
 }
 
 func SetHeatpumpTargetTemperature(temperature int) {
@@ -108,3 +297,116 @@ func SetHeatpumpCurrentTemperature(temperature float64) {
 func SetHeatpumpCurrentHumidity(humidity float64) {
 	heatpumpCurrentHumidity.Set(humidity)
 }
+
+func SetOutdoorTemperature(temperature float64) {
+	outdoorTemperature.Set(temperature)
+}
+
+func SetWeatherCompensationOffset(offset int) {
+	weatherCompensationOffset.Set(float64(offset))
+}
+
+func SetHumidityAutomationActive(active bool) {
+	var value float64
+	if active {
+		value = 1
+	}
+	humidityAutomationActive.Set(value)
+}
+
+func AddHumidityAutomationDecision(action string) {
+	humidityAutomationDecisions.WithLabelValues(action).Inc()
+}
+
+func SetOpenWindowActive(active bool) {
+	var value float64
+	if active {
+		value = 1
+	}
+	openWindowActive.Set(value)
+}
+
+func AddOpenWindowDetection(reason string) {
+	openWindowDetections.WithLabelValues(reason).Inc()
+}
+
+func SetAnyoneHome(home bool) {
+	var value float64
+	if home {
+		value = 1
+	}
+	presenceAnyoneHome.Set(value)
+}
+
+func SetPersonHome(person string, home bool) {
+	var value float64
+	if home {
+		value = 1
+	}
+	presencePersonHome.WithLabelValues(person).Set(value)
+}
+
+func AddBusEventDropped(eventType string) {
+	busEventsDropped.WithLabelValues(eventType).Inc()
+}
+
+func AddNotificationSent(channel, result string) {
+	notificationsSent.WithLabelValues(channel, result).Inc()
+}
+
+func AddNotificationSuppressed(reason string) {
+	notificationsSuppressed.WithLabelValues(reason).Inc()
+}
+
+func AddWebhookDelivery(status string) {
+	webhookDeliveries.WithLabelValues(status).Inc()
+}
+
+func SetWebhookQueueSize(size int) {
+	webhookQueueSize.Set(float64(size))
+}
+
+func AddRuleExecution(rule, result string) {
+	ruleExecutions.WithLabelValues(rule, result).Inc()
+}
+
+func AddStateChange(source heatpump.Source, field string) {
+	stateChanges.WithLabelValues(string(source), field).Inc()
+}
+
+func AddIRTransmission(result string) {
+	irTransmissions.WithLabelValues(result).Inc()
+}
+
+func ObserveIRTransmissionLatency(latency time.Duration) {
+	irTransmissionLatency.Observe(latency.Seconds())
+}
+
+func AddEnergyEstimated(kWh, cost float64) {
+	energyEstimated.Add(kWh)
+	energyEstimatedCost.Add(cost)
+}
+
+func SetEnergyEstimatedPower(watts float64) {
+	energyEstimatedPower.Set(watts)
+}
+
+func AddEnergyMeasured(kWh float64) {
+	energyMeasured.Add(kWh)
+}
+
+func setBuildInfo() {
+	version, revision := "unknown", "unknown"
+
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		version = info.Main.Version
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+
+	buildInfo.WithLabelValues(version, revision, runtime.Version()).Set(1)
+}
@@ -17,6 +17,8 @@ func newCollector[T prometheus.Collector](collector T) T {
 func Init() error {
 	errs := []error{}
 
+	setBuildInfo()
+
 	for i, collector := range collectors {
 		err := prometheus.Register(collector)
 		if err != nil {
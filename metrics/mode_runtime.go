@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// modeRuntimeCollector accumulates time spent in each mode. Time spent in the
+// current mode is added on scrape, so the counter grows while the mode stays
+// the same.
+type modeRuntimeCollector struct {
+	desc *prometheus.Desc
+
+	mu      sync.Mutex
+	mode    heatpump.Mode
+	since   time.Time
+	seconds map[heatpump.Mode]float64
+}
+
+func newModeRuntimeCollector(name, help string) *modeRuntimeCollector {
+	var c modeRuntimeCollector
+
+	c.desc = prometheus.NewDesc(name, help, []string{"mode"}, nil)
+	c.seconds = make(map[heatpump.Mode]float64, len(heatpump.Modes))
+
+	return &c
+}
+
+func (c *modeRuntimeCollector) setMode(mode heatpump.Mode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.mode != "" {
+		c.seconds[c.mode] += now.Sub(c.since).Seconds()
+	}
+
+	c.mode = mode
+	c.since = now
+}
+
+func (c *modeRuntimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *modeRuntimeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, mode := range heatpump.Modes {
+		seconds := c.seconds[mode]
+		if mode == c.mode {
+			seconds += time.Since(c.since).Seconds()
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, seconds, string(mode))
+	}
+}
@@ -0,0 +1,145 @@
+package alert
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+// Rule notifies the channels when its condition starts or stops holding.
+type Rule struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Enabled  bool     `json:"enabled"`
+	Type     Type     `json:"type"`
+	Severity Severity `json:"severity"`
+	// Channels to notify by name, all configured channels if empty.
+	Channels []string `json:"channels"`
+
+	// Sensor with Above or Below threshold for reading alert.
+	Sensor heatpump.Sensor `json:"sensor,omitempty"`
+	Above  *float64        `json:"above,omitempty"`
+	Below  *float64        `json:"below,omitempty"`
+
+	// Check is the name of the health check for health alert.
+	Check string `json:"check,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func NewID() (string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("error generating alert id: %v", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+func (r *Rule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name must not be empty")
+	}
+
+	if r.Severity == "" {
+		r.Severity = WarningSeverity
+	}
+	if !slices.Contains(Severities, r.Severity) {
+		return fmt.Errorf("severity must be one of: %v, got: %s", Severities, r.Severity)
+	}
+
+	switch r.Type {
+	case ReadingAlert:
+		if !slices.Contains(heatpump.Sensors, r.Sensor) {
+			return fmt.Errorf("sensor must be one of: %v, got: %s", heatpump.Sensors, r.Sensor)
+		}
+		if (r.Above == nil) == (r.Below == nil) {
+			return errors.New("exactly one of above and below must be set")
+		}
+	case HealthAlert:
+		if r.Check == "" {
+			return errors.New("check must not be empty")
+		}
+	default:
+		return fmt.Errorf("alert type must be one of: [%s, %s], got: %s", ReadingAlert, HealthAlert, r.Type)
+	}
+
+	if r.Channels == nil {
+		r.Channels = []string{}
+	}
+
+	return nil
+}
+
+// Holds reports whether the reading is beyond the threshold.
+func (r *Rule) Holds(value float64) bool {
+	if r.Above != nil {
+		return value > *r.Above
+	}
+	if r.Below != nil {
+		return value < *r.Below
+	}
+	return false
+}
+
+type Type string
+
+const (
+	// ReadingAlert fires while a sensor reading is beyond the threshold.
+	ReadingAlert Type = "reading"
+	// HealthAlert fires while a health check is failing.
+	HealthAlert Type = "health"
+)
+
+type Severity string
+
+const (
+	InfoSeverity    Severity = "info"
+	WarningSeverity Severity = "warning"
+	// CriticalSeverity notifications are sent during quiet hours too.
+	CriticalSeverity Severity = "critical"
+)
+
+var Severities = []Severity{InfoSeverity, WarningSeverity, CriticalSeverity}
+
+// State is the persisted state of a rule, so that a restart doesn't notify
+// about the same alert again.
+type State struct {
+	Firing bool      `json:"firing"`
+	Since  time.Time `json:"since"`
+	// Notified is true if the firing has been announced, only those are
+	// announced resolved.
+	Notified       bool       `json:"notified"`
+	LastNotifiedAt *time.Time `json:"lastNotifiedAt,omitempty"`
+}
+
+type Notification struct {
+	RuleID   string    `json:"ruleId,omitempty"`
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	Severity Severity  `json:"severity"`
+	Resolved bool      `json:"resolved"`
+	Time     time.Time `json:"time"`
+}
+
+// Record is an entry of the notification history.
+type Record struct {
+	Notification
+	Deliveries []Delivery `json:"deliveries"`
+	// Suppressed is the reason the notification wasn't sent, if it wasn't.
+	Suppressed string `json:"suppressed,omitempty"`
+}
+
+type Delivery struct {
+	Channel string `json:"channel"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
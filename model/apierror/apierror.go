@@ -0,0 +1,26 @@
+// Package apierror is the error body shared by the HTTP API and the MQTT
+// commands, so that clients can handle both the same way.
+package apierror
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type Response struct {
+	Error      string `json:"error"`
+	StatusCode int    `json:"statusCode"`
+}
+
+// New returns the error body, server errors are hidden from the client behind
+// the status text.
+func New(err error, statusCode int) *Response {
+	if statusCode >= 500 {
+		err = fmt.Errorf("%s: %d", http.StatusText(statusCode), statusCode)
+	}
+
+	return &Response{
+		Error:      err.Error(),
+		StatusCode: statusCode,
+	}
+}
@@ -0,0 +1,122 @@
+// Package backup describes archives of all stored data, which are exported by
+// the API, written as snapshots, and imported to restore or move the data.
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Format identifies backup archives, so that other JSON files aren't imported
+// by mistake.
+const Format = "heatpump-api-backup"
+
+// Version is increased when the layout of archives changes, archives of newer
+// versions are rejected.
+const Version = 1
+
+// Archive has the stored data as is, values are the raw database values, which
+// are JSON documents for structured data like rules and plain values
+// otherwise.
+type Archive struct {
+	Format    string            `json:"format"`
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Data      map[string]string `json:"data"`
+}
+
+func NewArchive(data map[string]string, createdAt time.Time) *Archive {
+	var a Archive
+
+	a.Format = Format
+	a.Version = Version
+	a.CreatedAt = createdAt.UTC()
+	a.Data = data
+
+	return &a
+}
+
+func (a *Archive) Validate() error {
+	if a.Format != Format {
+		return fmt.Errorf("format must be %s, got: %q", Format, a.Format)
+	}
+
+	if a.Version < 1 || a.Version > Version {
+		return fmt.Errorf("version %d isn't supported, latest supported version is %d", a.Version, Version)
+	}
+
+	if a.Data == nil {
+		return errors.New("data must be set")
+	}
+
+	return nil
+}
+
+type ImportMode string
+
+const (
+	// MergeMode sets the keys of the archive and keeps the other stored keys.
+	MergeMode ImportMode = "merge"
+	// ReplaceMode makes the stored data exactly the archive data.
+	ReplaceMode ImportMode = "replace"
+)
+
+var ImportModes = []ImportMode{MergeMode, ReplaceMode}
+
+// Apply returns the data that importing the archive with the mode results in,
+// current data is left unchanged.
+func (a *Archive) Apply(current map[string]string, mode ImportMode) (map[string]string, error) {
+	var data map[string]string
+
+	switch mode {
+	case MergeMode:
+		data = maps.Clone(current)
+		maps.Copy(data, a.Data)
+	case ReplaceMode:
+		data = maps.Clone(a.Data)
+	default:
+		return nil, fmt.Errorf("mode must be one of: %v, got: %s", ImportModes, mode)
+	}
+
+	return data, nil
+}
+
+// ImportResult lists the keys that an import changes, they are only planned
+// changes for a dry run.
+type ImportResult struct {
+	Mode    ImportMode `json:"mode"`
+	DryRun  bool       `json:"dryRun"`
+	Added   []string   `json:"added"`
+	Updated []string   `json:"updated"`
+	Removed []string   `json:"removed"`
+}
+
+// Compare fills the result with the keys that differ between the data before
+// and after the import.
+func (r *ImportResult) Compare(before, after map[string]string) {
+	r.Added, r.Updated, r.Removed = []string{}, []string{}, []string{}
+
+	for key, value := range after {
+		previous, ok := before[key]
+		switch {
+		case !ok:
+			r.Added = append(r.Added, key)
+		case previous != value:
+			r.Updated = append(r.Updated, key)
+		}
+	}
+
+	for key := range before {
+		_, ok := after[key]
+		if !ok {
+			r.Removed = append(r.Removed, key)
+		}
+	}
+
+	slices.Sort(r.Added)
+	slices.Sort(r.Updated)
+	slices.Sort(r.Removed)
+}
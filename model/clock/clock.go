@@ -0,0 +1,62 @@
+package clock
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Parse parses "HH:MM" into minutes since midnight.
+func Parse(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("time must be in HH:MM format, got: %s", clock)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Window is a daily time range in minutes since midnight, it may wrap around
+// midnight. The start is inclusive and the end is exclusive.
+type Window struct {
+	From int
+	To   int
+}
+
+// ParseWindow parses "HH:MM-HH:MM", e.g. "22:00-07:00".
+func ParseWindow(window string) (Window, error) {
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window must be in HH:MM-HH:MM format, got: %s", window)
+	}
+
+	var w Window
+	var err error
+
+	w.From, err = Parse(strings.TrimSpace(from))
+	if err != nil {
+		return Window{}, fmt.Errorf("error parsing window start: %v", err)
+	}
+
+	w.To, err = Parse(strings.TrimSpace(to))
+	if err != nil {
+		return Window{}, fmt.Errorf("error parsing window end: %v", err)
+	}
+
+	return w, nil
+}
+
+func (w Window) Contains(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+
+	if w.From <= w.To {
+		return minutes >= w.From && minutes < w.To
+	}
+
+	// Window wraps around midnight
+	return minutes >= w.From || minutes < w.To
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.From/60, w.From%60, w.To/60, w.To%60)
+}
@@ -0,0 +1,35 @@
+package deadletter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Message is an event payload that permanently failed to be processed.
+type Message struct {
+	ID       string    `json:"id"`
+	Topic    string    `json:"topic"`
+	Payload  string    `json:"payload"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+func NewMessage(topic string, payload []byte, handlerErr error) (*Message, error) {
+	var m Message
+
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("error generating dead letter id: %v", err)
+	}
+
+	m.ID = hex.EncodeToString(id)
+	m.Topic = topic
+	m.Payload = string(payload)
+	m.Error = handlerErr.Error()
+	m.FailedAt = time.Now().UTC()
+
+	return &m, nil
+}
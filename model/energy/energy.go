@@ -0,0 +1,256 @@
+package energy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/clock"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+// Model estimates electrical power draw of the heatpump. Heat output is rated
+// per mode and fan level, and it's converted to electrical power with the COP
+// interpolated for the outdoor temperature.
+type Model struct {
+	// HeatOutput in watts per mode, indexed by fan level: 0 is AUTO, 1-5 are
+	// the fan speeds 20-100.
+	HeatOutput   map[heatpump.Mode][]float64 `json:"heatOutput"`
+	StandbyPower float64                     `json:"standbyPower"`
+	// COPCurve is for heating, it's also used in AUTO mode, since the heatpump
+	// doesn't report whether it's heating or cooling.
+	COPCurve []COPPoint `json:"copCurve"`
+	// CoolingCOPCurve is for COOL and DRY modes, efficiency drops as it gets
+	// hotter outside.
+	CoolingCOPCurve []COPPoint `json:"coolingCopCurve"`
+	// OutdoorTemperature is used when the actual one is unknown.
+	OutdoorTemperature float64 `json:"outdoorTemperature"`
+}
+
+type COPPoint struct {
+	OutdoorTemperature float64 `json:"outdoorTemperature"`
+	COP                float64 `json:"cop"`
+}
+
+type Tariff struct {
+	Currency string  `json:"currency"`
+	Price    float64 `json:"price"`
+	// Periods override the default price per kWh, "from" and "to" are local
+	// "HH:MM" times and may wrap around midnight.
+	Periods []TariffPeriod `json:"periods"`
+}
+
+type TariffPeriod struct {
+	From  string  `json:"from"`
+	To    string  `json:"to"`
+	Price float64 `json:"price"`
+}
+
+type Config struct {
+	Model  Model  `json:"model"`
+	Tariff Tariff `json:"tariff"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Model: Model{
+			HeatOutput: map[heatpump.Mode][]float64{
+				heatpump.HeatMode: {2500, 1200, 1700, 2200, 2800, 3300},
+				heatpump.CoolMode: {2000, 1000, 1400, 1800, 2200, 2600},
+				heatpump.AutoMode: {2200, 1100, 1500, 2000, 2500, 3000},
+				heatpump.DryMode:  {1200, 700, 900, 1100, 1300, 1500},
+			},
+			StandbyPower: 3,
+			COPCurve: []COPPoint{
+				{OutdoorTemperature: -15, COP: 1.8},
+				{OutdoorTemperature: -7, COP: 2.3},
+				{OutdoorTemperature: 2, COP: 3.0},
+				{OutdoorTemperature: 7, COP: 3.6},
+				{OutdoorTemperature: 15, COP: 4.5},
+			},
+			CoolingCOPCurve: []COPPoint{
+				{OutdoorTemperature: 20, COP: 4.8},
+				{OutdoorTemperature: 27, COP: 4.0},
+				{OutdoorTemperature: 35, COP: 3.2},
+				{OutdoorTemperature: 43, COP: 2.4},
+			},
+			OutdoorTemperature: 7,
+		},
+		Tariff: Tariff{
+			Currency: "EUR",
+			Price:    0.25,
+		},
+	}
+}
+
+// LoadConfig reads energy config from the JSON file, missing fields keep
+// their default values. Empty filename returns the default config.
+func LoadConfig(filename string) (*Config, error) {
+	c := DefaultConfig()
+
+	if filename == "" {
+		return &c, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading energy config file: %v", err)
+	}
+
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding energy config file: %v", err)
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("error validating energy config: %v", err)
+	}
+
+	return &c, nil
+}
+
+func (c *Config) Validate() error {
+	if len(c.Model.COPCurve) == 0 {
+		return errors.New("cop curve must have at least one point")
+	}
+
+	if len(c.Model.CoolingCOPCurve) == 0 {
+		return errors.New("cooling cop curve must have at least one point")
+	}
+
+	for _, point := range slices.Concat(c.Model.COPCurve, c.Model.CoolingCOPCurve) {
+		if point.COP <= 0 {
+			return fmt.Errorf("cop must be positive, got: %g", point.COP)
+		}
+	}
+
+	for mode, output := range c.Model.HeatOutput {
+		if len(output) != 6 {
+			return fmt.Errorf("heat output of mode %s must have 6 fan levels, got: %d", mode, len(output))
+		}
+	}
+
+	for _, period := range c.Tariff.Periods {
+		_, err := clock.Parse(period.From)
+		if err != nil {
+			return fmt.Errorf("error parsing tariff period start: %v", err)
+		}
+
+		_, err = clock.Parse(period.To)
+		if err != nil {
+			return fmt.Errorf("error parsing tariff period end: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// COP interpolates the curve of the mode linearly, it's clamped outside of the
+// curve.
+func (m *Model) COP(mode heatpump.Mode, outdoorTemperature float64) float64 {
+	switch mode {
+	case heatpump.CoolMode, heatpump.DryMode:
+		return interpolate(m.CoolingCOPCurve, outdoorTemperature)
+	default:
+		return interpolate(m.COPCurve, outdoorTemperature)
+	}
+}
+
+func interpolate(points []COPPoint, outdoorTemperature float64) float64 {
+	curve := make([]COPPoint, len(points))
+	copy(curve, points)
+	sort.Slice(curve, func(i, j int) bool {
+		return curve[i].OutdoorTemperature < curve[j].OutdoorTemperature
+	})
+
+	if outdoorTemperature <= curve[0].OutdoorTemperature {
+		return curve[0].COP
+	}
+
+	for i := 1; i < len(curve); i++ {
+		if outdoorTemperature <= curve[i].OutdoorTemperature {
+			low, high := curve[i-1], curve[i]
+			ratio := (outdoorTemperature - low.OutdoorTemperature) / (high.OutdoorTemperature - low.OutdoorTemperature)
+			return low.COP + ratio*(high.COP-low.COP)
+		}
+	}
+
+	return curve[len(curve)-1].COP
+}
+
+// Power returns estimated electrical power in watts.
+func (m *Model) Power(mode heatpump.Mode, fanSpeed int, outdoorTemperature float64) float64 {
+	output, ok := m.HeatOutput[mode]
+	if !ok {
+		return m.StandbyPower
+	}
+
+	fanLevel := min(max(fanSpeed/20, 0), len(output)-1)
+
+	return m.StandbyPower + output[fanLevel]/m.COP(mode, outdoorTemperature)
+}
+
+// PriceAt returns price per kWh at the given time.
+func (t *Tariff) PriceAt(at time.Time) float64 {
+	for _, period := range t.Periods {
+		from, err := clock.Parse(period.From)
+		if err != nil {
+			continue
+		}
+
+		to, err := clock.Parse(period.To)
+		if err != nil {
+			continue
+		}
+
+		if (clock.Window{From: from, To: to}).Contains(at) {
+			return period.Price
+		}
+	}
+
+	return t.Price
+}
+
+type Period string
+
+const (
+	DayPeriod   Period = "day"
+	WeekPeriod  Period = "week"
+	MonthPeriod Period = "month"
+)
+
+// Start returns the beginning of the calendar period containing now.
+func (p Period) Start(now time.Time) (time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch p {
+	case DayPeriod:
+		return today, nil
+	case WeekPeriod:
+		// Weeks start on Monday
+		daysSinceMonday := (int(today.Weekday()) + 6) % 7
+		return today.AddDate(0, 0, -daysSinceMonday), nil
+	case MonthPeriod:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()), nil
+	default:
+		return time.Time{}, fmt.Errorf("period must be one of: [%s, %s, %s], got: %s", DayPeriod, WeekPeriod, MonthPeriod, p)
+	}
+}
+
+type Report struct {
+	Period    Period    `json:"period"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	EnergyKWh float64   `json:"energyKWh"`
+	Cost      float64   `json:"cost"`
+	Currency  string    `json:"currency"`
+	// CalibrationFactor is applied to the model estimate, it's derived from
+	// smart plug readings if available.
+	CalibrationFactor float64                   `json:"calibrationFactor"`
+	Runtime           map[heatpump.Mode]float64 `json:"runtimeSeconds"`
+}
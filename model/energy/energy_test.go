@@ -0,0 +1,41 @@
+package energy
+
+import (
+	"testing"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+func TestCOPUsesCurveOfMode(t *testing.T) {
+	m := DefaultConfig().Model
+
+	tests := []struct {
+		mode               heatpump.Mode
+		outdoorTemperature float64
+		want               float64
+	}{
+		{heatpump.HeatMode, 7, 3.6},
+		{heatpump.HeatMode, -30, 1.8},
+		{heatpump.AutoMode, 2, 3.0},
+		{heatpump.CoolMode, 35, 3.2},
+		{heatpump.CoolMode, 31, 3.6},
+		{heatpump.DryMode, 50, 2.4},
+	}
+
+	for _, test := range tests {
+		got := m.COP(test.mode, test.outdoorTemperature)
+		if got != test.want {
+			t.Errorf("expected %s cop at %g°C to be %g, got: %g", test.mode, test.outdoorTemperature, test.want, got)
+		}
+	}
+}
+
+func TestValidateRequiresCoolingCurve(t *testing.T) {
+	c := DefaultConfig()
+	c.Model.CoolingCOPCurve = nil
+
+	err := c.Validate()
+	if err == nil {
+		t.Error("expected error without cooling cop curve")
+	}
+}
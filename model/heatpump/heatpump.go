@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 )
 
 type State struct {
@@ -15,16 +16,16 @@ type State struct {
 func (s *State) Validate() error {
 	if s.Mode != nil {
 		switch *s.Mode {
-		case OffMode, HeatMode, CoolMode, AutoMode:
+		case OffMode, HeatMode, CoolMode, AutoMode, DryMode:
 			// Valid
 		default:
-			return fmt.Errorf("mode must be one of: [%s, %s, %s, %s], got: %s", OffMode, HeatMode, CoolMode, AutoMode, *s.Mode)
+			return fmt.Errorf("mode must be one of: [%s, %s, %s, %s, %s], got: %s", OffMode, HeatMode, CoolMode, AutoMode, DryMode, *s.Mode)
 		}
 	}
 
 	if s.TargetTemperature != nil {
-		if *s.TargetTemperature < 16 || *s.TargetTemperature > 30 {
-			return fmt.Errorf("target temperature must be in range [17,30]. got: %d", *s.TargetTemperature)
+		if *s.TargetTemperature < MinTargetTemperature || *s.TargetTemperature > MaxTargetTemperature {
+			return fmt.Errorf("target temperature must be in range [%d,%d]. got: %d", MinTargetTemperature, MaxTargetTemperature, *s.TargetTemperature)
 		}
 	}
 
@@ -87,6 +88,8 @@ func (s *State) ToBinary() (string, error) {
 		mo = 0
 	case CoolMode:
 		mo = 1
+	case DryMode:
+		mo = 2
 	case HeatMode:
 		mo = 3
 	case OffMode:
@@ -103,6 +106,10 @@ func (s *State) ToBinary() (string, error) {
 func NewStateFromBinary(binary string) (*State, error) {
 	var s State
 
+	if len(binary) != BINARY_LENGTH {
+		return nil, fmt.Errorf("error invalid binary length, expected: %d, got: %d", BINARY_LENGTH, len(binary))
+	}
+
 	// Validate binary header
 	header := binary[:len(BINARY_HEADER)]
 	if header != BINARY_HEADER {
@@ -152,7 +159,11 @@ func NewStateFromBinary(binary string) (*State, error) {
 	targetTemperature := int(temp) + 17
 	s.TargetTemperature = &targetTemperature
 
-	fanSpeed := int(fan) * 20
+	// Inverse of the FAN encoding, 0 is AUTO and levels start from 0100
+	var fanSpeed int
+	if fan > 0 {
+		fanSpeed = int(fan-2) / 2 * 20
+	}
 	s.FanSpeed = &fanSpeed
 
 	var mode Mode
@@ -163,10 +174,12 @@ func NewStateFromBinary(binary string) (*State, error) {
 			mode = AutoMode
 		case 1:
 			mode = CoolMode
+		case 2:
+			mode = DryMode
 		case 3:
 			mode = HeatMode
 		default:
-			return nil, fmt.Errorf("mode must be one of: [0, 1, 3], got: %d", mo)
+			return nil, fmt.Errorf("mode must be one of: [0, 1, 2, 3], got: %d", mo)
 		}
 	} else {
 		mode = OffMode
@@ -176,11 +189,33 @@ func NewStateFromBinary(binary string) (*State, error) {
 	return &s, nil
 }
 
+const (
+	MinTargetTemperature = 16
+	MaxTargetTemperature = 30
+)
+
 type TemperatureReading struct {
 	Temperature float64 `json:"temperature"`
 	Humidity    float64 `json:"humidity"`
 }
 
+// Reading is an entry of the temperature and humidity history.
+type Reading struct {
+	Time time.Time `json:"time"`
+	TemperatureReading
+}
+
+// Sensor identifies a reading that automations can react to.
+type Sensor string
+
+const (
+	TemperatureSensor        Sensor = "temperature"
+	HumiditySensor           Sensor = "humidity"
+	OutdoorTemperatureSensor Sensor = "outdoorTemperature"
+)
+
+var Sensors = []Sensor{TemperatureSensor, HumiditySensor, OutdoorTemperatureSensor}
+
 type Mode string
 
 const (
@@ -188,6 +223,37 @@ const (
 	HeatMode Mode = "HEAT"
 	CoolMode Mode = "COOL"
 	AutoMode Mode = "AUTO"
+	// DryMode dehumidifies the air, it's shown as DEHUMIDIFY on the remote.
+	DryMode Mode = "DRY"
+)
+
+var Modes = []Mode{OffMode, HeatMode, CoolMode, AutoMode, DryMode}
+
+// Source is the origin of a state change.
+type Source string
+
+const (
+	HTTPSource Source = "http"
+	MQTTSource Source = "mqtt"
+	// InitialSource marks the state the service has started with.
+	InitialSource Source = "initial"
+	// AutomationSource marks changes made by the service itself.
+	AutomationSource Source = "automation"
 )
 
+// Manual reports whether the change was requested by a user.
+func (s Source) Manual() bool {
+	return s == HTTPSource || s == MQTTSource
+}
+
 const BINARY_HEADER = "1111001000001101000000111111110000000001"
+
+// BINARY_LENGTH is the number of bits in an encoded state.
+const BINARY_LENGTH = 72
+
+// StateChange is an entry of the state history.
+type StateChange struct {
+	Time   time.Time `json:"time"`
+	Source Source    `json:"source"`
+	State
+}
@@ -0,0 +1,41 @@
+package humidity
+
+import (
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+// Automation is the persisted state of the humidity automation.
+type Automation struct {
+	Active      bool       `json:"active"`
+	ActivatedAt *time.Time `json:"activatedAt,omitempty"`
+	// PreviousState is restored once humidity drops below the low threshold.
+	PreviousState *heatpump.State `json:"previousState,omitempty"`
+	// HighSince is when humidity went above the high threshold, it's reset as
+	// soon as it drops below.
+	HighSince *time.Time `json:"highSince,omitempty"`
+}
+
+type Action string
+
+const (
+	// ActivateAction switches the heatpump to dehumidify.
+	ActivateAction Action = "activate"
+	// RestoreAction switches the heatpump back to the previous state.
+	RestoreAction Action = "restore"
+	// SkipAction means activation was due, but wasn't allowed.
+	SkipAction Action = "skip"
+	// CancelAction means the automation gave control back without restoring,
+	// because the state was changed manually meanwhile.
+	CancelAction Action = "cancel"
+)
+
+// Decision is an entry of the automation decision history.
+type Decision struct {
+	Time     time.Time       `json:"time"`
+	Action   Action          `json:"action"`
+	Reason   string          `json:"reason"`
+	Humidity float64         `json:"humidity"`
+	State    *heatpump.State `json:"state,omitempty"`
+}
@@ -0,0 +1,51 @@
+package presence
+
+import "time"
+
+type Source string
+
+const (
+	MQTTSource Source = "mqtt"
+	HTTPSource Source = "http"
+)
+
+type Person struct {
+	Name      string    `json:"name"`
+	Home      bool      `json:"home"`
+	Source    Source    `json:"source"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// MotionSensor counts as someone being home for a while after the last motion.
+type MotionSensor struct {
+	Name         string    `json:"name"`
+	LastMotionAt time.Time `json:"lastMotionAt"`
+}
+
+type Status struct {
+	// AnyoneHome is the debounced aggregate of people and motion sensors.
+	AnyoneHome bool      `json:"anyoneHome"`
+	Since      time.Time `json:"since"`
+	// AwayPendingSince is when everyone has left, AnyoneHome turns false once
+	// it's been long enough.
+	AwayPendingSince *time.Time              `json:"awayPendingSince,omitempty"`
+	People           map[string]Person       `json:"people"`
+	MotionSensors    map[string]MotionSensor `json:"motionSensors"`
+}
+
+// Occupied reports whether anyone is home right now, without debounce.
+func (s *Status) Occupied(now time.Time, motionTimeout time.Duration) bool {
+	for _, person := range s.People {
+		if person.Home {
+			return true
+		}
+	}
+
+	for _, sensor := range s.MotionSensors {
+		if now.Sub(sensor.LastMotionAt) < motionTimeout {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,158 @@
+// Package recording captures MQTT traffic as JSON lines, one message per line,
+// so that it can be inspected and replayed later.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	ReceivedDirection  Direction = "received"
+	PublishedDirection Direction = "published"
+)
+
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"direction"`
+	Topic     string    `json:"topic"`
+	Payload   string    `json:"payload"`
+}
+
+// Recorder appends entries to a writer, it's safe for concurrent use.
+type Recorder struct {
+	// Clock timestamps the entries, it defaults to time.Now. Replays set it
+	// to the time of the recording.
+	Clock func() time.Time
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	var r Recorder
+
+	r.Clock = time.Now
+	r.encoder = json.NewEncoder(w)
+	r.encoder.SetEscapeHTML(false)
+
+	return &r
+}
+
+// Create opens the file for appending, creating it if needed, so that
+// restarts continue the same recording.
+func Create(filename string) (*Recorder, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recording file: %v", err)
+	}
+
+	r := NewRecorder(file)
+	r.closer = file
+
+	return r, nil
+}
+
+func (r *Recorder) Record(direction Direction, topic string, payload []byte) error {
+	return r.Write(&Entry{
+		Time:      r.Clock().UTC(),
+		Direction: direction,
+		Topic:     topic,
+		Payload:   string(payload),
+	})
+}
+
+func (r *Recorder) Write(entry *Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.encoder.Encode(entry)
+	if err != nil {
+		return fmt.Errorf("error writing recording entry: %v", err)
+	}
+
+	return nil
+}
+
+// Close closes the file opened by Create, it's a no-op for other writers.
+func (r *Recorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.closer.Close()
+	if err != nil {
+		return fmt.Errorf("error closing recording file: %v", err)
+	}
+
+	return nil
+}
+
+// maxLineSize is the longest entry Read accepts.
+const maxLineSize = 1024 * 1024
+
+// Read parses a recording, empty lines are skipped.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing line %d: %v", line, err)
+		}
+
+		if entry.Topic == "" {
+			return nil, fmt.Errorf("line %d has no topic", line)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading recording: %v", err)
+	}
+
+	return entries, nil
+}
+
+func ReadFile(filename string) ([]Entry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recording file: %v", err)
+	}
+	defer file.Close()
+
+	entries, err := Read(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("recording is empty")
+	}
+
+	return entries, nil
+}
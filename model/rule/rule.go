@@ -0,0 +1,298 @@
+package rule
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/model/clock"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+// Rule runs its actions when the trigger fires and all of the conditions hold.
+type Rule struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Enabled    bool        `json:"enabled"`
+	Trigger    Trigger     `json:"trigger"`
+	Conditions []Condition `json:"conditions"`
+	Actions    []Action    `json:"actions"`
+
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+	LastTriggeredAt *time.Time `json:"lastTriggeredAt,omitempty"`
+}
+
+func NewID() (string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("error generating rule id: %v", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Validate checks the rule, reservedTopics are the topic filters the API
+// consumes or produces itself, which publish actions must not write to.
+func (r *Rule) Validate(reservedTopics []string) error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name must not be empty")
+	}
+
+	err := r.Trigger.Validate()
+	if err != nil {
+		return fmt.Errorf("error validating trigger: %v", err)
+	}
+
+	for i, condition := range r.Conditions {
+		err := condition.Validate()
+		if err != nil {
+			return fmt.Errorf("error validating condition %d: %v", i, err)
+		}
+	}
+
+	if r.Conditions == nil {
+		r.Conditions = []Condition{}
+	}
+
+	if len(r.Actions) == 0 {
+		return errors.New("rule must have at least one action")
+	}
+
+	for i, action := range r.Actions {
+		err := action.Validate(reservedTopics)
+		if err != nil {
+			return fmt.Errorf("error validating action %d: %v", i, err)
+		}
+
+		// Rule would keep triggering itself with its own messages
+		if r.Trigger.Type == MQTTTrigger && action.Type == PublishAction && pubsub.MatchTopic(r.Trigger.Topic, action.Topic) {
+			return fmt.Errorf("action %d publishes to %s, which matches the trigger topic %s", i, action.Topic, r.Trigger.Topic)
+		}
+	}
+
+	return nil
+}
+
+type TriggerType string
+
+const (
+	// ReadingTrigger fires when a sensor reading crosses the threshold.
+	ReadingTrigger TriggerType = "reading"
+	// TimeTrigger fires once a day at the given local time.
+	TimeTrigger TriggerType = "time"
+	// MQTTTrigger fires on any message matching the topic filter.
+	MQTTTrigger TriggerType = "mqtt"
+	// StateChangeTrigger fires when the heatpump state changes.
+	StateChangeTrigger TriggerType = "stateChange"
+)
+
+type Trigger struct {
+	Type TriggerType `json:"type"`
+
+	// Sensor with Above or Below threshold for reading trigger.
+	Sensor heatpump.Sensor `json:"sensor,omitempty"`
+	Above  *float64        `json:"above,omitempty"`
+	Below  *float64        `json:"below,omitempty"`
+
+	// At is "HH:MM" for time trigger.
+	At string `json:"at,omitempty"`
+
+	// Topic filter for mqtt trigger, may contain + and # wildcards.
+	Topic string `json:"topic,omitempty"`
+
+	// Field and Mode optionally narrow down state change trigger to a change
+	// of the given field, or a change to the given mode.
+	Field string         `json:"field,omitempty"`
+	Mode  *heatpump.Mode `json:"mode,omitempty"`
+}
+
+var stateFields = []string{"mode", "targetTemperature", "fanSpeed"}
+
+func (t *Trigger) Validate() error {
+	switch t.Type {
+	case ReadingTrigger:
+		if !slices.Contains(heatpump.Sensors, t.Sensor) {
+			return fmt.Errorf("sensor must be one of: %v, got: %s", heatpump.Sensors, t.Sensor)
+		}
+		if (t.Above == nil) == (t.Below == nil) {
+			return errors.New("exactly one of above and below must be set")
+		}
+	case TimeTrigger:
+		_, err := clock.Parse(t.At)
+		if err != nil {
+			return fmt.Errorf("error parsing trigger time: %v", err)
+		}
+	case MQTTTrigger:
+		if t.Topic == "" {
+			return errors.New("topic must not be empty")
+		}
+	case StateChangeTrigger:
+		if t.Field != "" && !slices.Contains(stateFields, t.Field) {
+			return fmt.Errorf("field must be one of: %v, got: %s", stateFields, t.Field)
+		}
+		if t.Mode != nil && !slices.Contains(heatpump.Modes, *t.Mode) {
+			return fmt.Errorf("mode must be one of: %v, got: %s", heatpump.Modes, *t.Mode)
+		}
+	default:
+		return fmt.Errorf("trigger type must be one of: [%s, %s, %s, %s], got: %s", ReadingTrigger, TimeTrigger, MQTTTrigger, StateChangeTrigger, t.Type)
+	}
+
+	return nil
+}
+
+type ConditionType string
+
+const (
+	// TimeWindowCondition holds within the "HH:MM-HH:MM" window.
+	TimeWindowCondition ConditionType = "timeWindow"
+	// ModeCondition holds if the heatpump is in one of the modes.
+	ModeCondition ConditionType = "mode"
+	// AwayCondition holds if everyone's away, or if someone's home when Away
+	// is false.
+	AwayCondition ConditionType = "away"
+)
+
+type Condition struct {
+	Type ConditionType `json:"type"`
+
+	Window string          `json:"window,omitempty"`
+	Modes  []heatpump.Mode `json:"modes,omitempty"`
+	Away   *bool           `json:"away,omitempty"`
+}
+
+func (c *Condition) Validate() error {
+	switch c.Type {
+	case TimeWindowCondition:
+		_, err := clock.ParseWindow(c.Window)
+		if err != nil {
+			return fmt.Errorf("error parsing condition window: %v", err)
+		}
+	case ModeCondition:
+		if len(c.Modes) == 0 {
+			return errors.New("modes must not be empty")
+		}
+		for _, mode := range c.Modes {
+			if !slices.Contains(heatpump.Modes, mode) {
+				return fmt.Errorf("mode must be one of: %v, got: %s", heatpump.Modes, mode)
+			}
+		}
+	case AwayCondition:
+		if c.Away == nil {
+			return errors.New("away must be set")
+		}
+	default:
+		return fmt.Errorf("condition type must be one of: [%s, %s, %s], got: %s", TimeWindowCondition, ModeCondition, AwayCondition, c.Type)
+	}
+
+	return nil
+}
+
+type ActionType string
+
+const (
+	// SetStateAction applies the partial heatpump state.
+	SetStateAction ActionType = "setState"
+	// PresetAction applies a named preset.
+	PresetAction ActionType = "preset"
+	// PublishAction publishes the payload to the MQTT topic. Rules aren't
+	// triggered by messages published by rules, so they can't loop.
+	PublishAction ActionType = "publish"
+	// WebhookAction posts the triggering event to the URL.
+	WebhookAction ActionType = "webhook"
+)
+
+type Action struct {
+	Type ActionType `json:"type"`
+
+	State   *heatpump.State `json:"state,omitempty"`
+	Preset  string          `json:"preset,omitempty"`
+	Topic   string          `json:"topic,omitempty"`
+	Payload string          `json:"payload,omitempty"`
+	URL     string          `json:"url,omitempty"`
+}
+
+func (a *Action) Validate(reservedTopics []string) error {
+	switch a.Type {
+	case SetStateAction:
+		if a.State == nil {
+			return errors.New("state must be set")
+		}
+		err := a.State.Validate()
+		if err != nil {
+			return fmt.Errorf("error validating state: %v", err)
+		}
+	case PresetAction:
+		// There are no presets to refer to yet, the type is reserved for them
+		return errors.New("presets aren't supported yet")
+	case PublishAction:
+		if a.Topic == "" {
+			return errors.New("topic must not be empty")
+		}
+		if strings.ContainsAny(a.Topic, "+#") {
+			return fmt.Errorf("topic must not contain wildcards, got: %s", a.Topic)
+		}
+		for _, reserved := range reservedTopics {
+			if pubsub.MatchTopic(reserved, a.Topic) {
+				return fmt.Errorf("topic %s is used by the API itself", a.Topic)
+			}
+		}
+	case WebhookAction:
+		u, err := url.Parse(a.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("url must be a valid http(s) URL, got: %s", a.URL)
+		}
+	default:
+		return fmt.Errorf("action type must be one of: [%s, %s, %s, %s], got: %s", SetStateAction, PresetAction, PublishAction, WebhookAction, a.Type)
+	}
+
+	return nil
+}
+
+// Event is the input a rule is evaluated against.
+type Event struct {
+	Type TriggerType `json:"type"`
+	Time time.Time   `json:"time"`
+
+	Sensor        heatpump.Sensor `json:"sensor,omitempty"`
+	Value         float64         `json:"value,omitempty"`
+	PreviousValue *float64        `json:"previousValue,omitempty"`
+
+	Topic   string `json:"topic,omitempty"`
+	Payload string `json:"payload,omitempty"`
+
+	State  *heatpump.State `json:"state,omitempty"`
+	Fields []string        `json:"fields,omitempty"`
+}
+
+// Evaluation explains what a rule did, or would do, for an event.
+type Evaluation struct {
+	RuleID     string                `json:"ruleId"`
+	Triggered  bool                  `json:"triggered"`
+	Conditions []ConditionEvaluation `json:"conditions"`
+	// Matched is true if the rule was triggered and all conditions hold.
+	Matched bool     `json:"matched"`
+	Actions []Action `json:"actions"`
+	// Errors of the executed actions, empty for a dry-run.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type ConditionEvaluation struct {
+	Condition
+	Holds  bool   `json:"holds"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TestInput is a dry-run input, Mode and Away override the current values if set.
+type TestInput struct {
+	Event Event          `json:"event"`
+	Mode  *heatpump.Mode `json:"mode,omitempty"`
+	Away  *bool          `json:"away,omitempty"`
+}
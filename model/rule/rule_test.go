@@ -0,0 +1,72 @@
+package rule
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRejectsSelfTriggeringRule(t *testing.T) {
+	tests := []struct {
+		name         string
+		triggerTopic string
+		actionTopic  string
+		wantErr      bool
+	}{
+		{"same topic", "home/door", "home/door", true},
+		{"single level wildcard", "home/+", "home/door", true},
+		{"multi level wildcard", "home/#", "home/door/state", true},
+		{"different topic", "home/door", "home/window", false},
+		{"wildcard doesn't match", "home/+", "office/door", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := Rule{
+				Name:    "test",
+				Trigger: Trigger{Type: MQTTTrigger, Topic: test.triggerTopic},
+				Actions: []Action{{Type: PublishAction, Topic: test.actionTopic, Payload: "on"}},
+			}
+
+			err := r.Validate(nil)
+			if test.wantErr && (err == nil || !strings.Contains(err.Error(), "matches the trigger topic")) {
+				t.Errorf("expected self-trigger error, got: %v", err)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsReservedTopics(t *testing.T) {
+	reservedTopics := []string{"heatpump/ir-transmitter", "home/+/presence"}
+
+	tests := []struct {
+		name        string
+		actionTopic string
+		wantErr     bool
+	}{
+		{"exact topic", "heatpump/ir-transmitter", true},
+		{"wildcard filter", "home/alex/presence", true},
+		{"sub topic", "heatpump/ir-transmitter/ack", false},
+		{"other topic", "home/lights", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := Rule{
+				Name:    "test",
+				Trigger: Trigger{Type: MQTTTrigger, Topic: "home/door"},
+				Actions: []Action{{Type: PublishAction, Topic: test.actionTopic, Payload: "on"}},
+			}
+
+			err := r.Validate(reservedTopics)
+			if test.wantErr && (err == nil || !strings.Contains(err.Error(), "is used by the API itself")) {
+				t.Errorf("expected reserved topic error, got: %v", err)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
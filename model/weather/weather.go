@@ -0,0 +1,88 @@
+package weather
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type OutdoorTemperatureReading struct {
+	Temperature float64 `json:"temperature"`
+}
+
+// CompensationCurve maps outdoor temperature to the offset applied to the
+// target temperature. Offset is interpolated linearly between the points and
+// clamped outside of them.
+type CompensationCurve []CompensationPoint
+
+type CompensationPoint struct {
+	OutdoorTemperature float64 `json:"outdoorTemperature"`
+	Offset             float64 `json:"offset"`
+}
+
+// ParseCompensationCurve parses comma separated "outdoor:offset" pairs, e.g.
+// "-10:2,0:1,10:0". Empty string returns empty curve, which disables the
+// compensation.
+func ParseCompensationCurve(value string) (CompensationCurve, error) {
+	var curve CompensationCurve
+
+	if strings.TrimSpace(value) == "" {
+		return curve, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		outdoor, offset, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("curve point must be in outdoor:offset format, got: %s", pair)
+		}
+
+		var point CompensationPoint
+		var err error
+
+		point.OutdoorTemperature, err = strconv.ParseFloat(outdoor, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing outdoor temperature of curve point %q: %v", pair, err)
+		}
+
+		point.Offset, err = strconv.ParseFloat(offset, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing offset of curve point %q: %v", pair, err)
+		}
+
+		curve = append(curve, point)
+	}
+
+	sort.Slice(curve, func(i, j int) bool {
+		return curve[i].OutdoorTemperature < curve[j].OutdoorTemperature
+	})
+
+	return curve, nil
+}
+
+func (c CompensationCurve) Enabled() bool {
+	return len(c) > 0
+}
+
+// Offset returns the target temperature offset rounded to whole degrees, as
+// the heatpump only accepts whole degrees.
+func (c CompensationCurve) Offset(outdoorTemperature float64) int {
+	if len(c) == 0 {
+		return 0
+	}
+
+	if outdoorTemperature <= c[0].OutdoorTemperature {
+		return int(math.Round(c[0].Offset))
+	}
+
+	for i := 1; i < len(c); i++ {
+		if outdoorTemperature <= c[i].OutdoorTemperature {
+			low, high := c[i-1], c[i]
+			ratio := (outdoorTemperature - low.OutdoorTemperature) / (high.OutdoorTemperature - low.OutdoorTemperature)
+			return int(math.Round(low.Offset + ratio*(high.Offset-low.Offset)))
+		}
+	}
+
+	return int(math.Round(c[len(c)-1].Offset))
+}
@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+// Webhook is a subscription of an external URL to events of the given types.
+type Webhook struct {
+	ID      string `json:"id"`
+	URL     string `json:"url"`
+	Enabled bool   `json:"enabled"`
+	// Secret signs the payloads, it's only returned when the webhook is
+	// created and generated if empty.
+	Secret     string      `json:"secret,omitempty"`
+	EventTypes []EventType `json:"eventTypes"`
+	// Thresholds are checked for threshold crossed events.
+	Thresholds []Threshold `json:"thresholds"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func NewID() (string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("error generating webhook id: %v", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %v", err)
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be a valid http(s) URL, got: %s", w.URL)
+	}
+
+	if len(w.EventTypes) == 0 {
+		return errors.New("webhook must have at least one event type")
+	}
+
+	for _, eventType := range w.EventTypes {
+		if !slices.Contains(EventTypes, eventType) {
+			return fmt.Errorf("event type must be one of: %v, got: %s", EventTypes, eventType)
+		}
+	}
+
+	if slices.Contains(w.EventTypes, ThresholdCrossedEvent) && len(w.Thresholds) == 0 {
+		return fmt.Errorf("thresholds must not be empty for %s event", ThresholdCrossedEvent)
+	}
+
+	for i, threshold := range w.Thresholds {
+		err := threshold.Validate()
+		if err != nil {
+			return fmt.Errorf("error validating threshold %d: %v", i, err)
+		}
+	}
+
+	if w.Thresholds == nil {
+		w.Thresholds = []Threshold{}
+	}
+
+	return nil
+}
+
+// Subscribed reports whether the webhook should receive events of the type.
+func (w *Webhook) Subscribed(eventType EventType) bool {
+	return w.Enabled && slices.Contains(w.EventTypes, eventType)
+}
+
+type EventType string
+
+const (
+	// StateChangedEvent carries heatpump.StateChange with changed fields.
+	StateChangedEvent EventType = "stateChanged"
+	// SensorStaleEvent is sent once the temperature sensor stops reporting.
+	SensorStaleEvent EventType = "sensorStale"
+	// TransmissionFailedEvent is sent when the IR signal can't be transmitted.
+	TransmissionFailedEvent EventType = "transmissionFailed"
+	// ThresholdCrossedEvent is sent when a reading crosses one of the
+	// webhook thresholds.
+	ThresholdCrossedEvent EventType = "thresholdCrossed"
+)
+
+var EventTypes = []EventType{StateChangedEvent, SensorStaleEvent, TransmissionFailedEvent, ThresholdCrossedEvent}
+
+type Threshold struct {
+	Sensor heatpump.Sensor `json:"sensor"`
+	Above  *float64        `json:"above,omitempty"`
+	Below  *float64        `json:"below,omitempty"`
+}
+
+func (t *Threshold) Validate() error {
+	if !slices.Contains(heatpump.Sensors, t.Sensor) {
+		return fmt.Errorf("sensor must be one of: %v, got: %s", heatpump.Sensors, t.Sensor)
+	}
+
+	if (t.Above == nil) == (t.Below == nil) {
+		return errors.New("exactly one of above and below must be set")
+	}
+
+	return nil
+}
+
+// Crossed reports whether the value has crossed the threshold since the
+// previous value, which is nil for the first reading.
+func (t *Threshold) Crossed(previousValue *float64, value float64) bool {
+	if t.Above != nil {
+		return value > *t.Above && (previousValue == nil || *previousValue <= *t.Above)
+	}
+	if t.Below != nil {
+		return value < *t.Below && (previousValue == nil || *previousValue >= *t.Below)
+	}
+	return false
+}
+
+// Event is the JSON payload posted to the webhook URL.
+type Event struct {
+	ID   string    `json:"id"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+type SensorStale struct {
+	Sensor    heatpump.Sensor `json:"sensor"`
+	UpdatedAt *time.Time      `json:"updatedAt"`
+	MaxAge    string          `json:"maxAge"`
+}
+
+type TransmissionFailed struct {
+	State heatpump.State `json:"state"`
+	Error string         `json:"error"`
+}
+
+type ThresholdCrossed struct {
+	Threshold
+	Value         float64  `json:"value"`
+	PreviousValue *float64 `json:"previousValue,omitempty"`
+}
+
+// Delivery is a queued event payload for one webhook. Payload is kept as
+// encoded, so that the signature is computed over the same bytes on retries.
+type Delivery struct {
+	ID            string          `json:"id"`
+	WebhookID     string          `json:"webhookId"`
+	EventType     EventType       `json:"eventType"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+type Status string
+
+const (
+	DeliveredStatus Status = "delivered"
+	// RetryingStatus means the attempt has failed and another is scheduled.
+	RetryingStatus Status = "retrying"
+	// FailedStatus means the attempt has failed and the delivery is dropped.
+	FailedStatus Status = "failed"
+)
+
+// Attempt is an entry of the webhook delivery log.
+type Attempt struct {
+	DeliveryID string     `json:"deliveryId"`
+	EventType  EventType  `json:"eventType"`
+	Attempt    int        `json:"attempt"`
+	Time       time.Time  `json:"time"`
+	Status     Status     `json:"status"`
+	StatusCode int        `json:"statusCode,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	NextRetry  *time.Time `json:"nextRetry,omitempty"`
+}
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Sign returns the SignatureHeader value, a hex HMAC-SHA256 of
+// "<timestamp>.<payload>" prefixed with "sha256=". Receivers should compute
+// the same and compare it in constant time, and reject old timestamps.
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package window
+
+import (
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+type Reason string
+
+const (
+	// TemperatureDropReason means the window was detected from a rapid
+	// temperature drop.
+	TemperatureDropReason Reason = "temperatureDrop"
+	// ContactReason means a window contact sensor has reported it open.
+	ContactReason Reason = "contact"
+)
+
+// Suspension is the persisted state of the open-window detector.
+type Suspension struct {
+	Active      bool       `json:"active"`
+	Reason      Reason     `json:"reason,omitempty"`
+	SuspendedAt *time.Time `json:"suspendedAt,omitempty"`
+	// PreviousState is restored once the window is closed.
+	PreviousState *heatpump.State `json:"previousState,omitempty"`
+}
+
+type EventType string
+
+const (
+	DetectedEvent EventType = "detected"
+	RestoredEvent EventType = "restored"
+	// CancelledEvent means the state was changed manually during suspension,
+	// so it's not restored.
+	CancelledEvent EventType = "cancelled"
+)
+
+type Event struct {
+	Type   EventType `json:"type"`
+	Reason Reason    `json:"reason"`
+	Time   time.Time `json:"time"`
+	// Temperature is the latest indoor reading, if known.
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+// Reading is a point of the temperature series the slope is computed over.
+type Reading struct {
+	Time        time.Time
+	Temperature float64
+}
+
+// Slope returns the temperature change in degrees per minute between the
+// first and the last reading. It's not ok if the readings span less than
+// minSpan.
+func Slope(readings []Reading, minSpan time.Duration) (slope float64, ok bool) {
+	if len(readings) < 2 {
+		return 0, false
+	}
+
+	first, last := readings[0], readings[len(readings)-1]
+	span := last.Time.Sub(first.Time)
+	if span < minSpan || span <= 0 {
+		return 0, false
+	}
+
+	return (last.Temperature - first.Temperature) / span.Minutes(), true
+}
@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+// dispatcher moves event handling off the pubsub network loop onto a pool of
+// workers. Each worker owns a bounded queue, events with the same key always
+// go to the same worker, so they are handled in order.
+type dispatcher struct {
+	topic   string
+	handler event.Handler
+	key     event.KeyFunc
+	queues  []chan job
+	next    atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type job struct {
+	ctx     context.Context
+	payload []byte
+}
+
+func newDispatcher(topic string, handler event.Handler, key event.KeyFunc, workers, queueSize int) *dispatcher {
+	var d dispatcher
+
+	d.topic = topic
+	d.handler = handler
+	d.key = key
+	d.queues = make([]chan job, max(workers, 1))
+	for i := range d.queues {
+		d.queues[i] = make(chan job, queueSize)
+	}
+
+	return &d
+}
+
+func (d *dispatcher) start() {
+	for _, queue := range d.queues {
+		d.wg.Add(1)
+		go d.work(queue)
+	}
+}
+
+func (d *dispatcher) work(queue <-chan job) {
+	defer d.wg.Done()
+
+	for j := range queue {
+		metrics.DecEventQueueDepth(d.topic)
+
+		err := d.handler(j.ctx, j.payload)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error handling event %s: %v", d.topic, err))
+		}
+	}
+}
+
+// dispatch enqueues the event without blocking, the event is dropped if the
+// queue is full or the dispatcher is stopped.
+func (d *dispatcher) dispatch(ctx context.Context, payload []byte) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		metrics.AddEventDropped(d.topic)
+		return fmt.Errorf("error dispatching event %s: dispatcher is stopped", d.topic)
+	}
+
+	queue := d.queues[d.queueIndex(payload)]
+
+	// Depth is increased before the send, otherwise the worker could pick the
+	// job up and decrease it first, leaving the gauge negative for a moment
+	metrics.IncEventQueueDepth(d.topic)
+
+	select {
+	case queue <- job{ctx: context.WithoutCancel(ctx), payload: payload}:
+		return nil
+	default:
+		metrics.DecEventQueueDepth(d.topic)
+		metrics.AddEventDropped(d.topic)
+		return fmt.Errorf("error dispatching event %s: queue is full", d.topic)
+	}
+}
+
+func (d *dispatcher) queueIndex(payload []byte) int {
+	if d.key == nil {
+		return int(d.next.Add(1) % uint64(len(d.queues)))
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(d.key(payload)))
+
+	return int(hash.Sum32() % uint32(len(d.queues)))
+}
+
+// stop stops accepting new events and waits for the queued ones to be handled.
+func (d *dispatcher) stop(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, queue := range d.queues {
+			close(queue)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error draining event %s queue: %v", d.topic, ctx.Err())
+	}
+}
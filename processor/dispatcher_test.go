@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+func TestDispatcherKeepsEventsOfAKeyInOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[string][]int)
+
+	handler := func(ctx context.Context, payload []byte) error {
+		var sensor string
+		var i int
+		_, err := fmt.Sscanf(string(payload), `{"sensorId":%q,"i":%d}`, &sensor, &i)
+		if err != nil {
+			return err
+		}
+
+		// Give the other workers the chance to overtake
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		handled[sensor] = append(handled[sensor], i)
+		mu.Unlock()
+		return nil
+	}
+
+	d := newDispatcher("test", handler, event.JSONKey("sensorId"), 4, 100)
+	d.start()
+
+	sensors := []string{"kitchen", "bedroom", "office", "hall"}
+	for i := range 20 {
+		for _, sensor := range sensors {
+			err := d.dispatch(context.Background(), fmt.Appendf(nil, `{"sensorId":%q,"i":%d}`, sensor, i))
+			if err != nil {
+				t.Fatalf("error dispatching event: %v", err)
+			}
+		}
+	}
+
+	workers := make(map[int]bool)
+	for _, sensor := range sensors {
+		workers[d.queueIndex(fmt.Appendf(nil, `{"sensorId":%q}`, sensor))] = true
+	}
+	if len(workers) < 2 {
+		t.Errorf("expected sensors to be spread between workers, got: %d worker", len(workers))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := d.stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sensor := range sensors {
+		if len(handled[sensor]) != 20 {
+			t.Fatalf("expected 20 events of %s to be handled, got: %d", sensor, len(handled[sensor]))
+		}
+		for i, got := range handled[sensor] {
+			if got != i {
+				t.Fatalf("expected events of %s in order, got: %v", sensor, handled[sensor])
+			}
+		}
+	}
+}
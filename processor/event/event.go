@@ -1,13 +1,62 @@
 package event
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
 
 type Event struct {
 	Topic       string
 	Handler     Handler
 	Middlewares []Middleware
+	// Key defines ordering of the events, if nil events are distributed
+	// between workers without any ordering guarantees.
+	Key KeyFunc
 }
 
 type Handler = func(ctx context.Context, payload []byte) error
 
 type Middleware func(topic string, next Handler) Handler
+
+// ErrPermanent marks handler errors that won't be fixed by retrying, like
+// malformed payloads.
+type ErrPermanent struct {
+	Err error
+}
+
+func (e *ErrPermanent) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ErrPermanent) Unwrap() error {
+	return e.Err
+}
+
+// KeyFunc extracts the ordering key from the payload. Events with the same key
+// are handled one at a time in the order they were received.
+type KeyFunc func(payload []byte) string
+
+// OrderedKey puts all events of the topic in a single ordered queue.
+func OrderedKey(payload []byte) string {
+	return ""
+}
+
+// JSONKey uses the value of the top level JSON field as the key. Payloads
+// without the field share a single ordered queue.
+func JSONKey(field string) KeyFunc {
+	return func(payload []byte) string {
+		var fields map[string]any
+		err := json.Unmarshal(payload, &fields)
+		if err != nil {
+			return ""
+		}
+
+		value, ok := fields[field]
+		if !ok || value == nil {
+			return ""
+		}
+
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,27 @@
+package event
+
+import "testing"
+
+func TestJSONKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"string field", `{"sensorId":"bedroom","temperature":21}`, "bedroom"},
+		{"number field", `{"sensorId":2,"temperature":21}`, "2"},
+		{"missing field", `{"temperature":21}`, ""},
+		{"null field", `{"sensorId":null}`, ""},
+		{"invalid json", `{"sensorId":`, ""},
+	}
+
+	key := JSONKey("sensorId")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := key([]byte(test.payload))
+			if got != test.want {
+				t.Errorf("expected key %q, got: %q", test.want, got)
+			}
+		})
+	}
+}
@@ -6,11 +6,84 @@ import (
 	"github.com/alexchebotarsky/heatpump-api/processor/middleware"
 )
 
+// TemperatureSensorTopic is where the indoor sensor publishes its readings.
+const TemperatureSensorTopic = "heatpump/temperature-sensor"
+
 func (p *Processor) setupEvents() {
-	p.use(middleware.Metrics)
+	p.use(
+		middleware.Recover,
+		middleware.Metrics,
+		middleware.Retry(p.Config.RetryAttempts, p.Config.RetryBackoff),
+		middleware.DeadLetter(p.Clients.Database, p.Clients.PubSub),
+		middleware.Tracing,
+	)
+
+	if p.Clients.Recorder != nil {
+		p.use(middleware.Recorder(p.Clients.Recorder))
+	}
+
+	p.handle(event.Event{
+		Topic:   TemperatureSensorTopic,
+		Handler: handler.TemperatureSensor(p.Clients.Database, p.Clients.PubSub, p.Clients.Events),
+		// Readings of each sensor are handled in order, so that an older one
+		// never overwrites a newer one
+		Key: event.JSONKey("sensorId"),
+	})
+
+	p.handle(event.Event{
+		Topic:   p.Config.StateSetTopic,
+		Handler: handler.SetHeatpumpState(p.Clients.Heatpump),
+		Key:     event.OrderedKey,
+	})
 
 	p.handle(event.Event{
-		Topic:   "heatpump/temperature-sensor",
-		Handler: handler.TemperatureSensor(p.Clients.Database),
+		Topic:   p.Config.CommandTopic,
+		Handler: handler.Command(p.Clients.Heatpump, p.Clients.PubSub),
+		Key:     event.OrderedKey,
 	})
+
+	p.handle(event.Event{
+		Topic:   p.Config.IRTransmitterAckTopic,
+		Handler: handler.IRTransmitterAck(p.Clients.Database),
+	})
+
+	if p.Config.SmartPlugTopic != "" {
+		p.handle(event.Event{
+			Topic:   p.Config.SmartPlugTopic,
+			Handler: handler.SmartPlug(p.Clients.Database),
+			Key:     event.OrderedKey,
+		})
+	}
+
+	if p.Config.WindowContactTopic != "" {
+		p.handle(event.Event{
+			Topic:   p.Config.WindowContactTopic,
+			Handler: handler.WindowContact(p.Clients.Events),
+			Key:     event.OrderedKey,
+		})
+	}
+
+	for name, topic := range p.Config.PresenceTopics {
+		p.handle(event.Event{
+			Topic:   topic,
+			Handler: handler.PersonPresence(p.Clients.Presence, name),
+			Key:     event.OrderedKey,
+		})
+	}
+
+	for name, topic := range p.Config.MotionTopics {
+		p.handle(event.Event{
+			Topic:   topic,
+			Handler: handler.Motion(p.Clients.Presence, name),
+			Key:     event.OrderedKey,
+		})
+	}
+
+	if p.Config.OutdoorTemperatureTopic != "" {
+		p.handle(event.Event{
+			Topic:   p.Config.OutdoorTemperatureTopic,
+			Handler: handler.OutdoorTemperature(p.Clients.Heatpump),
+			Key:     event.OrderedKey,
+		})
+	}
 }
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/model/apierror"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+	"github.com/alexchebotarsky/heatpump-api/service"
+)
+
+type CommandRequest struct {
+	Command CommandName     `json:"command"`
+	State   *heatpump.State `json:"state,omitempty"`
+}
+
+type CommandName string
+
+const (
+	GetStateCommand CommandName = "getState"
+	SetStateCommand CommandName = "setState"
+)
+
+type HeatpumpService interface {
+	FetchHeatpumpState(ctx context.Context) (*heatpump.State, error)
+	HeatpumpStateUpdater
+	OutdoorTemperatureUpdater
+}
+
+type Responder interface {
+	PublishResponse(ctx context.Context, payload []byte) error
+}
+
+// Command handles MQTT 5 request/response commands. The reply is published to
+// the ResponseTopic of the request with the same CorrelationData.
+func Command(heatpumpService HeatpumpService, responder Responder) event.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		response, statusCode, err := handleCommand(ctx, heatpumpService, payload)
+		if err != nil {
+			if statusCode >= 500 {
+				slog.Error(fmt.Sprintf("Command error: %v", err), "status", statusCode)
+			}
+			response = apierror.New(err, statusCode)
+		}
+
+		responsePayload, err := json.Marshal(response)
+		if err != nil {
+			return fmt.Errorf("error marshalling command response: %v", err)
+		}
+
+		err = responder.PublishResponse(ctx, responsePayload)
+		if err != nil {
+			return fmt.Errorf("error publishing command response: %v", err)
+		}
+
+		return nil
+	}
+}
+
+func handleCommand(ctx context.Context, heatpumpService HeatpumpService, payload []byte) (any, int, error) {
+	var req CommandRequest
+	err := json.Unmarshal(payload, &req)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("error decoding command request: %v", err)
+	}
+
+	switch req.Command {
+	case GetStateCommand:
+		state, err := heatpumpService.FetchHeatpumpState(ctx)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("error fetching state: %v", err)
+		}
+		return state, http.StatusOK, nil
+	case SetStateCommand:
+		if req.State == nil {
+			return nil, http.StatusBadRequest, errors.New("state is required for setState command")
+		}
+
+		updatedState, err := heatpumpService.UpdateHeatpumpState(ctx, heatpump.MQTTSource, req.State)
+		if err != nil {
+			var errInvalid *service.ErrInvalid
+			if errors.As(err, &errInvalid) {
+				return nil, http.StatusBadRequest, err
+			}
+			return nil, http.StatusInternalServerError, fmt.Errorf("error updating heatpump state: %v", err)
+		}
+		return updatedState, http.StatusOK, nil
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("command must be one of: [%s, %s], got: %s", GetStateCommand, SetStateCommand, req.Command)
+	}
+}
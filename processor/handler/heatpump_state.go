@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+	"github.com/alexchebotarsky/heatpump-api/service"
+)
+
+type HeatpumpStateUpdater interface {
+	UpdateHeatpumpState(ctx context.Context, source heatpump.Source, state *heatpump.State) (*heatpump.State, error)
+}
+
+func SetHeatpumpState(updater HeatpumpStateUpdater) event.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		var state heatpump.State
+		err := json.Unmarshal(payload, &state)
+		if err != nil {
+			return &event.ErrPermanent{Err: fmt.Errorf("error unmarshalling heatpump state: %v", err)}
+		}
+
+		_, err = updater.UpdateHeatpumpState(ctx, heatpump.MQTTSource, &state)
+		if err != nil {
+			var errInvalid *service.ErrInvalid
+			if errors.As(err, &errInvalid) {
+				return &event.ErrPermanent{Err: err}
+			}
+			return fmt.Errorf("error updating heatpump state: %v", err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+type IRTransmitterAckUpdater interface {
+	UpdateIRTransmitterAckedAt(ctx context.Context, ackedAt time.Time) error
+}
+
+// IRTransmitterAck records acknowledgements sent by the transmitter after it
+// has emitted the IR signal. The payload is not inspected.
+func IRTransmitterAck(updater IRTransmitterAckUpdater) event.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		err := updater.UpdateIRTransmitterAckedAt(ctx, time.Now())
+		if err != nil {
+			return fmt.Errorf("error updating transmitter ack time: %v", err)
+		}
+
+		return nil
+	}
+}
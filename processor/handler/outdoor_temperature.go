@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/model/weather"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+type OutdoorTemperatureUpdater interface {
+	UpdateOutdoorTemperature(ctx context.Context, temperature float64) error
+}
+
+func OutdoorTemperature(updater OutdoorTemperatureUpdater) event.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		var reading weather.OutdoorTemperatureReading
+		err := json.Unmarshal(payload, &reading)
+		if err != nil {
+			return &event.ErrPermanent{Err: fmt.Errorf("error unmarshalling outdoor temperature reading: %v", err)}
+		}
+
+		err = updater.UpdateOutdoorTemperature(ctx, reading.Temperature)
+		if err != nil {
+			return fmt.Errorf("error updating outdoor temperature: %v", err)
+		}
+
+		return nil
+	}
+}
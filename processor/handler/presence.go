@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/alexchebotarsky/heatpump-api/model/presence"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+type PresenceUpdater interface {
+	UpdatePersonPresence(ctx context.Context, name string, home bool, source presence.Source) error
+	UpdateMotion(ctx context.Context, sensor string, motion bool) error
+}
+
+// PersonPresenceReading accepts {"home": bool}, or {"state": "home"} as
+// reported by router and device trackers.
+type PersonPresenceReading struct {
+	Home  *bool  `json:"home"`
+	State string `json:"state"`
+}
+
+func PersonPresence(updater PresenceUpdater, name string) event.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		home, err := parsePersonPresence(payload)
+		if err != nil {
+			return &event.ErrPermanent{Err: fmt.Errorf("error parsing presence of %s: %v", name, err)}
+		}
+
+		err = updater.UpdatePersonPresence(ctx, name, home, presence.MQTTSource)
+		if err != nil {
+			return fmt.Errorf("error updating presence of %s: %v", name, err)
+		}
+
+		return nil
+	}
+}
+
+func parsePersonPresence(payload []byte) (bool, error) {
+	var reading PersonPresenceReading
+	err := json.Unmarshal(payload, &reading)
+	if err != nil {
+		// Plain string payloads are common for device trackers
+		reading.State = strings.Trim(strings.TrimSpace(string(payload)), `"`)
+	}
+
+	if reading.Home != nil {
+		return *reading.Home, nil
+	}
+
+	switch strings.ToLower(reading.State) {
+	case "home", "true", "on":
+		return true, nil
+	case "not_home", "away", "false", "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown presence state: %q", reading.State)
+	}
+}
+
+// MotionReading accepts {"occupancy": bool} as reported by Zigbee2MQTT, or
+// {"motion": bool}.
+type MotionReading struct {
+	Occupancy *bool `json:"occupancy"`
+	Motion    *bool `json:"motion"`
+}
+
+func Motion(updater PresenceUpdater, sensor string) event.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		var reading MotionReading
+		err := json.Unmarshal(payload, &reading)
+		if err != nil {
+			return &event.ErrPermanent{Err: fmt.Errorf("error unmarshalling motion reading: %v", err)}
+		}
+
+		var motion bool
+		switch {
+		case reading.Occupancy != nil:
+			motion = *reading.Occupancy
+		case reading.Motion != nil:
+			motion = *reading.Motion
+		default:
+			// Motion sensors report battery and such on the same topic
+			return nil
+		}
+
+		err = updater.UpdateMotion(ctx, sensor, motion)
+		if err != nil {
+			return fmt.Errorf("error updating motion of %s: %v", sensor, err)
+		}
+
+		return nil
+	}
+}
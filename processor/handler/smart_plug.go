@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+type SmartPlugReading struct {
+	// Power is the current draw in watts.
+	Power float64 `json:"power"`
+}
+
+type MeasuredPowerUpdater interface {
+	UpdateMeasuredPower(ctx context.Context, power float64, measuredAt time.Time) error
+}
+
+func SmartPlug(updater MeasuredPowerUpdater) event.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		var reading SmartPlugReading
+		err := json.Unmarshal(payload, &reading)
+		if err != nil {
+			return &event.ErrPermanent{Err: fmt.Errorf("error unmarshalling smart plug reading: %v", err)}
+		}
+
+		err = updater.UpdateMeasuredPower(ctx, reading.Power, time.Now())
+		if err != nil {
+			return fmt.Errorf("error updating measured power: %v", err)
+		}
+
+		return nil
+	}
+}
@@ -4,28 +4,55 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/alexchebotarsky/heatpump-api/bus"
 	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
 	"github.com/alexchebotarsky/heatpump-api/processor/event"
 )
 
 type TemperatureAndHumidityUpdater interface {
-	UpdateTemperatureAndHumidity(temperature float64, humidity float64) error
+	UpdateTemperatureAndHumidity(ctx context.Context, temperature float64, humidity float64) error
 }
 
-func TemperatureSensor(updater TemperatureAndHumidityUpdater) event.Handler {
+type TemperatureAndHumidityPublisher interface {
+	PublishTemperatureAndHumidity(ctx context.Context, reading *heatpump.TemperatureReading) error
+}
+
+type EventPublisher interface {
+	Publish(event bus.Event)
+}
+
+func TemperatureSensor(updater TemperatureAndHumidityUpdater, publisher TemperatureAndHumidityPublisher, events EventPublisher) event.Handler {
 	return func(ctx context.Context, payload []byte) error {
 		var reading heatpump.TemperatureReading
 		err := json.Unmarshal(payload, &reading)
 		if err != nil {
-			return fmt.Errorf("error unmarshalling temperature reading: %v", err)
+			return &event.ErrPermanent{Err: fmt.Errorf("error unmarshalling temperature reading: %v", err)}
 		}
 
-		err = updater.UpdateTemperatureAndHumidity(reading.Temperature, reading.Humidity)
+		err = updater.UpdateTemperatureAndHumidity(ctx, reading.Temperature, reading.Humidity)
 		if err != nil {
 			return fmt.Errorf("error updating temperature and humidity: %v", err)
 		}
 
+		err = publisher.PublishTemperatureAndHumidity(ctx, &reading)
+		if err != nil {
+			return fmt.Errorf("error publishing temperature and humidity: %v", err)
+		}
+
+		now := time.Now()
+		events.Publish(bus.Event{
+			Type: bus.ReadingEvent,
+			Time: now,
+			Data: bus.Reading{Sensor: heatpump.TemperatureSensor, Value: reading.Temperature},
+		})
+		events.Publish(bus.Event{
+			Type: bus.ReadingEvent,
+			Time: now,
+			Data: bus.Reading{Sensor: heatpump.HumiditySensor, Value: reading.Humidity},
+		})
+
 		return nil
 	}
 }
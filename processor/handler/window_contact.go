@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+// WindowContactReading accepts either "open", or "contact" as reported by
+// Zigbee2MQTT door/window sensors, where contact true means closed.
+type WindowContactReading struct {
+	Open    *bool `json:"open"`
+	Contact *bool `json:"contact"`
+}
+
+func WindowContact(events EventPublisher) event.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		var reading WindowContactReading
+		err := json.Unmarshal(payload, &reading)
+		if err != nil {
+			return &event.ErrPermanent{Err: fmt.Errorf("error unmarshalling window contact reading: %v", err)}
+		}
+
+		var open bool
+		switch {
+		case reading.Open != nil:
+			open = *reading.Open
+		case reading.Contact != nil:
+			open = !*reading.Contact
+		default:
+			return &event.ErrPermanent{Err: errors.New("window contact reading must have either open or contact")}
+		}
+
+		events.Publish(bus.Event{
+			Type: bus.WindowContactEvent,
+			Data: bus.WindowContact{Open: open},
+		})
+
+		return nil
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/alexchebotarsky/heatpump-api/model/deadletter"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+type DeadLetterAdder interface {
+	AddDeadLetter(ctx context.Context, message *deadletter.Message) error
+}
+
+type DeadLetterPublisher interface {
+	PublishDeadLetter(ctx context.Context, message *deadletter.Message) error
+}
+
+// DeadLetter stores and forwards payloads that failed to be handled to the
+// dead-letter topic, so that they can be inspected and replayed later.
+func DeadLetter(adder DeadLetterAdder, publisher DeadLetterPublisher) event.Middleware {
+	return func(eventName string, next event.Handler) event.Handler {
+		return func(ctx context.Context, payload []byte) error {
+			handlerErr := next(ctx, payload)
+			if handlerErr == nil {
+				return nil
+			}
+
+			message, err := deadletter.NewMessage(eventName, payload, handlerErr)
+			if err != nil {
+				return fmt.Errorf("error creating dead letter: %v, handler error: %v", err, handlerErr)
+			}
+
+			err = adder.AddDeadLetter(ctx, message)
+			if err != nil {
+				return fmt.Errorf("error adding dead letter: %v, handler error: %v", err, handlerErr)
+			}
+
+			err = publisher.PublishDeadLetter(ctx, message)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error publishing dead letter %s: %v", message.ID, err))
+			}
+
+			slog.Error(fmt.Sprintf("Event %s is dead-lettered: %v", eventName, handlerErr), "id", message.ID)
+
+			return nil
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/alexchebotarsky/heatpump-api/model/recording"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+type MessageRecorder interface {
+	Record(direction recording.Direction, topic string, payload []byte) error
+}
+
+// Recorder records received payloads before handling them. It should be the
+// outermost middleware, so that retries aren't recorded as separate messages.
+func Recorder(recorder MessageRecorder) event.Middleware {
+	return func(eventName string, next event.Handler) event.Handler {
+		return func(ctx context.Context, payload []byte) error {
+			err := recorder.Record(recording.ReceivedDirection, eventName, payload)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error recording event %s: %v", eventName, err))
+			}
+
+			return next(ctx, payload)
+		}
+	}
+}
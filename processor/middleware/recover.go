@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+func Recover(eventName string, next event.Handler) event.Handler {
+	return func(ctx context.Context, payload []byte) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic while handling event %s: %v\n%s", eventName, r, debug.Stack())
+			}
+		}()
+
+		return next(ctx, payload)
+	}
+}
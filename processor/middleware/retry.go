@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+// Retry calls the handler up to attempts times, doubling the backoff between
+// the attempts. Permanent errors are returned right away.
+func Retry(attempts int, backoff time.Duration) event.Middleware {
+	return func(eventName string, next event.Handler) event.Handler {
+		return func(ctx context.Context, payload []byte) error {
+			var err error
+			delay := backoff
+
+			for attempt := 1; attempt <= attempts; attempt++ {
+				err = next(ctx, payload)
+				if err == nil {
+					return nil
+				}
+
+				var errPermanent *event.ErrPermanent
+				if errors.As(err, &errPermanent) || attempt == attempts {
+					break
+				}
+
+				slog.Warn(fmt.Sprintf("Error handling event %s, retrying in %s: %v", eventName, delay, err), "attempt", attempt)
+
+				select {
+				case <-ctx.Done():
+					return fmt.Errorf("error handling event, retries cancelled: %v", err)
+				case <-time.After(delay):
+				}
+				delay *= 2
+			}
+
+			return err
+		}
+	}
+}
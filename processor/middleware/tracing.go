@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+func Tracing(eventName string, next event.Handler) event.Handler {
+	return func(ctx context.Context, payload []byte) (err error) {
+		ctx, span := tracing.Start(ctx, fmt.Sprintf("event %s", eventName))
+		defer func() { tracing.End(span, err) }()
+
+		return next(ctx, payload)
+	}
+}
@@ -2,36 +2,83 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
 	"github.com/alexchebotarsky/heatpump-api/processor/event"
 	"github.com/alexchebotarsky/heatpump-api/processor/handler"
+	"github.com/alexchebotarsky/heatpump-api/processor/middleware"
 )
 
 type Processor struct {
 	Events      []event.Event
 	Middlewares []event.Middleware
+	Config      Config
 	Clients     Clients
+
+	mu          sync.Mutex
+	dispatchers []*dispatcher
+	ready       chan struct{}
+	readyOnce   sync.Once
+}
+
+type Config struct {
+	StateSetTopic         string
+	CommandTopic          string
+	IRTransmitterAckTopic string
+	// SmartPlugTopic is optional, it's not subscribed to if empty.
+	SmartPlugTopic string
+	// OutdoorTemperatureTopic is optional, it's not subscribed to if empty.
+	OutdoorTemperatureTopic string
+	// WindowContactTopic is optional, it's not subscribed to if empty.
+	WindowContactTopic string
+	// PresenceTopics map person names to their presence topics.
+	PresenceTopics map[string]string
+	// MotionTopics map motion sensor names to their topics.
+	MotionTopics map[string]string
+
+	RetryAttempts int
+	RetryBackoff  time.Duration
+
+	Workers   int
+	QueueSize int
 }
 
 type Clients struct {
 	PubSub   PubSubClient
 	Database Database
+	Heatpump handler.HeatpumpService
+	Events   handler.EventPublisher
+	Presence handler.PresenceUpdater
+	// Recorder is optional, received messages are recorded if it's set.
+	Recorder middleware.MessageRecorder
 }
 
 type PubSubClient interface {
 	Subscribe(ctx context.Context, topic string, handler event.Handler) error
+	PublishHeatpumpState(ctx context.Context, state *heatpump.State) error
+	handler.TemperatureAndHumidityPublisher
+	handler.Responder
+	middleware.DeadLetterPublisher
 }
 
 type Database interface {
 	handler.TemperatureAndHumidityUpdater
+	middleware.DeadLetterAdder
+	handler.IRTransmitterAckUpdater
+	handler.MeasuredPowerUpdater
 }
 
-func New(clients Clients) *Processor {
+func New(config Config, clients Clients) *Processor {
 	var p Processor
 
+	p.Config = config
 	p.Clients = clients
+	p.ready = make(chan struct{})
 
 	p.setupEvents()
 
@@ -39,18 +86,23 @@ func New(clients Clients) *Processor {
 }
 
 func (p *Processor) Start(ctx context.Context, errc chan<- error) {
-	for _, e := range p.Events {
-		// Gather global processor middlewares and event specific middlewares
-		middlewares := make([]event.Middleware, 0, len(p.Middlewares)+len(e.Middlewares))
-		middlewares = append(middlewares, p.Middlewares...)
-		middlewares = append(middlewares, e.Middlewares...)
+	// Dispatchers of the previous run are stopped by Stop before restarting
+	p.mu.Lock()
+	p.dispatchers = nil
+	p.mu.Unlock()
 
+	for _, e := range p.Events {
 		// Apply relevant middlewares before listening to the event
-		for _, middleware := range middlewares {
-			e.Handler = middleware(e.Topic, e.Handler)
-		}
+		e.Handler = p.wrap(e)
+
+		// Handle events on workers instead of blocking pubsub network loop
+		d := newDispatcher(e.Topic, e.Handler, e.Key, p.Config.Workers, p.Config.QueueSize)
+		d.start()
+		p.mu.Lock()
+		p.dispatchers = append(p.dispatchers, d)
+		p.mu.Unlock()
 
-		err := p.Clients.PubSub.Subscribe(ctx, e.Topic, e.Handler)
+		err := p.Clients.PubSub.Subscribe(ctx, e.Topic, d.dispatch)
 		if err != nil {
 			errc <- fmt.Errorf("error subscribing to topic %s: %v", e.Topic, err)
 			return
@@ -58,9 +110,45 @@ func (p *Processor) Start(ctx context.Context, errc chan<- error) {
 	}
 
 	slog.Info(fmt.Sprintf("PubSub event processor listening to %d events", len(p.Events)))
+	p.readyOnce.Do(func() { close(p.ready) })
+
+	// Publish current state, so that retained message is available to other consumers right away
+	state, err := p.Clients.Heatpump.FetchHeatpumpState(ctx)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error fetching initial heatpump state: %v", err))
+		return
+	}
+
+	err = p.Clients.PubSub.PublishHeatpumpState(ctx, state)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error publishing initial heatpump state: %v", err))
+	}
+}
+
+// Ready is closed once all subscriptions are in place.
+func (p *Processor) Ready() <-chan struct{} {
+	return p.ready
 }
 
 func (p *Processor) Stop(ctx context.Context) error {
+	// Dispatchers are drained without holding the lock, draining can take a while
+	p.mu.Lock()
+	dispatchers := p.dispatchers
+	p.mu.Unlock()
+
+	var errs []error
+
+	for _, d := range dispatchers {
+		err := d.stop(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error draining events: %v", errors.Join(errs...))
+	}
+
 	return nil
 }
 
@@ -71,3 +159,18 @@ func (p *Processor) handle(e event.Event) {
 func (p *Processor) use(middlewares ...event.Middleware) {
 	p.Middlewares = append(p.Middlewares, middlewares...)
 }
+
+// wrap applies the global processor middlewares and the event specific
+// middlewares to the event handler, the first middleware is the innermost one.
+func (p *Processor) wrap(e event.Event) event.Handler {
+	middlewares := make([]event.Middleware, 0, len(p.Middlewares)+len(e.Middlewares))
+	middlewares = append(middlewares, p.Middlewares...)
+	middlewares = append(middlewares, e.Middlewares...)
+
+	handler := e.Handler
+	for _, middleware := range middlewares {
+		handler = middleware(e.Topic, handler)
+	}
+
+	return handler
+}
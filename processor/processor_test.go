@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/deadletter"
+	"github.com/alexchebotarsky/heatpump-api/processor/event"
+)
+
+func TestMiddlewaresRetryBeforeDeadLettering(t *testing.T) {
+	errTransient := errors.New("broker unavailable")
+
+	tests := []struct {
+		name            string
+		results         []error
+		panicFirst      bool
+		wantCalls       int
+		wantDeadLetters int
+	}{
+		{"succeeds", []error{nil}, false, 1, 0},
+		{"succeeds after retries", []error{errTransient, errTransient, nil}, false, 3, 0},
+		{"fails every attempt", []error{errTransient, errTransient, errTransient}, false, 3, 1},
+		{"permanent error isn't retried", []error{&event.ErrPermanent{Err: errTransient}}, false, 1, 1},
+		{"panic is retried", []error{nil, nil}, true, 2, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deadLetters := &fakeDeadLetters{}
+			p := New(Config{RetryAttempts: 3, RetryBackoff: time.Millisecond}, Clients{
+				PubSub:   &fakePubSub{deadLetters: deadLetters},
+				Database: &fakeDatabase{deadLetters: deadLetters},
+			})
+
+			var calls int
+			handler := p.wrap(event.Event{
+				Topic: "test",
+				Handler: func(ctx context.Context, payload []byte) error {
+					calls++
+					if test.panicFirst && calls == 1 {
+						panic("malformed state")
+					}
+					return test.results[calls-1]
+				},
+			})
+
+			// Dead-lettered events are handled as far as the caller is concerned
+			err := handler(context.Background(), []byte("{}"))
+			if err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("expected handler to be called %d times, got: %d", test.wantCalls, calls)
+			}
+			if len(deadLetters.added) != test.wantDeadLetters || len(deadLetters.published) != test.wantDeadLetters {
+				t.Errorf("expected %d dead letters to be added and published, got: %d and %d", test.wantDeadLetters, len(deadLetters.added), len(deadLetters.published))
+			}
+		})
+	}
+}
+
+type fakeDeadLetters struct {
+	mu        sync.Mutex
+	added     []*deadletter.Message
+	published []*deadletter.Message
+}
+
+// fakePubSub and fakeDatabase only implement dead letters, other methods
+// panic, as they aren't called by the middlewares.
+type fakePubSub struct {
+	PubSubClient
+	deadLetters *fakeDeadLetters
+}
+
+func (f *fakePubSub) PublishDeadLetter(ctx context.Context, message *deadletter.Message) error {
+	f.deadLetters.mu.Lock()
+	defer f.deadLetters.mu.Unlock()
+
+	f.deadLetters.published = append(f.deadLetters.published, message)
+	return nil
+}
+
+type fakeDatabase struct {
+	Database
+	deadLetters *fakeDeadLetters
+}
+
+func (f *fakeDatabase) AddDeadLetter(ctx context.Context, message *deadletter.Message) error {
+	f.deadLetters.mu.Lock()
+	defer f.deadLetters.mu.Unlock()
+
+	f.deadLetters.added = append(f.deadLetters.added, message)
+	return nil
+}
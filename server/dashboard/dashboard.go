@@ -0,0 +1,29 @@
+// Package dashboard serves the web UI. It's a static single page that only
+// talks to the public API, so all assets are embedded and nothing is loaded
+// from a CDN.
+package dashboard
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the embedded assets, index.html at the root.
+func Handler() http.Handler {
+	fileServer := http.FileServerFS(static)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Assets are small, revalidating them keeps the UI in sync with the API
+		w.Header().Set("Cache-Control", "no-cache")
+
+		u := *r.URL
+		u.Path = "/static" + r.URL.Path
+		req := *r
+		req.URL = &u
+
+		fileServer.ServeHTTP(w, &req)
+	})
+}
@@ -0,0 +1,347 @@
+"use strict";
+
+// API paths are relative, so that the dashboard works behind a reverse proxy
+// mounting the service under a prefix.
+const API = "api/v1";
+const TOKEN_KEY = "heatpump-api-token";
+const MIN_TARGET = 16;
+const MAX_TARGET = 30;
+
+const state = {
+  heatpump: null,
+  readings: [],
+  hours: 24,
+};
+
+const $ = (id) => document.getElementById(id);
+
+class AuthError extends Error {}
+
+// api calls the API with the saved token, if any. The token is only needed
+// when the API is set up to require one.
+async function api(path, options = {}) {
+  const headers = new Headers(options.headers);
+  const token = localStorage.getItem(TOKEN_KEY);
+  if (token) {
+    headers.set("Authorization", `Bearer ${token}`);
+  }
+
+  const res = await fetch(`${API}${path}`, { ...options, headers });
+  if (res.status === 401 || res.status === 403) {
+    showAuth();
+    throw new AuthError("unauthorized");
+  }
+  if (!res.ok) {
+    let message = `${res.status} ${res.statusText}`;
+    try {
+      const body = await res.json();
+      message = body.error || message;
+    } catch {
+      // Not a JSON error, keep the status text
+    }
+    throw new Error(message);
+  }
+
+  return res;
+}
+
+function showAuth() {
+  $("auth").hidden = false;
+}
+
+function showError(err) {
+  if (err instanceof AuthError) {
+    return;
+  }
+  $("error").textContent = err.message;
+  $("error").hidden = false;
+}
+
+function clearError() {
+  $("error").hidden = true;
+}
+
+function renderState() {
+  const s = state.heatpump;
+  if (!s) {
+    return;
+  }
+
+  for (const button of $("modes").querySelectorAll("button")) {
+    button.classList.toggle("active", button.dataset.mode === s.mode);
+  }
+  for (const button of $("fan-speeds").querySelectorAll("button")) {
+    button.classList.toggle("active", Number(button.dataset.fan) === s.fanSpeed);
+  }
+
+  $("target").textContent = `${s.targetTemperature}°C`;
+  $("target-down").disabled = s.targetTemperature <= MIN_TARGET;
+  $("target-up").disabled = s.targetTemperature >= MAX_TARGET;
+}
+
+function renderReading(temperature, humidity) {
+  $("temperature").textContent = `${temperature.toFixed(1)}°C`;
+  $("humidity").textContent = `${Math.round(humidity)}%`;
+}
+
+async function loadState() {
+  const res = await api("/state");
+  state.heatpump = await res.json();
+  renderState();
+}
+
+async function loadReading() {
+  const res = await api("/temperature-and-humidity");
+  const reading = await res.json();
+  renderReading(reading.temperature, reading.humidity);
+}
+
+async function loadHistory() {
+  const from = new Date(Date.now() - state.hours * 3600 * 1000).toISOString();
+  const res = await api(`/temperature-and-humidity/history?from=${encodeURIComponent(from)}`);
+  state.readings = (await res.json()).map((r) => ({
+    time: new Date(r.time),
+    temperature: r.temperature,
+    humidity: r.humidity,
+  }));
+  renderChart();
+}
+
+async function updateState(partial) {
+  clearError();
+  try {
+    const res = await api("/state", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(partial),
+    });
+    state.heatpump = await res.json();
+    renderState();
+  } catch (err) {
+    showError(err);
+  }
+}
+
+const SVG_NS = "http://www.w3.org/2000/svg";
+const CHART = { width: 600, height: 240, left: 36, right: 36, top: 10, bottom: 20 };
+
+function svg(tag, attrs, text) {
+  const el = document.createElementNS(SVG_NS, tag);
+  for (const [key, value] of Object.entries(attrs)) {
+    el.setAttribute(key, value);
+  }
+  if (text !== undefined) {
+    el.textContent = text;
+  }
+  return el;
+}
+
+// range returns padded bounds of the values, so that flat lines are visible.
+function range(values, minSpan) {
+  let min = Math.min(...values);
+  let max = Math.max(...values);
+  if (max - min < minSpan) {
+    const mid = (max + min) / 2;
+    min = mid - minSpan / 2;
+    max = mid + minSpan / 2;
+  }
+  return { min: Math.floor(min), max: Math.ceil(max) };
+}
+
+function renderChart() {
+  const chart = $("chart");
+  chart.replaceChildren();
+
+  const end = Date.now();
+  const start = end - state.hours * 3600 * 1000;
+  const readings = state.readings.filter((r) => r.time.getTime() >= start);
+  if (readings.length < 2) {
+    chart.append(svg("text", { x: CHART.width / 2, y: CHART.height / 2, "text-anchor": "middle" }, "Not enough readings yet"));
+    return;
+  }
+
+  const t = range(readings.map((r) => r.temperature), 2);
+  const h = range(readings.map((r) => r.humidity), 10);
+
+  const plotWidth = CHART.width - CHART.left - CHART.right;
+  const plotHeight = CHART.height - CHART.top - CHART.bottom;
+  const x = (time) => CHART.left + ((time.getTime() - start) / (end - start)) * plotWidth;
+  const y = (value, r) => CHART.top + (1 - (value - r.min) / (r.max - r.min)) * plotHeight;
+
+  const ticks = 4;
+  for (let i = 0; i <= ticks; i++) {
+    const yPos = CHART.top + (i / ticks) * plotHeight;
+    chart.append(svg("line", { class: "grid", x1: CHART.left, x2: CHART.width - CHART.right, y1: yPos, y2: yPos }));
+
+    const temperature = t.max - (i / ticks) * (t.max - t.min);
+    const humidity = h.max - (i / ticks) * (h.max - h.min);
+    chart.append(svg("text", { x: CHART.left - 4, y: yPos + 3, "text-anchor": "end" }, `${temperature.toFixed(1)}°`));
+    chart.append(svg("text", { x: CHART.width - CHART.right + 4, y: yPos + 3 }, `${Math.round(humidity)}%`));
+  }
+
+  const format = state.hours > 24 ? { weekday: "short" } : { hour: "2-digit", minute: "2-digit" };
+  for (let i = 0; i <= ticks; i++) {
+    const time = new Date(start + (i / ticks) * (end - start));
+    const anchor = i === 0 ? "start" : i === ticks ? "end" : "middle";
+    chart.append(svg("text", { x: x(time), y: CHART.height - 4, "text-anchor": anchor }, time.toLocaleTimeString([], format)));
+  }
+
+  const points = (key, r) => readings.map((reading) => `${x(reading.time).toFixed(1)},${y(reading[key], r).toFixed(1)}`).join(" ");
+  chart.append(svg("polyline", { class: "humidity", points: points("humidity", h) }));
+  chart.append(svg("polyline", { class: "temperature", points: points("temperature", t) }));
+}
+
+function setConnection(live) {
+  $("connection").textContent = live ? "live" : "offline";
+  $("connection").classList.toggle("live", live);
+}
+
+// stream reads server-sent events with fetch rather than EventSource, which
+// can't send the Authorization header.
+async function stream() {
+  const res = await api("/events", { headers: { Accept: "text/event-stream" } });
+  setConnection(true);
+
+  const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) {
+      return;
+    }
+
+    buffer += value;
+    let end;
+    while ((end = buffer.indexOf("\n\n")) >= 0) {
+      handleMessage(buffer.slice(0, end));
+      buffer = buffer.slice(end + 2);
+    }
+  }
+}
+
+function handleMessage(message) {
+  let data = "";
+  for (const line of message.split("\n")) {
+    if (line.startsWith("data:")) {
+      data += line.slice(5).trim();
+    }
+  }
+  if (!data) {
+    return;
+  }
+
+  const event = JSON.parse(data);
+  switch (event.type) {
+    case "reading":
+      handleReading(event);
+      break;
+    case "stateChange":
+      state.heatpump = {
+        mode: event.data.mode,
+        targetTemperature: event.data.targetTemperature,
+        fanSpeed: event.data.fanSpeed,
+      };
+      renderState();
+      break;
+  }
+}
+
+let latest = {};
+
+function handleReading(event) {
+  const { sensor, value } = event.data;
+  if (sensor !== "temperature" && sensor !== "humidity") {
+    return;
+  }
+
+  latest[sensor] = value;
+  if (latest.temperature === undefined || latest.humidity === undefined) {
+    return;
+  }
+
+  renderReading(latest.temperature, latest.humidity);
+
+  // The sensor reports temperature and humidity together, add the point
+  // once both have arrived
+  if (sensor === "humidity") {
+    state.readings.push({ time: new Date(event.time), temperature: latest.temperature, humidity: latest.humidity });
+    renderChart();
+  }
+}
+
+async function keepStreaming() {
+  let backoff = 1000;
+  for (;;) {
+    const started = Date.now();
+    try {
+      await stream();
+    } catch (err) {
+      if (err instanceof AuthError) {
+        setConnection(false);
+        return;
+      }
+    }
+    setConnection(false);
+
+    // Reset backoff if the stream has been up for a while
+    if (Date.now() - started > 60000) {
+      backoff = 1000;
+    }
+    await new Promise((resolve) => setTimeout(resolve, backoff));
+    backoff = Math.min(backoff * 2, 30000);
+
+    // Catch up on anything missed while disconnected
+    await Promise.allSettled([loadState(), loadReading(), loadHistory()]);
+  }
+}
+
+function bindControls() {
+  for (const button of $("modes").querySelectorAll("button")) {
+    button.addEventListener("click", () => updateState({ mode: button.dataset.mode }));
+  }
+  for (const button of $("fan-speeds").querySelectorAll("button")) {
+    button.addEventListener("click", () => updateState({ fanSpeed: Number(button.dataset.fan) }));
+  }
+  $("target-down").addEventListener("click", () => {
+    if (state.heatpump) {
+      updateState({ targetTemperature: state.heatpump.targetTemperature - 1 });
+    }
+  });
+  $("target-up").addEventListener("click", () => {
+    if (state.heatpump) {
+      updateState({ targetTemperature: state.heatpump.targetTemperature + 1 });
+    }
+  });
+
+  for (const button of $("periods").querySelectorAll("button")) {
+    button.classList.toggle("active", Number(button.dataset.hours) === state.hours);
+    button.addEventListener("click", () => {
+      state.hours = Number(button.dataset.hours);
+      for (const b of $("periods").querySelectorAll("button")) {
+        b.classList.toggle("active", b === button);
+      }
+      loadHistory().catch(showError);
+    });
+  }
+
+  $("auth-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    localStorage.setItem(TOKEN_KEY, $("auth-token").value);
+    location.reload();
+  });
+}
+
+async function main() {
+  bindControls();
+
+  const results = await Promise.allSettled([loadState(), loadReading(), loadHistory()]);
+  for (const result of results) {
+    if (result.status === "rejected") {
+      showError(result.reason);
+    }
+  }
+
+  keepStreaming();
+}
+
+main();
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/alexchebotarsky/heatpump-api/model/backup"
+	"github.com/alexchebotarsky/heatpump-api/service"
+)
+
+type BackupExporter interface {
+	ExportBackup(ctx context.Context) (*backup.Archive, error)
+}
+
+func ExportBackup(exporter BackupExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		archive, err := exporter.ExportBackup(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error exporting backup: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		filename := fmt.Sprintf("heatpump-api-backup-%s.json", archive.CreatedAt.Format("20060102T150405Z"))
+
+		w.Header().Add("Content-Type", "application/json")
+		w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(archive)
+		handleWritingErr(err)
+	}
+}
+
+type BackupImporter interface {
+	ImportBackup(ctx context.Context, archive *backup.Archive, mode backup.ImportMode, dryRun bool) (*backup.ImportResult, error)
+}
+
+// ImportBackup merges the archive into the stored data by default, "replace"
+// mode drops the keys that aren't in the archive.
+func ImportBackup(importer BackupImporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := backup.ImportMode(r.URL.Query().Get("mode"))
+		if mode == "" {
+			mode = backup.MergeMode
+		}
+
+		var dryRun bool
+		dryRunParam := r.URL.Query().Get("dryRun")
+		if dryRunParam != "" {
+			var err error
+			dryRun, err = strconv.ParseBool(dryRunParam)
+			if err != nil {
+				HandleError(w, fmt.Errorf("error parsing dryRun query param: %v", err), http.StatusBadRequest, false)
+				return
+			}
+		}
+
+		var archive backup.Archive
+		err := json.NewDecoder(r.Body).Decode(&archive)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding archive: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		result, err := importer.ImportBackup(r.Context(), &archive, mode, dryRun)
+		if err != nil {
+			var errInvalid *service.ErrInvalid
+			switch {
+			case errors.As(err, &errInvalid):
+				HandleError(w, err, http.StatusBadRequest, false)
+			default:
+				HandleError(w, fmt.Errorf("error importing backup: %v", err), http.StatusInternalServerError, true)
+			}
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(result)
+		handleWritingErr(err)
+	}
+}
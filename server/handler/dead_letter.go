@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/deadletter"
+	chi "github.com/go-chi/chi/v5"
+)
+
+type DeadLettersFetcher interface {
+	FetchDeadLetters(ctx context.Context) ([]deadletter.Message, error)
+}
+
+func GetDeadLetters(fetcher DeadLettersFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		messages, err := fetcher.FetchDeadLetters(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching dead letters: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(messages)
+		handleWritingErr(err)
+	}
+}
+
+type DeadLetterReplayStore interface {
+	FetchDeadLetter(ctx context.Context, id string) (*deadletter.Message, error)
+	DeleteDeadLetter(ctx context.Context, id string) error
+}
+
+type MessagePublisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+// ReplayDeadLetter publishes the dead-lettered payload to its original topic
+// again, so that it goes through the regular processing.
+func ReplayDeadLetter(store DeadLetterReplayStore, publisher MessagePublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		message, err := store.FetchDeadLetter(r.Context(), id)
+		if err != nil {
+			handleDeadLetterErr(w, err)
+			return
+		}
+
+		err = publisher.Publish(r.Context(), message.Topic, []byte(message.Payload))
+		if err != nil {
+			HandleError(w, fmt.Errorf("error replaying dead letter: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		err = store.DeleteDeadLetter(r.Context(), id)
+		if err != nil {
+			handleDeadLetterErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(message)
+		handleWritingErr(err)
+	}
+}
+
+type DeadLetterDeleter interface {
+	DeleteDeadLetter(ctx context.Context, id string) error
+}
+
+func DeleteDeadLetter(deleter DeadLetterDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := deleter.DeleteDeadLetter(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			handleDeadLetterErr(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleDeadLetterErr(w http.ResponseWriter, err error) {
+	var errNotFound *client.ErrNotFound
+	switch {
+	case errors.As(err, &errNotFound):
+		HandleError(w, err, http.StatusNotFound, false)
+	default:
+		HandleError(w, fmt.Errorf("error accessing dead letter: %v", err), http.StatusInternalServerError, true)
+	}
+}
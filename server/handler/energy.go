@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/model/energy"
+	"github.com/alexchebotarsky/heatpump-api/service"
+)
+
+type EnergyReportFetcher interface {
+	FetchEnergyReport(ctx context.Context, period energy.Period) (*energy.Report, error)
+}
+
+func GetEnergyReport(fetcher EnergyReportFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		period := energy.Period(r.URL.Query().Get("period"))
+		if period == "" {
+			period = energy.DayPeriod
+		}
+
+		report, err := fetcher.FetchEnergyReport(r.Context(), period)
+		if err != nil {
+			var errInvalid *service.ErrInvalid
+			switch {
+			case errors.As(err, &errInvalid):
+				HandleError(w, err, http.StatusBadRequest, false)
+			default:
+				HandleError(w, fmt.Errorf("error fetching energy report: %v", err), http.StatusInternalServerError, true)
+			}
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(report)
+		handleWritingErr(err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/model/apierror"
 )
 
 func handleWritingErr(err error) {
@@ -13,11 +15,6 @@ func handleWritingErr(err error) {
 	}
 }
 
-type errorResponse struct {
-	Error      string `json:"error"`
-	StatusCode int    `json:"statusCode"`
-}
-
 func HandleError(w http.ResponseWriter, handlerErr error, statusCode int, shouldLog bool) {
 	if shouldLog {
 		slog.Error(fmt.Sprintf("Handler error: %v", handlerErr), "status", statusCode)
@@ -26,14 +23,6 @@ func HandleError(w http.ResponseWriter, handlerErr error, statusCode int, should
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 
-	// Hide server errors from the client
-	if statusCode >= 500 {
-		handlerErr = fmt.Errorf("%s: %d", http.StatusText(statusCode), statusCode)
-	}
-
-	err := json.NewEncoder(w).Encode(errorResponse{
-		Error:      handlerErr.Error(),
-		StatusCode: statusCode,
-	})
+	err := json.NewEncoder(w).Encode(apierror.New(handlerErr, statusCode))
 	handleWritingErr(err)
 }
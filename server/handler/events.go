@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+)
+
+type EventSubscriber interface {
+	Subscribe(bufferSize int, types ...bus.Type) (events <-chan bus.Event, unsubscribe func())
+}
+
+const (
+	streamBufferSize = 20
+	// streamHeartbeatInterval keeps idle connections from being closed by
+	// proxies, and detects clients that are gone.
+	streamHeartbeatInterval = 15 * time.Second
+	streamWriteTimeout      = 5 * time.Second
+)
+
+// StreamEvents streams readings and state changes as server-sent events until
+// the client disconnects or done is closed.
+func StreamEvents(subscriber EventSubscriber, done <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+
+		events, unsubscribe := subscriber.Subscribe(streamBufferSize, bus.ReadingEvent, bus.StateChangeEvent)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		heartbeat := time.NewTicker(streamHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		// The server write timeout is meant for regular requests, stream
+		// writes get their own deadline instead
+		write := func(format string, args ...any) error {
+			err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err != nil {
+				return fmt.Errorf("error setting write deadline: %v", err)
+			}
+
+			_, err = fmt.Fprintf(w, format, args...)
+			if err != nil {
+				return fmt.Errorf("error writing event: %v", err)
+			}
+
+			return rc.Flush()
+		}
+
+		err := write(": connected\n\n")
+		if err != nil {
+			slog.Debug(fmt.Sprintf("Error starting event stream: %v", err))
+			return
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-done:
+				return
+			case <-heartbeat.C:
+				err = write(": heartbeat\n\n")
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+
+				data, marshalErr := json.Marshal(e)
+				if marshalErr != nil {
+					slog.Error(fmt.Sprintf("Error encoding %s event: %v", e.Type, marshalErr))
+					continue
+				}
+
+				err = write("event: %s\ndata: %s\n\n", e.Type, data)
+			}
+
+			if err != nil {
+				slog.Debug(fmt.Sprintf("Event stream closed: %v", err))
+				return
+			}
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/health"
 )
 
 type healthResponse struct {
@@ -18,3 +21,28 @@ func Health(w http.ResponseWriter, r *http.Request) {
 	})
 	handleWritingErr(err)
 }
+
+func Live(w http.ResponseWriter, r *http.Request) {
+	Health(w, r)
+}
+
+type ReadinessChecker interface {
+	Run(ctx context.Context) health.Report
+}
+
+func Ready(checker ReadinessChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Run(r.Context())
+
+		statusCode := http.StatusOK
+		if report.Status == health.ErrStatus {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+
+		err := json.NewEncoder(w).Encode(report)
+		handleWritingErr(err)
+	}
+}
@@ -3,19 +3,21 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/service"
 )
 
 type HeatpumpStateFetcher interface {
-	FetchHeatpumpState() (*heatpump.State, error)
+	FetchHeatpumpState(ctx context.Context) (*heatpump.State, error)
 }
 
 func GetHeatpumpState(fetcher HeatpumpStateFetcher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		state, err := fetcher.FetchHeatpumpState()
+		state, err := fetcher.FetchHeatpumpState(r.Context())
 		if err != nil {
 			HandleError(w, fmt.Errorf("error fetching state: %v", err), http.StatusInternalServerError, true)
 			return
@@ -30,14 +32,10 @@ func GetHeatpumpState(fetcher HeatpumpStateFetcher) http.HandlerFunc {
 }
 
 type HeatpumpStateUpdater interface {
-	UpdateHeatpumpState(*heatpump.State) (*heatpump.State, error)
+	UpdateHeatpumpState(ctx context.Context, source heatpump.Source, state *heatpump.State) (*heatpump.State, error)
 }
 
-type IRTransmitter interface {
-	TransmitIRSignal(ctx context.Context, binaryString string) error
-}
-
-func UpdateHeatpumpState(updater HeatpumpStateUpdater, irTransmitter IRTransmitter) http.HandlerFunc {
+func UpdateHeatpumpState(updater HeatpumpStateUpdater) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var state heatpump.State
 		err := json.NewDecoder(r.Body).Decode(&state)
@@ -46,27 +44,15 @@ func UpdateHeatpumpState(updater HeatpumpStateUpdater, irTransmitter IRTransmitt
 			return
 		}
 
-		err = state.Validate()
-		if err != nil {
-			HandleError(w, fmt.Errorf("error validating heatpump state: %v", err), http.StatusBadRequest, false)
-			return
-		}
-
-		updatedState, err := updater.UpdateHeatpumpState(&state)
-		if err != nil {
-			HandleError(w, fmt.Errorf("error updating heatpump state: %v", err), http.StatusInternalServerError, true)
-			return
-		}
-
-		binaryString, err := updatedState.ToBinary()
-		if err != nil {
-			HandleError(w, fmt.Errorf("error converting heatpump state to binary: %v", err), http.StatusInternalServerError, true)
-			return
-		}
-
-		err = irTransmitter.TransmitIRSignal(r.Context(), binaryString)
+		updatedState, err := updater.UpdateHeatpumpState(r.Context(), heatpump.HTTPSource, &state)
 		if err != nil {
-			HandleError(w, fmt.Errorf("error publishing binary heatpump state: %v", err), http.StatusInternalServerError, true)
+			var errInvalid *service.ErrInvalid
+			switch {
+			case errors.As(err, &errInvalid):
+				HandleError(w, err, http.StatusBadRequest, false)
+			default:
+				HandleError(w, fmt.Errorf("error updating heatpump state: %v", err), http.StatusInternalServerError, true)
+			}
 			return
 		}
 
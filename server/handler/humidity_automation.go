@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/service"
+)
+
+type HumidityAutomationFetcher interface {
+	FetchHumidityAutomationStatus(ctx context.Context) (*service.HumidityAutomationStatus, error)
+}
+
+func GetHumidityAutomation(fetcher HumidityAutomationFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := fetcher.FetchHumidityAutomationStatus(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching humidity automation status: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(status)
+		handleWritingErr(err)
+	}
+}
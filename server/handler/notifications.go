@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/alert"
+	"github.com/alexchebotarsky/heatpump-api/service"
+	chi "github.com/go-chi/chi/v5"
+)
+
+type AlertsFetcher interface {
+	FetchAlerts(ctx context.Context) ([]alert.Rule, error)
+}
+
+func GetAlerts(fetcher AlertsFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alerts, err := fetcher.FetchAlerts(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching alerts: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(alerts)
+		handleWritingErr(err)
+	}
+}
+
+type AlertFetcher interface {
+	FetchAlert(ctx context.Context, id string) (*alert.Rule, error)
+}
+
+func GetAlert(fetcher AlertFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fetchedAlert, err := fetcher.FetchAlert(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			handleAlertErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(fetchedAlert)
+		handleWritingErr(err)
+	}
+}
+
+type AlertCreator interface {
+	CreateAlert(ctx context.Context, a *alert.Rule) (*alert.Rule, error)
+}
+
+func CreateAlert(creator AlertCreator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var newAlert alert.Rule
+		err := json.NewDecoder(r.Body).Decode(&newAlert)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding alert: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		createdAlert, err := creator.CreateAlert(r.Context(), &newAlert)
+		if err != nil {
+			handleAlertErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		err = json.NewEncoder(w).Encode(createdAlert)
+		handleWritingErr(err)
+	}
+}
+
+type AlertUpdater interface {
+	UpdateAlert(ctx context.Context, id string, a *alert.Rule) (*alert.Rule, error)
+}
+
+func UpdateAlert(updater AlertUpdater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var updatedAlert alert.Rule
+		err := json.NewDecoder(r.Body).Decode(&updatedAlert)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding alert: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		result, err := updater.UpdateAlert(r.Context(), chi.URLParam(r, "id"), &updatedAlert)
+		if err != nil {
+			handleAlertErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(result)
+		handleWritingErr(err)
+	}
+}
+
+type AlertDeleter interface {
+	DeleteAlert(ctx context.Context, id string) error
+}
+
+func DeleteAlert(deleter AlertDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := deleter.DeleteAlert(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			handleAlertErr(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type NotificationHistoryFetcher interface {
+	FetchNotificationHistory(ctx context.Context) ([]alert.Record, error)
+}
+
+func GetNotificationHistory(fetcher NotificationHistoryFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		history, err := fetcher.FetchNotificationHistory(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching notification history: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(history)
+		handleWritingErr(err)
+	}
+}
+
+type NotificationTester interface {
+	TestNotification(ctx context.Context, channel string) ([]alert.Delivery, error)
+}
+
+type TestNotificationRequest struct {
+	// Channel to send to, all channels if empty.
+	Channel string `json:"channel"`
+}
+
+// TestNotification sends a test notification and responds with the result
+// of every channel. The body is optional.
+func TestNotification(tester NotificationTester) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TestNotificationRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			HandleError(w, fmt.Errorf("error decoding test notification request: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		deliveries, err := tester.TestNotification(r.Context(), req.Channel)
+		if err != nil {
+			handleAlertErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(deliveries)
+		handleWritingErr(err)
+	}
+}
+
+func handleAlertErr(w http.ResponseWriter, err error) {
+	var errNotFound *client.ErrNotFound
+	var errInvalid *service.ErrInvalid
+	switch {
+	case errors.As(err, &errNotFound):
+		HandleError(w, err, http.StatusNotFound, false)
+	case errors.As(err, &errInvalid):
+		HandleError(w, err, http.StatusBadRequest, false)
+	default:
+		HandleError(w, fmt.Errorf("error accessing alert: %v", err), http.StatusInternalServerError, true)
+	}
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/model/window"
+)
+
+type OpenWindowFetcher interface {
+	FetchOpenWindow(ctx context.Context) (*window.Suspension, error)
+}
+
+func GetOpenWindow(fetcher OpenWindowFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		suspension, err := fetcher.FetchOpenWindow(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching open window: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(suspension)
+		handleWritingErr(err)
+	}
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/service"
+)
+
+type OutdoorTemperatureFetcher interface {
+	FetchOutdoorTemperature(ctx context.Context) (*service.OutdoorTemperature, error)
+}
+
+func GetOutdoorTemperature(fetcher OutdoorTemperatureFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		outdoorTemperature, err := fetcher.FetchOutdoorTemperature(r.Context())
+		if err != nil {
+			var errNotFound *client.ErrNotFound
+			switch {
+			case errors.As(err, &errNotFound):
+				HandleError(w, err, http.StatusNotFound, false)
+			default:
+				HandleError(w, fmt.Errorf("error fetching outdoor temperature: %v", err), http.StatusInternalServerError, true)
+			}
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(outdoorTemperature)
+		handleWritingErr(err)
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/model/presence"
+	"github.com/alexchebotarsky/heatpump-api/service"
+	chi "github.com/go-chi/chi/v5"
+)
+
+type PresenceFetcher interface {
+	FetchPresence(ctx context.Context) (*presence.Status, error)
+}
+
+func GetPresence(fetcher PresenceFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := fetcher.FetchPresence(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching presence: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(status)
+		handleWritingErr(err)
+	}
+}
+
+type PersonPresenceUpdater interface {
+	UpdatePersonPresence(ctx context.Context, name string, home bool, source presence.Source) error
+}
+
+type PersonPresenceRequest struct {
+	Home *bool `json:"home"`
+}
+
+// UpdatePersonPresence is a webhook for geofencing apps.
+func UpdatePersonPresence(updater PersonPresenceUpdater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PersonPresenceRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding presence: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		if req.Home == nil {
+			HandleError(w, errors.New("home must be set"), http.StatusBadRequest, false)
+			return
+		}
+
+		err = updater.UpdatePersonPresence(r.Context(), chi.URLParam(r, "person"), *req.Home, presence.HTTPSource)
+		if err != nil {
+			var errInvalid *service.ErrInvalid
+			switch {
+			case errors.As(err, &errInvalid):
+				HandleError(w, err, http.StatusBadRequest, false)
+			default:
+				HandleError(w, fmt.Errorf("error updating presence: %v", err), http.StatusInternalServerError, true)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
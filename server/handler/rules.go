@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/rule"
+	"github.com/alexchebotarsky/heatpump-api/service"
+	chi "github.com/go-chi/chi/v5"
+)
+
+type RulesFetcher interface {
+	FetchRules(ctx context.Context) ([]rule.Rule, error)
+}
+
+func GetRules(fetcher RulesFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rules, err := fetcher.FetchRules(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching rules: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(rules)
+		handleWritingErr(err)
+	}
+}
+
+type RuleFetcher interface {
+	FetchRule(ctx context.Context, id string) (*rule.Rule, error)
+}
+
+func GetRule(fetcher RuleFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fetchedRule, err := fetcher.FetchRule(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			handleRuleErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(fetchedRule)
+		handleWritingErr(err)
+	}
+}
+
+type RuleCreator interface {
+	CreateRule(ctx context.Context, r *rule.Rule) (*rule.Rule, error)
+}
+
+func CreateRule(creator RuleCreator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var newRule rule.Rule
+		err := json.NewDecoder(r.Body).Decode(&newRule)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding rule: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		createdRule, err := creator.CreateRule(r.Context(), &newRule)
+		if err != nil {
+			handleRuleErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		err = json.NewEncoder(w).Encode(createdRule)
+		handleWritingErr(err)
+	}
+}
+
+type RuleUpdater interface {
+	UpdateRule(ctx context.Context, id string, r *rule.Rule) (*rule.Rule, error)
+}
+
+func UpdateRule(updater RuleUpdater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var updatedRule rule.Rule
+		err := json.NewDecoder(r.Body).Decode(&updatedRule)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding rule: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		result, err := updater.UpdateRule(r.Context(), chi.URLParam(r, "id"), &updatedRule)
+		if err != nil {
+			handleRuleErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(result)
+		handleWritingErr(err)
+	}
+}
+
+type RuleDeleter interface {
+	DeleteRule(ctx context.Context, id string) error
+}
+
+func DeleteRule(deleter RuleDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := deleter.DeleteRule(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			handleRuleErr(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type RuleTester interface {
+	TestRule(ctx context.Context, id string, input *rule.TestInput) (*rule.Evaluation, error)
+}
+
+// TestRule shows what the rule would do for the given input, without running
+// its actions.
+func TestRule(tester RuleTester) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input rule.TestInput
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding rule test input: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		evaluation, err := tester.TestRule(r.Context(), chi.URLParam(r, "id"), &input)
+		if err != nil {
+			handleRuleErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(evaluation)
+		handleWritingErr(err)
+	}
+}
+
+func handleRuleErr(w http.ResponseWriter, err error) {
+	var errNotFound *client.ErrNotFound
+	var errInvalid *service.ErrInvalid
+	switch {
+	case errors.As(err, &errNotFound):
+		HandleError(w, err, http.StatusNotFound, false)
+	case errors.As(err, &errInvalid):
+		HandleError(w, err, http.StatusBadRequest, false)
+	default:
+		HandleError(w, fmt.Errorf("error accessing rule: %v", err), http.StatusInternalServerError, true)
+	}
+}
@@ -1,22 +1,24 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/alexchebotarsky/heatpump-api/client"
 	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
 )
 
 type TemperatureAndHumidityFetcher interface {
-	FetchTemperatureAndHumidity() (temperature float64, humidity float64, err error)
+	FetchTemperatureAndHumidity(ctx context.Context) (temperature float64, humidity float64, err error)
 }
 
 func GetTemperatureAndHumidity(fetcher TemperatureAndHumidityFetcher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		temperature, humidity, err := fetcher.FetchTemperatureAndHumidity()
+		temperature, humidity, err := fetcher.FetchTemperatureAndHumidity(r.Context())
 		if err != nil {
 			switch err.(type) {
 			case *client.ErrNotFound:
@@ -39,3 +41,40 @@ func GetTemperatureAndHumidity(fetcher TemperatureAndHumidityFetcher) http.Handl
 		handleWritingErr(err)
 	}
 }
+
+type ReadingHistoryFetcher interface {
+	FetchReadingHistory(ctx context.Context, from time.Time) ([]heatpump.Reading, error)
+}
+
+// defaultReadingHistoryPeriod is returned if the from query param isn't set.
+const defaultReadingHistoryPeriod = 24 * time.Hour
+
+// GetReadingHistory returns stored readings since the RFC 3339 from query
+// param, or the last 24 hours.
+func GetReadingHistory(fetcher ReadingHistoryFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from := time.Now().Add(-defaultReadingHistoryPeriod)
+
+		fromParam := r.URL.Query().Get("from")
+		if fromParam != "" {
+			var err error
+			from, err = time.Parse(time.RFC3339, fromParam)
+			if err != nil {
+				HandleError(w, fmt.Errorf("error parsing from query param: %v", err), http.StatusBadRequest, false)
+				return
+			}
+		}
+
+		readings, err := fetcher.FetchReadingHistory(r.Context(), from)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching reading history: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(readings)
+		handleWritingErr(err)
+	}
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/webhook"
+	"github.com/alexchebotarsky/heatpump-api/service"
+	chi "github.com/go-chi/chi/v5"
+)
+
+type WebhooksFetcher interface {
+	FetchWebhooks(ctx context.Context) ([]webhook.Webhook, error)
+}
+
+func GetWebhooks(fetcher WebhooksFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhooks, err := fetcher.FetchWebhooks(r.Context())
+		if err != nil {
+			HandleError(w, fmt.Errorf("error fetching webhooks: %v", err), http.StatusInternalServerError, true)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(webhooks)
+		handleWritingErr(err)
+	}
+}
+
+type WebhookFetcher interface {
+	FetchWebhook(ctx context.Context, id string) (*webhook.Webhook, error)
+}
+
+func GetWebhook(fetcher WebhookFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fetchedWebhook, err := fetcher.FetchWebhook(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			handleWebhookErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(fetchedWebhook)
+		handleWritingErr(err)
+	}
+}
+
+type WebhookCreator interface {
+	CreateWebhook(ctx context.Context, w *webhook.Webhook) (*webhook.Webhook, error)
+}
+
+func CreateWebhook(creator WebhookCreator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var newWebhook webhook.Webhook
+		err := json.NewDecoder(r.Body).Decode(&newWebhook)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding webhook: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		createdWebhook, err := creator.CreateWebhook(r.Context(), &newWebhook)
+		if err != nil {
+			handleWebhookErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		err = json.NewEncoder(w).Encode(createdWebhook)
+		handleWritingErr(err)
+	}
+}
+
+type WebhookUpdater interface {
+	UpdateWebhook(ctx context.Context, id string, w *webhook.Webhook) (*webhook.Webhook, error)
+}
+
+func UpdateWebhook(updater WebhookUpdater) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var updatedWebhook webhook.Webhook
+		err := json.NewDecoder(r.Body).Decode(&updatedWebhook)
+		if err != nil {
+			HandleError(w, fmt.Errorf("error decoding webhook: %v", err), http.StatusBadRequest, false)
+			return
+		}
+
+		result, err := updater.UpdateWebhook(r.Context(), chi.URLParam(r, "id"), &updatedWebhook)
+		if err != nil {
+			handleWebhookErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(result)
+		handleWritingErr(err)
+	}
+}
+
+type WebhookDeleter interface {
+	DeleteWebhook(ctx context.Context, id string) error
+}
+
+func DeleteWebhook(deleter WebhookDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := deleter.DeleteWebhook(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			handleWebhookErr(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type WebhookDeliveriesFetcher interface {
+	FetchWebhookDeliveries(ctx context.Context, id string) ([]webhook.Attempt, error)
+}
+
+func GetWebhookDeliveries(fetcher WebhookDeliveriesFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		attempts, err := fetcher.FetchWebhookDeliveries(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			handleWebhookErr(w, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(attempts)
+		handleWritingErr(err)
+	}
+}
+
+func handleWebhookErr(w http.ResponseWriter, err error) {
+	var errNotFound *client.ErrNotFound
+	var errInvalid *service.ErrInvalid
+	switch {
+	case errors.As(err, &errNotFound):
+		HandleError(w, err, http.StatusNotFound, false)
+	case errors.As(err, &errInvalid):
+		HandleError(w, err, http.StatusBadRequest, false)
+	default:
+		HandleError(w, fmt.Errorf("error accessing webhook: %v", err), http.StatusInternalServerError, true)
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/alexchebotarsky/heatpump-api/server/handler"
+)
+
+// Admin only lets requests with the admin API key as a bearer token through.
+// Admin routes are disabled without a key, rather than left open, since they
+// can read and overwrite all stored data.
+func Admin(apiKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey == "" {
+				handler.HandleError(w, errors.New("admin API is disabled, ADMIN_API_KEY isn't set"), http.StatusForbidden, false)
+				return
+			}
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(apiKey)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				handler.HandleError(w, errors.New("invalid or missing admin API key"), http.StatusUnauthorized, false)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -17,10 +17,11 @@ func Metrics(next http.Handler) http.Handler {
 		next.ServeHTTP(&crw, r)
 		duration := time.Since(start)
 
-		routeName := fmt.Sprintf("%s %s", r.Method, chi.RouteContext(r.Context()).RoutePattern())
+		routePattern := chi.RouteContext(r.Context()).RoutePattern()
+		routeName := fmt.Sprintf("%s %s", r.Method, routePattern)
 
 		metrics.AddRequestHandled(routeName, crw.status)
-		metrics.ObserveRequestDuration(duration)
+		metrics.ObserveRequestDuration(r.Method, routePattern, duration)
 	})
 }
 
@@ -33,3 +34,9 @@ func (crw *customResponseWriter) WriteHeader(status int) {
 	crw.status = status
 	crw.ResponseWriter.WriteHeader(status)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses.
+func (crw *customResponseWriter) Unwrap() http.ResponseWriter {
+	return crw.ResponseWriter
+}
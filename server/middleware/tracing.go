@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+	chi "github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracing.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		))
+		defer span.End()
+
+		crw := customResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(&crw, r.WithContext(ctx))
+
+		// Route pattern is only known after the request has been routed
+		routePattern := chi.RouteContext(r.Context()).RoutePattern()
+		span.SetName(fmt.Sprintf("%s %s", r.Method, routePattern))
+		span.SetAttributes(
+			semconv.HTTPRoute(routePattern),
+			semconv.HTTPResponseStatusCode(crw.status),
+		)
+		if crw.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(crw.status))
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/alexchebotarsky/heatpump-api/server/handler"
+	"github.com/alexchebotarsky/heatpump-api/server/openapi"
+)
+
+// maxBodySize is far above any valid request, it only guards against reading
+// arbitrarily large bodies into memory. Operations can set their own limit in
+// the document.
+const maxBodySize = 1 << 20
+
+// Validation rejects requests with a body that doesn't match the OpenAPI
+// document. Handlers decode into structs, which would silently ignore unknown
+// fields, e.g. a misspelled "fanspeed".
+func Validation(doc *openapi.Document) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			operation := doc.Operation(r.Method, r.URL.Path)
+			if operation == nil || operation.RequestBody == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limit := int64(maxBodySize)
+			if operation.MaxBodySize > 0 {
+				limit = operation.MaxBodySize
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+			if err != nil {
+				handler.HandleError(w, fmt.Errorf("error reading request body: %v", err), http.StatusBadRequest, false)
+				return
+			}
+
+			err = doc.ValidateRequestBody(operation, body)
+			if err != nil {
+				handler.HandleError(w, fmt.Errorf("invalid request: %v", err), http.StatusBadRequest, false)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
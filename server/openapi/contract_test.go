@@ -0,0 +1,236 @@
+package openapi_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/alexchebotarsky/heatpump-api/app/apptest"
+	"github.com/alexchebotarsky/heatpump-api/server"
+	"github.com/alexchebotarsky/heatpump-api/server/handler"
+	"github.com/alexchebotarsky/heatpump-api/server/middleware"
+	"github.com/alexchebotarsky/heatpump-api/server/openapi"
+)
+
+func TestRoutesMatchDocument(t *testing.T) {
+	// Handlers aren't called, so the clients can be left empty
+	s, err := server.New("localhost", 0, "", server.Clients{})
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+
+	err = s.Spec.CheckRoutes(s.Router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An undocumented route has to be caught as well
+	s.Router.Get("/api/v1/undocumented", handler.Live)
+	err = s.Spec.CheckRoutes(s.Router)
+	if err == nil || !strings.Contains(err.Error(), "GET /undocumented is not documented") {
+		t.Errorf("expected undocumented route error, got: %v", err)
+	}
+}
+
+func TestOperationsDocumentResponses(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, item := range doc.Paths {
+		for method, operation := range item.Operations() {
+			if operation.OperationID == "" {
+				t.Errorf("%s %s has no operationId", method, path)
+			}
+			if len(operation.Responses) == 0 {
+				t.Errorf("%s %s has no responses", method, path)
+			}
+		}
+	}
+}
+
+func TestResponsesMatchSchemas(t *testing.T) {
+	h := apptest.StartWithFake(t, nil)
+
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.PubSub.Inject(context.Background(), "heatpump/temperature-sensor", []byte(`{"temperature":21.5,"humidity":48}`))
+	if err != nil {
+		t.Fatalf("error injecting reading: %v", err)
+	}
+
+	_, export := send(t, h, http.MethodGet, "/admin/export", "")
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		statusCode int
+	}{
+		{http.MethodGet, "/state", "", http.StatusOK},
+		{http.MethodPost, "/state", `{"mode":"HEAT","targetTemperature":23}`, http.StatusOK},
+		{http.MethodPost, "/state", `{"targetTemperature":40}`, http.StatusBadRequest},
+		{http.MethodGet, "/temperature-and-humidity", "", http.StatusOK},
+		{http.MethodGet, "/temperature-and-humidity/history", "", http.StatusOK},
+		{http.MethodGet, "/temperature-and-humidity/history?from=yesterday", "", http.StatusBadRequest},
+		{http.MethodGet, "/outdoor-temperature", "", http.StatusNotFound},
+		{http.MethodGet, "/energy?period=week", "", http.StatusOK},
+		{http.MethodGet, "/energy?period=decade", "", http.StatusBadRequest},
+		{http.MethodGet, "/automation/humidity", "", http.StatusOK},
+		{http.MethodGet, "/open-window", "", http.StatusOK},
+		{http.MethodGet, "/presence", "", http.StatusOK},
+		{http.MethodGet, "/rules", "", http.StatusOK},
+		{http.MethodGet, "/rules/unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/alerts", "", http.StatusOK},
+		{http.MethodGet, "/alerts/unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/notifications", "", http.StatusOK},
+		{http.MethodGet, "/webhooks", "", http.StatusOK},
+		{http.MethodGet, "/webhooks/unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/admin/dead-letters", "", http.StatusOK},
+		{http.MethodDelete, "/admin/dead-letters/unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/admin/export", "", http.StatusOK},
+		{http.MethodPost, "/admin/import?dryRun=true", string(export), http.StatusOK},
+		{http.MethodPost, "/admin/import?mode=overwrite", string(export), http.StatusBadRequest},
+		{http.MethodGet, "/openapi.json", "", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			statusCode, body := send(t, h, test.method, test.path, test.body)
+			if statusCode != test.statusCode {
+				t.Fatalf("expected status %d, got: %d, body: %s", test.statusCode, statusCode, body)
+			}
+
+			path, _, _ := strings.Cut(test.path, "?")
+			operation := doc.Operation(test.method, doc.BasePath()+path)
+			if operation == nil {
+				t.Fatalf("%s %s isn't documented", test.method, path)
+			}
+
+			err := doc.ValidateResponseBody(operation, statusCode, body)
+			if err != nil {
+				t.Errorf("response doesn't match the document: %v, body: %s", err, body)
+			}
+		})
+	}
+}
+
+func TestSecuredOperationsRequireAdminKey(t *testing.T) {
+	h := apptest.StartWithFake(t, nil)
+
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var checked int
+	for path, item := range doc.Paths {
+		for method, operation := range item.Operations() {
+			// Request bodies are validated before the key is checked
+			if len(operation.Security) == 0 || (operation.RequestBody != nil && operation.RequestBody.Required) {
+				continue
+			}
+			checked++
+
+			url := h.URL + doc.BasePath() + pathParam.ReplaceAllString(path, "test")
+			req, err := http.NewRequest(method, url, nil)
+			if err != nil {
+				t.Fatalf("error creating request: %v", err)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("error sending request: %v", err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("expected %s %s without admin key to be %d, got: %d", method, path, http.StatusUnauthorized, res.StatusCode)
+			}
+		}
+	}
+
+	if checked == 0 {
+		t.Error("expected some operations to require the admin key")
+	}
+}
+
+var pathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+func TestValidationRejectsUnknownFields(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"valid", `{"fanSpeed":40}`, http.StatusOK},
+		{"misspelled field", `{"fanspeed":40}`, http.StatusBadRequest},
+		{"wrong type", `{"fanSpeed":"40"}`, http.StatusBadRequest},
+		{"out of range", `{"fanSpeed":140}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var called bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/state", strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			middleware.Validation(doc)(next).ServeHTTP(rec, req)
+
+			if rec.Code != test.statusCode {
+				t.Fatalf("expected status %d, got: %d, body: %s", test.statusCode, rec.Code, rec.Body)
+			}
+			if called != (test.statusCode == http.StatusOK) {
+				t.Errorf("expected handler to be called: %t, got: %t", test.statusCode == http.StatusOK, called)
+			}
+		})
+	}
+}
+
+// send makes a request relative to the API base path, with the admin API key
+// of the harness.
+func send(t *testing.T, h *apptest.Harness, method, path, body string) (int, []byte) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = bytes.NewReader([]byte(body))
+	}
+
+	req, err := http.NewRequest(method, h.URL+"/api/v1"+path, reader)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.Config.AdminAPIKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("error reading response body: %v", err)
+	}
+
+	return res.StatusCode, data
+}
@@ -0,0 +1,204 @@
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+//go:embed openapi.json docs.html
+var files embed.FS
+
+// Document is the part of the OpenAPI document needed to validate requests,
+// the rest is only served to clients.
+type Document struct {
+	Servers    []Server            `json:"servers"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type PathItem struct {
+	Get    *Operation `json:"get"`
+	Post   *Operation `json:"post"`
+	Put    *Operation `json:"put"`
+	Patch  *Operation `json:"patch"`
+	Delete *Operation `json:"delete"`
+}
+
+// Operations returns the operations of the path by HTTP method.
+func (p *PathItem) Operations() map[string]*Operation {
+	operations := make(map[string]*Operation)
+	for method, operation := range map[string]*Operation{
+		http.MethodGet:    p.Get,
+		http.MethodPost:   p.Post,
+		http.MethodPut:    p.Put,
+		http.MethodPatch:  p.Patch,
+		http.MethodDelete: p.Delete,
+	} {
+		if operation != nil {
+			operations[method] = operation
+		}
+	}
+	return operations
+}
+
+type Operation struct {
+	OperationID string       `json:"operationId"`
+	RequestBody *RequestBody `json:"requestBody"`
+	// MaxBodySize overrides the default limit of the request body size, for
+	// operations that take large documents, like backup imports.
+	MaxBodySize int64 `json:"x-max-body-size"`
+	// Responses are by status code, they are only checked by tests.
+	Responses map[string]*Response `json:"responses"`
+	// Security lists the required schemes, it's only checked by tests.
+	Security []map[string][]string `json:"security"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas   map[string]*Schema   `json:"schemas"`
+	Responses map[string]*Response `json:"responses"`
+}
+
+// Load parses the embedded document.
+func Load() (*Document, error) {
+	data, err := files.ReadFile("openapi.json")
+	if err != nil {
+		return nil, fmt.Errorf("error reading openapi document: %v", err)
+	}
+
+	var d Document
+	err = json.Unmarshal(data, &d)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing openapi document: %v", err)
+	}
+
+	if len(d.Servers) == 0 {
+		return nil, errors.New("openapi document must have a server")
+	}
+
+	return &d, nil
+}
+
+// BasePath is the path all documented paths are relative to.
+func (d *Document) BasePath() string {
+	return strings.TrimSuffix(d.Servers[0].URL, "/")
+}
+
+// Operation finds the operation for the request method and path, nil if it's
+// not documented. The path includes the base path.
+func (d *Document) Operation(method, path string) *Operation {
+	path, ok := strings.CutPrefix(path, d.BasePath())
+	if !ok {
+		return nil
+	}
+	path = trimSlash(path)
+
+	// Literal paths take precedence over templated ones
+	if item, ok := d.Paths[path]; ok {
+		if operation := item.Operations()[method]; operation != nil {
+			return operation
+		}
+	}
+
+	for template, item := range d.Paths {
+		if matchPath(template, path) {
+			if operation := item.Operations()[method]; operation != nil {
+				return operation
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchPath reports whether the path matches the template, where every
+// "{param}" segment matches any single segment.
+func matchPath(template, path string) bool {
+	templateSegments := strings.Split(template, "/")
+	pathSegments := strings.Split(path, "/")
+	if len(templateSegments) != len(pathSegments) {
+		return false
+	}
+
+	for i, segment := range templateSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != pathSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func trimSlash(path string) string {
+	if path == "" || path == "/" {
+		return "/"
+	}
+	return strings.TrimSuffix(path, "/")
+}
+
+// Handler serves the document.
+func Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := files.ReadFile("openapi.json")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		_, err = w.Write(data)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error writing openapi document: %v", err))
+		}
+	}
+}
+
+// DocsHandler serves a page rendering the document. It's self-contained, so
+// that it works without access to a CDN.
+func DocsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := files.ReadFile("docs.html")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		_, err = w.Write(data)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error writing docs page: %v", err))
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	chi "github.com/go-chi/chi/v5"
+)
+
+// CheckRoutes compares the routes under the base path with the documented
+// operations, so that neither can be added or removed without the other.
+func (d *Document) CheckRoutes(routes chi.Routes) error {
+	routed := make(map[string]bool)
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		path, ok := strings.CutPrefix(route, d.BasePath())
+		if !ok {
+			return nil
+		}
+		routed[method+" "+trimSlash(path)] = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error walking routes: %v", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range d.Paths {
+		for method := range item.Operations() {
+			documented[method+" "+trimSlash(path)] = true
+		}
+	}
+
+	var mismatches []string
+	for route := range routed {
+		if !documented[route] {
+			mismatches = append(mismatches, fmt.Sprintf("%s is not documented", route))
+		}
+	}
+	for operation := range documented {
+		if !routed[operation] {
+			mismatches = append(mismatches, fmt.Sprintf("%s is not routed", operation))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("routes don't match openapi document: %s", strings.Join(mismatches, "; "))
+	}
+
+	return nil
+}
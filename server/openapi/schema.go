@@ -0,0 +1,256 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	schemaRefPrefix   = "#/components/schemas/"
+	responseRefPrefix = "#/components/responses/"
+)
+
+// Schema is the subset of JSON Schema used by the document.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 Types              `json:"type"`
+	Enum                 []any              `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *Schema            `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+
+	// never is set for the false schema, which no value matches.
+	never bool
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	// Boolean schemas: true matches anything, false matches nothing
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{never: true}
+		return nil
+	}
+
+	type schema Schema
+	return json.Unmarshal(data, (*schema)(s))
+}
+
+// Types is the schema type, it's either a single type or a list of them.
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*t = Types{single}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(data, &list)
+	if err != nil {
+		return fmt.Errorf("error parsing schema type: %v", err)
+	}
+	*t = list
+
+	return nil
+}
+
+// ValidateRequestBody checks the body against the schema of the operation.
+func (d *Document) ValidateRequestBody(operation *Operation, body []byte) error {
+	if operation.RequestBody == nil {
+		return nil
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if operation.RequestBody.Required {
+			return errors.New("request body is required")
+		}
+		return nil
+	}
+
+	mediaType, ok := operation.RequestBody.Content["application/json"]
+	if !ok || mediaType.Schema == nil {
+		return nil
+	}
+
+	var value any
+	err := json.Unmarshal(body, &value)
+	if err != nil {
+		return fmt.Errorf("error decoding request body: %v", err)
+	}
+
+	return d.validate(mediaType.Schema, value, "")
+}
+
+// ValidateResponseBody checks that the status code is documented for the
+// operation, and that a JSON body matches its schema.
+func (d *Document) ValidateResponseBody(operation *Operation, statusCode int, body []byte) error {
+	response, ok := operation.Responses[strconv.Itoa(statusCode)]
+	if !ok {
+		return fmt.Errorf("status code %d isn't documented for %s", statusCode, operation.OperationID)
+	}
+
+	if ref := response.Ref; ref != "" {
+		response, ok = d.Components.Responses[strings.TrimPrefix(ref, responseRefPrefix)]
+		if !ok {
+			return fmt.Errorf("unknown response reference: %s", ref)
+		}
+	}
+
+	mediaType, ok := response.Content["application/json"]
+	if !ok || mediaType.Schema == nil {
+		return nil
+	}
+
+	var value any
+	err := json.Unmarshal(body, &value)
+	if err != nil {
+		return fmt.Errorf("error decoding response body: %v", err)
+	}
+
+	return d.validate(mediaType.Schema, value, "")
+}
+
+func (d *Document) validate(s *Schema, value any, path string) error {
+	if s.Ref != "" {
+		resolved, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+		if !ok {
+			return fmt.Errorf("unknown schema reference: %s", s.Ref)
+		}
+		return d.validate(resolved, value, path)
+	}
+
+	if s.never {
+		return fmt.Errorf("unknown field: %s", path)
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasType(value, t) }) {
+		return fmt.Errorf("%s must be of type %s", describe(path), strings.Join(s.Type, " or "))
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		return fmt.Errorf("%s must be one of: %v, got: %v", describe(path), s.Enum, value)
+	}
+
+	switch value := value.(type) {
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			return fmt.Errorf("%s must be at least %v, got: %v", describe(path), *s.Minimum, value)
+		}
+		if s.Maximum != nil && value > *s.Maximum {
+			return fmt.Errorf("%s must be at most %v, got: %v", describe(path), *s.Maximum, value)
+		}
+
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				return fmt.Errorf("missing field: %s", join(path, name))
+			}
+		}
+
+		// Sorted, so that the reported error doesn't change between requests
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				property = s.AdditionalProperties
+			}
+			if property == nil {
+				continue
+			}
+
+			err := d.validate(property, value[name], join(path, name))
+			if err != nil {
+				return err
+			}
+		}
+
+	case []any:
+		if s.Items == nil {
+			break
+		}
+		for i, item := range value {
+			err := d.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasType(value any, t string) bool {
+	switch t {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	default:
+		return false
+	}
+}
+
+func inEnum(enum []any, value any) bool {
+	// Only scalars are comparable, enums never list objects or arrays
+	switch value.(type) {
+	case map[string]any, []any:
+		return false
+	}
+
+	for _, allowed := range enum {
+		if allowed == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func describe(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
@@ -1,23 +1,94 @@
 package server
 
 import (
+	"github.com/alexchebotarsky/heatpump-api/server/dashboard"
 	"github.com/alexchebotarsky/heatpump-api/server/handler"
 	"github.com/alexchebotarsky/heatpump-api/server/middleware"
+	"github.com/alexchebotarsky/heatpump-api/server/openapi"
 	chi "github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (s *Server) setupRoutes() {
 	s.Router.Get("/_healthz", handler.Health)
+	s.Router.Get("/_healthz/live", handler.Live)
+	s.Router.Get("/_healthz/ready", handler.Ready(s.Clients.Health))
 	s.Router.Handle("/metrics", promhttp.Handler())
+	s.Router.Handle("/*", dashboard.Handler())
 
 	s.Router.Route(v1API, func(r chi.Router) {
+		r.Use(middleware.Tracing)
 		r.Use(middleware.Metrics)
+		r.Use(middleware.Validation(s.Spec))
 
-		r.Get("/state", handler.GetHeatpumpState(s.Clients.Database))
-		r.Post("/state", handler.UpdateHeatpumpState(s.Clients.Database, s.Clients.PubSub))
+		r.Get("/openapi.json", openapi.Handler())
+		r.Get("/docs", openapi.DocsHandler())
+
+		r.Get("/state", handler.GetHeatpumpState(s.Clients.Heatpump))
+		r.Post("/state", handler.UpdateHeatpumpState(s.Clients.Heatpump))
 
 		r.Get("/temperature-and-humidity", handler.GetTemperatureAndHumidity(s.Clients.Database))
+		r.Get("/temperature-and-humidity/history", handler.GetReadingHistory(s.Clients.Database))
+
+		r.Get("/events", handler.StreamEvents(s.Clients.Events, s.streamsDone))
+
+		r.Get("/outdoor-temperature", handler.GetOutdoorTemperature(s.Clients.Heatpump))
+
+		r.Get("/energy", handler.GetEnergyReport(s.Clients.Energy))
+
+		r.Get("/automation/humidity", handler.GetHumidityAutomation(s.Clients.Humidity))
+
+		r.Get("/open-window", handler.GetOpenWindow(s.Clients.Window))
+
+		r.Get("/presence", handler.GetPresence(s.Clients.Presence))
+		r.Post("/presence/{person}", handler.UpdatePersonPresence(s.Clients.Presence))
+
+		r.Get("/notifications", handler.GetNotificationHistory(s.Clients.Alerts))
+
+		// Alerts, rules and webhooks send data to external URLs or MQTT topics,
+		// so they are admin only
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Admin(s.AdminAPIKey))
+
+			r.Route("/rules", func(r chi.Router) {
+				r.Get("/", handler.GetRules(s.Clients.Rules))
+				r.Post("/", handler.CreateRule(s.Clients.Rules))
+				r.Get("/{id}", handler.GetRule(s.Clients.Rules))
+				r.Put("/{id}", handler.UpdateRule(s.Clients.Rules))
+				r.Delete("/{id}", handler.DeleteRule(s.Clients.Rules))
+				r.Post("/{id}/test", handler.TestRule(s.Clients.Rules))
+			})
+
+			r.Route("/alerts", func(r chi.Router) {
+				r.Get("/", handler.GetAlerts(s.Clients.Alerts))
+				r.Post("/", handler.CreateAlert(s.Clients.Alerts))
+				r.Get("/{id}", handler.GetAlert(s.Clients.Alerts))
+				r.Put("/{id}", handler.UpdateAlert(s.Clients.Alerts))
+				r.Delete("/{id}", handler.DeleteAlert(s.Clients.Alerts))
+			})
+
+			r.Post("/notifications/test", handler.TestNotification(s.Clients.Alerts))
+
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Get("/", handler.GetWebhooks(s.Clients.Webhooks))
+				r.Post("/", handler.CreateWebhook(s.Clients.Webhooks))
+				r.Get("/{id}", handler.GetWebhook(s.Clients.Webhooks))
+				r.Put("/{id}", handler.UpdateWebhook(s.Clients.Webhooks))
+				r.Delete("/{id}", handler.DeleteWebhook(s.Clients.Webhooks))
+				r.Get("/{id}/deliveries", handler.GetWebhookDeliveries(s.Clients.Webhooks))
+			})
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(middleware.Admin(s.AdminAPIKey))
+
+			r.Get("/export", handler.ExportBackup(s.Clients.Backup))
+			r.Post("/import", handler.ImportBackup(s.Clients.Backup))
+
+			r.Get("/dead-letters", handler.GetDeadLetters(s.Clients.Database))
+			r.Post("/dead-letters/{id}/replay", handler.ReplayDeadLetter(s.Clients.Database, s.Clients.PubSub))
+			r.Delete("/dead-letters/{id}", handler.DeleteDeadLetter(s.Clients.Database))
+		})
 	})
 }
 
@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/alexchebotarsky/heatpump-api/server/handler"
+	"github.com/alexchebotarsky/heatpump-api/server/openapi"
 	chi "github.com/go-chi/chi/v5"
 )
 
@@ -17,25 +19,90 @@ type Server struct {
 	Router  chi.Router
 	HTTP    *http.Server
 	Clients Clients
+	Spec    *openapi.Document
+
+	// AdminAPIKey is required as a bearer token by the admin routes.
+	AdminAPIKey string
+
+	// streamsDone is closed on shutdown, so that event streams don't keep it
+	// waiting.
+	streamsDone chan struct{}
 }
 
 type Clients struct {
 	Database Database
 	PubSub   PubSub
+	Heatpump HeatpumpService
+	Health   handler.ReadinessChecker
+	Energy   handler.EnergyReportFetcher
+	Humidity handler.HumidityAutomationFetcher
+	Rules    RulesService
+	Window   handler.OpenWindowFetcher
+	Presence PresenceService
+	Webhooks WebhooksService
+	Alerts   AlertsService
+	Backup   BackupService
+	Events   handler.EventSubscriber
 }
 
 type Database interface {
-	handler.HeatpumpStateFetcher
-	handler.HeatpumpStateUpdater
 	handler.TemperatureAndHumidityFetcher
+	handler.ReadingHistoryFetcher
+	handler.DeadLettersFetcher
+	handler.DeadLetterReplayStore
 }
 
 type PubSub interface {
-	handler.IRTransmitter
+	handler.MessagePublisher
+}
+
+type PresenceService interface {
+	handler.PresenceFetcher
+	handler.PersonPresenceUpdater
+}
+
+type RulesService interface {
+	handler.RulesFetcher
+	handler.RuleFetcher
+	handler.RuleCreator
+	handler.RuleUpdater
+	handler.RuleDeleter
+	handler.RuleTester
+}
+
+type AlertsService interface {
+	handler.AlertsFetcher
+	handler.AlertFetcher
+	handler.AlertCreator
+	handler.AlertUpdater
+	handler.AlertDeleter
+	handler.NotificationHistoryFetcher
+	handler.NotificationTester
+}
+
+type WebhooksService interface {
+	handler.WebhooksFetcher
+	handler.WebhookFetcher
+	handler.WebhookCreator
+	handler.WebhookUpdater
+	handler.WebhookDeleter
+	handler.WebhookDeliveriesFetcher
+}
+
+type BackupService interface {
+	handler.BackupExporter
+	handler.BackupImporter
 }
 
-func New(host string, port uint16, clients Clients) *Server {
+type HeatpumpService interface {
+	handler.HeatpumpStateFetcher
+	handler.HeatpumpStateUpdater
+	handler.OutdoorTemperatureFetcher
+}
+
+func New(host string, port uint16, adminAPIKey string, clients Clients) (*Server, error) {
 	var s Server
+	var err error
 
 	s.Host = host
 	s.Port = port
@@ -47,15 +114,35 @@ func New(host string, port uint16, clients Clients) *Server {
 		WriteTimeout: 5 * time.Second,
 	}
 	s.Clients = clients
+	s.AdminAPIKey = adminAPIKey
+	s.streamsDone = make(chan struct{})
+	s.HTTP.RegisterOnShutdown(func() { close(s.streamsDone) })
+
+	s.Spec, err = openapi.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading openapi document: %v", err)
+	}
 
 	s.setupRoutes()
 
-	return &s
+	err = s.Spec.CheckRoutes(s.Router)
+	if err != nil {
+		return nil, fmt.Errorf("error checking routes: %v", err)
+	}
+
+	return &s, nil
 }
 
 func (s *Server) Start(ctx context.Context, errc chan<- error) {
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "tcp", s.HTTP.Addr)
+	if err != nil {
+		errc <- fmt.Errorf("error listening: %v", err)
+		return
+	}
+
 	slog.Info(fmt.Sprintf("Server is listening at %s:%d", s.Host, s.Port))
-	err := s.HTTP.ListenAndServe()
+	err = s.HTTP.Serve(listener)
 	if err != http.ErrServerClosed {
 		errc <- fmt.Errorf("error listening and serving: %v", err)
 	}
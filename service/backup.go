@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+	"github.com/alexchebotarsky/heatpump-api/model/backup"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+// Backup exports and imports all stored data as archives, and writes them as
+// snapshots to a local directory while running.
+type Backup struct {
+	Database BackupDatabase
+	Config   BackupConfig
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	// snapshotMu serializes snapshots, so that rotation doesn't race.
+	snapshotMu sync.Mutex
+}
+
+type BackupConfig struct {
+	// SnapshotDir is where snapshots are written, they are disabled if it's
+	// empty.
+	SnapshotDir      string
+	SnapshotInterval time.Duration
+	// SnapshotKeep is how many of the latest snapshots are kept, older ones
+	// are removed.
+	SnapshotKeep int
+}
+
+func (c *BackupConfig) Validate() error {
+	if c.SnapshotDir == "" {
+		return nil
+	}
+
+	if c.SnapshotInterval <= 0 {
+		return errors.New("snapshot interval must be positive")
+	}
+
+	if c.SnapshotKeep < 1 {
+		return fmt.Errorf("snapshot keep must be at least 1, got: %d", c.SnapshotKeep)
+	}
+
+	return nil
+}
+
+type BackupDatabase interface {
+	ExportData(ctx context.Context) (map[string]string, error)
+	ReplaceData(ctx context.Context, fn func(data map[string]string) (map[string]string, error)) error
+}
+
+const (
+	snapshotPrefix = "heatpump-api-backup-"
+	snapshotSuffix = ".json"
+	// snapshotTimeFormat has a fixed width, so that snapshots sort by name in
+	// the order they were taken.
+	snapshotTimeFormat = "20060102T150405.000Z"
+	// preImportSuffix marks the snapshots taken right before an import.
+	preImportSuffix = "-pre-import"
+)
+
+func NewBackup(database BackupDatabase, config BackupConfig) *Backup {
+	var b Backup
+
+	b.Database = database
+	b.Config = config
+
+	return &b
+}
+
+func (b *Backup) ExportBackup(ctx context.Context) (_ *backup.Archive, err error) {
+	ctx, span := tracing.Start(ctx, "service.ExportBackup")
+	defer func() { tracing.End(span, err) }()
+
+	data, err := b.Database.ExportData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting data: %v", err)
+	}
+
+	return backup.NewArchive(data, time.Now()), nil
+}
+
+// ImportBackup applies the archive to the stored data with the mode, a dry
+// run only reports what would change. With snapshots enabled, the data is
+// snapshotted right before it's changed, so that an import can be undone.
+func (b *Backup) ImportBackup(ctx context.Context, archive *backup.Archive, mode backup.ImportMode, dryRun bool) (_ *backup.ImportResult, err error) {
+	ctx, span := tracing.Start(ctx, "service.ImportBackup")
+	defer func() { tracing.End(span, err) }()
+
+	err = archive.Validate()
+	if err != nil {
+		return nil, &ErrInvalid{Err: fmt.Errorf("invalid archive: %v", err)}
+	}
+
+	result := backup.ImportResult{
+		Mode:   mode,
+		DryRun: dryRun,
+	}
+
+	err = b.Database.ReplaceData(ctx, func(current map[string]string) (map[string]string, error) {
+		data, err := archive.Apply(current, mode)
+		if err != nil {
+			return nil, &ErrInvalid{Err: err}
+		}
+
+		result.Compare(current, data)
+
+		if dryRun {
+			return nil, nil
+		}
+
+		if b.Config.SnapshotDir != "" {
+			_, err := b.writeSnapshot(backup.NewArchive(current, time.Now()), preImportSuffix)
+			if err != nil {
+				return nil, fmt.Errorf("error taking pre-import snapshot: %v", err)
+			}
+		}
+
+		return data, nil
+	})
+	if err != nil {
+		var errInvalid *client.ErrInvalid
+		if errors.As(err, &errInvalid) {
+			return nil, &ErrInvalid{Err: fmt.Errorf("invalid archive data: %v", err)}
+		}
+		return nil, err
+	}
+
+	if !dryRun {
+		slog.Info(fmt.Sprintf("Imported backup from %s in %s mode: %d added, %d updated, %d removed", archive.CreatedAt.Local().Format(time.DateTime), mode, len(result.Added), len(result.Updated), len(result.Removed)))
+	}
+
+	return &result, nil
+}
+
+// Snapshot writes the current data to a new snapshot, and removes the oldest
+// ones beyond the number to keep. It returns the snapshot filename.
+func (b *Backup) Snapshot(ctx context.Context) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "service.Snapshot")
+	defer func() { tracing.End(span, err) }()
+
+	archive, err := b.ExportBackup(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return b.writeSnapshot(archive, "")
+}
+
+func (b *Backup) writeSnapshot(archive *backup.Archive, suffix string) (string, error) {
+	b.snapshotMu.Lock()
+	defer b.snapshotMu.Unlock()
+
+	err := os.MkdirAll(b.Config.SnapshotDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("error creating snapshot dir: %v", err)
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding archive: %v", err)
+	}
+
+	name := snapshotPrefix + archive.CreatedAt.Format(snapshotTimeFormat) + suffix + snapshotSuffix
+	filename := filepath.Join(b.Config.SnapshotDir, name)
+
+	// Written to a temporary file first, so that a crash never leaves a
+	// partial snapshot behind
+	file, err := os.CreateTemp(b.Config.SnapshotDir, "."+name+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("error creating snapshot file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return "", fmt.Errorf("error writing snapshot file: %v", err)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return "", fmt.Errorf("error syncing snapshot file: %v", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return "", fmt.Errorf("error closing snapshot file: %v", err)
+	}
+
+	err = os.Rename(file.Name(), filename)
+	if err != nil {
+		return "", fmt.Errorf("error renaming snapshot file: %v", err)
+	}
+
+	err = b.rotateSnapshots()
+	if err != nil {
+		return "", fmt.Errorf("error rotating snapshots: %v", err)
+	}
+
+	return filename, nil
+}
+
+// rotateSnapshots removes the oldest snapshots beyond the number to keep,
+// pre-import snapshots included.
+func (b *Backup) rotateSnapshots() error {
+	names, err := b.snapshots()
+	if err != nil {
+		return err
+	}
+
+	if len(names) <= b.Config.SnapshotKeep {
+		return nil
+	}
+
+	for _, name := range names[:len(names)-b.Config.SnapshotKeep] {
+		err := os.Remove(filepath.Join(b.Config.SnapshotDir, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing snapshot %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// snapshots returns the snapshot filenames in the snapshot dir, oldest first.
+func (b *Backup) snapshots() ([]string, error) {
+	entries, err := os.ReadDir(b.Config.SnapshotDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot dir: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotSuffix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// lastSnapshotAt returns when the latest snapshot was taken, or the zero time
+// if there are none yet.
+func (b *Backup) lastSnapshotAt() (time.Time, error) {
+	names, err := b.snapshots()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(names) == 0 {
+		return time.Time{}, nil
+	}
+
+	info, err := os.Stat(filepath.Join(b.Config.SnapshotDir, names[len(names)-1]))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting latest snapshot info: %v", err)
+	}
+
+	return info.ModTime(), nil
+}
+
+func (b *Backup) Start(ctx context.Context, errc chan<- error) {
+	b.mu.Lock()
+	ctx, b.cancel = context.WithCancel(ctx)
+	b.mu.Unlock()
+
+	err := os.MkdirAll(b.Config.SnapshotDir, 0755)
+	if err != nil {
+		errc <- fmt.Errorf("error creating snapshot dir: %v", err)
+		return
+	}
+
+	// Restarts continue the schedule, instead of taking a snapshot every time
+	last, err := b.lastSnapshotAt()
+	if err != nil {
+		errc <- fmt.Errorf("error checking latest snapshot: %v", err)
+		return
+	}
+
+	timer := time.NewTimer(max(time.Until(last.Add(b.Config.SnapshotInterval)), 0))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			filename, err := b.Snapshot(ctx)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error taking snapshot: %v", err))
+			} else {
+				slog.Debug(fmt.Sprintf("Snapshot is written to %s", filename))
+			}
+			timer.Reset(b.Config.SnapshotInterval)
+		}
+	}
+}
+
+func (b *Backup) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cancel != nil {
+		b.cancel()
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client/database"
+	"github.com/alexchebotarsky/heatpump-api/model/backup"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/rule"
+)
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	source := newTestDatabase(t)
+	mode := heatpump.CoolMode
+	targetTemperature := 19
+	_, err := source.UpdateHeatpumpState(ctx, &heatpump.State{Mode: &mode, TargetTemperature: &targetTemperature})
+	if err != nil {
+		t.Fatalf("error updating state: %v", err)
+	}
+	err = source.AddRule(ctx, &rule.Rule{
+		ID:      "evening",
+		Name:    "evening",
+		Enabled: true,
+		Trigger: rule.Trigger{Type: rule.MQTTTrigger, Topic: "home/door"},
+		Actions: []rule.Action{{Type: rule.PublishAction, Topic: "home/lights", Payload: "on"}},
+	})
+	if err != nil {
+		t.Fatalf("error adding rule: %v", err)
+	}
+
+	archive, err := NewBackup(source, BackupConfig{}).ExportBackup(ctx)
+	if err != nil {
+		t.Fatalf("error exporting backup: %v", err)
+	}
+
+	// The archive goes through JSON, like a downloaded export
+	data, err := json.Marshal(archive)
+	if err != nil {
+		t.Fatalf("error marshalling archive: %v", err)
+	}
+	var decoded backup.Archive
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatalf("error unmarshalling archive: %v", err)
+	}
+
+	target := newTestDatabase(t)
+	snapshotDir := t.TempDir()
+	b := NewBackup(target, BackupConfig{SnapshotDir: snapshotDir, SnapshotInterval: time.Hour, SnapshotKeep: 5})
+
+	dryRun, err := b.ImportBackup(ctx, &decoded, backup.ReplaceMode, true)
+	if err != nil {
+		t.Fatalf("error importing backup as dry run: %v", err)
+	}
+	if len(dryRun.Added) == 0 && len(dryRun.Updated) == 0 {
+		t.Errorf("expected dry run to report changes, got: %+v", dryRun)
+	}
+	state, err := target.FetchHeatpumpState(ctx)
+	if err != nil {
+		t.Fatalf("error fetching state: %v", err)
+	}
+	if *state.Mode != heatpump.HeatMode {
+		t.Errorf("expected dry run to leave the state alone, got mode: %s", *state.Mode)
+	}
+
+	_, err = b.ImportBackup(ctx, &decoded, backup.ReplaceMode, false)
+	if err != nil {
+		t.Fatalf("error importing backup: %v", err)
+	}
+
+	restored, err := target.ExportData(ctx)
+	if err != nil {
+		t.Fatalf("error exporting restored data: %v", err)
+	}
+	if !maps.Equal(restored, archive.Data) {
+		t.Errorf("expected restored data to equal the exported data, got: %v, want: %v", restored, archive.Data)
+	}
+
+	state, err = target.FetchHeatpumpState(ctx)
+	if err != nil {
+		t.Fatalf("error fetching state: %v", err)
+	}
+	if *state.Mode != heatpump.CoolMode || *state.TargetTemperature != 19 {
+		t.Errorf("expected restored state, got mode %s, targetTemperature %d", *state.Mode, *state.TargetTemperature)
+	}
+
+	r, err := target.FetchRule(ctx, "evening")
+	if err != nil {
+		t.Fatalf("expected restored rule, got: %v", err)
+	}
+	if r.Actions[0].Topic != "home/lights" {
+		t.Errorf("expected restored rule action, got: %+v", r.Actions)
+	}
+
+	entries, err := os.ReadDir(snapshotDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.Contains(entries[0].Name(), preImportSuffix) {
+		t.Errorf("expected a single pre-import snapshot, got: %v", entries)
+	}
+}
+
+func TestBackupRejectsInvalidArchives(t *testing.T) {
+	b := NewBackup(newTestDatabase(t), BackupConfig{})
+
+	tests := []struct {
+		name    string
+		archive backup.Archive
+	}{
+		{"wrong format", backup.Archive{Format: "other", Version: backup.Version, Data: map[string]string{}}},
+		{"newer version", backup.Archive{Format: backup.Format, Version: backup.Version + 1, Data: map[string]string{}}},
+		{"invalid state", *backup.NewArchive(map[string]string{database.ModeKey: "TURBO"}, time.Now())},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := b.ImportBackup(context.Background(), &test.archive, backup.MergeMode, false)
+			var errInvalid *ErrInvalid
+			if !errors.As(err, &errInvalid) {
+				t.Errorf("expected invalid archive error, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/energy"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+// Energy estimates energy consumption from the state history, and meters it
+// into Prometheus counters while running.
+type Energy struct {
+	Database EnergyDatabase
+	Config   energy.Config
+	Interval time.Duration
+	// OutdoorTemperatureMaxAge is how long a reading is used before falling
+	// back to the model's outdoor temperature.
+	OutdoorTemperatureMaxAge time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+type EnergyDatabase interface {
+	FetchHeatpumpState(ctx context.Context) (*heatpump.State, error)
+	FetchStateHistory(ctx context.Context, from time.Time) ([]heatpump.StateChange, error)
+	AddStateChange(ctx context.Context, change *heatpump.StateChange) error
+	FetchMeasuredPower(ctx context.Context) (power float64, measuredAt time.Time, err error)
+	FetchEnergyCalibrationFactor(ctx context.Context) (float64, error)
+	UpdateEnergyCalibrationFactor(ctx context.Context, factor float64) error
+	FetchOutdoorTemperature(ctx context.Context) (temperature float64, updatedAt time.Time, err error)
+}
+
+// calibrationWeight is how much a single smart plug reading moves the
+// calibration factor.
+const calibrationWeight = 0.1
+
+func NewEnergy(database EnergyDatabase, config energy.Config, interval, outdoorTemperatureMaxAge time.Duration) *Energy {
+	var e Energy
+
+	e.Database = database
+	e.Config = config
+	e.Interval = interval
+	e.OutdoorTemperatureMaxAge = outdoorTemperatureMaxAge
+
+	return &e
+}
+
+func (e *Energy) FetchEnergyReport(ctx context.Context, period energy.Period) (_ *energy.Report, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchEnergyReport")
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now()
+	from, err := period.Start(now)
+	if err != nil {
+		return nil, &ErrInvalid{Err: err}
+	}
+
+	history, err := e.Database.FetchStateHistory(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching state history: %v", err)
+	}
+
+	factor, err := e.Database.FetchEnergyCalibrationFactor(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching calibration factor: %v", err)
+	}
+
+	// Outdoor temperature history isn't kept, so the whole period is
+	// estimated with the current one
+	outdoorTemperature, err := e.outdoorTemperature(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("error getting outdoor temperature: %v", err)
+	}
+
+	report := energy.Report{
+		Period:            period,
+		From:              from,
+		To:                now,
+		Currency:          e.Config.Tariff.Currency,
+		CalibrationFactor: factor,
+		Runtime:           make(map[heatpump.Mode]float64),
+	}
+
+	for i, change := range history {
+		start := change.Time
+		if start.Before(from) {
+			start = from
+		}
+
+		end := now
+		if i+1 < len(history) {
+			end = history[i+1].Time
+		}
+
+		// Integrate minute by minute, so that tariff changes are accounted for
+		for t := start; t.Before(end); {
+			next := t.Truncate(time.Minute).Add(time.Minute)
+			if next.After(end) {
+				next = end
+			}
+			duration := next.Sub(t)
+
+			kWh := e.power(&change.State, outdoorTemperature) * factor * duration.Hours() / 1000
+			report.EnergyKWh += kWh
+			report.Cost += kWh * e.Config.Tariff.PriceAt(t)
+			report.Runtime[*change.Mode] += duration.Seconds()
+
+			t = next
+		}
+	}
+
+	return &report, nil
+}
+
+func (e *Energy) power(state *heatpump.State, outdoorTemperature float64) float64 {
+	return e.Config.Model.Power(*state.Mode, *state.FanSpeed, outdoorTemperature)
+}
+
+// outdoorTemperature returns the measured outdoor temperature if it's fresh,
+// or the model's default one otherwise.
+func (e *Energy) outdoorTemperature(ctx context.Context, now time.Time) (float64, error) {
+	temperature, updatedAt, err := e.Database.FetchOutdoorTemperature(ctx)
+	if err != nil {
+		if isNotFound(err) {
+			return e.Config.Model.OutdoorTemperature, nil
+		}
+		return 0, fmt.Errorf("error fetching outdoor temperature: %v", err)
+	}
+
+	if now.Sub(updatedAt) > e.OutdoorTemperatureMaxAge {
+		return e.Config.Model.OutdoorTemperature, nil
+	}
+
+	return temperature, nil
+}
+
+func (e *Energy) Start(ctx context.Context, errc chan<- error) {
+	e.mu.Lock()
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.mu.Unlock()
+
+	err := e.ensureHistoryBaseline(ctx)
+	if err != nil {
+		errc <- fmt.Errorf("error ensuring state history baseline: %v", err)
+		return
+	}
+
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := e.meter(ctx, now.Sub(last), now)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error metering energy: %v", err))
+			}
+			last = now
+		}
+	}
+}
+
+func (e *Energy) Stop(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.cancel != nil {
+		e.cancel()
+	}
+
+	return nil
+}
+
+// ensureHistoryBaseline records the current state if the history is empty,
+// so that the time before the first change is accounted for.
+func (e *Energy) ensureHistoryBaseline(ctx context.Context) error {
+	history, err := e.Database.FetchStateHistory(ctx, time.Time{})
+	if err != nil {
+		return fmt.Errorf("error fetching state history: %v", err)
+	}
+
+	if len(history) > 0 {
+		return nil
+	}
+
+	state, err := e.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching heatpump state: %v", err)
+	}
+
+	err = e.Database.AddStateChange(ctx, &heatpump.StateChange{
+		Time:   time.Now(),
+		Source: heatpump.InitialSource,
+		State:  *state,
+	})
+	if err != nil {
+		return fmt.Errorf("error adding initial state change: %v", err)
+	}
+
+	return nil
+}
+
+func (e *Energy) meter(ctx context.Context, elapsed time.Duration, now time.Time) error {
+	state, err := e.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching heatpump state: %v", err)
+	}
+
+	factor, err := e.Database.FetchEnergyCalibrationFactor(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching calibration factor: %v", err)
+	}
+
+	outdoorTemperature, err := e.outdoorTemperature(ctx, now)
+	if err != nil {
+		return fmt.Errorf("error getting outdoor temperature: %v", err)
+	}
+
+	estimatedPower := e.power(state, outdoorTemperature)
+
+	measuredPower, measuredAt, err := e.Database.FetchMeasuredPower(ctx)
+	if err == nil && now.Sub(measuredAt) <= 2*e.Interval {
+		metrics.AddEnergyMeasured(measuredPower * elapsed.Hours() / 1000)
+
+		// Standby draw is too small to calibrate the model reliably
+		if estimatedPower > 2*e.Config.Model.StandbyPower {
+			factor = (1-calibrationWeight)*factor + calibrationWeight*measuredPower/estimatedPower
+
+			err = e.Database.UpdateEnergyCalibrationFactor(ctx, factor)
+			if err != nil {
+				return fmt.Errorf("error updating calibration factor: %v", err)
+			}
+		}
+	}
+
+	kWh := estimatedPower * factor * elapsed.Hours() / 1000
+	metrics.SetEnergyEstimatedPower(estimatedPower * factor)
+	metrics.AddEnergyEstimated(kWh, kWh*e.Config.Tariff.PriceAt(now))
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/alexchebotarsky/heatpump-api/client"
+)
+
+type ErrInvalid struct {
+	Err error
+}
+
+func (e *ErrInvalid) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ErrInvalid) Unwrap() error {
+	return e.Err
+}
+
+func isNotFound(err error) bool {
+	var errNotFound *client.ErrNotFound
+	return errors.As(err, &errNotFound)
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/weather"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+type Heatpump struct {
+	Database Database
+	PubSub   PubSub
+	Events   EventPublisher
+
+	Compensation weather.CompensationCurve
+	// OutdoorTemperatureMaxAge disables compensation if the outdoor
+	// temperature hasn't been updated for longer.
+	OutdoorTemperatureMaxAge time.Duration
+
+	mu                 sync.Mutex
+	compensationOffset int
+
+	// stateMu serializes state updates and transmissions, so that the stored,
+	// transmitted and published states are always the same one.
+	stateMu sync.Mutex
+}
+
+type Database interface {
+	FetchHeatpumpState(ctx context.Context) (*heatpump.State, error)
+	UpdateHeatpumpState(ctx context.Context, state *heatpump.State) (*heatpump.State, error)
+	UpdateIRTransmittedAt(ctx context.Context, transmittedAt time.Time) error
+	AddStateChange(ctx context.Context, change *heatpump.StateChange) error
+	FetchOutdoorTemperature(ctx context.Context) (temperature float64, updatedAt time.Time, err error)
+	UpdateOutdoorTemperature(ctx context.Context, temperature float64) error
+}
+
+type EventPublisher interface {
+	Publish(event bus.Event)
+}
+
+type PubSub interface {
+	TransmitIRSignal(ctx context.Context, binaryString string) error
+	PublishHeatpumpState(ctx context.Context, state *heatpump.State) error
+}
+
+func NewHeatpump(database Database, pubsub PubSub, events EventPublisher, compensation weather.CompensationCurve, outdoorTemperatureMaxAge time.Duration) *Heatpump {
+	var h Heatpump
+
+	h.Database = database
+	h.PubSub = pubsub
+	h.Events = events
+	h.Compensation = compensation
+	h.OutdoorTemperatureMaxAge = outdoorTemperatureMaxAge
+
+	return &h
+}
+
+func (h *Heatpump) FetchHeatpumpState(ctx context.Context) (_ *heatpump.State, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchHeatpumpState")
+	defer func() { tracing.End(span, err) }()
+
+	state, err := h.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching heatpump state: %v", err)
+	}
+
+	return state, nil
+}
+
+// UpdateHeatpumpState validates and stores the partial state, transmits the
+// resulting full state to the heatpump and announces it to other consumers.
+func (h *Heatpump) UpdateHeatpumpState(ctx context.Context, source heatpump.Source, state *heatpump.State) (_ *heatpump.State, err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateHeatpumpState")
+	defer func() { tracing.End(span, err) }()
+
+	err = state.Validate()
+	if err != nil {
+		return nil, &ErrInvalid{Err: fmt.Errorf("error validating heatpump state: %v", err)}
+	}
+
+	h.stateMu.Lock()
+	defer h.stateMu.Unlock()
+
+	previousState, err := h.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching previous heatpump state: %v", err)
+	}
+
+	updatedState, err := h.Database.UpdateHeatpumpState(ctx, state)
+	if err != nil {
+		return nil, fmt.Errorf("error updating heatpump state: %v", err)
+	}
+
+	fields := changedFields(previousState, updatedState)
+	for _, field := range fields {
+		metrics.AddStateChange(source, field)
+	}
+
+	if len(fields) > 0 {
+		change := heatpump.StateChange{
+			Time:   time.Now(),
+			Source: source,
+			State:  *updatedState,
+		}
+
+		err = h.Database.AddStateChange(ctx, &change)
+		if err != nil {
+			return nil, fmt.Errorf("error adding state change to history: %v", err)
+		}
+
+		h.Events.Publish(bus.Event{
+			Type: bus.StateChangeEvent,
+			Time: change.Time,
+			Data: bus.StateChange{StateChange: change, Fields: fields},
+		})
+	}
+
+	err = h.transmit(ctx, updatedState)
+	if err != nil {
+		return nil, fmt.Errorf("error transmitting heatpump state: %v", err)
+	}
+
+	err = h.PubSub.PublishHeatpumpState(ctx, updatedState)
+	if err != nil {
+		return nil, fmt.Errorf("error publishing heatpump state: %v", err)
+	}
+
+	return updatedState, nil
+}
+
+// transmit sends the state to the heatpump, with the target temperature
+// adjusted by weather compensation in HEAT mode.
+func (h *Heatpump) transmit(ctx context.Context, state *heatpump.State) error {
+	transmittedState := *state
+
+	offset, err := h.currentCompensationOffset(ctx, *state.Mode)
+	if err != nil {
+		return fmt.Errorf("error getting weather compensation offset: %v", err)
+	}
+
+	if offset != 0 {
+		targetTemperature := min(max(*state.TargetTemperature+offset, heatpump.MinTargetTemperature), heatpump.MaxTargetTemperature)
+		transmittedState.TargetTemperature = &targetTemperature
+	}
+
+	binaryString, err := transmittedState.ToBinary()
+	if err != nil {
+		return fmt.Errorf("error converting heatpump state to binary: %v", err)
+	}
+
+	err = h.PubSub.TransmitIRSignal(ctx, binaryString)
+	if err != nil {
+		metrics.AddIRTransmission("ERR")
+		h.Events.Publish(bus.Event{
+			Type: bus.TransmissionFailedEvent,
+			Data: bus.TransmissionFailed{State: transmittedState, Error: err.Error()},
+		})
+		return fmt.Errorf("error publishing binary heatpump state: %v", err)
+	}
+	metrics.AddIRTransmission("OK")
+
+	err = h.Database.UpdateIRTransmittedAt(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("error updating transmission time: %v", err)
+	}
+
+	return nil
+}
+
+// currentCompensationOffset returns the offset applied in the given mode.
+// Compensation curve is a heating curve, so it's only applied in HEAT mode,
+// AUTO mode is left to the heatpump's own logic.
+func (h *Heatpump) currentCompensationOffset(ctx context.Context, mode heatpump.Mode) (int, error) {
+	if !h.Compensation.Enabled() {
+		return 0, nil
+	}
+
+	var offset int
+	if mode == heatpump.HeatMode {
+		temperature, updatedAt, err := h.Database.FetchOutdoorTemperature(ctx)
+		if err != nil && !isNotFound(err) {
+			return 0, fmt.Errorf("error fetching outdoor temperature: %v", err)
+		}
+
+		if err == nil && time.Since(updatedAt) <= h.OutdoorTemperatureMaxAge {
+			offset = h.Compensation.Offset(temperature)
+		}
+	}
+
+	h.mu.Lock()
+	h.compensationOffset = offset
+	h.mu.Unlock()
+	metrics.SetWeatherCompensationOffset(offset)
+
+	return offset, nil
+}
+
+type OutdoorTemperature struct {
+	Temperature float64   `json:"temperature"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// CompensationOffset is added to the target temperature sent to the
+	// heatpump, it's always 0 outside of HEAT mode.
+	CompensationOffset int `json:"compensationOffset"`
+}
+
+func (h *Heatpump) FetchOutdoorTemperature(ctx context.Context) (_ *OutdoorTemperature, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchOutdoorTemperature")
+	defer func() { tracing.End(span, err) }()
+
+	temperature, updatedAt, err := h.Database.FetchOutdoorTemperature(ctx)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error fetching outdoor temperature: %v", err)
+	}
+
+	state, err := h.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching heatpump state: %v", err)
+	}
+
+	offset, err := h.currentCompensationOffset(ctx, *state.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("error getting weather compensation offset: %v", err)
+	}
+
+	return &OutdoorTemperature{
+		Temperature:        temperature,
+		UpdatedAt:          updatedAt,
+		CompensationOffset: offset,
+	}, nil
+}
+
+// UpdateOutdoorTemperature stores the reading and re-transmits the state if
+// weather compensation offset has changed because of it.
+func (h *Heatpump) UpdateOutdoorTemperature(ctx context.Context, temperature float64) (err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateOutdoorTemperature")
+	defer func() { tracing.End(span, err) }()
+
+	err = h.Database.UpdateOutdoorTemperature(ctx, temperature)
+	if err != nil {
+		return fmt.Errorf("error updating outdoor temperature: %v", err)
+	}
+
+	h.Events.Publish(bus.Event{
+		Type: bus.ReadingEvent,
+		Data: bus.Reading{Sensor: heatpump.OutdoorTemperatureSensor, Value: temperature},
+	})
+
+	if !h.Compensation.Enabled() {
+		return nil
+	}
+
+	h.mu.Lock()
+	previousOffset := h.compensationOffset
+	h.mu.Unlock()
+
+	if h.Compensation.Offset(temperature) == previousOffset {
+		return nil
+	}
+
+	h.stateMu.Lock()
+	defer h.stateMu.Unlock()
+
+	state, err := h.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching heatpump state: %v", err)
+	}
+
+	// Offset isn't applied outside of HEAT mode, there's nothing to update
+	if *state.Mode != heatpump.HeatMode {
+		return nil
+	}
+
+	slog.Info("Weather compensation offset has changed, transmitting heatpump state", "outdoorTemperature", temperature)
+
+	err = h.transmit(ctx, state)
+	if err != nil {
+		return fmt.Errorf("error transmitting compensated heatpump state: %v", err)
+	}
+
+	return nil
+}
+
+func changedFields(previous, current *heatpump.State) []string {
+	var fields []string
+
+	if *previous.Mode != *current.Mode {
+		fields = append(fields, "mode")
+	}
+
+	if *previous.TargetTemperature != *current.TargetTemperature {
+		fields = append(fields, "targetTemperature")
+	}
+
+	if *previous.FanSpeed != *current.FanSpeed {
+		fields = append(fields, "fanSpeed")
+	}
+
+	return fields
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/clock"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/humidity"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+// HumidityAutomation switches the heatpump to dehumidify when humidity stays
+// above the high threshold, and restores the previous state once it drops
+// below the low threshold.
+type HumidityAutomation struct {
+	Database HumidityAutomationDatabase
+	Heatpump HeatpumpStateUpdater
+	Config   HumidityAutomationConfig
+
+	// configMu guards Config, which can be updated while running.
+	configMu sync.RWMutex
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	// lastSkipReason avoids recording the same skip on every check.
+	lastSkipReason string
+}
+
+type HumidityAutomationConfig struct {
+	Enabled       bool
+	HighThreshold float64
+	LowThreshold  float64
+	// SustainFor is how long humidity has to stay above the high threshold.
+	SustainFor time.Duration
+	Mode       heatpump.Mode
+	FanSpeed   int
+	// QuietHours prevent activation, restoring is still allowed.
+	QuietHours *clock.Window
+	// ManualOverrideFor is how long a manual state change blocks activation.
+	ManualOverrideFor time.Duration
+	// SensorMaxAge is how old a humidity reading can be to be acted upon.
+	SensorMaxAge  time.Duration
+	CheckInterval time.Duration
+}
+
+func (c *HumidityAutomationConfig) Validate() error {
+	if c.LowThreshold >= c.HighThreshold {
+		return fmt.Errorf("low threshold must be below high threshold, got: %g and %g", c.LowThreshold, c.HighThreshold)
+	}
+
+	if c.Mode != heatpump.DryMode && c.Mode != heatpump.CoolMode {
+		return fmt.Errorf("mode must be one of: [%s, %s], got: %s", heatpump.DryMode, heatpump.CoolMode, c.Mode)
+	}
+
+	if c.FanSpeed < 0 || c.FanSpeed > 100 {
+		return fmt.Errorf("fan speed must be in range [0,100]. got: %d", c.FanSpeed)
+	}
+
+	if c.CheckInterval <= 0 {
+		return errors.New("check interval must be positive")
+	}
+
+	return nil
+}
+
+type HumidityAutomationDatabase interface {
+	FetchHeatpumpState(ctx context.Context) (*heatpump.State, error)
+	FetchStateHistory(ctx context.Context, from time.Time) ([]heatpump.StateChange, error)
+	FetchTemperatureAndHumidity(ctx context.Context) (temperature float64, humidity float64, err error)
+	FetchTemperatureAndHumidityUpdatedAt(ctx context.Context) (time.Time, error)
+	FetchHumidityAutomation(ctx context.Context) (*humidity.Automation, error)
+	UpdateHumidityAutomation(ctx context.Context, automation *humidity.Automation) error
+	FetchHumidityAutomationDecisions(ctx context.Context) ([]humidity.Decision, error)
+	AddHumidityAutomationDecision(ctx context.Context, decision *humidity.Decision) error
+}
+
+type HeatpumpStateUpdater interface {
+	UpdateHeatpumpState(ctx context.Context, source heatpump.Source, state *heatpump.State) (*heatpump.State, error)
+}
+
+func NewHumidityAutomation(database HumidityAutomationDatabase, heatpumpService HeatpumpStateUpdater, config HumidityAutomationConfig) *HumidityAutomation {
+	var h HumidityAutomation
+
+	h.Database = database
+	h.Heatpump = heatpumpService
+	h.Config = config
+
+	return &h
+}
+
+func (h *HumidityAutomation) Start(ctx context.Context, errc chan<- error) {
+	h.mu.Lock()
+	ctx, h.cancel = context.WithCancel(ctx)
+	h.mu.Unlock()
+
+	h.configMu.RLock()
+	ticker := time.NewTicker(h.Config.CheckInterval)
+	h.configMu.RUnlock()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := h.check(ctx, now)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error checking humidity automation: %v", err))
+			}
+		}
+	}
+}
+
+func (h *HumidityAutomation) Stop(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cancel != nil {
+		h.cancel()
+	}
+
+	return nil
+}
+
+// UpdateConfig applies the thresholds, durations, mode and quiet hours of the
+// config from the next check on. Enabling and the check interval need a
+// restart.
+func (h *HumidityAutomation) UpdateConfig(config HumidityAutomationConfig) error {
+	err := config.Validate()
+	if err != nil {
+		return fmt.Errorf("error validating humidity automation config: %v", err)
+	}
+
+	h.configMu.Lock()
+	defer h.configMu.Unlock()
+
+	config.Enabled = h.Config.Enabled
+	config.CheckInterval = h.Config.CheckInterval
+	h.Config = config
+
+	return nil
+}
+
+type HumidityAutomationStatus struct {
+	Enabled    bool                `json:"enabled"`
+	Automation humidity.Automation `json:"automation"`
+	Decisions  []humidity.Decision `json:"decisions"`
+}
+
+func (h *HumidityAutomation) FetchHumidityAutomationStatus(ctx context.Context) (_ *HumidityAutomationStatus, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchHumidityAutomationStatus")
+	defer func() { tracing.End(span, err) }()
+
+	automation, err := h.Database.FetchHumidityAutomation(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching humidity automation: %v", err)
+	}
+
+	decisions, err := h.Database.FetchHumidityAutomationDecisions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching humidity automation decisions: %v", err)
+	}
+
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+
+	return &HumidityAutomationStatus{
+		Enabled:    h.Config.Enabled,
+		Automation: *automation,
+		Decisions:  decisions,
+	}, nil
+}
+
+func (h *HumidityAutomation) check(ctx context.Context, now time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "service.CheckHumidityAutomation")
+	defer func() { tracing.End(span, err) }()
+
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+
+	_, currentHumidity, err := h.Database.FetchTemperatureAndHumidity(ctx)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error fetching humidity: %v", err)
+	}
+
+	updatedAt, err := h.Database.FetchTemperatureAndHumidityUpdatedAt(ctx)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error fetching humidity update time: %v", err)
+	}
+
+	// Don't act on stale readings, the sensor might be offline
+	if now.Sub(updatedAt) > h.Config.SensorMaxAge {
+		return nil
+	}
+
+	automation, err := h.Database.FetchHumidityAutomation(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching humidity automation: %v", err)
+	}
+
+	var changed bool
+	if automation.Active {
+		changed, err = h.checkActive(ctx, now, currentHumidity, automation)
+	} else {
+		changed, err = h.checkInactive(ctx, now, currentHumidity, automation)
+	}
+	if err != nil {
+		return err
+	}
+
+	metrics.SetHumidityAutomationActive(automation.Active)
+
+	if changed {
+		err = h.Database.UpdateHumidityAutomation(ctx, automation)
+		if err != nil {
+			return fmt.Errorf("error updating humidity automation: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (h *HumidityAutomation) checkActive(ctx context.Context, now time.Time, currentHumidity float64, automation *humidity.Automation) (bool, error) {
+	lastChange, err := h.lastStateChange(ctx, now)
+	if err != nil {
+		return false, err
+	}
+
+	// Whoever has changed the state after activation has taken over
+	if lastChange != nil && lastChange.Source.Manual() && automation.ActivatedAt != nil && lastChange.Time.After(*automation.ActivatedAt) {
+		*automation = humidity.Automation{}
+
+		err = h.decide(ctx, now, humidity.CancelAction, "state was changed manually", currentHumidity, nil)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	if currentHumidity >= h.Config.LowThreshold {
+		return false, nil
+	}
+
+	if automation.PreviousState != nil {
+		_, err = h.Heatpump.UpdateHeatpumpState(ctx, heatpump.AutomationSource, automation.PreviousState)
+		if err != nil {
+			return false, fmt.Errorf("error restoring heatpump state: %v", err)
+		}
+	}
+
+	previousState := automation.PreviousState
+	*automation = humidity.Automation{}
+
+	reason := fmt.Sprintf("humidity dropped below %g%%", h.Config.LowThreshold)
+	err = h.decide(ctx, now, humidity.RestoreAction, reason, currentHumidity, previousState)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (h *HumidityAutomation) checkInactive(ctx context.Context, now time.Time, currentHumidity float64, automation *humidity.Automation) (bool, error) {
+	if currentHumidity <= h.Config.HighThreshold {
+		h.lastSkipReason = ""
+
+		if automation.HighSince == nil {
+			return false, nil
+		}
+
+		automation.HighSince = nil
+		return true, nil
+	}
+
+	if automation.HighSince == nil {
+		automation.HighSince = &now
+		return true, nil
+	}
+
+	if now.Sub(*automation.HighSince) < h.Config.SustainFor {
+		return false, nil
+	}
+
+	reason, err := h.blockedReason(ctx, now)
+	if err != nil {
+		return false, err
+	}
+
+	if reason != "" {
+		if reason == h.lastSkipReason {
+			return false, nil
+		}
+		h.lastSkipReason = reason
+
+		return false, h.decide(ctx, now, humidity.SkipAction, reason, currentHumidity, nil)
+	}
+
+	state, err := h.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error fetching heatpump state: %v", err)
+	}
+
+	// Already dehumidifying, e.g. it was set manually
+	if *state.Mode == h.Config.Mode {
+		return false, nil
+	}
+
+	mode := h.Config.Mode
+	fanSpeed := h.Config.FanSpeed
+	updatedState, err := h.Heatpump.UpdateHeatpumpState(ctx, heatpump.AutomationSource, &heatpump.State{
+		Mode:     &mode,
+		FanSpeed: &fanSpeed,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error updating heatpump state: %v", err)
+	}
+
+	*automation = humidity.Automation{
+		Active:        true,
+		ActivatedAt:   &now,
+		PreviousState: state,
+	}
+	h.lastSkipReason = ""
+
+	reason = fmt.Sprintf("humidity above %g%% for %s", h.Config.HighThreshold, h.Config.SustainFor)
+	err = h.decide(ctx, now, humidity.ActivateAction, reason, currentHumidity, updatedState)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// blockedReason returns why activation isn't allowed right now, or an empty
+// string if it is.
+func (h *HumidityAutomation) blockedReason(ctx context.Context, now time.Time) (string, error) {
+	if h.Config.QuietHours != nil && h.Config.QuietHours.Contains(now) {
+		return fmt.Sprintf("quiet hours %s", h.Config.QuietHours), nil
+	}
+
+	lastChange, err := h.lastStateChange(ctx, now)
+	if err != nil {
+		return "", err
+	}
+
+	if lastChange != nil && lastChange.Source.Manual() && now.Sub(lastChange.Time) < h.Config.ManualOverrideFor {
+		return fmt.Sprintf("manual override until %s", lastChange.Time.Add(h.Config.ManualOverrideFor).Format(time.RFC3339)), nil
+	}
+
+	return "", nil
+}
+
+func (h *HumidityAutomation) lastStateChange(ctx context.Context, now time.Time) (*heatpump.StateChange, error) {
+	history, err := h.Database.FetchStateHistory(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching state history: %v", err)
+	}
+
+	if len(history) == 0 {
+		return nil, nil
+	}
+
+	return &history[len(history)-1], nil
+}
+
+func (h *HumidityAutomation) decide(ctx context.Context, now time.Time, action humidity.Action, reason string, currentHumidity float64, state *heatpump.State) error {
+	slog.Info(fmt.Sprintf("Humidity automation decision: %s, %s", action, reason), "humidity", currentHumidity)
+	metrics.AddHumidityAutomationDecision(string(action))
+
+	err := h.Database.AddHumidityAutomationDecision(ctx, &humidity.Decision{
+		Time:     now,
+		Action:   action,
+		Reason:   reason,
+		Humidity: currentHumidity,
+		State:    state,
+	})
+	if err != nil {
+		return fmt.Errorf("error adding humidity automation decision: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client/database"
+	"github.com/alexchebotarsky/heatpump-api/model/clock"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/humidity"
+)
+
+func TestHumidityAutomationHysteresis(t *testing.T) {
+	db := newTestDatabase(t)
+	updater := &fakeStateUpdater{database: db}
+
+	h := NewHumidityAutomation(db, updater, HumidityAutomationConfig{
+		Enabled:           true,
+		HighThreshold:     70,
+		LowThreshold:      55,
+		SustainFor:        10 * time.Minute,
+		Mode:              heatpump.DryMode,
+		FanSpeed:          40,
+		ManualOverrideFor: 30 * time.Minute,
+		SensorMaxAge:      time.Hour,
+		CheckInterval:     time.Minute,
+	})
+
+	start := time.Now()
+	steps := []struct {
+		name     string
+		after    time.Duration
+		humidity float64
+		active   bool
+		mode     heatpump.Mode
+	}{
+		{"goes above high threshold", 0, 75, false, heatpump.HeatMode},
+		{"not sustained yet", 5 * time.Minute, 75, false, heatpump.HeatMode},
+		{"drops before sustained", 6 * time.Minute, 68, false, heatpump.HeatMode},
+		{"goes above again", 7 * time.Minute, 72, false, heatpump.HeatMode},
+		{"sustain restarted", 16 * time.Minute, 72, false, heatpump.HeatMode},
+		{"sustained", 17 * time.Minute, 72, true, heatpump.DryMode},
+		{"below high but above low threshold", 20 * time.Minute, 60, true, heatpump.DryMode},
+		{"below low threshold", 25 * time.Minute, 50, false, heatpump.HeatMode},
+	}
+
+	for _, step := range steps {
+		err := db.UpdateTemperatureAndHumidity(context.Background(), 21, step.humidity)
+		if err != nil {
+			t.Fatalf("error updating humidity: %v", err)
+		}
+
+		err = h.check(context.Background(), start.Add(step.after))
+		if err != nil {
+			t.Fatalf("%s: error checking: %v", step.name, err)
+		}
+
+		automation, err := db.FetchHumidityAutomation(context.Background())
+		if err != nil {
+			t.Fatalf("error fetching automation: %v", err)
+		}
+		state, err := db.FetchHeatpumpState(context.Background())
+		if err != nil {
+			t.Fatalf("error fetching state: %v", err)
+		}
+
+		if automation.Active != step.active {
+			t.Errorf("%s: expected active %t, got: %t", step.name, step.active, automation.Active)
+		}
+		if *state.Mode != step.mode {
+			t.Errorf("%s: expected mode %s, got: %s", step.name, step.mode, *state.Mode)
+		}
+	}
+
+	// The state before activation is restored as a whole
+	state, err := db.FetchHeatpumpState(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching state: %v", err)
+	}
+	if *state.FanSpeed != 0 || *state.TargetTemperature != 22 {
+		t.Errorf("expected previous fan speed and target temperature, got: %d and %d", *state.FanSpeed, *state.TargetTemperature)
+	}
+
+	decisions, err := db.FetchHumidityAutomationDecisions(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching decisions: %v", err)
+	}
+	var actions []humidity.Action
+	for _, decision := range decisions {
+		actions = append(actions, decision.Action)
+	}
+	if len(actions) != 2 || actions[0] != humidity.ActivateAction || actions[1] != humidity.RestoreAction {
+		t.Errorf("expected activate and restore decisions, got: %v", actions)
+	}
+}
+
+func TestHumidityAutomationSkipsDuringQuietHours(t *testing.T) {
+	db := newTestDatabase(t)
+	updater := &fakeStateUpdater{database: db}
+
+	start := time.Now()
+	minutes := start.Hour()*60 + start.Minute()
+	quietHours := clock.Window{From: (minutes + 1440 - 60) % 1440, To: (minutes + 120) % 1440}
+
+	h := NewHumidityAutomation(db, updater, HumidityAutomationConfig{
+		Enabled:       true,
+		HighThreshold: 70,
+		LowThreshold:  55,
+		SustainFor:    10 * time.Minute,
+		Mode:          heatpump.DryMode,
+		QuietHours:    &quietHours,
+		SensorMaxAge:  time.Hour,
+		CheckInterval: time.Minute,
+	})
+
+	err := db.UpdateTemperatureAndHumidity(context.Background(), 21, 80)
+	if err != nil {
+		t.Fatalf("error updating humidity: %v", err)
+	}
+
+	for _, after := range []time.Duration{0, 10 * time.Minute, 11 * time.Minute, 12 * time.Minute} {
+		err = h.check(context.Background(), start.Add(after))
+		if err != nil {
+			t.Fatalf("error checking: %v", err)
+		}
+	}
+
+	automation, err := db.FetchHumidityAutomation(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching automation: %v", err)
+	}
+	if automation.Active {
+		t.Error("expected automation not to activate during quiet hours")
+	}
+
+	decisions, err := db.FetchHumidityAutomationDecisions(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching decisions: %v", err)
+	}
+	if len(decisions) != 1 || decisions[0].Action != humidity.SkipAction {
+		t.Errorf("expected a single skip decision, got: %+v", decisions)
+	}
+}
+
+// fakeStateUpdater applies state updates to the database, without
+// transmitting them.
+type fakeStateUpdater struct {
+	database *database.Database
+}
+
+func (f *fakeStateUpdater) UpdateHeatpumpState(ctx context.Context, source heatpump.Source, state *heatpump.State) (*heatpump.State, error) {
+	return f.database.UpdateHeatpumpState(ctx, state)
+}
+
+func newTestDatabase(t *testing.T) *database.Database {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "database.json"), map[string]string{
+		database.ModeKey:              string(heatpump.HeatMode),
+		database.TargetTemperatureKey: "22",
+		database.FanSpeedKey:          "0",
+	})
+	if err != nil {
+		t.Fatalf("error creating database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
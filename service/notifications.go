@@ -0,0 +1,493 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/health"
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/alert"
+	"github.com/alexchebotarsky/heatpump-api/model/clock"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+// Notifications evaluates alert rules over sensor readings and health checks,
+// and notifies the channels once when an alert starts firing and once when
+// it's resolved.
+type Notifications struct {
+	Database NotificationsDatabase
+	Events   EventSubscriber
+	Health   HealthChecker
+	Channels []NotificationChannel
+	Config   NotificationsConfig
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+type NotificationsConfig struct {
+	// Cooldown is the minimum time between notifications of the same alert,
+	// so that a reading hovering around the threshold isn't spamming.
+	Cooldown time.Duration
+	// QuietHours suppress notifications that aren't critical, if set.
+	QuietHours          *clock.Window
+	HealthCheckInterval time.Duration
+}
+
+func (c *NotificationsConfig) Validate() error {
+	if c.Cooldown < 0 {
+		return errors.New("cooldown must not be negative")
+	}
+
+	if c.HealthCheckInterval <= 0 {
+		return errors.New("health check interval must be positive")
+	}
+
+	return nil
+}
+
+type NotificationsDatabase interface {
+	FetchAlerts(ctx context.Context) ([]alert.Rule, error)
+	FetchAlert(ctx context.Context, id string) (*alert.Rule, error)
+	AddAlert(ctx context.Context, a *alert.Rule) error
+	UpdateAlert(ctx context.Context, a *alert.Rule) error
+	DeleteAlert(ctx context.Context, id string) error
+	FetchAlertState(ctx context.Context, id string) (*alert.State, error)
+	UpdateAlertState(ctx context.Context, id string, state *alert.State) error
+	FetchNotificationHistory(ctx context.Context) ([]alert.Record, error)
+	AddNotificationRecord(ctx context.Context, record *alert.Record) error
+}
+
+type HealthChecker interface {
+	Names() []string
+	Run(ctx context.Context) health.Report
+}
+
+type NotificationChannel interface {
+	Name() string
+	Send(ctx context.Context, n *alert.Notification) error
+}
+
+func NewNotifications(database NotificationsDatabase, events EventSubscriber, healthChecker HealthChecker, channels []NotificationChannel, config NotificationsConfig) *Notifications {
+	var n Notifications
+
+	n.Database = database
+	n.Events = events
+	n.Health = healthChecker
+	n.Channels = channels
+	n.Config = config
+
+	return &n
+}
+
+func (n *Notifications) Start(ctx context.Context, errc chan<- error) {
+	n.mu.Lock()
+	ctx, n.cancel = context.WithCancel(ctx)
+	n.mu.Unlock()
+
+	events, unsubscribe := n.Events.Subscribe(eventsBufferSize, bus.ReadingEvent)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(n.Config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			reading, ok := e.Data.(bus.Reading)
+			if !ok {
+				continue
+			}
+
+			err := n.checkReading(ctx, e.Time, reading)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error checking %s alerts: %v", reading.Sensor, err))
+			}
+		case now := <-ticker.C:
+			err := n.checkHealth(ctx, now)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error checking health alerts: %v", err))
+			}
+		}
+	}
+}
+
+func (n *Notifications) Stop(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.cancel != nil {
+		n.cancel()
+	}
+
+	return nil
+}
+
+func (n *Notifications) FetchAlerts(ctx context.Context) (_ []alert.Rule, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchAlerts")
+	defer func() { tracing.End(span, err) }()
+
+	alerts, err := n.Database.FetchAlerts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching alerts: %v", err)
+	}
+
+	return alerts, nil
+}
+
+func (n *Notifications) FetchAlert(ctx context.Context, id string) (_ *alert.Rule, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchAlert")
+	defer func() { tracing.End(span, err) }()
+
+	return n.Database.FetchAlert(ctx, id)
+}
+
+func (n *Notifications) CreateAlert(ctx context.Context, newAlert *alert.Rule) (_ *alert.Rule, err error) {
+	ctx, span := tracing.Start(ctx, "service.CreateAlert")
+	defer func() { tracing.End(span, err) }()
+
+	err = n.validate(newAlert)
+	if err != nil {
+		return nil, err
+	}
+
+	newAlert.ID, err = alert.NewID()
+	if err != nil {
+		return nil, err
+	}
+	newAlert.CreatedAt = time.Now().UTC()
+	newAlert.UpdatedAt = newAlert.CreatedAt
+
+	err = n.Database.AddAlert(ctx, newAlert)
+	if err != nil {
+		return nil, fmt.Errorf("error adding alert: %v", err)
+	}
+
+	return newAlert, nil
+}
+
+// UpdateAlert replaces the alert and resets whether it's firing, so that it's
+// evaluated afresh against the new condition.
+func (n *Notifications) UpdateAlert(ctx context.Context, id string, updatedAlert *alert.Rule) (_ *alert.Rule, err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateAlert")
+	defer func() { tracing.End(span, err) }()
+
+	err = n.validate(updatedAlert)
+	if err != nil {
+		return nil, err
+	}
+
+	existingAlert, err := n.Database.FetchAlert(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updatedAlert.ID = existingAlert.ID
+	updatedAlert.CreatedAt = existingAlert.CreatedAt
+	updatedAlert.UpdatedAt = time.Now().UTC()
+
+	err = n.Database.UpdateAlert(ctx, updatedAlert)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := n.Database.FetchAlertState(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching alert state: %v", err)
+	}
+
+	// Cooldown still applies to the updated alert
+	err = n.Database.UpdateAlertState(ctx, id, &alert.State{LastNotifiedAt: state.LastNotifiedAt})
+	if err != nil {
+		return nil, fmt.Errorf("error resetting alert state: %v", err)
+	}
+
+	return updatedAlert, nil
+}
+
+func (n *Notifications) DeleteAlert(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.DeleteAlert")
+	defer func() { tracing.End(span, err) }()
+
+	return n.Database.DeleteAlert(ctx, id)
+}
+
+func (n *Notifications) FetchNotificationHistory(ctx context.Context) (_ []alert.Record, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchNotificationHistory")
+	defer func() { tracing.End(span, err) }()
+
+	history, err := n.Database.FetchNotificationHistory(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching notification history: %v", err)
+	}
+
+	return history, nil
+}
+
+// TestNotification sends a test notification to the channel, or to all
+// channels if it's empty, regardless of quiet hours.
+func (n *Notifications) TestNotification(ctx context.Context, channel string) (_ []alert.Delivery, err error) {
+	ctx, span := tracing.Start(ctx, "service.TestNotification")
+	defer func() { tracing.End(span, err) }()
+
+	var channels []string
+	if channel != "" {
+		channels = []string{channel}
+	}
+
+	err = n.validateChannels(channels)
+	if err != nil {
+		return nil, &ErrInvalid{Err: err}
+	}
+
+	notification := alert.Notification{
+		Title:    "Test notification",
+		Message:  "Notifications from heatpump-api are working.",
+		Severity: alert.InfoSeverity,
+		Time:     time.Now().UTC(),
+	}
+
+	record := alert.Record{
+		Notification: notification,
+		Deliveries:   n.send(ctx, &notification, channels),
+	}
+
+	err = n.Database.AddNotificationRecord(ctx, &record)
+	if err != nil {
+		return nil, fmt.Errorf("error adding notification to history: %v", err)
+	}
+
+	return record.Deliveries, nil
+}
+
+func (n *Notifications) validate(a *alert.Rule) error {
+	err := a.Validate()
+	if err != nil {
+		return &ErrInvalid{Err: fmt.Errorf("error validating alert: %v", err)}
+	}
+
+	err = n.validateChannels(a.Channels)
+	if err != nil {
+		return &ErrInvalid{Err: fmt.Errorf("error validating alert: %v", err)}
+	}
+
+	if a.Type == alert.HealthAlert && !slices.Contains(n.Health.Names(), a.Check) {
+		return &ErrInvalid{Err: fmt.Errorf("error validating alert: check must be one of: %v, got: %s", n.Health.Names(), a.Check)}
+	}
+
+	return nil
+}
+
+func (n *Notifications) validateChannels(channels []string) error {
+	if len(n.Channels) == 0 {
+		return errors.New("no notification channels are configured")
+	}
+
+	var names []string
+	for _, c := range n.Channels {
+		names = append(names, c.Name())
+	}
+
+	for _, channel := range channels {
+		if !slices.Contains(names, channel) {
+			return fmt.Errorf("channel must be one of the configured: %v, got: %s", names, channel)
+		}
+	}
+
+	return nil
+}
+
+func (n *Notifications) checkReading(ctx context.Context, now time.Time, reading bus.Reading) error {
+	alerts, err := n.Database.FetchAlerts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching alerts: %v", err)
+	}
+
+	for _, a := range alerts {
+		if !a.Enabled || a.Type != alert.ReadingAlert || a.Sensor != reading.Sensor {
+			continue
+		}
+
+		var condition string
+		if a.Above != nil {
+			condition = fmt.Sprintf("above %s", formatReading(a.Sensor, *a.Above))
+		} else {
+			condition = fmt.Sprintf("below %s", formatReading(a.Sensor, *a.Below))
+		}
+
+		firing := a.Holds(reading.Value)
+		message := fmt.Sprintf("%s is %s, %s", a.Sensor, formatReading(a.Sensor, reading.Value), condition)
+		if !firing {
+			message = fmt.Sprintf("%s is %s, no longer %s", a.Sensor, formatReading(a.Sensor, reading.Value), condition)
+		}
+
+		err := n.transition(ctx, now, &a, firing, message)
+		if err != nil {
+			return fmt.Errorf("error updating alert %s: %v", a.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (n *Notifications) checkHealth(ctx context.Context, now time.Time) error {
+	alerts, err := n.Database.FetchAlerts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching alerts: %v", err)
+	}
+
+	var report *health.Report
+	for _, a := range alerts {
+		if !a.Enabled || a.Type != alert.HealthAlert {
+			continue
+		}
+
+		// Checks are only run if there are alerts for them
+		if report == nil {
+			r := n.Health.Run(ctx)
+			report = &r
+		}
+
+		i := slices.IndexFunc(report.Checks, func(result health.Result) bool {
+			return result.Name == a.Check
+		})
+		if i < 0 {
+			slog.Warn(fmt.Sprintf("Health check %q of alert %s doesn't exist", a.Check, a.ID))
+			continue
+		}
+		result := report.Checks[i]
+
+		message := fmt.Sprintf("%s check is %s", result.Name, result.Status)
+		if result.Error != "" {
+			message = fmt.Sprintf("%s: %s", message, result.Error)
+		}
+
+		err := n.transition(ctx, now, &a, result.Status != health.OKStatus, message)
+		if err != nil {
+			return fmt.Errorf("error updating alert %s: %v", a.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// transition notifies about the alert if it has started or stopped firing.
+func (n *Notifications) transition(ctx context.Context, now time.Time, a *alert.Rule, firing bool, message string) error {
+	state, err := n.Database.FetchAlertState(ctx, a.ID)
+	if err != nil {
+		return fmt.Errorf("error fetching alert state: %v", err)
+	}
+
+	if state.Firing == firing {
+		return nil
+	}
+
+	state.Firing = firing
+	state.Since = now.UTC()
+
+	if !firing && !state.Notified {
+		// Nobody was told it's firing, so there's nothing to resolve
+		err = n.Database.UpdateAlertState(ctx, a.ID, state)
+		if err != nil {
+			return fmt.Errorf("error updating alert state: %v", err)
+		}
+		return nil
+	}
+
+	notification := alert.Notification{
+		RuleID:   a.ID,
+		Title:    a.Name,
+		Message:  message,
+		Severity: a.Severity,
+		Resolved: !firing,
+		Time:     now.UTC(),
+	}
+	if !firing {
+		notification.Title = fmt.Sprintf("Resolved: %s", a.Name)
+	}
+
+	record := alert.Record{
+		Notification: notification,
+		Deliveries:   []alert.Delivery{},
+	}
+
+	switch {
+	case firing && state.LastNotifiedAt != nil && now.Sub(*state.LastNotifiedAt) < n.Config.Cooldown:
+		record.Suppressed = "cooldown"
+	case a.Severity != alert.CriticalSeverity && n.Config.QuietHours != nil && n.Config.QuietHours.Contains(now):
+		record.Suppressed = "quiet hours"
+	default:
+		record.Deliveries = n.send(ctx, &notification, a.Channels)
+	}
+
+	if record.Suppressed != "" {
+		slog.Info(fmt.Sprintf("Notification %q is suppressed: %s", notification.Title, record.Suppressed), "alert", a.ID)
+		metrics.AddNotificationSuppressed(record.Suppressed)
+	}
+
+	state.Notified = firing && record.Suppressed == ""
+	if record.Suppressed == "" {
+		notifiedAt := now.UTC()
+		state.LastNotifiedAt = &notifiedAt
+	}
+
+	err = n.Database.UpdateAlertState(ctx, a.ID, state)
+	if err != nil {
+		return fmt.Errorf("error updating alert state: %v", err)
+	}
+
+	err = n.Database.AddNotificationRecord(ctx, &record)
+	if err != nil {
+		return fmt.Errorf("error adding notification to history: %v", err)
+	}
+
+	return nil
+}
+
+// send sends the notification to the channels by name, or to all channels if
+// none are given.
+func (n *Notifications) send(ctx context.Context, notification *alert.Notification, channels []string) []alert.Delivery {
+	deliveries := []alert.Delivery{}
+
+	for _, channel := range n.Channels {
+		if len(channels) > 0 && !slices.Contains(channels, channel.Name()) {
+			continue
+		}
+
+		delivery := alert.Delivery{Channel: channel.Name(), OK: true}
+
+		err := channel.Send(ctx, notification)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error sending notification to %s: %v", channel.Name(), err))
+			delivery.OK = false
+			delivery.Error = err.Error()
+			metrics.AddNotificationSent(channel.Name(), "ERR")
+		} else {
+			metrics.AddNotificationSent(channel.Name(), "OK")
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries
+}
+
+func formatReading(sensor heatpump.Sensor, value float64) string {
+	switch sensor {
+	case heatpump.TemperatureSensor, heatpump.OutdoorTemperatureSensor:
+		return fmt.Sprintf("%.1f°C", value)
+	case heatpump.HumiditySensor:
+		return fmt.Sprintf("%.0f%%", value)
+	default:
+		return fmt.Sprintf("%g", value)
+	}
+}
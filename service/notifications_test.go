@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/model/alert"
+	"github.com/alexchebotarsky/heatpump-api/model/clock"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+func TestNotificationsCooldownAndResolve(t *testing.T) {
+	db := newTestDatabase(t)
+	channel := &fakeNotificationChannel{name: "test"}
+	n := NewNotifications(db, nil, nil, []NotificationChannel{channel}, NotificationsConfig{
+		Cooldown:            30 * time.Minute,
+		HealthCheckInterval: time.Minute,
+	})
+
+	above := 25.0
+	a, err := n.CreateAlert(context.Background(), &alert.Rule{
+		Name:    "too warm",
+		Enabled: true,
+		Type:    alert.ReadingAlert,
+		Sensor:  heatpump.TemperatureSensor,
+		Above:   &above,
+	})
+	if err != nil {
+		t.Fatalf("error creating alert: %v", err)
+	}
+
+	start := time.Now()
+	steps := []struct {
+		name           string
+		after          time.Duration
+		temperature    float64
+		wantSent       []string
+		wantSuppressed int
+	}{
+		{"fires", 0, 26, []string{"too warm"}, 0},
+		{"still firing", time.Minute, 27, nil, 0},
+		{"resolves", 2 * time.Minute, 24, []string{"Resolved: too warm"}, 0},
+		{"fires within cooldown", 3 * time.Minute, 26, nil, 1},
+		{"unnotified firing isn't resolved", 4 * time.Minute, 24, nil, 0},
+		{"fires after cooldown", 40 * time.Minute, 26, []string{"too warm"}, 0},
+	}
+
+	var records int
+	for _, step := range steps {
+		channel.reset()
+
+		err := n.checkReading(context.Background(), start.Add(step.after), bus.Reading{Sensor: heatpump.TemperatureSensor, Value: step.temperature})
+		if err != nil {
+			t.Fatalf("%s: error checking reading: %v", step.name, err)
+		}
+
+		sent := channel.titles()
+		if len(sent) != len(step.wantSent) || (len(sent) > 0 && sent[0] != step.wantSent[0]) {
+			t.Errorf("%s: expected %v to be sent, got: %v", step.name, step.wantSent, sent)
+		}
+
+		history, err := db.FetchNotificationHistory(context.Background())
+		if err != nil {
+			t.Fatalf("error fetching history: %v", err)
+		}
+		// History is newest first
+		newRecords := history[:len(history)-records]
+		records = len(history)
+
+		var suppressed int
+		for _, record := range newRecords {
+			if record.Suppressed != "" {
+				suppressed++
+			}
+		}
+		if suppressed != step.wantSuppressed {
+			t.Errorf("%s: expected %d suppressed, got: %d", step.name, step.wantSuppressed, suppressed)
+		}
+	}
+
+	state, err := db.FetchAlertState(context.Background(), a.ID)
+	if err != nil {
+		t.Fatalf("error fetching alert state: %v", err)
+	}
+	if !state.Firing || !state.Notified {
+		t.Errorf("expected alert to be firing and notified, got: %+v", state)
+	}
+}
+
+func TestNotificationsQuietHoursLetCriticalThrough(t *testing.T) {
+	db := newTestDatabase(t)
+	channel := &fakeNotificationChannel{name: "test"}
+
+	now := time.Now()
+	minutes := now.Hour()*60 + now.Minute()
+	quietHours := clock.Window{From: (minutes + 1440 - 60) % 1440, To: (minutes + 60) % 1440}
+
+	n := NewNotifications(db, nil, nil, []NotificationChannel{channel}, NotificationsConfig{
+		QuietHours:          &quietHours,
+		HealthCheckInterval: time.Minute,
+	})
+
+	below := 10.0
+	for _, severity := range []alert.Severity{alert.WarningSeverity, alert.CriticalSeverity} {
+		_, err := n.CreateAlert(context.Background(), &alert.Rule{
+			Name:     string(severity),
+			Enabled:  true,
+			Type:     alert.ReadingAlert,
+			Severity: severity,
+			Sensor:   heatpump.TemperatureSensor,
+			Below:    &below,
+		})
+		if err != nil {
+			t.Fatalf("error creating alert: %v", err)
+		}
+	}
+
+	err := n.checkReading(context.Background(), now, bus.Reading{Sensor: heatpump.TemperatureSensor, Value: 8})
+	if err != nil {
+		t.Fatalf("error checking reading: %v", err)
+	}
+
+	sent := channel.titles()
+	if len(sent) != 1 || sent[0] != string(alert.CriticalSeverity) {
+		t.Errorf("expected only the critical alert to be sent, got: %v", sent)
+	}
+}
+
+func TestNotificationsRecordFailedDeliveries(t *testing.T) {
+	db := newTestDatabase(t)
+	failing := &fakeNotificationChannel{name: "failing", err: errors.New("connection refused")}
+	working := &fakeNotificationChannel{name: "working"}
+	n := NewNotifications(db, nil, nil, []NotificationChannel{failing, working}, NotificationsConfig{
+		HealthCheckInterval: time.Minute,
+	})
+
+	deliveries, err := n.TestNotification(context.Background(), "")
+	if err != nil {
+		t.Fatalf("error sending test notification: %v", err)
+	}
+
+	if len(deliveries) != 2 || deliveries[0].OK || deliveries[0].Error == "" || !deliveries[1].OK {
+		t.Errorf("expected the failing delivery not to stop the other one, got: %+v", deliveries)
+	}
+}
+
+type fakeNotificationChannel struct {
+	name string
+	err  error
+
+	mu   sync.Mutex
+	sent []alert.Notification
+}
+
+func (f *fakeNotificationChannel) Name() string {
+	return f.name
+}
+
+func (f *fakeNotificationChannel) Send(ctx context.Context, n *alert.Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sent = append(f.sent, *n)
+	return f.err
+}
+
+func (f *fakeNotificationChannel) titles() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var titles []string
+	for _, n := range f.sent {
+		titles = append(titles, n.Title)
+	}
+	return titles
+}
+
+func (f *fakeNotificationChannel) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sent = nil
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/window"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+// OpenWindow suspends heating when a window is opened, either detected from a
+// rapid temperature drop or reported by a contact sensor, and restores the
+// previous state once it's closed.
+type OpenWindow struct {
+	Database OpenWindowDatabase
+	Heatpump HeatpumpStateUpdater
+	PubSub   OpenWindowPublisher
+	Events   EventSubscriber
+	Config   OpenWindowConfig
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	readings []window.Reading
+}
+
+type OpenWindowConfig struct {
+	Enabled bool
+	// DropSlope in degrees per minute that is considered an open window.
+	DropSlope float64
+	// Window is the period the slope is computed over.
+	Window time.Duration
+	// StableSlope in degrees per minute below which temperature is considered
+	// stabilised after MinDuration.
+	StableSlope float64
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// SuspendState is applied while the window is open, e.g. OFF or HEAT at
+	// a frost protection temperature.
+	SuspendState heatpump.State
+}
+
+// FrostProtectSuspend keeps heating at the frost protection temperature
+// instead of turning the heatpump off.
+const FrostProtectSuspend = "FROST_PROTECT"
+
+// NewSuspendState returns the state applied while the window is open, mode is
+// either OFF or FROST_PROTECT.
+func NewSuspendState(mode string, frostProtectTemperature int) (heatpump.State, error) {
+	switch mode {
+	case string(heatpump.OffMode):
+		offMode := heatpump.OffMode
+		return heatpump.State{Mode: &offMode}, nil
+	case FrostProtectSuspend:
+		heatMode := heatpump.HeatMode
+		return heatpump.State{Mode: &heatMode, TargetTemperature: &frostProtectTemperature}, nil
+	default:
+		return heatpump.State{}, fmt.Errorf("suspend mode must be one of: [%s, %s], got: %s", heatpump.OffMode, FrostProtectSuspend, mode)
+	}
+}
+
+func (c *OpenWindowConfig) Validate() error {
+	if c.DropSlope <= 0 {
+		return fmt.Errorf("drop slope must be positive, got: %g", c.DropSlope)
+	}
+
+	if c.StableSlope < 0 || c.StableSlope >= c.DropSlope {
+		return fmt.Errorf("stable slope must be in range [0,%g), got: %g", c.DropSlope, c.StableSlope)
+	}
+
+	if c.Window <= 0 {
+		return errors.New("window must be positive")
+	}
+
+	if c.MinDuration > c.MaxDuration {
+		return fmt.Errorf("min duration must not exceed max duration, got: %s and %s", c.MinDuration, c.MaxDuration)
+	}
+
+	if c.SuspendState.Mode == nil {
+		return errors.New("suspend state mode must be set")
+	}
+
+	err := c.SuspendState.Validate()
+	if err != nil {
+		return fmt.Errorf("error validating suspend state: %v", err)
+	}
+
+	return nil
+}
+
+type OpenWindowDatabase interface {
+	FetchHeatpumpState(ctx context.Context) (*heatpump.State, error)
+	FetchStateHistory(ctx context.Context, from time.Time) ([]heatpump.StateChange, error)
+	FetchOpenWindow(ctx context.Context) (*window.Suspension, error)
+	UpdateOpenWindow(ctx context.Context, suspension *window.Suspension) error
+}
+
+type OpenWindowPublisher interface {
+	PublishOpenWindowEvent(ctx context.Context, event *window.Event) error
+}
+
+// openWindowCheckInterval is how often max duration and manual changes are
+// checked while suspended.
+const openWindowCheckInterval = 30 * time.Second
+
+// heatingModes are suspended when a window is opened.
+var heatingModes = []heatpump.Mode{heatpump.HeatMode, heatpump.AutoMode}
+
+func NewOpenWindow(database OpenWindowDatabase, heatpumpService HeatpumpStateUpdater, pubsub OpenWindowPublisher, events EventSubscriber, config OpenWindowConfig) *OpenWindow {
+	var o OpenWindow
+
+	o.Database = database
+	o.Heatpump = heatpumpService
+	o.PubSub = pubsub
+	o.Events = events
+	o.Config = config
+
+	return &o
+}
+
+func (o *OpenWindow) Start(ctx context.Context, errc chan<- error) {
+	o.mu.Lock()
+	ctx, o.cancel = context.WithCancel(ctx)
+	o.readings = nil
+	o.mu.Unlock()
+
+	events, unsubscribe := o.Events.Subscribe(eventsBufferSize, bus.ReadingEvent, bus.WindowContactEvent)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(openWindowCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			switch data := e.Data.(type) {
+			case bus.Reading:
+				if data.Sensor == heatpump.TemperatureSensor {
+					err = o.handleTemperature(ctx, e.Time, data.Value)
+				}
+			case bus.WindowContact:
+				err = o.handleContact(ctx, e.Time, data.Open)
+			}
+		case now := <-ticker.C:
+			err = o.check(ctx, now)
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error handling open window detection: %v", err))
+		}
+	}
+}
+
+func (o *OpenWindow) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.cancel != nil {
+		o.cancel()
+	}
+
+	return nil
+}
+
+func (o *OpenWindow) FetchOpenWindow(ctx context.Context) (_ *window.Suspension, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchOpenWindow")
+	defer func() { tracing.End(span, err) }()
+
+	suspension, err := o.Database.FetchOpenWindow(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching open window: %v", err)
+	}
+
+	return suspension, nil
+}
+
+func (o *OpenWindow) handleTemperature(ctx context.Context, now time.Time, temperature float64) error {
+	o.readings = append(o.readings, window.Reading{Time: now, Temperature: temperature})
+
+	cutoff := now.Add(-o.Config.Window)
+	for len(o.readings) > 0 && o.readings[0].Time.Before(cutoff) {
+		o.readings = o.readings[1:]
+	}
+
+	// Require half of the window to be covered, so that a single noisy reading
+	// doesn't count as a drop
+	slope, ok := window.Slope(o.readings, o.Config.Window/2)
+	if !ok {
+		return nil
+	}
+
+	suspension, err := o.Database.FetchOpenWindow(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching open window: %v", err)
+	}
+
+	if !suspension.Active {
+		if slope > -o.Config.DropSlope {
+			return nil
+		}
+
+		return o.suspend(ctx, now, window.TemperatureDropReason, &temperature)
+	}
+
+	// Contact sensor knows better when the window is closed
+	if suspension.Reason != window.TemperatureDropReason {
+		return nil
+	}
+
+	if now.Sub(*suspension.SuspendedAt) < o.Config.MinDuration || slope < -o.Config.StableSlope {
+		return nil
+	}
+
+	return o.restore(ctx, now, suspension, &temperature)
+}
+
+func (o *OpenWindow) handleContact(ctx context.Context, now time.Time, open bool) error {
+	suspension, err := o.Database.FetchOpenWindow(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching open window: %v", err)
+	}
+
+	if open && !suspension.Active {
+		return o.suspend(ctx, now, window.ContactReason, o.latestTemperature())
+	}
+
+	if !open && suspension.Active {
+		return o.restore(ctx, now, suspension, o.latestTemperature())
+	}
+
+	return nil
+}
+
+// check restores the state after max duration, and gives up the suspension if
+// the state was changed manually meanwhile.
+func (o *OpenWindow) check(ctx context.Context, now time.Time) error {
+	suspension, err := o.Database.FetchOpenWindow(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching open window: %v", err)
+	}
+
+	metrics.SetOpenWindowActive(suspension.Active)
+
+	if !suspension.Active {
+		return nil
+	}
+
+	history, err := o.Database.FetchStateHistory(ctx, now)
+	if err != nil {
+		return fmt.Errorf("error fetching state history: %v", err)
+	}
+
+	if len(history) > 0 {
+		lastChange := history[len(history)-1]
+		if lastChange.Source.Manual() && lastChange.Time.After(*suspension.SuspendedAt) {
+			return o.finish(ctx, now, suspension, window.CancelledEvent, o.latestTemperature())
+		}
+	}
+
+	if now.Sub(*suspension.SuspendedAt) >= o.Config.MaxDuration {
+		return o.restore(ctx, now, suspension, o.latestTemperature())
+	}
+
+	return nil
+}
+
+func (o *OpenWindow) suspend(ctx context.Context, now time.Time, reason window.Reason, temperature *float64) error {
+	state, err := o.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching heatpump state: %v", err)
+	}
+
+	// Nothing to suspend if it's not heating
+	if !slices.Contains(heatingModes, *state.Mode) {
+		return nil
+	}
+
+	slog.Info("Open window detected, suspending heating", "reason", reason)
+
+	suspendState := o.Config.SuspendState
+	_, err = o.Heatpump.UpdateHeatpumpState(ctx, heatpump.AutomationSource, &suspendState)
+	if err != nil {
+		return fmt.Errorf("error suspending heating: %v", err)
+	}
+
+	err = o.Database.UpdateOpenWindow(ctx, &window.Suspension{
+		Active:        true,
+		Reason:        reason,
+		SuspendedAt:   &now,
+		PreviousState: state,
+	})
+	if err != nil {
+		return fmt.Errorf("error updating open window: %v", err)
+	}
+
+	metrics.SetOpenWindowActive(true)
+	metrics.AddOpenWindowDetection(string(reason))
+
+	return o.publish(ctx, &window.Event{
+		Type:        window.DetectedEvent,
+		Reason:      reason,
+		Time:        now,
+		Temperature: temperature,
+	})
+}
+
+func (o *OpenWindow) restore(ctx context.Context, now time.Time, suspension *window.Suspension, temperature *float64) error {
+	slog.Info("Open window closed, restoring heating", "reason", suspension.Reason)
+
+	if suspension.PreviousState != nil {
+		_, err := o.Heatpump.UpdateHeatpumpState(ctx, heatpump.AutomationSource, suspension.PreviousState)
+		if err != nil {
+			return fmt.Errorf("error restoring heatpump state: %v", err)
+		}
+	}
+
+	return o.finish(ctx, now, suspension, window.RestoredEvent, temperature)
+}
+
+func (o *OpenWindow) finish(ctx context.Context, now time.Time, suspension *window.Suspension, eventType window.EventType, temperature *float64) error {
+	err := o.Database.UpdateOpenWindow(ctx, &window.Suspension{})
+	if err != nil {
+		return fmt.Errorf("error updating open window: %v", err)
+	}
+
+	metrics.SetOpenWindowActive(false)
+
+	return o.publish(ctx, &window.Event{
+		Type:        eventType,
+		Reason:      suspension.Reason,
+		Time:        now,
+		Temperature: temperature,
+	})
+}
+
+func (o *OpenWindow) publish(ctx context.Context, event *window.Event) error {
+	err := o.PubSub.PublishOpenWindowEvent(ctx, event)
+	if err != nil {
+		return fmt.Errorf("error publishing open window event: %v", err)
+	}
+
+	return nil
+}
+
+func (o *OpenWindow) latestTemperature() *float64 {
+	if len(o.readings) == 0 {
+		return nil
+	}
+
+	temperature := o.readings[len(o.readings)-1].Temperature
+	return &temperature
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/window"
+)
+
+func TestOpenWindowSuspendsOnDropAndRestoresWhenStable(t *testing.T) {
+	db := newTestDatabase(t)
+	publisher := &fakeOpenWindowPublisher{}
+	o := NewOpenWindow(db, &fakeStateUpdater{database: db}, publisher, nil, newTestOpenWindowConfig(t))
+
+	start := time.Now()
+	steps := []struct {
+		name        string
+		after       time.Duration
+		temperature float64
+		active      bool
+		mode        heatpump.Mode
+	}{
+		{"first reading", 0, 21, false, heatpump.HeatMode},
+		{"window not covered yet", 4 * time.Minute, 20.2, false, heatpump.HeatMode},
+		{"rapid drop", 6 * time.Minute, 18, true, heatpump.OffMode},
+		{"min duration not reached", 8 * time.Minute, 17.5, true, heatpump.OffMode},
+		{"still dropping", 14 * time.Minute, 16, true, heatpump.OffMode},
+		{"stabilised", 22 * time.Minute, 15.9, false, heatpump.HeatMode},
+	}
+
+	for _, step := range steps {
+		err := o.handleTemperature(context.Background(), start.Add(step.after), step.temperature)
+		if err != nil {
+			t.Fatalf("%s: error handling temperature: %v", step.name, err)
+		}
+
+		assertOpenWindow(t, step.name, db, step.active, step.mode)
+	}
+
+	state, err := db.FetchHeatpumpState(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching state: %v", err)
+	}
+	if *state.TargetTemperature != 22 {
+		t.Errorf("expected previous target temperature to be restored, got: %d", *state.TargetTemperature)
+	}
+
+	publisher.assertEvents(t, window.DetectedEvent, window.RestoredEvent)
+}
+
+func TestOpenWindowIsCancelledByManualChange(t *testing.T) {
+	db := newTestDatabase(t)
+	publisher := &fakeOpenWindowPublisher{}
+	o := NewOpenWindow(db, &fakeStateUpdater{database: db}, publisher, nil, newTestOpenWindowConfig(t))
+
+	start := time.Now()
+	err := o.handleContact(context.Background(), start, true)
+	if err != nil {
+		t.Fatalf("error handling contact: %v", err)
+	}
+	assertOpenWindow(t, "opened", db, true, heatpump.OffMode)
+
+	// Temperature doesn't restore windows reported by the contact sensor
+	for i, temperature := range []float64{18, 18, 18} {
+		err = o.handleTemperature(context.Background(), start.Add(time.Duration(i)*10*time.Minute), temperature)
+		if err != nil {
+			t.Fatalf("error handling temperature: %v", err)
+		}
+	}
+	assertOpenWindow(t, "stable temperature", db, true, heatpump.OffMode)
+
+	mode := heatpump.CoolMode
+	state, err := db.UpdateHeatpumpState(context.Background(), &heatpump.State{Mode: &mode})
+	if err != nil {
+		t.Fatalf("error updating state: %v", err)
+	}
+	err = db.AddStateChange(context.Background(), &heatpump.StateChange{Time: start.Add(time.Minute), Source: heatpump.HTTPSource, State: *state})
+	if err != nil {
+		t.Fatalf("error adding state change: %v", err)
+	}
+
+	err = o.check(context.Background(), start.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("error checking: %v", err)
+	}
+	assertOpenWindow(t, "changed manually", db, false, heatpump.CoolMode)
+
+	publisher.assertEvents(t, window.DetectedEvent, window.CancelledEvent)
+}
+
+func newTestOpenWindowConfig(t *testing.T) OpenWindowConfig {
+	t.Helper()
+
+	suspendState, err := NewSuspendState(string(heatpump.OffMode), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := OpenWindowConfig{
+		Enabled:      true,
+		DropSlope:    0.5,
+		Window:       10 * time.Minute,
+		StableSlope:  0.1,
+		MinDuration:  5 * time.Minute,
+		MaxDuration:  time.Hour,
+		SuspendState: suspendState,
+	}
+	err = config.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func assertOpenWindow(t *testing.T, step string, db OpenWindowDatabase, active bool, mode heatpump.Mode) {
+	t.Helper()
+
+	suspension, err := db.FetchOpenWindow(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching open window: %v", err)
+	}
+	state, err := db.FetchHeatpumpState(context.Background())
+	if err != nil {
+		t.Fatalf("error fetching state: %v", err)
+	}
+
+	if suspension.Active != active {
+		t.Errorf("%s: expected active %t, got: %t", step, active, suspension.Active)
+	}
+	if *state.Mode != mode {
+		t.Errorf("%s: expected mode %s, got: %s", step, mode, *state.Mode)
+	}
+}
+
+type fakeOpenWindowPublisher struct {
+	mu     sync.Mutex
+	events []window.Event
+}
+
+func (f *fakeOpenWindowPublisher) PublishOpenWindowEvent(ctx context.Context, event *window.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.events = append(f.events, *event)
+	return nil
+}
+
+func (f *fakeOpenWindowPublisher) assertEvents(t *testing.T, types ...window.EventType) {
+	t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.events) != len(types) {
+		t.Fatalf("expected %d events, got: %+v", len(types), f.events)
+	}
+	for i, eventType := range types {
+		if f.events[i].Type != eventType {
+			t.Errorf("expected event %d to be %s, got: %s", i, eventType, f.events[i].Type)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/weather"
+)
+
+// OutdoorTemperaturePoller periodically reads outdoor temperature from an
+// http(s) URL or a local file, for setups without an outdoor MQTT sensor.
+type OutdoorTemperaturePoller struct {
+	Source   string
+	Interval time.Duration
+	Updater  OutdoorTemperatureUpdater
+
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+type OutdoorTemperatureUpdater interface {
+	UpdateOutdoorTemperature(ctx context.Context, temperature float64) error
+}
+
+func NewOutdoorTemperaturePoller(source string, interval time.Duration, updater OutdoorTemperatureUpdater) *OutdoorTemperaturePoller {
+	var p OutdoorTemperaturePoller
+
+	p.Source = source
+	p.Interval = interval
+	p.Updater = updater
+	p.httpClient = &http.Client{Timeout: 10 * time.Second}
+
+	return &p
+}
+
+func (p *OutdoorTemperaturePoller) Start(ctx context.Context, errc chan<- error) {
+	p.mu.Lock()
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.mu.Unlock()
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := p.poll(ctx)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error polling outdoor temperature: %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *OutdoorTemperaturePoller) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	return nil
+}
+
+func (p *OutdoorTemperaturePoller) poll(ctx context.Context) error {
+	payload, err := p.read(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", p.Source, err)
+	}
+
+	var reading weather.OutdoorTemperatureReading
+	err = json.Unmarshal(payload, &reading)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling outdoor temperature reading: %v", err)
+	}
+
+	err = p.Updater.UpdateOutdoorTemperature(ctx, reading.Temperature)
+	if err != nil {
+		return fmt.Errorf("error updating outdoor temperature: %v", err)
+	}
+
+	return nil
+}
+
+func (p *OutdoorTemperaturePoller) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(p.Source, "http://") && !strings.HasPrefix(p.Source, "https://") {
+		return os.ReadFile(p.Source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return io.ReadAll(res.Body)
+}
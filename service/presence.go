@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/presence"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+// Presence tracks who's home from MQTT and HTTP sources, and aggregates it
+// into a debounced "anyone home" state used to pick comfort or eco target.
+type Presence struct {
+	Database PresenceDatabase
+	Heatpump HeatpumpStateUpdater
+	Config   PresenceConfig
+
+	// mu serialises read-modify-write of the stored status.
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+type PresenceConfig struct {
+	// AwayDebounce is how long everyone has to be gone before the house is
+	// considered empty, so that a phone briefly dropping off Wi-Fi is ignored.
+	AwayDebounce  time.Duration
+	MotionTimeout time.Duration
+
+	ComfortTemperature int
+	EcoTemperature     int
+	// ControlEnabled applies comfort or eco target temperature whenever the
+	// aggregate changes while heating.
+	ControlEnabled bool
+}
+
+func (c *PresenceConfig) Validate() error {
+	for _, temperature := range []int{c.ComfortTemperature, c.EcoTemperature} {
+		if temperature < heatpump.MinTargetTemperature || temperature > heatpump.MaxTargetTemperature {
+			return fmt.Errorf("target temperature must be in range [%d,%d]. got: %d", heatpump.MinTargetTemperature, heatpump.MaxTargetTemperature, temperature)
+		}
+	}
+
+	return nil
+}
+
+type PresenceDatabase interface {
+	FetchHeatpumpState(ctx context.Context) (*heatpump.State, error)
+	FetchPresence(ctx context.Context) (*presence.Status, error)
+	UpdatePresence(ctx context.Context, status *presence.Status) error
+}
+
+// presenceCheckInterval is how often debounce and motion timeouts are checked.
+const presenceCheckInterval = 30 * time.Second
+
+func NewPresence(database PresenceDatabase, heatpumpService HeatpumpStateUpdater, config PresenceConfig) *Presence {
+	var p Presence
+
+	p.Database = database
+	p.Heatpump = heatpumpService
+	p.Config = config
+
+	return &p
+}
+
+func (p *Presence) Start(ctx context.Context, errc chan<- error) {
+	p.mu.Lock()
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.mu.Unlock()
+
+	ticker := time.NewTicker(presenceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := p.update(ctx, now, nil)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error checking presence: %v", err))
+			}
+		}
+	}
+}
+
+func (p *Presence) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	return nil
+}
+
+func (p *Presence) FetchPresence(ctx context.Context) (_ *presence.Status, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchPresence")
+	defer func() { tracing.End(span, err) }()
+
+	status, err := p.Database.FetchPresence(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching presence: %v", err)
+	}
+
+	return status, nil
+}
+
+func (p *Presence) UpdatePersonPresence(ctx context.Context, name string, home bool, source presence.Source) (err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdatePersonPresence")
+	defer func() { tracing.End(span, err) }()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return &ErrInvalid{Err: errors.New("person name must not be empty")}
+	}
+
+	now := time.Now()
+	return p.update(ctx, now, func(status *presence.Status) {
+		status.People[name] = presence.Person{
+			Name:      name,
+			Home:      home,
+			Source:    source,
+			UpdatedAt: now,
+		}
+		metrics.SetPersonHome(name, home)
+	})
+}
+
+func (p *Presence) UpdateMotion(ctx context.Context, sensor string, motion bool) (err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateMotion")
+	defer func() { tracing.End(span, err) }()
+
+	// Absence of motion doesn't tell anything, the timeout takes care of it
+	if !motion {
+		return nil
+	}
+
+	now := time.Now()
+	return p.update(ctx, now, func(status *presence.Status) {
+		status.MotionSensors[sensor] = presence.MotionSensor{
+			Name:         sensor,
+			LastMotionAt: now,
+		}
+	})
+}
+
+// IsAway reports whether everyone's away, it's used by the rule conditions.
+func (p *Presence) IsAway(ctx context.Context) (bool, error) {
+	status, err := p.Database.FetchPresence(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error fetching presence: %v", err)
+	}
+
+	return !status.AnyoneHome, nil
+}
+
+// update applies the change to the stored status and recomputes the
+// debounced aggregate.
+func (p *Presence) update(ctx context.Context, now time.Time, change func(status *presence.Status)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status, err := p.Database.FetchPresence(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching presence: %v", err)
+	}
+
+	if change != nil {
+		change(status)
+	}
+
+	wasHome := status.AnyoneHome
+	wasPendingSince := status.AwayPendingSince
+
+	if status.Since.IsZero() {
+		status.Since = now
+	}
+
+	if status.Occupied(now, p.Config.MotionTimeout) {
+		status.AwayPendingSince = nil
+		if !status.AnyoneHome {
+			status.AnyoneHome = true
+			status.Since = now
+		}
+	} else if status.AnyoneHome {
+		if status.AwayPendingSince == nil {
+			status.AwayPendingSince = &now
+		}
+
+		if now.Sub(*status.AwayPendingSince) >= p.Config.AwayDebounce {
+			status.AnyoneHome = false
+			status.Since = now
+			status.AwayPendingSince = nil
+		}
+	}
+
+	// Nothing has changed on periodic checks most of the time
+	if change == nil && wasHome == status.AnyoneHome && wasPendingSince == status.AwayPendingSince {
+		return nil
+	}
+
+	err = p.Database.UpdatePresence(ctx, status)
+	if err != nil {
+		return fmt.Errorf("error updating presence: %v", err)
+	}
+
+	metrics.SetAnyoneHome(status.AnyoneHome)
+
+	if wasHome != status.AnyoneHome {
+		slog.Info("Presence has changed", "anyoneHome", status.AnyoneHome)
+
+		err = p.applyTarget(ctx, status.AnyoneHome)
+		if err != nil {
+			return fmt.Errorf("error applying presence target: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Presence) applyTarget(ctx context.Context, anyoneHome bool) error {
+	if !p.Config.ControlEnabled {
+		return nil
+	}
+
+	state, err := p.Database.FetchHeatpumpState(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching heatpump state: %v", err)
+	}
+
+	// Comfort and eco setpoints are heating ones, cooling is left alone
+	if !slices.Contains(heatingModes, *state.Mode) {
+		return nil
+	}
+
+	targetTemperature := p.Config.EcoTemperature
+	if anyoneHome {
+		targetTemperature = p.Config.ComfortTemperature
+	}
+
+	_, err = p.Heatpump.UpdateHeatpumpState(ctx, heatpump.AutomationSource, &heatpump.State{
+		TargetTemperature: &targetTemperature,
+	})
+	if err != nil {
+		return fmt.Errorf("error updating heatpump state: %v", err)
+	}
+
+	return nil
+}
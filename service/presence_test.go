@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/presence"
+)
+
+func TestPresenceDebouncesAwayAndAppliesTargets(t *testing.T) {
+	db := newTestDatabase(t)
+	p := NewPresence(db, &fakeStateUpdater{database: db}, PresenceConfig{
+		AwayDebounce:       10 * time.Minute,
+		MotionTimeout:      15 * time.Minute,
+		ComfortTemperature: 23,
+		EcoTemperature:     18,
+		ControlEnabled:     true,
+	})
+
+	person := func(home bool) func(status *presence.Status) {
+		return func(status *presence.Status) {
+			status.People["alex"] = presence.Person{Name: "alex", Home: home, Source: presence.HTTPSource}
+		}
+	}
+	motion := func(at time.Time) func(status *presence.Status) {
+		return func(status *presence.Status) {
+			status.MotionSensors["hall"] = presence.MotionSensor{Name: "hall", LastMotionAt: at}
+		}
+	}
+
+	start := time.Now()
+	steps := []struct {
+		name              string
+		after             time.Duration
+		change            func(status *presence.Status)
+		anyoneHome        bool
+		targetTemperature int
+	}{
+		{"leaves", 0, person(false), true, 22},
+		{"away within debounce", 5 * time.Minute, nil, true, 22},
+		{"back before debounce", 6 * time.Minute, person(true), true, 22},
+		{"leaves again", 7 * time.Minute, person(false), true, 22},
+		{"debounce restarted", 16 * time.Minute, nil, true, 22},
+		{"away after debounce", 17 * time.Minute, nil, false, 18},
+		{"motion", 20 * time.Minute, motion(start.Add(20 * time.Minute)), true, 23},
+		{"motion timed out", 35 * time.Minute, nil, true, 23},
+		{"away after motion timeout and debounce", 45 * time.Minute, nil, false, 18},
+	}
+
+	for _, step := range steps {
+		err := p.update(context.Background(), start.Add(step.after), step.change)
+		if err != nil {
+			t.Fatalf("%s: error updating presence: %v", step.name, err)
+		}
+
+		status, err := db.FetchPresence(context.Background())
+		if err != nil {
+			t.Fatalf("error fetching presence: %v", err)
+		}
+		state, err := db.FetchHeatpumpState(context.Background())
+		if err != nil {
+			t.Fatalf("error fetching state: %v", err)
+		}
+
+		if status.AnyoneHome != step.anyoneHome {
+			t.Errorf("%s: expected anyone home %t, got: %t", step.name, step.anyoneHome, status.AnyoneHome)
+		}
+		if *state.TargetTemperature != step.targetTemperature {
+			t.Errorf("%s: expected target temperature %d, got: %d", step.name, step.targetTemperature, *state.TargetTemperature)
+		}
+	}
+}
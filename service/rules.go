@@ -0,0 +1,689 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/clock"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/rule"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Rules evaluates the stored rules against sensor readings, state changes,
+// MQTT messages and time of day.
+type Rules struct {
+	Database RulesDatabase
+	Heatpump HeatpumpStateUpdater
+	PubSub   RulesPubSub
+	Events   EventSubscriber
+	// Presence is optional, away conditions never hold without it.
+	Presence PresenceChecker
+	// ReservedTopics are the topic filters the API consumes or produces, rules
+	// can't publish to them.
+	ReservedTopics []string
+
+	httpClient *http.Client
+	// mqttEvents decouples rule execution from the pubsub network loop.
+	mqttEvents chan rule.Event
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	running  bool
+	watched  map[string]bool
+	readings map[heatpump.Sensor]float64
+	// firedOn is the last date time triggers have fired, by rule id.
+	firedOn map[string]string
+
+	// ownMessages are the messages published by rule actions that are still
+	// expected back from the watched topics, by topic and payload. They are
+	// ignored, so that rules can't trigger each other in a loop. It has its
+	// own lock, since watches deliver messages while mu is held.
+	ownMu       sync.Mutex
+	ownMessages map[ownMessage][]time.Time
+}
+
+type ownMessage struct {
+	topic   string
+	payload string
+}
+
+type RulesDatabase interface {
+	FetchRules(ctx context.Context) ([]rule.Rule, error)
+	FetchRule(ctx context.Context, id string) (*rule.Rule, error)
+	AddRule(ctx context.Context, r *rule.Rule) error
+	UpdateRule(ctx context.Context, r *rule.Rule) error
+	UpdateRuleTriggeredAt(ctx context.Context, id string, triggeredAt time.Time) error
+	DeleteRule(ctx context.Context, id string) error
+	FetchHeatpumpState(ctx context.Context) (*heatpump.State, error)
+}
+
+type RulesPubSub interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Watch(ctx context.Context, filter string, handler func(ctx context.Context, topic string, payload []byte) error) error
+	Unwatch(ctx context.Context, filter string) error
+}
+
+type EventSubscriber interface {
+	Subscribe(bufferSize int, types ...bus.Type) (events <-chan bus.Event, unsubscribe func())
+}
+
+type PresenceChecker interface {
+	IsAway(ctx context.Context) (bool, error)
+}
+
+const (
+	eventsBufferSize       = 100
+	rulesTimeCheckInterval = 15 * time.Second
+	webhookTimeout         = 10 * time.Second
+	// ownMessageTimeout is how long a published message is expected back, in
+	// case the broker drops it.
+	ownMessageTimeout = 30 * time.Second
+)
+
+func NewRules(database RulesDatabase, heatpumpService HeatpumpStateUpdater, pubsub RulesPubSub, events EventSubscriber, presence PresenceChecker, reservedTopics []string) *Rules {
+	var r Rules
+
+	r.Database = database
+	r.Heatpump = heatpumpService
+	r.PubSub = pubsub
+	r.Events = events
+	r.Presence = presence
+	r.ReservedTopics = reservedTopics
+	r.httpClient = &http.Client{Timeout: webhookTimeout}
+	r.mqttEvents = make(chan rule.Event, eventsBufferSize)
+	r.watched = make(map[string]bool)
+	r.readings = make(map[heatpump.Sensor]float64)
+	r.firedOn = make(map[string]string)
+	r.ownMessages = make(map[ownMessage][]time.Time)
+
+	return &r
+}
+
+func (r *Rules) Start(ctx context.Context, errc chan<- error) {
+	r.mu.Lock()
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.running = true
+	r.mu.Unlock()
+
+	events, unsubscribe := r.Events.Subscribe(eventsBufferSize, bus.ReadingEvent, bus.StateChangeEvent)
+	defer unsubscribe()
+
+	err := r.syncWatches(ctx)
+	if err != nil {
+		errc <- fmt.Errorf("error watching rule topics: %v", err)
+		return
+	}
+
+	ticker := time.NewTicker(rulesTimeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			event, ok := r.fromBusEvent(e)
+			if ok {
+				r.handle(ctx, event)
+			}
+		case event := <-r.mqttEvents:
+			r.handle(ctx, event)
+		case now := <-ticker.C:
+			r.handle(ctx, rule.Event{Type: rule.TimeTrigger, Time: now})
+		}
+	}
+}
+
+func (r *Rules) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	r.running = false
+	if r.cancel != nil {
+		r.cancel()
+	}
+	watched := r.watched
+	r.watched = make(map[string]bool)
+	r.mu.Unlock()
+
+	for filter := range watched {
+		err := r.PubSub.Unwatch(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("error unwatching topic %s: %v", filter, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rules) FetchRules(ctx context.Context) (_ []rule.Rule, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchRules")
+	defer func() { tracing.End(span, err) }()
+
+	rules, err := r.Database.FetchRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching rules: %v", err)
+	}
+
+	return rules, nil
+}
+
+func (r *Rules) FetchRule(ctx context.Context, id string) (_ *rule.Rule, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchRule")
+	defer func() { tracing.End(span, err) }()
+
+	return r.Database.FetchRule(ctx, id)
+}
+
+func (r *Rules) CreateRule(ctx context.Context, newRule *rule.Rule) (_ *rule.Rule, err error) {
+	ctx, span := tracing.Start(ctx, "service.CreateRule")
+	defer func() { tracing.End(span, err) }()
+
+	err = newRule.Validate(r.ReservedTopics)
+	if err != nil {
+		return nil, &ErrInvalid{Err: fmt.Errorf("error validating rule: %v", err)}
+	}
+
+	newRule.ID, err = rule.NewID()
+	if err != nil {
+		return nil, err
+	}
+	newRule.CreatedAt = time.Now().UTC()
+	newRule.UpdatedAt = newRule.CreatedAt
+	newRule.LastTriggeredAt = nil
+
+	err = r.Database.AddRule(ctx, newRule)
+	if err != nil {
+		return nil, fmt.Errorf("error adding rule: %v", err)
+	}
+
+	err = r.syncWatches(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error watching rule topics: %v", err)
+	}
+
+	return newRule, nil
+}
+
+func (r *Rules) UpdateRule(ctx context.Context, id string, updatedRule *rule.Rule) (_ *rule.Rule, err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateRule")
+	defer func() { tracing.End(span, err) }()
+
+	err = updatedRule.Validate(r.ReservedTopics)
+	if err != nil {
+		return nil, &ErrInvalid{Err: fmt.Errorf("error validating rule: %v", err)}
+	}
+
+	existingRule, err := r.Database.FetchRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updatedRule.ID = existingRule.ID
+	updatedRule.CreatedAt = existingRule.CreatedAt
+	updatedRule.LastTriggeredAt = existingRule.LastTriggeredAt
+	updatedRule.UpdatedAt = time.Now().UTC()
+
+	err = r.Database.UpdateRule(ctx, updatedRule)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.syncWatches(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error watching rule topics: %v", err)
+	}
+
+	return updatedRule, nil
+}
+
+func (r *Rules) DeleteRule(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.DeleteRule")
+	defer func() { tracing.End(span, err) }()
+
+	err = r.Database.DeleteRule(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = r.syncWatches(ctx)
+	if err != nil {
+		return fmt.Errorf("error watching rule topics: %v", err)
+	}
+
+	return nil
+}
+
+// TestRule evaluates the rule against the input without running its actions.
+func (r *Rules) TestRule(ctx context.Context, id string, input *rule.TestInput) (_ *rule.Evaluation, err error) {
+	ctx, span := tracing.Start(ctx, "service.TestRule")
+	defer func() { tracing.End(span, err) }()
+
+	testedRule, err := r.Database.FetchRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Event.Time.IsZero() {
+		input.Event.Time = time.Now()
+	}
+
+	return r.evaluate(ctx, testedRule, &input.Event, input.Mode, input.Away)
+}
+
+// syncWatches watches topics of enabled mqtt rules and unwatches the rest. It
+// does nothing while the service isn't running.
+func (r *Rules) syncWatches(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.running {
+		return nil
+	}
+
+	rules, err := r.Database.FetchRules(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching rules: %v", err)
+	}
+
+	filters := make(map[string]bool)
+	for _, rl := range rules {
+		if rl.Enabled && rl.Trigger.Type == rule.MQTTTrigger {
+			filters[rl.Trigger.Topic] = true
+		}
+	}
+
+	for filter := range filters {
+		if r.watched[filter] {
+			continue
+		}
+
+		err := r.PubSub.Watch(ctx, filter, r.handleMessage)
+		if err != nil {
+			return fmt.Errorf("error watching topic %s: %v", filter, err)
+		}
+		r.watched[filter] = true
+	}
+
+	for filter := range r.watched {
+		if filters[filter] {
+			continue
+		}
+
+		err := r.PubSub.Unwatch(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("error unwatching topic %s: %v", filter, err)
+		}
+		delete(r.watched, filter)
+	}
+
+	return nil
+}
+
+func (r *Rules) handleMessage(ctx context.Context, topic string, payload []byte) error {
+	if r.takeOwnMessage(topic, payload) {
+		slog.Debug("Ignoring message published by a rule", "topic", topic)
+		return nil
+	}
+
+	event := rule.Event{
+		Type:    rule.MQTTTrigger,
+		Time:    time.Now(),
+		Topic:   topic,
+		Payload: string(payload),
+	}
+
+	select {
+	case r.mqttEvents <- event:
+	default:
+		slog.Warn("Rule engine is busy, dropping MQTT event", "topic", topic)
+	}
+
+	return nil
+}
+
+func (r *Rules) fromBusEvent(e bus.Event) (rule.Event, bool) {
+	switch data := e.Data.(type) {
+	case bus.Reading:
+		event := rule.Event{
+			Type:   rule.ReadingTrigger,
+			Time:   e.Time,
+			Sensor: data.Sensor,
+			Value:  data.Value,
+		}
+
+		previousValue, ok := r.readings[data.Sensor]
+		if ok {
+			event.PreviousValue = &previousValue
+		}
+		r.readings[data.Sensor] = data.Value
+
+		return event, true
+	case bus.StateChange:
+		// Changes made by rules themselves would let rules trigger each other
+		// endlessly
+		if data.Source == heatpump.AutomationSource {
+			return rule.Event{}, false
+		}
+
+		return rule.Event{
+			Type:   rule.StateChangeTrigger,
+			Time:   e.Time,
+			State:  &data.State,
+			Fields: data.Fields,
+		}, true
+	default:
+		return rule.Event{}, false
+	}
+}
+
+func (r *Rules) handle(ctx context.Context, event rule.Event) {
+	rules, err := r.Database.FetchRules(ctx)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error fetching rules: %v", err))
+		return
+	}
+
+	for _, rl := range rules {
+		if !rl.Enabled || rl.Trigger.Type != event.Type {
+			continue
+		}
+
+		date := event.Time.Format(time.DateOnly)
+		if rl.Trigger.Type == rule.TimeTrigger && r.firedOn[rl.ID] == date {
+			continue
+		}
+
+		evaluation, err := r.evaluate(ctx, &rl, &event, nil, nil)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error evaluating rule %s: %v", rl.ID, err))
+			continue
+		}
+
+		if !evaluation.Triggered {
+			continue
+		}
+
+		if rl.Trigger.Type == rule.TimeTrigger {
+			r.firedOn[rl.ID] = date
+		}
+
+		if !evaluation.Matched {
+			continue
+		}
+
+		r.execute(ctx, &rl, &event)
+	}
+}
+
+func (r *Rules) evaluate(ctx context.Context, rl *rule.Rule, event *rule.Event, mode *heatpump.Mode, away *bool) (*rule.Evaluation, error) {
+	evaluation := rule.Evaluation{
+		RuleID:     rl.ID,
+		Triggered:  triggered(&rl.Trigger, event),
+		Conditions: []rule.ConditionEvaluation{},
+		Actions:    []rule.Action{},
+	}
+
+	if !evaluation.Triggered {
+		return &evaluation, nil
+	}
+
+	evaluation.Matched = true
+	for _, condition := range rl.Conditions {
+		conditionEvaluation, err := r.evaluateCondition(ctx, condition, event.Time, mode, away)
+		if err != nil {
+			return nil, err
+		}
+
+		evaluation.Conditions = append(evaluation.Conditions, *conditionEvaluation)
+		if !conditionEvaluation.Holds {
+			evaluation.Matched = false
+		}
+	}
+
+	if evaluation.Matched {
+		evaluation.Actions = rl.Actions
+	}
+
+	return &evaluation, nil
+}
+
+func triggered(trigger *rule.Trigger, event *rule.Event) bool {
+	if trigger.Type != event.Type {
+		return false
+	}
+
+	switch trigger.Type {
+	case rule.ReadingTrigger:
+		if trigger.Sensor != event.Sensor {
+			return false
+		}
+		// Fire only when crossing the threshold, not on every reading beyond it
+		if trigger.Above != nil {
+			return event.Value > *trigger.Above && (event.PreviousValue == nil || *event.PreviousValue <= *trigger.Above)
+		}
+		if trigger.Below != nil {
+			return event.Value < *trigger.Below && (event.PreviousValue == nil || *event.PreviousValue >= *trigger.Below)
+		}
+		return false
+	case rule.TimeTrigger:
+		return event.Time.Format("15:04") == trigger.At
+	case rule.MQTTTrigger:
+		return pubsub.MatchTopic(trigger.Topic, event.Topic)
+	case rule.StateChangeTrigger:
+		if trigger.Field != "" && !slices.Contains(event.Fields, trigger.Field) {
+			return false
+		}
+		if trigger.Mode != nil {
+			return slices.Contains(event.Fields, "mode") && event.State != nil && event.State.Mode != nil && *event.State.Mode == *trigger.Mode
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Rules) evaluateCondition(ctx context.Context, condition rule.Condition, now time.Time, mode *heatpump.Mode, away *bool) (*rule.ConditionEvaluation, error) {
+	evaluation := rule.ConditionEvaluation{Condition: condition}
+
+	switch condition.Type {
+	case rule.TimeWindowCondition:
+		window, err := clock.ParseWindow(condition.Window)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing condition window: %v", err)
+		}
+		evaluation.Holds = window.Contains(now)
+	case rule.ModeCondition:
+		if mode == nil {
+			state, err := r.Database.FetchHeatpumpState(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error fetching heatpump state: %v", err)
+			}
+			mode = state.Mode
+		}
+		evaluation.Holds = slices.Contains(condition.Modes, *mode)
+		evaluation.Reason = fmt.Sprintf("mode is %s", *mode)
+	case rule.AwayCondition:
+		if away == nil {
+			if r.Presence == nil {
+				evaluation.Reason = "presence isn't configured"
+				return &evaluation, nil
+			}
+
+			isAway, err := r.Presence.IsAway(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error checking presence: %v", err)
+			}
+			away = &isAway
+		}
+		evaluation.Holds = *away == *condition.Away
+		evaluation.Reason = fmt.Sprintf("away is %t", *away)
+	}
+
+	return &evaluation, nil
+}
+
+func (r *Rules) execute(ctx context.Context, rl *rule.Rule, event *rule.Event) {
+	ctx, span := tracing.Start(ctx, "service.ExecuteRule", trace.WithAttributes(tracing.Attr("rule.id", rl.ID)))
+	var errs []error
+	defer func() { tracing.End(span, errors.Join(errs...)) }()
+
+	slog.Info(fmt.Sprintf("Executing rule %q", rl.Name), "id", rl.ID, "trigger", rl.Trigger.Type)
+
+	for _, action := range rl.Actions {
+		err := r.executeAction(ctx, rl, &action, event)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error executing %s action of rule %s: %v", action.Type, rl.ID, err))
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		metrics.AddRuleExecution(rl.ID, "ERR")
+	} else {
+		metrics.AddRuleExecution(rl.ID, "OK")
+	}
+
+	err := r.Database.UpdateRuleTriggeredAt(ctx, rl.ID, event.Time.UTC())
+	if err != nil && !isNotFound(err) {
+		slog.Error(fmt.Sprintf("Error updating rule %s trigger time: %v", rl.ID, err))
+	}
+}
+
+func (r *Rules) executeAction(ctx context.Context, rl *rule.Rule, action *rule.Action, event *rule.Event) error {
+	switch action.Type {
+	case rule.SetStateAction:
+		state := *action.State
+		_, err := r.Heatpump.UpdateHeatpumpState(ctx, heatpump.AutomationSource, &state)
+		if err != nil {
+			return fmt.Errorf("error updating heatpump state: %v", err)
+		}
+	case rule.PublishAction:
+		// Rules stored before the topic was reserved, or restored from a
+		// backup, aren't validated again
+		for _, reserved := range r.ReservedTopics {
+			if pubsub.MatchTopic(reserved, action.Topic) {
+				return fmt.Errorf("topic %s is used by the API itself", action.Topic)
+			}
+		}
+
+		r.addOwnMessage(action.Topic, []byte(action.Payload))
+		err := r.PubSub.Publish(ctx, action.Topic, []byte(action.Payload))
+		if err != nil {
+			r.takeOwnMessage(action.Topic, []byte(action.Payload))
+			return fmt.Errorf("error publishing message: %v", err)
+		}
+	case rule.WebhookAction:
+		err := r.callWebhook(ctx, rl, action.URL, event)
+		if err != nil {
+			return fmt.Errorf("error calling webhook: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported action type: %s", action.Type)
+	}
+
+	return nil
+}
+
+// addOwnMessage expects the message back if one of the watched filters
+// matches its topic.
+func (r *Rules) addOwnMessage(topic string, payload []byte) {
+	r.mu.Lock()
+	var watched bool
+	for filter := range r.watched {
+		if pubsub.MatchTopic(filter, topic) {
+			watched = true
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	if !watched {
+		return
+	}
+
+	r.ownMu.Lock()
+	defer r.ownMu.Unlock()
+
+	key := ownMessage{topic: topic, payload: string(payload)}
+	r.ownMessages[key] = append(r.ownMessages[key], time.Now().Add(ownMessageTimeout))
+}
+
+// takeOwnMessage reports whether the message was published by a rule, and
+// stops expecting it.
+func (r *Rules) takeOwnMessage(topic string, payload []byte) bool {
+	r.ownMu.Lock()
+	defer r.ownMu.Unlock()
+
+	now := time.Now()
+	for key, expiries := range r.ownMessages {
+		expiries = slices.DeleteFunc(expiries, func(expiresAt time.Time) bool {
+			return now.After(expiresAt)
+		})
+		if len(expiries) == 0 {
+			delete(r.ownMessages, key)
+		} else {
+			r.ownMessages[key] = expiries
+		}
+	}
+
+	key := ownMessage{topic: topic, payload: string(payload)}
+	expiries, ok := r.ownMessages[key]
+	if !ok {
+		return false
+	}
+
+	if len(expiries) == 1 {
+		delete(r.ownMessages, key)
+	} else {
+		r.ownMessages[key] = expiries[1:]
+	}
+
+	return true
+}
+
+type webhookPayload struct {
+	RuleID   string     `json:"ruleId"`
+	RuleName string     `json:"ruleName"`
+	Event    rule.Event `json:"event"`
+}
+
+func (r *Rules) callWebhook(ctx context.Context, rl *rule.Rule, url string, event *rule.Event) error {
+	body, err := json.Marshal(webhookPayload{
+		RuleID:   rl.ID,
+		RuleName: rl.Name,
+		Event:    *event,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,594 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/bus"
+	"github.com/alexchebotarsky/heatpump-api/metrics"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/webhook"
+	"github.com/alexchebotarsky/heatpump-api/tracing"
+)
+
+// Webhooks notifies subscribed URLs of events. Deliveries are queued in the
+// database, so that pending ones survive a restart, and retried with
+// exponential backoff. A delivery may be sent more than once if the service
+// stops right after sending it, receivers can deduplicate by its id.
+type Webhooks struct {
+	Database WebhooksDatabase
+	Events   EventSubscriber
+	Config   WebhooksConfig
+
+	httpClient *http.Client
+	// wake starts delivery of newly queued events without waiting for the
+	// next tick.
+	wake chan struct{}
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	// readings and sensorStale are only accessed by the event loop.
+	readings    map[heatpump.Sensor]float64
+	sensorStale bool
+}
+
+type WebhooksConfig struct {
+	// MaxAttempts after which the delivery is dropped.
+	MaxAttempts int
+	// RetryBackoff is doubled after every failed attempt up to MaxRetryBackoff.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	Timeout         time.Duration
+	// SensorMaxAge is how old the last temperature reading can be before the
+	// sensor is reported stale.
+	SensorMaxAge time.Duration
+}
+
+func (c *WebhooksConfig) Validate() error {
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1, got: %d", c.MaxAttempts)
+	}
+
+	if c.RetryBackoff <= 0 {
+		return errors.New("retry backoff must be positive")
+	}
+
+	if c.MaxRetryBackoff < c.RetryBackoff {
+		return fmt.Errorf("max retry backoff must not be less than retry backoff, got: %s and %s", c.MaxRetryBackoff, c.RetryBackoff)
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+
+	return nil
+}
+
+// backoff returns the delay before the next attempt after the given number
+// of attempts.
+func (c *WebhooksConfig) backoff(attempts int) time.Duration {
+	backoff := c.RetryBackoff
+	for i := 1; i < attempts && backoff < c.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, c.MaxRetryBackoff)
+}
+
+type WebhooksDatabase interface {
+	FetchWebhooks(ctx context.Context) ([]webhook.Webhook, error)
+	FetchWebhook(ctx context.Context, id string) (*webhook.Webhook, error)
+	AddWebhook(ctx context.Context, w *webhook.Webhook) error
+	UpdateWebhook(ctx context.Context, w *webhook.Webhook) error
+	DeleteWebhook(ctx context.Context, id string) error
+	FetchWebhookQueue(ctx context.Context) ([]webhook.Delivery, error)
+	EnqueueWebhookDeliveries(ctx context.Context, deliveries []webhook.Delivery) error
+	UpdateWebhookDelivery(ctx context.Context, delivery *webhook.Delivery) error
+	RemoveWebhookDelivery(ctx context.Context, id string) error
+	FetchWebhookDeliveries(ctx context.Context, webhookID string) ([]webhook.Attempt, error)
+	AddWebhookAttempt(ctx context.Context, webhookID string, attempt *webhook.Attempt) error
+	FetchTemperatureAndHumidityUpdatedAt(ctx context.Context) (time.Time, error)
+}
+
+const (
+	webhookDeliveryInterval    = time.Second
+	webhookSensorCheckInterval = 30 * time.Second
+	// maxWebhookResponseSize is read from the response before it's discarded,
+	// so that the connection can be reused.
+	maxWebhookResponseSize = 64 << 10
+)
+
+func NewWebhooks(database WebhooksDatabase, events EventSubscriber, config WebhooksConfig) *Webhooks {
+	var w Webhooks
+
+	w.Database = database
+	w.Events = events
+	w.Config = config
+	w.httpClient = &http.Client{Timeout: config.Timeout}
+	w.wake = make(chan struct{}, 1)
+
+	return &w
+}
+
+func (w *Webhooks) Start(ctx context.Context, errc chan<- error) {
+	w.mu.Lock()
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.readings = make(map[heatpump.Sensor]float64)
+	w.sensorStale = false
+	w.mu.Unlock()
+
+	events, unsubscribe := w.Events.Subscribe(eventsBufferSize, bus.ReadingEvent, bus.StateChangeEvent, bus.TransmissionFailedEvent)
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.deliverLoop(ctx)
+	}()
+	defer wg.Wait()
+
+	ticker := time.NewTicker(webhookSensorCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			err := w.handleEvent(ctx, e)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error queueing webhook deliveries for %s event: %v", e.Type, err))
+			}
+		case now := <-ticker.C:
+			err := w.checkSensor(ctx, now)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error checking temperature sensor for webhooks: %v", err))
+			}
+		}
+	}
+}
+
+func (w *Webhooks) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cancel != nil {
+		w.cancel()
+	}
+
+	return nil
+}
+
+func (w *Webhooks) FetchWebhooks(ctx context.Context) (_ []webhook.Webhook, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchWebhooks")
+	defer func() { tracing.End(span, err) }()
+
+	webhooks, err := w.Database.FetchWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhooks: %v", err)
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+func (w *Webhooks) FetchWebhook(ctx context.Context, id string) (_ *webhook.Webhook, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchWebhook")
+	defer func() { tracing.End(span, err) }()
+
+	fetchedWebhook, err := w.Database.FetchWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	fetchedWebhook.Secret = ""
+
+	return fetchedWebhook, nil
+}
+
+// CreateWebhook stores the webhook and returns it with its secret, which is
+// not returned afterwards.
+func (w *Webhooks) CreateWebhook(ctx context.Context, newWebhook *webhook.Webhook) (_ *webhook.Webhook, err error) {
+	ctx, span := tracing.Start(ctx, "service.CreateWebhook")
+	defer func() { tracing.End(span, err) }()
+
+	err = newWebhook.Validate()
+	if err != nil {
+		return nil, &ErrInvalid{Err: fmt.Errorf("error validating webhook: %v", err)}
+	}
+
+	newWebhook.ID, err = webhook.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	if newWebhook.Secret == "" {
+		newWebhook.Secret, err = webhook.NewSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newWebhook.CreatedAt = time.Now().UTC()
+	newWebhook.UpdatedAt = newWebhook.CreatedAt
+
+	err = w.Database.AddWebhook(ctx, newWebhook)
+	if err != nil {
+		return nil, fmt.Errorf("error adding webhook: %v", err)
+	}
+
+	return newWebhook, nil
+}
+
+// UpdateWebhook replaces the webhook, the secret is kept if it's not set.
+func (w *Webhooks) UpdateWebhook(ctx context.Context, id string, updatedWebhook *webhook.Webhook) (_ *webhook.Webhook, err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateWebhook")
+	defer func() { tracing.End(span, err) }()
+
+	err = updatedWebhook.Validate()
+	if err != nil {
+		return nil, &ErrInvalid{Err: fmt.Errorf("error validating webhook: %v", err)}
+	}
+
+	existingWebhook, err := w.Database.FetchWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updatedWebhook.ID = existingWebhook.ID
+	updatedWebhook.CreatedAt = existingWebhook.CreatedAt
+	updatedWebhook.UpdatedAt = time.Now().UTC()
+	if updatedWebhook.Secret == "" {
+		updatedWebhook.Secret = existingWebhook.Secret
+	}
+
+	err = w.Database.UpdateWebhook(ctx, updatedWebhook)
+	if err != nil {
+		return nil, err
+	}
+
+	result := *updatedWebhook
+	result.Secret = ""
+
+	return &result, nil
+}
+
+func (w *Webhooks) DeleteWebhook(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.DeleteWebhook")
+	defer func() { tracing.End(span, err) }()
+
+	return w.Database.DeleteWebhook(ctx, id)
+}
+
+// FetchWebhookDeliveries returns the delivery log of the webhook, latest
+// attempts first.
+func (w *Webhooks) FetchWebhookDeliveries(ctx context.Context, id string) (_ []webhook.Attempt, err error) {
+	ctx, span := tracing.Start(ctx, "service.FetchWebhookDeliveries")
+	defer func() { tracing.End(span, err) }()
+
+	_, err = w.Database.FetchWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts, err := w.Database.FetchWebhookDeliveries(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook deliveries: %v", err)
+	}
+
+	return attempts, nil
+}
+
+func (w *Webhooks) handleEvent(ctx context.Context, e bus.Event) error {
+	switch data := e.Data.(type) {
+	case bus.StateChange:
+		return w.enqueue(ctx, webhook.StateChangedEvent, e.Time, func(*webhook.Webhook) (any, bool) {
+			return data, true
+		})
+	case bus.TransmissionFailed:
+		return w.enqueue(ctx, webhook.TransmissionFailedEvent, e.Time, func(*webhook.Webhook) (any, bool) {
+			return webhook.TransmissionFailed{State: data.State, Error: data.Error}, true
+		})
+	case bus.Reading:
+		var previousValue *float64
+		value, ok := w.readings[data.Sensor]
+		if ok {
+			previousValue = &value
+		}
+		w.readings[data.Sensor] = data.Value
+
+		return w.enqueue(ctx, webhook.ThresholdCrossedEvent, e.Time, func(wh *webhook.Webhook) (any, bool) {
+			for _, threshold := range wh.Thresholds {
+				if threshold.Sensor == data.Sensor && threshold.Crossed(previousValue, data.Value) {
+					return webhook.ThresholdCrossed{
+						Threshold:     threshold,
+						Value:         data.Value,
+						PreviousValue: previousValue,
+					}, true
+				}
+			}
+			return nil, false
+		})
+	default:
+		return nil
+	}
+}
+
+// checkSensor reports the temperature sensor stale once, until it reports
+// again.
+func (w *Webhooks) checkSensor(ctx context.Context, now time.Time) error {
+	updatedAt, err := w.Database.FetchTemperatureAndHumidityUpdatedAt(ctx)
+	if err != nil {
+		if isNotFound(err) {
+			// The sensor has never reported, there's nothing to go stale
+			return nil
+		}
+		return fmt.Errorf("error fetching temperature sensor update time: %v", err)
+	}
+
+	stale := now.Sub(updatedAt) > w.Config.SensorMaxAge
+	if !stale || w.sensorStale {
+		w.sensorStale = stale
+		return nil
+	}
+
+	err = w.enqueue(ctx, webhook.SensorStaleEvent, now, func(*webhook.Webhook) (any, bool) {
+		return webhook.SensorStale{
+			Sensor:    heatpump.TemperatureSensor,
+			UpdatedAt: &updatedAt,
+			MaxAge:    w.Config.SensorMaxAge.String(),
+		}, true
+	})
+	if err != nil {
+		return err
+	}
+	w.sensorStale = true
+
+	return nil
+}
+
+// enqueue queues a delivery of the event to every subscribed webhook that
+// data returns true for.
+func (w *Webhooks) enqueue(ctx context.Context, eventType webhook.EventType, eventTime time.Time, data func(wh *webhook.Webhook) (any, bool)) error {
+	webhooks, err := w.Database.FetchWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching webhooks: %v", err)
+	}
+
+	now := time.Now().UTC()
+
+	var deliveries []webhook.Delivery
+	for _, wh := range webhooks {
+		if !wh.Subscribed(eventType) {
+			continue
+		}
+
+		eventData, ok := data(&wh)
+		if !ok {
+			continue
+		}
+
+		id, err := webhook.NewID()
+		if err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(webhook.Event{
+			ID:   id,
+			Type: eventType,
+			Time: eventTime.UTC(),
+			Data: eventData,
+		})
+		if err != nil {
+			return fmt.Errorf("error encoding webhook event: %v", err)
+		}
+
+		deliveries = append(deliveries, webhook.Delivery{
+			ID:            id,
+			WebhookID:     wh.ID,
+			EventType:     eventType,
+			Payload:       payload,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	err = w.Database.EnqueueWebhookDeliveries(ctx, deliveries)
+	if err != nil {
+		return fmt.Errorf("error queueing webhook deliveries: %v", err)
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (w *Webhooks) deliverLoop(ctx context.Context) {
+	ticker := time.NewTicker(webhookDeliveryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+
+		err := w.deliverDue(ctx)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error delivering webhooks: %v", err))
+		}
+	}
+}
+
+// deliverDue sends the queued deliveries whose next attempt is due. Each
+// webhook gets its own goroutine, so that a slow or failing receiver doesn't
+// hold up the others, and its deliveries are sent in the queued order.
+func (w *Webhooks) deliverDue(ctx context.Context) error {
+	queue, err := w.Database.FetchWebhookQueue(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching webhook queue: %v", err)
+	}
+	metrics.SetWebhookQueueSize(len(queue))
+
+	now := time.Now()
+	due := make(map[string][]webhook.Delivery)
+	for _, delivery := range queue {
+		if delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		due[delivery.WebhookID] = append(due[delivery.WebhookID], delivery)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 0, len(due))
+	var errsMu sync.Mutex
+
+	for webhookID, deliveries := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := w.deliverAll(ctx, deliveries)
+			if err != nil {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("error delivering to webhook %s: %v", webhookID, err))
+				errsMu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// deliverAll sends the deliveries of a single webhook one by one. The rest are
+// left for the next round once one isn't accepted, the receiver is likely down.
+func (w *Webhooks) deliverAll(ctx context.Context, deliveries []webhook.Delivery) error {
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		accepted, err := w.deliver(ctx, &delivery)
+		if err != nil {
+			return fmt.Errorf("error delivering %s: %v", delivery.ID, err)
+		}
+
+		if !accepted {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// deliver sends the delivery and records the attempt, it reports whether the
+// webhook can take further deliveries.
+func (w *Webhooks) deliver(ctx context.Context, delivery *webhook.Delivery) (bool, error) {
+	wh, err := w.Database.FetchWebhook(ctx, delivery.WebhookID)
+	if err != nil && !isNotFound(err) {
+		return false, fmt.Errorf("error fetching webhook: %v", err)
+	}
+
+	if wh == nil || !wh.Enabled {
+		// Deleted or disabled since the event, it's not interested anymore
+		return true, w.Database.RemoveWebhookDelivery(ctx, delivery.ID)
+	}
+
+	now := time.Now().UTC()
+	statusCode, sendErr := w.send(ctx, wh, delivery, now)
+	if ctx.Err() != nil {
+		// Interrupted by shutdown, the attempt is repeated after restart
+		return false, nil
+	}
+
+	delivery.Attempts++
+	attempt := webhook.Attempt{
+		DeliveryID: delivery.ID,
+		EventType:  delivery.EventType,
+		Attempt:    delivery.Attempts,
+		Time:       now,
+		StatusCode: statusCode,
+	}
+
+	switch {
+	case sendErr == nil:
+		attempt.Status = webhook.DeliveredStatus
+		err = w.Database.RemoveWebhookDelivery(ctx, delivery.ID)
+	case delivery.Attempts >= w.Config.MaxAttempts:
+		attempt.Status = webhook.FailedStatus
+		attempt.Error = sendErr.Error()
+		slog.Warn(fmt.Sprintf("Webhook delivery %s failed after %d attempts: %v", delivery.ID, delivery.Attempts, sendErr), "webhook", wh.ID)
+		err = w.Database.RemoveWebhookDelivery(ctx, delivery.ID)
+	default:
+		attempt.Status = webhook.RetryingStatus
+		attempt.Error = sendErr.Error()
+		delivery.NextAttemptAt = now.Add(w.Config.backoff(delivery.Attempts))
+		attempt.NextRetry = &delivery.NextAttemptAt
+		err = w.Database.UpdateWebhookDelivery(ctx, delivery)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error updating webhook queue: %v", err)
+	}
+	metrics.AddWebhookDelivery(string(attempt.Status))
+
+	err = w.Database.AddWebhookAttempt(ctx, wh.ID, &attempt)
+	if err != nil {
+		return false, fmt.Errorf("error adding webhook attempt to delivery log: %v", err)
+	}
+
+	return sendErr == nil, nil
+}
+
+// send posts the signed payload and returns the response status code, if
+// any.
+func (w *Webhooks) send(ctx context.Context, wh *webhook.Webhook, delivery *webhook.Delivery, timestamp time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.EventHeader, string(delivery.EventType))
+	req.Header.Set(webhook.DeliveryHeader, delivery.ID)
+	req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(wh.Secret, timestamp, delivery.Payload))
+
+	res, err := w.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(io.Discard, io.LimitReader(res.Body, maxWebhookResponseSize))
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Error reading webhook response: %v", err))
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,135 @@
+package simulator
+
+import (
+	"math"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/model/energy"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+)
+
+const (
+	// controlBand is how far from the target the heatpump runs at full
+	// output, it modulates down linearly closer to the target.
+	controlBand = 1.0
+	// dryModeCooling is the share of the rated output that dry mode removes
+	// as heat, the rest goes into condensing moisture.
+	dryModeCooling = 0.3
+
+	// Moisture removed in hPa of vapor pressure per hour at full output.
+	dryModeDehumidification  = 1.5
+	coolModeDehumidification = 0.6
+	// moistureTimeConstant is how fast vapor pressure settles back to the
+	// base level, from ventilation and people in the room.
+	moistureTimeConstant = 3 * time.Hour
+)
+
+// Room is a single zone thermal model. Heat flows in from the heatpump and
+// out to the outdoors proportionally to the temperature difference.
+type Room struct {
+	Temperature float64
+	// VaporPressure in hPa, relative humidity is derived from it, so that it
+	// drops when the room warms up as it does in reality.
+	VaporPressure float64
+	// BaseVaporPressure is what the vapor pressure settles to without
+	// dehumidification.
+	BaseVaporPressure float64
+
+	// HeatCapacity of the air, walls and furniture in J/K.
+	HeatCapacity float64
+	// HeatLoss to the outdoors in W/K.
+	HeatLoss float64
+
+	// HeatOutput in watts per mode, indexed by fan level like the energy
+	// model.
+	HeatOutput map[heatpump.Mode][]float64
+}
+
+func NewRoom(temperature, humidity, heatCapacity, heatLoss float64) *Room {
+	var r Room
+
+	r.Temperature = temperature
+	r.VaporPressure = humidity / 100 * saturationVaporPressure(temperature)
+	r.BaseVaporPressure = r.VaporPressure
+	r.HeatCapacity = heatCapacity
+	r.HeatLoss = heatLoss
+	r.HeatOutput = energy.DefaultConfig().Model.HeatOutput
+
+	return &r
+}
+
+// Humidity is the relative humidity in percent.
+func (r *Room) Humidity() float64 {
+	return math.Min(100, r.VaporPressure/saturationVaporPressure(r.Temperature)*100)
+}
+
+// Output is the heat the heatpump delivers in watts, negative when cooling,
+// and the moisture it removes in hPa per hour.
+func (r *Room) Output(state *heatpump.State) (heat, dehumidification float64) {
+	if state.Mode == nil || state.TargetTemperature == nil || state.FanSpeed == nil {
+		return 0, 0
+	}
+
+	levels := r.HeatOutput[*state.Mode]
+	if len(levels) == 0 {
+		return 0, 0
+	}
+	rated := levels[max(0, min(*state.FanSpeed/20, len(levels)-1))]
+	target := float64(*state.TargetTemperature)
+
+	heating := clamp((target-r.Temperature)/controlBand, 0, 1)
+	cooling := clamp((r.Temperature-target)/controlBand, 0, 1)
+
+	switch *state.Mode {
+	case heatpump.HeatMode:
+		return heating * rated, 0
+	case heatpump.CoolMode:
+		return -cooling * rated, cooling * coolModeDehumidification
+	case heatpump.AutoMode:
+		if heating > 0 {
+			return heating * rated, 0
+		}
+		return -cooling * rated, cooling * coolModeDehumidification
+	case heatpump.DryMode:
+		// Dry mode keeps running regardless of the target, it only stops
+		// cooling once it's reached
+		return -cooling * dryModeCooling * rated, dryModeDehumidification
+	default:
+		return 0, 0
+	}
+}
+
+// Step advances the room by dt with the heatpump in the given state.
+func (r *Room) Step(dt time.Duration, outdoorTemperature float64, state *heatpump.State) {
+	heat, dehumidification := r.Output(state)
+
+	loss := r.HeatLoss * (r.Temperature - outdoorTemperature)
+	r.Temperature += (heat - loss) * dt.Seconds() / r.HeatCapacity
+
+	recovery := (r.BaseVaporPressure - r.VaporPressure) * dt.Seconds() / moistureTimeConstant.Seconds()
+	r.VaporPressure += recovery - dehumidification*dt.Hours()
+	r.VaporPressure = max(r.VaporPressure, 0)
+}
+
+// OutdoorProfile is a daily sine wave peaking in the afternoon.
+type OutdoorProfile struct {
+	Mean      float64
+	Amplitude float64
+}
+
+// peakHour is the time of day the outdoor temperature is the highest.
+const peakHour = 15
+
+func (p *OutdoorProfile) TemperatureAt(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	return p.Mean + p.Amplitude*math.Cos(2*math.Pi*(hour-peakHour)/24)
+}
+
+// saturationVaporPressure in hPa, using the Magnus formula.
+func saturationVaporPressure(temperature float64) float64 {
+	return 6.112 * math.Exp(17.62*temperature/(243.12+temperature))
+}
+
+func clamp(value, low, high float64) float64 {
+	return math.Max(low, math.Min(high, value))
+}
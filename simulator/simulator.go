@@ -0,0 +1,270 @@
+// Package simulator stands in for the heatpump and the room it's in. It
+// receives IR frames like the transmitter does, and publishes sensor readings
+// from a thermal model, so that automations can be tested end to end.
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/alexchebotarsky/heatpump-api/client/pubsub"
+	"github.com/alexchebotarsky/heatpump-api/model/heatpump"
+	"github.com/alexchebotarsky/heatpump-api/model/weather"
+)
+
+type PubSub interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Subscribe(ctx context.Context, topic string, handler func(ctx context.Context, payload []byte) error) error
+}
+
+type Config struct {
+	// Speed is how many times faster than real time the simulation runs,
+	// e.g. 8640 runs a day in 10 seconds.
+	Speed float64
+	// Start is the simulated time to start from.
+	Start time.Time
+	// ReadingInterval is the simulated time between sensor readings. At high
+	// speeds readings are published at most once per tick.
+	ReadingInterval time.Duration
+	// StatusInterval is the simulated time between status logs.
+	StatusInterval time.Duration
+
+	Temperature  float64
+	Humidity     float64
+	HeatCapacity float64
+	HeatLoss     float64
+	Outdoor      OutdoorProfile
+
+	IRTransmitterTopic     string
+	TemperatureSensorTopic string
+	// AckTopic receives an acknowledgement of every IR frame, empty disables
+	// them.
+	AckTopic string
+	// AckDelay is real time, as it simulates the transmitter rather than the
+	// room.
+	AckDelay time.Duration
+	// AckDropRate is the probability of a frame being lost, it's neither
+	// applied nor acknowledged.
+	AckDropRate float64
+	// OutdoorTemperatureTopic receives outdoor readings along with the indoor
+	// ones, empty disables them.
+	OutdoorTemperatureTopic string
+}
+
+func (c *Config) Validate() error {
+	if c.Speed <= 0 {
+		return fmt.Errorf("speed must be positive, got: %v", c.Speed)
+	}
+
+	if c.ReadingInterval <= 0 {
+		return fmt.Errorf("reading interval must be positive, got: %s", c.ReadingInterval)
+	}
+
+	if c.StatusInterval <= 0 {
+		return fmt.Errorf("status interval must be positive, got: %s", c.StatusInterval)
+	}
+
+	if c.Humidity < 0 || c.Humidity > 100 {
+		return fmt.Errorf("humidity must be in range [0,100], got: %v", c.Humidity)
+	}
+
+	if c.HeatCapacity <= 0 || c.HeatLoss <= 0 {
+		return errors.New("heat capacity and heat loss must be positive")
+	}
+
+	if c.AckDropRate < 0 || c.AckDropRate > 1 {
+		return fmt.Errorf("ack drop rate must be in range [0,1], got: %v", c.AckDropRate)
+	}
+
+	if c.IRTransmitterTopic == "" || c.TemperatureSensorTopic == "" {
+		return errors.New("ir transmitter and temperature sensor topics must be set")
+	}
+
+	return nil
+}
+
+const (
+	// tickInterval is the real time between simulation updates.
+	tickInterval = 100 * time.Millisecond
+	// maxStep keeps the integration stable when a tick covers a lot of
+	// simulated time.
+	maxStep = 10 * time.Second
+
+	// Standard deviation of the sensor noise.
+	temperatureNoise = 0.05
+	humidityNoise    = 0.5
+)
+
+type Simulator struct {
+	config Config
+	pubsub PubSub
+
+	mu    sync.Mutex
+	now   time.Time
+	room  *Room
+	state heatpump.State
+}
+
+func New(config Config, pubsub PubSub) *Simulator {
+	var s Simulator
+
+	s.config = config
+	s.pubsub = pubsub
+	s.now = config.Start
+	s.room = NewRoom(config.Temperature, config.Humidity, config.HeatCapacity, config.HeatLoss)
+
+	mode := heatpump.OffMode
+	targetTemperature := 22
+	fanSpeed := 0
+	s.state = heatpump.State{Mode: &mode, TargetTemperature: &targetTemperature, FanSpeed: &fanSpeed}
+
+	return &s
+}
+
+// Run simulates until ctx is done.
+func (s *Simulator) Run(ctx context.Context) error {
+	err := s.pubsub.Subscribe(ctx, s.config.IRTransmitterTopic, s.handleIRSignal)
+	if err != nil {
+		return fmt.Errorf("error subscribing to ir transmitter: %v", err)
+	}
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	nextReading := s.config.Start
+	nextStatus := s.config.Start
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		now, reading, outdoor := s.advance(time.Duration(float64(tickInterval) * s.config.Speed))
+
+		if !now.Before(nextReading) {
+			nextReading = now.Add(s.config.ReadingInterval)
+			s.publishReadings(ctx, reading, outdoor)
+		}
+
+		if !now.Before(nextStatus) {
+			nextStatus = now.Add(s.config.StatusInterval)
+			s.logStatus(now, outdoor)
+		}
+	}
+}
+
+// advance steps the simulation forward and returns the sensor readings.
+func (s *Simulator) advance(elapsed time.Duration) (time.Time, heatpump.TemperatureReading, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for elapsed > 0 {
+		dt := min(elapsed, maxStep)
+		s.room.Step(dt, s.config.Outdoor.TemperatureAt(s.now), &s.state)
+		s.now = s.now.Add(dt)
+		elapsed -= dt
+	}
+
+	reading := heatpump.TemperatureReading{
+		Temperature: round(s.room.Temperature+rand.NormFloat64()*temperatureNoise, 1),
+		Humidity:    round(clamp(s.room.Humidity()+rand.NormFloat64()*humidityNoise, 0, 100), 1),
+	}
+
+	return s.now, reading, round(s.config.Outdoor.TemperatureAt(s.now), 1)
+}
+
+func (s *Simulator) publishReadings(ctx context.Context, reading heatpump.TemperatureReading, outdoor float64) {
+	payload, err := json.Marshal(&reading)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error marshalling temperature reading: %v", err))
+		return
+	}
+
+	err = s.pubsub.Publish(ctx, s.config.TemperatureSensorTopic, payload)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error publishing temperature reading: %v", err))
+	}
+
+	if s.config.OutdoorTemperatureTopic == "" {
+		return
+	}
+
+	payload, err = json.Marshal(&weather.OutdoorTemperatureReading{Temperature: outdoor})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error marshalling outdoor temperature reading: %v", err))
+		return
+	}
+
+	err = s.pubsub.Publish(ctx, s.config.OutdoorTemperatureTopic, payload)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error publishing outdoor temperature reading: %v", err))
+	}
+}
+
+func (s *Simulator) logStatus(now time.Time, outdoor float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	heat, _ := s.room.Output(&s.state)
+	slog.Info(fmt.Sprintf("%s room %.1f°C %.0f%%, outdoor %.1f°C, heatpump %s %d°C fan %d output %.0fW",
+		now.Format(time.DateTime), s.room.Temperature, s.room.Humidity(), outdoor,
+		*s.state.Mode, *s.state.TargetTemperature, *s.state.FanSpeed, heat))
+}
+
+func (s *Simulator) handleIRSignal(ctx context.Context, payload []byte) error {
+	var signal pubsub.IRSignal
+	err := json.Unmarshal(payload, &signal)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling ir signal: %v", err)
+	}
+
+	state, err := heatpump.NewStateFromBinary(signal.Signal)
+	if err != nil {
+		return fmt.Errorf("error decoding ir signal: %v", err)
+	}
+
+	if rand.Float64() < s.config.AckDropRate {
+		slog.Info("Dropped IR signal")
+		return nil
+	}
+
+	s.mu.Lock()
+	s.state = *state
+	s.mu.Unlock()
+
+	slog.Info(fmt.Sprintf("Heatpump set to %s %d°C fan %d", *state.Mode, *state.TargetTemperature, *state.FanSpeed))
+
+	if s.config.AckTopic == "" {
+		return nil
+	}
+
+	// Acknowledge asynchronously, so that the delay doesn't hold up message
+	// handling
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.config.AckDelay):
+		}
+
+		err := s.pubsub.Publish(context.WithoutCancel(ctx), s.config.AckTopic, payload)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error publishing ir transmitter ack: %v", err))
+		}
+	}()
+
+	return nil
+}
+
+func round(value float64, decimals int) float64 {
+	factor := math.Pow(10, float64(decimals))
+	return math.Round(value*factor) / factor
+}